@@ -0,0 +1,80 @@
+// Package main provides a utility to create user accounts for the private tools
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	scopesFlag := flag.String("scopes", models.ScopeStorageRead+","+models.ScopeStorageWrite,
+		"scopes granted to the user, one of "+strings.Join(models.KnownScopes, ", "))
+	flag.Parse()
+
+	// Check if a username was provided
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: adduser [-scopes storage:read,storage:write] <username> [password]")
+		fmt.Println("Creates a user that can log in to use the private tools")
+		fmt.Println("The password is read from standard input when it is not given")
+		os.Exit(1)
+	}
+	username := flag.Arg(0)
+
+	scopes, err := models.ParseScopes(*scopesFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Get the password from the arguments or standard input
+	var password string
+	if flag.NArg() > 1 {
+		password = flag.Arg(1)
+	} else {
+		fmt.Print("Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Printf("Error reading password: %v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		fmt.Println("Error: password cannot be empty")
+		os.Exit(1)
+	}
+
+	// Load the database configuration from the .env file if it exists
+	godotenv.Load()
+
+	dao, err := database.GetUserDAO()
+	if err != nil {
+		fmt.Printf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Shutdown()
+
+	// Hash the password
+	passwordHash, err := middleware.HashPassword(password)
+	if err != nil {
+		fmt.Printf("Error hashing password: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Create the user
+	id, err := dao.CreateUser(username, passwordHash, scopes)
+	if err != nil {
+		fmt.Printf("Error creating user: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created user '%s' with ID %s and scopes %s\n", username, id, strings.Join(scopes, ", "))
+}
@@ -0,0 +1,144 @@
+// Package main provides a utility to manage the API keys used to reach the private tools
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/joho/godotenv"
+)
+
+// usage prints how to use the utility and exits
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  apikey create [-expires 720h] [-scopes storage:read] <username> <name>  Create an API key")
+	fmt.Println("  apikey list <username>                                                List the API keys of a user")
+	fmt.Println("  apikey revoke <key_id>                                                Revoke an API key")
+	fmt.Println("Keys without -scopes get every scope of the user")
+	fmt.Println("API keys are sent as 'Authorization: Bearer <key>' to the /private endpoints")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	// Load the database configuration from the .env file if it exists
+	godotenv.Load()
+
+	dao, err := database.GetAPIKeyDAO()
+	if err != nil {
+		fmt.Printf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Shutdown()
+
+	switch os.Args[1] {
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ExitOnError)
+		expiresIn := flags.String("expires", "", "how long the key stays valid, such as 720h")
+		scopes := flags.String("scopes", "", "scopes granted to the key, one of "+strings.Join(models.KnownScopes, ", "))
+		flags.Parse(os.Args[2:])
+		if flags.NArg() < 2 {
+			usage()
+		}
+		err = createKey(dao, flags.Arg(0), flags.Arg(1), *expiresIn, *scopes)
+	case "list":
+		err = listKeys(dao, os.Args[2])
+	case "revoke":
+		err = dao.RevokeAPIKey(os.Args[2])
+		if err == nil {
+			fmt.Printf("Revoked API key %s\n", os.Args[2])
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// lookupUser returns the user with a username
+func lookupUser(username string) (*database.User, error) {
+	users, err := database.GetUserDAO()
+	if err != nil {
+		return nil, err
+	}
+	return users.GetUserByUsername(username)
+}
+
+// createKey creates an API key for a user and prints it
+// The key gets every scope of the user when no scopes are given
+func createKey(dao *database.APIKeyDAO, username string, name string, expiresIn string, scopeList string) error {
+	expiresAt, err := middleware.ParseAPIKeyExpiry(expiresIn)
+	if err != nil {
+		return err
+	}
+	scopes, err := models.ParseScopes(scopeList)
+	if err != nil {
+		return err
+	}
+	user, err := lookupUser(username)
+	if err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		scopes = user.Scopes
+	}
+	if !models.HasScopes(user.Scopes, scopes...) {
+		return fmt.Errorf("user '%s' does not have every scope of %s", user.Username, strings.Join(scopes, ", "))
+	}
+
+	apiKey, key, err := middleware.CreateAPIKey(dao, user.ID, name, scopes, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created API key '%s' with ID %s for user '%s'\n", apiKey.Name, apiKey.ID, user.Username)
+	fmt.Printf("Scopes: %s\n", strings.Join(apiKey.Scopes, ", "))
+	if apiKey.ExpiresAt != nil {
+		fmt.Printf("Expires at %s\n", apiKey.ExpiresAt.Format(time.RFC3339))
+	}
+	fmt.Println("Store the key now, it cannot be shown again:")
+	fmt.Println(key)
+	return nil
+}
+
+// listKeys prints the API keys of a user
+func listKeys(dao *database.APIKeyDAO, username string) error {
+	user, err := lookupUser(username)
+	if err != nil {
+		return err
+	}
+	keys, err := dao.ListAPIKeys(user.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		status := "active"
+		switch {
+		case key.RevokedAt != nil:
+			status = "revoked"
+		case !key.Active(now):
+			status = "expired"
+		}
+		lastUsed := "never"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s  %s...  %-8s  last used %s  %s  [%s]\n", key.ID, key.Prefix, status, lastUsed, key.Name, strings.Join(key.Scopes, " "))
+	}
+	fmt.Printf("%d API keys\n", len(keys))
+	return nil
+}
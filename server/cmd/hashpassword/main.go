@@ -1,36 +1,45 @@
-// Package main provides a utility to generate SHA-256 hashes for passwords
+// Package main provides a utility to generate password hashes for the users table
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bufio"
 	"fmt"
 	"os"
-)
+	"strings"
 
-// HashPassword generates a SHA-256 hash for a password
-func HashPassword(password string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	return hex.EncodeToString(hasher.Sum(nil))
-}
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+)
 
 func main() {
-	// Check if a password was provided
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: hashpassword <password>")
-		fmt.Println("Generates a SHA-256 hash for the provided password")
+	// Get the password from the arguments or standard input
+	var password string
+	if len(os.Args) > 1 {
+		password = os.Args[1]
+	} else {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		fmt.Println("Usage: hashpassword [password]")
+		fmt.Println("Generates an argon2id hash for the provided password")
+		fmt.Println("The password is read from standard input when it is not given")
 		os.Exit(1)
 	}
 
-	// Get the password from the command line arguments
-	password := os.Args[1]
-
 	// Hash the password
-	hash := HashPassword(password)
+	hash, err := middleware.HashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing password: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Print the hash
-	fmt.Printf("SHA-256 hash for '%s':\n%s\n", password, hash)
-	fmt.Println("\nAdd this to your .env file as:")
-	fmt.Printf("PRIVATE_USE_PASSWORD=%s\n", hash)
+	fmt.Println(hash)
+	fmt.Fprintln(os.Stderr, "\nStore this in the password_hash column of the users table, or create the user with:")
+	fmt.Fprintln(os.Stderr, "go run ./cmd/adduser <username>")
 }
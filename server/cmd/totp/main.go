@@ -0,0 +1,106 @@
+// Package main provides a utility to manage the two-factor authentication of users
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/joho/godotenv"
+)
+
+// usage prints how to use the utility and exits
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  totp enroll <username>          Enable two-factor authentication and print the enrollment URI")
+	fmt.Println("  totp recovery-codes <username>  Replace the recovery codes of a user")
+	fmt.Println("  totp disable <username>         Disable two-factor authentication")
+	fmt.Println("The enrollment URI is added to an authenticator app, usually as a QR code")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	// Load the database configuration from the .env file if it exists
+	godotenv.Load()
+
+	dao, err := database.GetUserDAO()
+	if err != nil {
+		fmt.Printf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Shutdown()
+
+	user, err := dao.GetUserByUsername(os.Args[2])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "enroll":
+		err = enroll(dao, user)
+	case "recovery-codes":
+		err = replaceRecoveryCodes(dao, user)
+	case "disable":
+		err = dao.DisableTOTP(user.ID)
+		if err == nil {
+			fmt.Printf("Disabled two-factor authentication for user '%s'\n", user.Username)
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// enroll creates a TOTP secret for a user, enables two-factor authentication and prints the enrollment URI
+// Users already using two-factor authentication must be disabled first
+func enroll(dao *database.UserDAO, user *database.User) error {
+	secret, uri, err := middleware.EnrollTOTP(dao, user.ID)
+	if err != nil {
+		return err
+	}
+	codes, hashes, err := middleware.GenerateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := dao.EnableTOTP(user.ID, hashes); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enabled two-factor authentication for user '%s'\n", user.Username)
+	fmt.Println("Enrollment URI:")
+	fmt.Println(uri)
+	fmt.Printf("Secret: %s\n", secret)
+	printRecoveryCodes(codes)
+	return nil
+}
+
+// replaceRecoveryCodes creates new recovery codes for a user and prints them
+func replaceRecoveryCodes(dao *database.UserDAO, user *database.User) error {
+	if !user.TOTPEnabled {
+		return fmt.Errorf("user '%s' does not use two-factor authentication", user.Username)
+	}
+	codes, err := middleware.RegenerateRecoveryCodes(dao, user.ID)
+	if err != nil {
+		return err
+	}
+	printRecoveryCodes(codes)
+	return nil
+}
+
+// printRecoveryCodes prints recovery codes, which are not stored and cannot be shown again
+func printRecoveryCodes(codes []string) {
+	fmt.Println("Recovery codes, each can be used once instead of a TOTP code. Store them now, they cannot be shown again:")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
+}
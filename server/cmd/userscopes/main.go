@@ -0,0 +1,55 @@
+// Package main provides a utility to show and change the scopes granted to a user
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	// Check if a username was provided
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: userscopes <username> [scopes]")
+		fmt.Println("Shows the scopes of a user, or replaces them with a comma separated list")
+		fmt.Printf("Known scopes: %s\n", strings.Join(models.KnownScopes, ", "))
+		fmt.Println("Logged in users get the new scopes the next time they log in")
+		os.Exit(1)
+	}
+	username := os.Args[1]
+
+	// Load the database configuration from the .env file if it exists
+	godotenv.Load()
+
+	dao, err := database.GetUserDAO()
+	if err != nil {
+		fmt.Printf("Error connecting to the database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Shutdown()
+
+	user, err := dao.GetUserByUsername(username)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 2 {
+		scopes, err := models.ParseScopes(os.Args[2])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := dao.UpdateScopes(user.ID, scopes); err != nil {
+			fmt.Printf("Error updating scopes: %v\n", err)
+			os.Exit(1)
+		}
+		user.Scopes = scopes
+	}
+
+	fmt.Printf("User '%s' has the scopes: %s\n", user.Username, strings.Join(user.Scopes, ", "))
+}
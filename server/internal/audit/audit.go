@@ -0,0 +1,168 @@
+// Package audit records security events such as failed logins, and who used the privileged features
+package audit
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Types of audit events
+const (
+	// EventLoginFailed is a login with a wrong username or password
+	EventLoginFailed = "login_failed"
+	// EventLoginThrottled is a login rejected because of too many failed attempts
+	EventLoginThrottled = "login_throttled"
+	// EventLockout is an account or IP address locked after too many failed attempts
+	EventLockout = "lockout"
+	// EventTOTPFailed is a login with a correct password but a wrong two-factor authentication code
+	EventTOTPFailed = "totp_failed"
+	// EventRecoveryCodeUsed is a login with a recovery code instead of a TOTP code
+	EventRecoveryCodeUsed = "recovery_code_used"
+	// EventTOTPEnabled is a user turning on two-factor authentication
+	EventTOTPEnabled = "totp_enabled"
+	// EventTOTPDisabled is a user turning off two-factor authentication
+	EventTOTPDisabled = "totp_disabled"
+	// EventLogin is a user logging in
+	EventLogin = "login"
+	// EventLogout is a user logging out
+	EventLogout = "logout"
+	// EventAPIKeyCreated is a user creating an API key, the target is the key
+	EventAPIKeyCreated = "api_key_created"
+	// EventAPIKeyRevoked is a user revoking an API key, the target is the key
+	EventAPIKeyRevoked = "api_key_revoked"
+	// EventTextStored is a text entry stored with the text storage tool, the target is the entry
+	EventTextStored = "text_stored"
+	// EventTextRetrieved is a text entry read with the text retrieval tool, the target is the entry
+	EventTextRetrieved = "text_retrieved"
+	// EventTextUpdated is a text entry replaced, appended to, saved or unsaved, the target is the entry
+	EventTextUpdated = "text_updated"
+	// EventTextDeleted is a text entry deleted with the text delete tool, the target is the entry
+	EventTextDeleted = "text_deleted"
+	// EventCleanup is a database cleanup started through the maintenance endpoint
+	EventCleanup = "cleanup"
+)
+
+// Outcomes of audit events
+const (
+	// OutcomeSuccess is an action that was carried out
+	OutcomeSuccess = "success"
+	// OutcomeFailure is an action that failed, such as a wrong password or a missing text entry
+	OutcomeFailure = "failure"
+	// OutcomeDenied is an action refused to the user, such as a locked out login
+	OutcomeDenied = "denied"
+)
+
+// failureEvents are the outcomes of event types that are never successful
+var failureEvents = map[string]string{
+	EventLoginFailed:    OutcomeFailure,
+	EventTOTPFailed:     OutcomeFailure,
+	EventLoginThrottled: OutcomeDenied,
+	EventLockout:        OutcomeDenied,
+}
+
+// ErrNotQueryable is returned by Query when the recorder only writes events to the log
+var ErrNotQueryable = errors.New("audit events are only written to the server log")
+
+// Event is a security event
+type Event struct {
+	ID        int64     `json:"id,omitempty"`        // Sequence number of a stored event
+	Type      string    `json:"type"`                // The action, such as login or text_stored
+	Outcome   string    `json:"outcome"`             // Whether the action succeeded, success when not set
+	UserID    string    `json:"user_id,omitempty"`   // ID of the user acting, empty if unknown
+	Username  string    `json:"username,omitempty"`  // Username given by the client, which may not exist
+	TargetID  string    `json:"target_id,omitempty"` // ID of the object acted on, such as a text entry
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Filter selects stored audit events; empty fields match every event
+type Filter struct {
+	Type     string
+	Outcome  string
+	UserID   string
+	Username string
+	TargetID string
+	Since    time.Time // Events at or after this time
+	Until    time.Time // Events before this time
+	BeforeID int64     // Events older than this event, for paging
+	Limit    int       // Maximum number of events, newest first
+}
+
+// Recorder stores audit events
+type Recorder interface {
+	RecordEvent(event Event) error
+}
+
+// Querier is a Recorder whose events can be queried
+type Querier interface {
+	Recorder
+	ListEvents(filter Filter) ([]Event, error)
+}
+
+// LogRecorder writes audit events to the standard logger
+type LogRecorder struct{}
+
+// RecordEvent writes an audit event to the standard logger
+func (LogRecorder) RecordEvent(event Event) error {
+	log.Printf("Audit: %s %s user=%q target=%s ip=%s %s", event.Type, event.Outcome, event.Username, event.TargetID, event.IPAddress, event.Details)
+	return nil
+}
+
+var (
+	// Recorder of the audit events
+	recorder Recorder = LogRecorder{}
+	// Mutex protecting recorder
+	recorderMutex sync.RWMutex
+)
+
+// SetRecorder replaces the recorder of the audit events
+// Passing nil restores the LogRecorder
+func SetRecorder(r Recorder) {
+	recorderMutex.Lock()
+	defer recorderMutex.Unlock()
+	if r == nil {
+		r = LogRecorder{}
+	}
+	recorder = r
+}
+
+// Record records an audit event, setting its time and outcome if it has none
+// Failed logins, lockouts and throttled logins default to failure or denied, other events to success
+// Errors are logged with the event so recording an event never fails the request that caused it
+func Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+		if outcome, ok := failureEvents[event.Type]; ok {
+			event.Outcome = outcome
+		}
+	}
+
+	recorderMutex.RLock()
+	r := recorder
+	recorderMutex.RUnlock()
+	if err := r.RecordEvent(event); err != nil {
+		log.Printf("Error recording audit event %s: %v", event.Type, err)
+		LogRecorder{}.RecordEvent(event)
+	}
+}
+
+// Query returns the stored audit events selected by a filter, newest first
+// It returns ErrNotQueryable when the recorder does not store events
+func Query(filter Filter) ([]Event, error) {
+	recorderMutex.RLock()
+	r := recorder
+	recorderMutex.RUnlock()
+
+	querier, ok := r.(Querier)
+	if !ok {
+		return nil, ErrNotQueryable
+	}
+	return querier.ListEvents(filter)
+}
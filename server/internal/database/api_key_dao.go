@@ -0,0 +1,283 @@
+// Package database provides functionality for database operations
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyDAO handles database operations for API keys
+type APIKeyDAO struct {
+	dbManager DBManagerInterface
+}
+
+// APIKey represents an API key in the database
+// The key itself is never stored, only its hash
+type APIKey struct {
+	ID         string     `json:"id"`                     // Unique identifier
+	UserID     string     `json:"user_id"`                // ID of the user the key acts as
+	Username   string     `json:"username"`               // Name of the user the key acts as
+	Name       string     `json:"name"`                   // What the key is used for
+	Prefix     string     `json:"prefix"`                 // First characters of the key
+	Scopes     []string   `json:"scopes"`                 // Scopes granted to the key
+	UserScopes []string   `json:"-"`                      // Scopes of the user, which limit the scopes of the key
+	KeyHash    string     `json:"-"`                      // SHA-256 hash of the key
+	CreatedAt  time.Time  `json:"created_at"`             // Creation timestamp
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`   // Expiry timestamp, nil if the key does not expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"` // Timestamp of the last request made with the key
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`   // Revocation timestamp, nil while the key is active
+}
+
+// Active reports whether the key can be used at the given time
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// apiKeyColumns are the columns selected for an APIKey, joined with the username
+const apiKeyColumns = `
+	k.id, k.user_id, u.username, k.name, k.prefix, k.scopes, u.scopes, k.key_hash,
+	k.created_at, k.expires_at, k.last_used_at, k.revoked_at
+`
+
+// NewAPIKeyDAO creates a new APIKeyDAO
+func NewAPIKeyDAO(dbManager DBManagerInterface) *APIKeyDAO {
+	return &APIKeyDAO{
+		dbManager: dbManager,
+	}
+}
+
+// CreateAPIKey stores a new API key for a user with the scopes granted to the key
+// expiresAt is nil for keys that do not expire
+// Returns the ID of the new key
+func (dao *APIKeyDAO) CreateAPIKey(userID string, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (string, error) {
+	// Validate input
+	name = strings.TrimSpace(name)
+	if userID == "" {
+		return "", errors.New("user id cannot be empty")
+	}
+	if name == "" {
+		return "", errors.New("name cannot be empty")
+	}
+	if keyHash == "" {
+		return "", errors.New("key hash cannot be empty")
+	}
+
+	// Generate a unique ID
+	id := uuid.New().String()
+
+	// Prepare the SQL statement
+	query := `
+		INSERT INTO api_keys (id, user_id, name, prefix, scopes, key_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7)
+		RETURNING id
+	`
+
+	// Execute the query with retry logic
+	var returnedID string
+	err := dao.dbManager.QueryRowWithRetry(query, id, userID, name, prefix, JoinScopes(scopes), keyHash, nullTime(expiresAt)).Scan(&returnedID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return returnedID, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of the key
+// Revoked and expired keys are returned too, see APIKey.Active
+func (dao *APIKeyDAO) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	// Validate input
+	if keyHash == "" {
+		return nil, errors.New("key hash cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+	`
+
+	// Execute the query with retry logic
+	key, err := scanAPIKey(dao.dbManager.QueryRowWithRetry(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("API key %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to retrieve API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// GetAPIKeyByID retrieves an API key by ID
+func (dao *APIKeyDAO) GetAPIKeyByID(id string) (*APIKey, error) {
+	// Validate input
+	if id == "" {
+		return nil, errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.id = $1
+	`
+
+	// Execute the query with retry logic
+	key, err := scanAPIKey(dao.dbManager.QueryRowWithRetry(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("API key with ID %s %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to retrieve API key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys retrieves the API keys of a user, newest first
+func (dao *APIKeyDAO) ListAPIKeys(userID string) ([]*APIKey, error) {
+	// Prepare the SQL statement
+	query := `
+		SELECT` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.user_id = $1
+		ORDER BY k.created_at DESC
+	`
+
+	// Execute the query with retry logic
+	rows, err := dao.dbManager.QueryWithRetry(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+	defer rows.Close()
+
+	// Process the results
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during iteration: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active API key by ID
+func (dao *APIKeyDAO) RevokeAPIKey(id string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("active API key with ID %s %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that an API key was used
+func (dao *APIKeyDAO) TouchAPIKey(id string) error {
+	// Prepare the SQL statement
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+	`
+
+	// Execute the query with retry logic
+	if _, err := dao.dbManager.ExecWithRetry(query, id); err != nil {
+		return fmt.Errorf("failed to update API key last use: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey scans the apiKeyColumns of a row
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes, userScopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Username,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&userScopes,
+		&key.KeyHash,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = SplitScopes(scopes)
+	key.UserScopes = SplitScopes(userScopes)
+	key.ExpiresAt = timePointer(expiresAt)
+	key.LastUsedAt = timePointer(lastUsedAt)
+	key.RevokedAt = timePointer(revokedAt)
+	return &key, nil
+}
+
+// nullTime converts an optional time to a sql.NullTime, so nil is stored as NULL
+func nullTime(value *time.Time) sql.NullTime {
+	if value == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *value, Valid: true}
+}
+
+// timePointer converts a sql.NullTime to an optional time
+func timePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
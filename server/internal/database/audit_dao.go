@@ -0,0 +1,161 @@
+// Package database provides functionality for database operations
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+)
+
+const (
+	// DefaultAuditEventLimit is the number of audit events listed when the filter sets no limit
+	DefaultAuditEventLimit = 100
+	// MaxAuditEventLimit is the largest number of audit events listed at once
+	MaxAuditEventLimit = 1000
+)
+
+// AuditDAO handles database operations for audit events
+// It is an audit.Querier, so it can be passed to audit.SetRecorder
+type AuditDAO struct {
+	dbManager DBManagerInterface
+}
+
+// NewAuditDAO creates a new AuditDAO
+func NewAuditDAO(dbManager DBManagerInterface) *AuditDAO {
+	return &AuditDAO{
+		dbManager: dbManager,
+	}
+}
+
+// RecordEvent appends an audit event to the audit_events table
+func (dao *AuditDAO) RecordEvent(event audit.Event) error {
+	// Validate input
+	if event.Type == "" {
+		return errors.New("event type cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		INSERT INTO audit_events (event_type, outcome, actor_id, actor_username, target_id, ip_address, user_agent, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	// Execute the query with retry logic
+	_, err := dao.dbManager.ExecWithRetry(query,
+		event.Type,
+		event.Outcome,
+		NullString(event.UserID),
+		NullString(event.Username),
+		NullString(event.TargetID),
+		NullString(event.IPAddress),
+		NullString(event.UserAgent),
+		NullString(event.Details),
+		event.Time,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents retrieves the audit events selected by a filter, newest first
+// The number of events is limited to DefaultAuditEventLimit, or the limit of the filter up to MaxAuditEventLimit
+func (dao *AuditDAO) ListEvents(filter audit.Filter) ([]audit.Event, error) {
+	// Build the conditions of the filter
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Type != "" {
+		where("event_type = $%d", filter.Type)
+	}
+	if filter.Outcome != "" {
+		where("outcome = $%d", filter.Outcome)
+	}
+	if filter.UserID != "" {
+		where("actor_id = $%d", filter.UserID)
+	}
+	if filter.Username != "" {
+		where("actor_username = $%d", filter.Username)
+	}
+	if filter.TargetID != "" {
+		where("target_id = $%d", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < $%d", filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuditEventLimit
+	}
+	if limit > MaxAuditEventLimit {
+		limit = MaxAuditEventLimit
+	}
+	args = append(args, limit)
+
+	// Prepare the SQL statement
+	query := `
+		SELECT id, event_type, outcome, actor_id, actor_username, target_id, ip_address, user_agent, details, created_at
+		FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	query += fmt.Sprintf("ORDER BY id DESC LIMIT $%d", len(args))
+
+	// Execute the query with retry logic
+	rows, err := dao.dbManager.QueryWithRetry(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit events: %w", err)
+	}
+	defer rows.Close()
+
+	// Process the results
+	events := []audit.Event{}
+	for rows.Next() {
+		var event audit.Event
+		var userID, username, targetID, ipAddress, userAgent, details sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.Outcome,
+			&userID,
+			&username,
+			&targetID,
+			&ipAddress,
+			&userAgent,
+			&details,
+			&event.Time,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		event.UserID = userID.String
+		event.Username = username.String
+		event.TargetID = targetID.String
+		event.IPAddress = ipAddress.String
+		event.UserAgent = userAgent.String
+		event.Details = details.String
+		events = append(events, event)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during iteration: %w", err)
+	}
+
+	return events, nil
+}
@@ -86,3 +86,43 @@ func GetTextStorageDAO() (*TextStorageDAO, error) {
 	}
 	return NewTextStorageDAO(manager), nil
 }
+
+// GetUserDAO returns a new UserDAO instance
+// using the global database manager
+func GetUserDAO() (*UserDAO, error) {
+	manager, err := GetManager()
+	if err != nil {
+		return nil, err
+	}
+	return NewUserDAO(manager), nil
+}
+
+// GetSessionDAO returns a new SessionDAO instance
+// using the global database manager
+func GetSessionDAO() (*SessionDAO, error) {
+	manager, err := GetManager()
+	if err != nil {
+		return nil, err
+	}
+	return NewSessionDAO(manager), nil
+}
+
+// GetAPIKeyDAO returns a new APIKeyDAO instance
+// using the global database manager
+func GetAPIKeyDAO() (*APIKeyDAO, error) {
+	manager, err := GetManager()
+	if err != nil {
+		return nil, err
+	}
+	return NewAPIKeyDAO(manager), nil
+}
+
+// GetAuditDAO returns a new AuditDAO instance
+// using the global database manager
+func GetAuditDAO() (*AuditDAO, error) {
+	manager, err := GetManager()
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditDAO(manager), nil
+}
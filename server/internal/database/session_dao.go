@@ -0,0 +1,200 @@
+// Package database provides functionality for database operations
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionDAO handles database operations for login sessions
+type SessionDAO struct {
+	dbManager DBManagerInterface
+}
+
+// Session represents a login session in the database
+type Session struct {
+	ID        string    `json:"id"`                   // Unique identifier, carried in the session cookie
+	UserID    string    `json:"user_id"`              // ID of the user that logged in
+	CreatedAt time.Time `json:"created_at"`           // Login timestamp
+	ExpiresAt time.Time `json:"expires_at"`           // Expiry timestamp
+	UserAgent string    `json:"user_agent,omitempty"` // User agent of the login request
+	IPAddress string    `json:"ip_address,omitempty"` // IP address of the login request
+}
+
+// NewSessionDAO creates a new SessionDAO
+func NewSessionDAO(dbManager DBManagerInterface) *SessionDAO {
+	return &SessionDAO{
+		dbManager: dbManager,
+	}
+}
+
+// CreateSession creates a session for a user
+// Returns the ID of the new session
+func (dao *SessionDAO) CreateSession(userID string, expiresAt time.Time, userAgent string, ipAddress string) (string, error) {
+	// Validate input
+	if userID == "" {
+		return "", errors.New("user id cannot be empty")
+	}
+
+	// Generate a unique ID
+	id := uuid.New().String()
+
+	// Prepare the SQL statement
+	query := `
+		INSERT INTO sessions (id, user_id, created_at, expires_at, user_agent, ip_address)
+		VALUES ($1, $2, NOW(), $3, $4, $5)
+		RETURNING id
+	`
+
+	// Execute the query with retry logic
+	var returnedID string
+	err := dao.dbManager.QueryRowWithRetry(query, id, userID, expiresAt, userAgent, ipAddress).Scan(&returnedID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return returnedID, nil
+}
+
+// GetSession retrieves a session that has not expired by ID
+func (dao *SessionDAO) GetSession(id string) (*Session, error) {
+	// Validate input
+	if id == "" {
+		return nil, errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT id, user_id, created_at, expires_at, user_agent, ip_address
+		FROM sessions
+		WHERE id = $1 AND expires_at > NOW()
+	`
+
+	// Execute the query with retry logic
+	var session Session
+	var userAgent, ipAddress sql.NullString
+	err := dao.dbManager.QueryRowWithRetry(query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&userAgent,
+		&ipAddress,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("session with ID %s %w", id, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	session.UserAgent = userAgent.String
+	session.IPAddress = ipAddress.String
+
+	return &session, nil
+}
+
+// ListSessions retrieves the sessions of a user that have not expired, newest first
+func (dao *SessionDAO) ListSessions(userID string) ([]*Session, error) {
+	// Prepare the SQL statement
+	query := `
+		SELECT id, user_id, created_at, expires_at, user_agent, ip_address
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`
+
+	// Execute the query with retry logic
+	rows, err := dao.dbManager.QueryWithRetry(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+	defer rows.Close()
+
+	// Process the results
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var userAgent, ipAddress sql.NullString
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.CreatedAt,
+			&session.ExpiresAt,
+			&userAgent,
+			&ipAddress,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		session.UserAgent = userAgent.String
+		session.IPAddress = ipAddress.String
+		sessions = append(sessions, &session)
+	}
+
+	// Check for errors after iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during iteration: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession deletes a session by ID, which logs it out
+func (dao *SessionDAO) DeleteSession(id string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session with ID %s %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// DeleteExpiredSessions deletes the sessions that have expired
+func (dao *SessionDAO) DeleteExpiredSessions() (int64, error) {
+	// Prepare the SQL statement
+	query := `
+		DELETE FROM sessions
+		WHERE expires_at <= NOW()
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	// Get the number of affected rows
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return rowsAffected, nil
+}
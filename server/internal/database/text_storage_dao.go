@@ -10,6 +10,12 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrNotFound is returned when a text entry, user, session or API key does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrVersionConflict is returned when a text entry changed since the version a conditional update was made against
+var ErrVersionConflict = errors.New("version conflict")
+
 // TextStorageDAO handles database operations for text storage
 type TextStorageDAO struct {
 	dbManager DBManagerInterface
@@ -17,10 +23,25 @@ type TextStorageDAO struct {
 
 // TextEntry represents a text entry in the database
 type TextEntry struct {
-	ID        string    // Unique identifier
-	Content   string    // Text content
-	SaveFlag  bool      // Whether to save permanently
-	CreatedAt time.Time // Creation timestamp
+	ID        string     // Unique identifier
+	Content   string     // Text content
+	SaveFlag  bool       // Whether to save permanently
+	CreatedAt time.Time  // Creation timestamp
+	CreatedBy string     // ID of the user that stored the entry, empty if unknown
+	ExpiresAt *time.Time // Expiry timestamp, nil if the entry does not expire
+	Version   int64      // Version of the entry, incremented by every change
+	UpdatedAt *time.Time // Timestamp of the last change, nil if the entry never changed
+}
+
+// textEntryColumns are the columns selected for a TextEntry
+const textEntryColumns = `
+	id, content, save_flag, created_at, created_by, expires_at, version, updated_at
+`
+
+// Expired reports whether the entry has expired at the given time
+// Saved entries never expire
+func (e *TextEntry) Expired(now time.Time) bool {
+	return !e.SaveFlag && e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
 }
 
 // NewTextStorageDAO creates a new TextStorageDAO
@@ -31,8 +52,10 @@ func NewTextStorageDAO(dbManager DBManagerInterface) *TextStorageDAO {
 }
 
 // StoreText stores text content in the database
+// createdBy is the ID of the user storing the text, or empty if unknown
+// expiresAt is nil for entries that do not expire
 // Returns the ID of the stored text
-func (dao *TextStorageDAO) StoreText(content string, saveFlag bool) (string, error) {
+func (dao *TextStorageDAO) StoreText(content string, saveFlag bool, createdBy string, expiresAt *time.Time) (string, error) {
 	// Validate input
 	if content == "" {
 		return "", errors.New("content cannot be empty")
@@ -43,14 +66,14 @@ func (dao *TextStorageDAO) StoreText(content string, saveFlag bool) (string, err
 
 	// Prepare the SQL statement
 	query := `
-		INSERT INTO text_storage (id, content, save_flag, created_at)
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO text_storage (id, content, save_flag, created_at, created_by, expires_at)
+		VALUES ($1, $2, $3, NOW(), $4, $5)
 		RETURNING id
 	`
 
 	// Execute the query with retry logic
 	var returnedID string
-	err := dao.dbManager.QueryRowWithRetry(query, id, content, saveFlag).Scan(&returnedID)
+	err := dao.dbManager.QueryRowWithRetry(query, id, content, saveFlag, NullString(createdBy), nullTime(expiresAt)).Scan(&returnedID)
 	if err != nil {
 		return "", fmt.Errorf("failed to store text: %w", err)
 	}
@@ -59,6 +82,7 @@ func (dao *TextStorageDAO) StoreText(content string, saveFlag bool) (string, err
 }
 
 // GetTextByID retrieves text content by ID
+// Expired entries that were not cleaned up yet are returned too, see TextEntry.Expired
 func (dao *TextStorageDAO) GetTextByID(id string) (*TextEntry, error) {
 	// Validate input
 	if id == "" {
@@ -67,44 +91,34 @@ func (dao *TextStorageDAO) GetTextByID(id string) (*TextEntry, error) {
 
 	// Prepare the SQL statement
 	query := `
-		SELECT id, content, save_flag, created_at
+		SELECT` + textEntryColumns + `
 		FROM text_storage
 		WHERE id = $1
 	`
 
 	// Execute the query with retry logic
-	var entry TextEntry
-	err := dao.dbManager.QueryRowWithRetry(query, id).Scan(
-		&entry.ID,
-		&entry.Content,
-		&entry.SaveFlag,
-		&entry.CreatedAt,
-	)
-
+	entry, err := scanTextEntry(dao.dbManager.QueryRowWithRetry(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("text entry with ID %s not found", id)
+			return nil, fmt.Errorf("text entry with ID %s %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to retrieve text: %w", err)
 	}
 
-	return &entry, nil
+	return entry, nil
 }
 
-// DeleteExpiredEntries deletes unsaved entries older than the specified duration
-func (dao *TextStorageDAO) DeleteExpiredEntries(age time.Duration) (int64, error) {
+// DeleteExpiredEntries deletes unsaved entries whose expiry time has passed
+func (dao *TextStorageDAO) DeleteExpiredEntries() (int64, error) {
 	// Prepare the SQL statement
 	query := `
 		DELETE FROM text_storage
 		WHERE save_flag = false
-		AND created_at < $1
+		AND expires_at <= NOW()
 	`
 
-	// Calculate the cutoff time
-	cutoffTime := time.Now().Add(-age)
-
 	// Execute the query with retry logic
-	result, err := dao.dbManager.ExecWithRetry(query, cutoffTime)
+	result, err := dao.dbManager.ExecWithRetry(query)
 	if err != nil {
 		return 0, fmt.Errorf("failed to delete expired entries: %w", err)
 	}
@@ -119,7 +133,8 @@ func (dao *TextStorageDAO) DeleteExpiredEntries(age time.Duration) (int64, error
 }
 
 // DeleteTextByID deletes a text entry by ID
-func (dao *TextStorageDAO) DeleteTextByID(id string) error {
+// With a version other than 0, the entry is only deleted at that version, otherwise ErrVersionConflict is returned
+func (dao *TextStorageDAO) DeleteTextByID(id string, version int64) error {
 	// Validate input
 	if id == "" {
 		return errors.New("id cannot be empty")
@@ -129,10 +144,11 @@ func (dao *TextStorageDAO) DeleteTextByID(id string) error {
 	query := `
 		DELETE FROM text_storage
 		WHERE id = $1
+		AND ($2 = 0 OR version = $2)
 	`
 
 	// Execute the query with retry logic
-	result, err := dao.dbManager.ExecWithRetry(query, id)
+	result, err := dao.dbManager.ExecWithRetry(query, id, version)
 	if err != nil {
 		return fmt.Errorf("failed to delete text: %w", err)
 	}
@@ -144,50 +160,105 @@ func (dao *TextStorageDAO) DeleteTextByID(id string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("text entry with ID %s not found", id)
+		return dao.unchangedError(id, version)
 	}
 
 	return nil
 }
 
-// UpdateTextSaveFlag updates the save flag for a text entry
-func (dao *TextStorageDAO) UpdateTextSaveFlag(id string, saveFlag bool) error {
+// ReplaceText replaces the content of a text entry and returns the changed entry
+// With a version other than 0, the entry is only changed at that version, otherwise ErrVersionConflict is returned
+func (dao *TextStorageDAO) ReplaceText(id string, content string, version int64) (*TextEntry, error) {
+	query := `
+		UPDATE text_storage
+		SET content = $2, version = version + 1, updated_at = NOW()
+		WHERE id = $1
+		AND ($3 = 0 OR version = $3)
+		RETURNING` + textEntryColumns
+	return dao.updateText(query, id, content, version)
+}
+
+// AppendText appends content to a text entry and returns the changed entry
+// With a version other than 0, the entry is only changed at that version, otherwise ErrVersionConflict is returned
+func (dao *TextStorageDAO) AppendText(id string, content string, version int64) (*TextEntry, error) {
+	query := `
+		UPDATE text_storage
+		SET content = content || $2, version = version + 1, updated_at = NOW()
+		WHERE id = $1
+		AND ($3 = 0 OR version = $3)
+		RETURNING` + textEntryColumns
+	return dao.updateText(query, id, content, version)
+}
+
+// updateText runs a query changing the content of a text entry
+func (dao *TextStorageDAO) updateText(query string, id string, content string, version int64) (*TextEntry, error) {
 	// Validate input
 	if id == "" {
-		return errors.New("id cannot be empty")
+		return nil, errors.New("id cannot be empty")
+	}
+	if content == "" {
+		return nil, errors.New("content cannot be empty")
+	}
+
+	// Execute the query with retry logic
+	entry, err := scanTextEntry(dao.dbManager.QueryRowWithRetry(query, id, content, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dao.unchangedError(id, version)
+		}
+		return nil, fmt.Errorf("failed to update text: %w", err)
+	}
+
+	return entry, nil
+}
+
+// UpdateTextSaveFlag updates the save flag and expiry time of a text entry and returns the changed entry
+// expiresAt is nil for entries that do not expire
+// With a version other than 0, the entry is only changed at that version, otherwise ErrVersionConflict is returned
+func (dao *TextStorageDAO) UpdateTextSaveFlag(id string, saveFlag bool, expiresAt *time.Time, version int64) (*TextEntry, error) {
+	// Validate input
+	if id == "" {
+		return nil, errors.New("id cannot be empty")
 	}
 
 	// Prepare the SQL statement
 	query := `
 		UPDATE text_storage
-		SET save_flag = $2
+		SET save_flag = $2, expires_at = $3, version = version + 1, updated_at = NOW()
 		WHERE id = $1
-	`
+		AND ($4 = 0 OR version = $4)
+		RETURNING` + textEntryColumns
 
 	// Execute the query with retry logic
-	result, err := dao.dbManager.ExecWithRetry(query, id, saveFlag)
+	entry, err := scanTextEntry(dao.dbManager.QueryRowWithRetry(query, id, saveFlag, nullTime(expiresAt), version))
 	if err != nil {
-		return fmt.Errorf("failed to update text save flag: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dao.unchangedError(id, version)
+		}
+		return nil, fmt.Errorf("failed to update text save flag: %w", err)
 	}
 
-	// Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get affected rows: %w", err)
-	}
+	return entry, nil
+}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("text entry with ID %s not found", id)
+// unchangedError explains why a change of a text entry matched no row
+// The entry either does not exist or is at another version than the one given
+func (dao *TextStorageDAO) unchangedError(id string, version int64) error {
+	if version == 0 {
+		return fmt.Errorf("text entry with ID %s %w", id, ErrNotFound)
 	}
-
-	return nil
+	entry, err := dao.GetTextByID(id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("text entry with ID %s is at version %d, not %d: %w", id, entry.Version, version, ErrVersionConflict)
 }
 
 // GetAllSavedEntries retrieves all saved text entries
 func (dao *TextStorageDAO) GetAllSavedEntries() ([]*TextEntry, error) {
 	// Prepare the SQL statement
 	query := `
-		SELECT id, content, save_flag, created_at
+		SELECT` + textEntryColumns + `
 		FROM text_storage
 		WHERE save_flag = true
 		ORDER BY created_at DESC
@@ -203,17 +274,11 @@ func (dao *TextStorageDAO) GetAllSavedEntries() ([]*TextEntry, error) {
 	// Process the results
 	var entries []*TextEntry
 	for rows.Next() {
-		var entry TextEntry
-		err := rows.Scan(
-			&entry.ID,
-			&entry.Content,
-			&entry.SaveFlag,
-			&entry.CreatedAt,
-		)
+		entry, err := scanTextEntry(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		entries = append(entries, &entry)
+		entries = append(entries, entry)
 	}
 
 	// Check for errors after iteration
@@ -223,3 +288,36 @@ func (dao *TextStorageDAO) GetAllSavedEntries() ([]*TextEntry, error) {
 
 	return entries, nil
 }
+
+// scanTextEntry scans the textEntryColumns of a row
+func scanTextEntry(row rowScanner) (*TextEntry, error) {
+	var entry TextEntry
+	var createdBy sql.NullString
+	var expiresAt, updatedAt sql.NullTime
+	err := row.Scan(
+		&entry.ID,
+		&entry.Content,
+		&entry.SaveFlag,
+		&entry.CreatedAt,
+		&createdBy,
+		&expiresAt,
+		&entry.Version,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.CreatedBy = createdBy.String
+	if expiresAt.Valid {
+		entry.ExpiresAt = &expiresAt.Time
+	}
+	if updatedAt.Valid {
+		entry.UpdatedAt = &updatedAt.Time
+	}
+	return &entry, nil
+}
+
+// NullString converts an optional value to a sql.NullString, so an empty string is stored as NULL
+func NullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
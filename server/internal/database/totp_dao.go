@@ -0,0 +1,171 @@
+// Package database provides functionality for database operations
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SetTOTPSecret stores a new TOTP secret for a user
+// Two-factor authentication stays disabled until EnableTOTP is called, after the user confirmed a code
+func (dao *UserDAO) SetTOTPSecret(id string, secret string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+	if secret == "" {
+		return errors.New("secret cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET totp_secret = $2, totp_enabled = false, totp_last_step = 0
+		WHERE id = $1
+	`
+
+	return dao.updateUser(query, "failed to store TOTP secret", id, secret)
+}
+
+// EnableTOTP turns on two-factor authentication for a user with a stored TOTP secret
+// The recovery codes of the user are replaced by the codes with the given hashes
+func (dao *UserDAO) EnableTOTP(id string, recoveryCodeHashes []string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET totp_enabled = true
+		WHERE id = $1 AND totp_secret IS NOT NULL
+	`
+
+	if err := dao.updateUser(query, "failed to enable TOTP", id); err != nil {
+		return err
+	}
+	return dao.ReplaceRecoveryCodes(id, recoveryCodeHashes)
+}
+
+// DisableTOTP turns off two-factor authentication for a user and deletes the secret and recovery codes
+func (dao *UserDAO) DisableTOTP(id string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0
+		WHERE id = $1
+	`
+
+	if err := dao.updateUser(query, "failed to disable TOTP", id); err != nil {
+		return err
+	}
+	return dao.ReplaceRecoveryCodes(id, nil)
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code
+// Returns false if a code of this or a later step was already used, so each code only works once
+func (dao *UserDAO) UseTOTPStep(id string, step int64) (bool, error) {
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1 AND totp_last_step < $2
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, id, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of a user and stores the codes with the given hashes
+func (dao *UserDAO) ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	// Delete the previous codes
+	_, err := dao.dbManager.ExecWithRetry(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	// Prepare the SQL statement
+	query := `
+		INSERT INTO recovery_codes (id, user_id, code_hash, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`
+
+	// Execute the query with retry logic for each code
+	for _, codeHash := range codeHashes {
+		if _, err := dao.dbManager.ExecWithRetry(query, uuid.New().String(), userID, codeHash); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used
+// Returns ErrNotFound if the user has no unused code with this hash
+func (dao *UserDAO) UseRecoveryCode(userID string, codeHash string) error {
+	// Prepare the SQL statement
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("unused recovery code %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// updateUser runs an update of a single user
+// Returns ErrNotFound if no user was updated
+func (dao *UserDAO) updateUser(query string, message string, id string, args ...interface{}) error {
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", message, err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", id, ErrNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+// Package database provides functionality for database operations
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserDAO handles database operations for users
+type UserDAO struct {
+	dbManager DBManagerInterface
+}
+
+// User represents a user account in the database
+type User struct {
+	ID           string    // Unique identifier
+	Username     string    // Name the user logs in with
+	PasswordHash string    // Hash of the user's password
+	Scopes       []string  // Scopes granted to the user
+	TOTPSecret   string    // Base32 TOTP secret, empty without two-factor authentication
+	TOTPEnabled  bool      // Whether logins need a TOTP or recovery code
+	CreatedAt    time.Time // Creation timestamp
+}
+
+// NewUserDAO creates a new UserDAO
+func NewUserDAO(dbManager DBManagerInterface) *UserDAO {
+	return &UserDAO{
+		dbManager: dbManager,
+	}
+}
+
+// CreateUser creates a user with a password hash and the scopes granted to the user
+// Returns the ID of the new user
+func (dao *UserDAO) CreateUser(username string, passwordHash string, scopes []string) (string, error) {
+	// Validate input
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return "", errors.New("username cannot be empty")
+	}
+	if passwordHash == "" {
+		return "", errors.New("password hash cannot be empty")
+	}
+
+	// Generate a unique ID
+	id := uuid.New().String()
+
+	// Prepare the SQL statement
+	query := `
+		INSERT INTO users (id, username, password_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id
+	`
+
+	// Execute the query with retry logic
+	var returnedID string
+	err := dao.dbManager.QueryRowWithRetry(query, id, username, passwordHash, JoinScopes(scopes)).Scan(&returnedID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return returnedID, nil
+}
+
+// GetUserByUsername retrieves a user by username
+func (dao *UserDAO) GetUserByUsername(username string) (*User, error) {
+	// Validate input
+	if username == "" {
+		return nil, errors.New("username cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT id, username, password_hash, scopes, totp_secret, totp_enabled, created_at
+		FROM users
+		WHERE username = $1
+	`
+
+	return dao.getUser(query, username, fmt.Sprintf("user %s", username))
+}
+
+// GetUserByID retrieves a user by ID
+func (dao *UserDAO) GetUserByID(id string) (*User, error) {
+	// Validate input
+	if id == "" {
+		return nil, errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		SELECT id, username, password_hash, scopes, totp_secret, totp_enabled, created_at
+		FROM users
+		WHERE id = $1
+	`
+
+	return dao.getUser(query, id, fmt.Sprintf("user with ID %s", id))
+}
+
+// getUser runs a query returning a single user
+// The description names the user in the not found error
+func (dao *UserDAO) getUser(query string, arg string, description string) (*User, error) {
+	var user User
+	var scopes string
+	var totpSecret sql.NullString
+	err := dao.dbManager.QueryRowWithRetry(query, arg).Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&scopes,
+		&totpSecret,
+		&user.TOTPEnabled,
+		&user.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s %w", description, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	user.Scopes = SplitScopes(scopes)
+	user.TOTPSecret = totpSecret.String
+
+	return &user, nil
+}
+
+// UpdatePasswordHash replaces the password hash of a user
+func (dao *UserDAO) UpdatePasswordHash(id string, passwordHash string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+	if passwordHash == "" {
+		return errors.New("password hash cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET password_hash = $2
+		WHERE id = $1
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, id, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// UpdateScopes replaces the scopes granted to a user
+func (dao *UserDAO) UpdateScopes(id string, scopes []string) error {
+	// Validate input
+	if id == "" {
+		return errors.New("id cannot be empty")
+	}
+
+	// Prepare the SQL statement
+	query := `
+		UPDATE users
+		SET scopes = $2
+		WHERE id = $1
+	`
+
+	// Execute the query with retry logic
+	result, err := dao.dbManager.ExecWithRetry(query, id, JoinScopes(scopes))
+	if err != nil {
+		return fmt.Errorf("failed to update scopes: %w", err)
+	}
+
+	// Check if any rows were affected
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %s %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+// JoinScopes converts a list of scopes to the space separated form stored in the database
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// SplitScopes converts the space separated scopes stored in the database to a list
+func SplitScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
@@ -0,0 +1,194 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+// maxAPIKeyRequestSize is the maximum size of a request creating an API key
+const maxAPIKeyRequestSize = 4096
+
+// APIKeyRequest is the body of a request creating an API key
+type APIKeyRequest struct {
+	// Name describes what the key is used for
+	Name string `json:"name"`
+	// ExpiresIn is how long the key stays valid, as a Go duration such as "720h", empty for no expiry
+	ExpiresIn string `json:"expires_in,omitempty"`
+	// Scopes granted to the key, empty for every scope of the user
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// CreatedAPIKey is the response to a request creating an API key
+type CreatedAPIKey struct {
+	*database.APIKey
+	// Key is the API key itself, which is only shown once
+	Key string `json:"key"`
+}
+
+// APIKeysHandler lists the API keys of the current user
+// This handler is protected by the auth middleware
+func APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := apiKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := store.ListAPIKeys(user.ID)
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error listing API keys", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d API keys", len(keys)),
+		Data:    keys,
+	})
+}
+
+// CreateAPIKeyHandler creates an API key for the current user
+// The key is returned once in the response and only its hash is stored
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := apiKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	request, err := readAPIKeyRequest(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	expiresAt, err := middleware.ParseAPIKeyExpiry(request.ExpiresIn)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if strings.TrimSpace(request.Name) == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "name is required", nil)
+		return
+	}
+
+	// Keys get the scopes of the user unless fewer are requested, but never more
+	scopes, err := models.NormalizeScopes(request.Scopes)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if len(scopes) == 0 {
+		scopes = user.Scopes
+	}
+	if !user.HasScopes(scopes...) {
+		writeErrorResponse(w, http.StatusForbidden, "API keys cannot have scopes the user does not have", nil)
+		return
+	}
+
+	apiKey, key, err := middleware.CreateAPIKey(store, user.ID, request.Name, scopes, expiresAt)
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error creating API key", err))
+		return
+	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventAPIKeyCreated, TargetID: apiKey.ID, Details: "scopes=" + strings.Join(apiKey.Scopes, " ")})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: "API key created, store the key now as it cannot be shown again",
+		Data:    CreatedAPIKey{APIKey: apiKey, Key: key},
+	})
+}
+
+// RevokeAPIKeyHandler revokes one of the API keys of the current user
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := apiKeyRequest(w, r)
+	if !ok {
+		return
+	}
+	keyID := mux.Vars(r)["key_id"]
+
+	// Users can only revoke their own keys, other keys are reported as not found
+	apiKey, err := store.GetAPIKeyByID(keyID)
+	if err == nil && apiKey.UserID != user.ID {
+		err = database.ErrNotFound
+	}
+	if err == nil {
+		err = store.RevokeAPIKey(keyID)
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("API key not found: %s", keyID), nil)
+		return
+	}
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error revoking API key", err))
+		return
+	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventAPIKeyRevoked, TargetID: keyID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("API key %s revoked", keyID),
+	})
+}
+
+// readAPIKeyRequest reads the JSON or form body of a request creating an API key
+func readAPIKeyRequest(r *http.Request) (APIKeyRequest, error) {
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		var request APIKeyRequest
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAPIKeyRequestSize))
+		if err != nil {
+			return APIKeyRequest{}, fmt.Errorf("error reading request body: %w", err)
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return APIKeyRequest{}, fmt.Errorf("error parsing JSON data: %w", err)
+		}
+		return request, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return APIKeyRequest{}, fmt.Errorf("error parsing form data: %w", err)
+	}
+	scopes, err := models.ParseScopes(r.FormValue("scopes"))
+	if err != nil {
+		return APIKeyRequest{}, err
+	}
+	return APIKeyRequest{Name: r.FormValue("name"), ExpiresIn: r.FormValue("expires_in"), Scopes: scopes}, nil
+}
+
+// apiKeyRequest returns the API key store and the user of an API key management request
+// It writes an error response and returns false when the user is unknown, or when the request
+// is itself authenticated with an API key, so a leaked key cannot be used to create more keys
+func apiKeyRequest(w http.ResponseWriter, r *http.Request) (middleware.APIKeyStore, middleware.Identity, bool) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		writeErrorResponse(w, http.StatusUnauthorized, "authentication required", nil)
+		return nil, middleware.Identity{}, false
+	}
+	if user.APIKeyID != "" {
+		writeErrorResponse(w, http.StatusForbidden, "API keys cannot manage API keys, log in to manage them", nil)
+		return nil, middleware.Identity{}, false
+	}
+
+	store, err := middleware.APIKeys()
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error accessing API keys", err))
+		return nil, middleware.Identity{}, false
+	}
+	return store, user, true
+}
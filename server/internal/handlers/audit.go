@@ -0,0 +1,94 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+// AuditEventsHandler lists the recorded audit events, newest first
+// The query string filters the events by type, outcome, user_id, username and target_id,
+// by time with since and until as RFC 3339 timestamps, and pages with limit and before_id
+// This handler is protected by the auth middleware and needs the admin scope
+func AuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	events, err := audit.Query(filter)
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error listing audit events", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d audit events", len(events)),
+		Data:    events,
+	})
+}
+
+// parseAuditFilter reads the filter of an audit event query
+func parseAuditFilter(query url.Values) (audit.Filter, error) {
+	filter := audit.Filter{
+		Type:     query.Get("type"),
+		Outcome:  query.Get("outcome"),
+		UserID:   query.Get("user_id"),
+		Username: query.Get("username"),
+		TargetID: query.Get("target_id"),
+	}
+
+	switch filter.Outcome {
+	case "", audit.OutcomeSuccess, audit.OutcomeFailure, audit.OutcomeDenied:
+	default:
+		return audit.Filter{}, fmt.Errorf("outcome must be %s, %s or %s", audit.OutcomeSuccess, audit.OutcomeFailure, audit.OutcomeDenied)
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(query, "since"); err != nil {
+		return audit.Filter{}, err
+	}
+	if filter.Until, err = parseAuditTime(query, "until"); err != nil {
+		return audit.Filter{}, err
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > database.MaxAuditEventLimit {
+			return audit.Filter{}, fmt.Errorf("limit must be a number from 1 to %d", database.MaxAuditEventLimit)
+		}
+	}
+	if value := query.Get("before_id"); value != "" {
+		filter.BeforeID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || filter.BeforeID < 1 {
+			return audit.Filter{}, errors.New("before_id must be the ID of an audit event")
+		}
+	}
+
+	return filter, nil
+}
+
+// parseAuditTime reads an RFC 3339 timestamp from the query string, the zero time if it is not set
+func parseAuditTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp such as 2026-01-02T15:04:05Z", name)
+	}
+	return parsed, nil
+}
@@ -2,98 +2,90 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"html"
+	"log"
 	"net/http"
-	"strings"
+	"net/url"
 
 	"github.com/CJFEdu/allmitools/server/internal/middleware"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
 )
 
 // LoginHandler handles requests to the login page
+// After logging in, the user returns to the page in the next parameter, or to the home page
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := loginNext(r)
+
 	// Check if this is a POST request (login attempt)
 	if r.Method == http.MethodPost {
-		// Get the password from the request
-		var password string
-		
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				http.Error(w, "Error parsing form data", http.StatusBadRequest)
-				return
-			}
-
-			// Get the password from the form
-			password = r.FormValue("password")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var loginData struct {
-				Password string `json:"password"`
-			}
-
-			// Limit request body size to prevent DoS attacks
-			body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
-			if err != nil {
-				http.Error(w, "Error reading request body", http.StatusBadRequest)
-				return
-			}
-
-			// Restore the request body so it can be read by other handlers
-			r.Body.Close()
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Get the username and password from the request
+		credentials, err := middleware.ReadCredentials(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			// Decode JSON
-			if err := json.Unmarshal(body, &loginData); err != nil {
-				http.Error(w, "Error parsing JSON data", http.StatusBadRequest)
+		// Verify the credentials, unless there were too many failed attempts
+		identity, err := middleware.AuthenticateLogin(r, credentials)
+		if errors.Is(err, middleware.ErrTOTPRequired) {
+			// The password is correct, ask for the second factor
+			if err := middleware.StartPendingLogin(w, identity); err != nil {
+				log.Printf("Error starting login for user %s: %v", credentials.Username, err)
+				http.Error(w, "Error starting login", http.StatusServiceUnavailable)
 				return
 			}
-
-			// Get the password from JSON
-			password = loginData.Password
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			http.Redirect(w, r, withNext("/login/totp", next), http.StatusSeeOther)
+			return
+		}
+		if err == nil {
+			// Credentials are correct, start a session and return to the requested page
+			if _, err := middleware.Login(w, r, identity); err != nil {
+				log.Printf("Error starting session for user %s: %v", credentials.Username, err)
+				http.Error(w, "Error starting session", http.StatusServiceUnavailable)
 				return
 			}
-
-			// Get the password from the form
-			password = r.FormValue("password")
-		}
-		
-		// Verify the password
-		if password != "" && verifyPassword(password) {
-			// Password is correct, set cookie and redirect to home page
-			middleware.SetAuthCookie(w)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, next, http.StatusSeeOther)
 			return
 		}
+		message := "Invalid username or password. Please try again."
+		var throttled *middleware.ThrottledError
+		if errors.As(err, &throttled) {
+			// Too many failed attempts, ask the client to wait
+			message = fmt.Sprintf("Too many failed login attempts. Please try again in %s seconds.", throttled.RetryAfterSeconds())
+			w.Header().Set("Retry-After", throttled.RetryAfterSeconds())
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if !errors.Is(err, middleware.ErrInvalidCredentials) {
+			log.Printf("Error authenticating user %s: %v", credentials.Username, err)
+		}
 
-		// Password is incorrect, render login page with error
+		// Credentials are incorrect, render login page with error
 		data := map[string]interface{}{
 			"Title":       "Login",
 			"CurrentPage": "login",
-			"Error":       "Invalid password. Please try again.",
+			"Error":       message,
+			"Username":    credentials.Username,
+			"Next":        nextField(next),
+			"OIDC":        middleware.OIDCEnabled(),
 		}
 
 		// Render the login template
-		err := templates.TemplateManager.RenderTemplate(w, "login", data)
+		err = templates.TemplateManager.RenderTemplate(w, r, loginTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
+			// The token is created before the page is written, so a new CSRF cookie is still sent
+			csrfToken := middleware.CSRFToken(r)
 			fmt.Fprintf(w, "<html><body>")
 			fmt.Fprintf(w, "<h1>Login</h1>")
-			fmt.Fprintf(w, "<p style='color: red;'>Invalid password. Please try again.</p>")
+			fmt.Fprintf(w, "<p style='color: red;'>%s</p>", message)
 			fmt.Fprintf(w, "<form method='post' action='/login'>")
+			fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.CSRFFieldName, csrfToken)
+			fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.NextParam, html.EscapeString(next))
+			fmt.Fprintf(w, "<label for='username'>Username:</label><br>")
+			fmt.Fprintf(w, "<input type='text' id='username' name='username'><br><br>")
 			fmt.Fprintf(w, "<label for='password'>Password:</label><br>")
 			fmt.Fprintf(w, "<input type='password' id='password' name='password'><br><br>")
 			fmt.Fprintf(w, "<input type='submit' value='Login'>")
@@ -107,16 +99,23 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"Title":       "Login",
 		"CurrentPage": "login",
+		"Next":        nextField(next),
+		"OIDC":        middleware.OIDCEnabled(),
 	}
 
 	// Render the login template
-	err := templates.TemplateManager.RenderTemplate(w, "login", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, loginTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
+		csrfToken := middleware.CSRFToken(r)
 		fmt.Fprintf(w, "<html><body>")
 		fmt.Fprintf(w, "<h1>Login</h1>")
 		fmt.Fprintf(w, "<form method='post' action='/login'>")
+		fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.CSRFFieldName, csrfToken)
+		fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.NextParam, html.EscapeString(next))
+		fmt.Fprintf(w, "<label for='username'>Username:</label><br>")
+		fmt.Fprintf(w, "<input type='text' id='username' name='username'><br><br>")
 		fmt.Fprintf(w, "<label for='password'>Password:</label><br>")
 		fmt.Fprintf(w, "<input type='password' id='password' name='password'><br><br>")
 		fmt.Fprintf(w, "<input type='submit' value='Login'>")
@@ -125,17 +124,114 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// LoginTOTPHandler handles the second step of a login for users with two-factor authentication
+// It asks for a TOTP code or a recovery code once LoginHandler accepted the password
+func LoginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	next := loginNext(r)
+
+	// The password must have been accepted in the last few minutes
+	identity, ok := middleware.PendingLogin(r)
+	if !ok {
+		http.Redirect(w, r, withNext("/login", next), http.StatusSeeOther)
+		return
+	}
+
+	// This is a GET request, ask for the code
+	if r.Method != http.MethodPost {
+		renderLoginTOTP(w, r, http.StatusOK, next, "")
+		return
+	}
+
+	code, err := readCode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Verify the code, unless there were too many failed attempts
+	identity, err = middleware.AuthenticateTOTP(r, identity, code)
+	if err == nil {
+		// The code is correct, start a session and return to the requested page
+		middleware.ClearPendingLogin(w)
+		if _, err := middleware.Login(w, r, identity); err != nil {
+			log.Printf("Error starting session for user %s: %v", identity.Username, err)
+			http.Error(w, "Error starting session", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	var throttled *middleware.ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.RetryAfterSeconds())
+		renderLoginTOTP(w, r, http.StatusTooManyRequests, next,
+			fmt.Sprintf("Too many failed login attempts. Please try again in %s seconds.", throttled.RetryAfterSeconds()))
+	case errors.Is(err, middleware.ErrInvalidTOTP):
+		renderLoginTOTP(w, r, http.StatusOK, next, "Invalid authentication code. Please try again.")
+	default:
+		log.Printf("Error checking the authentication code of user %s: %v", identity.Username, err)
+		http.Error(w, "Error checking authentication code", http.StatusServiceUnavailable)
+	}
+}
+
+// renderLoginTOTP renders the page asking for the second factor of a login
+func renderLoginTOTP(w http.ResponseWriter, r *http.Request, status int, next string, message string) {
+	data := map[string]interface{}{
+		"Title":       "Two-Factor Authentication",
+		"CurrentPage": "login",
+		"Error":       message,
+		"Next":        nextField(next),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.TemplateManager.RenderTemplate(w, r, loginTOTPTemplate, data); err != nil {
+		// Fallback if template rendering fails
+		csrfToken := middleware.CSRFToken(r)
+		fmt.Fprintf(w, "<html><body>")
+		fmt.Fprintf(w, "<h1>Two-Factor Authentication</h1>")
+		if message != "" {
+			fmt.Fprintf(w, "<p style='color: red;'>%s</p>", message)
+		}
+		fmt.Fprintf(w, "<form method='post' action='/login/totp'>")
+		fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.CSRFFieldName, csrfToken)
+		fmt.Fprintf(w, "<input type='hidden' name='%s' value='%s'>", middleware.NextParam, html.EscapeString(next))
+		fmt.Fprintf(w, "<label for='code'>Authentication code:</label><br>")
+		fmt.Fprintf(w, "<input type='text' id='code' name='code' autocomplete='one-time-code'><br><br>")
+		fmt.Fprintf(w, "<input type='submit' value='Verify'>")
+		fmt.Fprintf(w, "</form>")
+		fmt.Fprintf(w, "</body></html>")
+	}
+}
+
+// loginNext returns the validated page to return to after logging in, from the login form or the query string
+func loginNext(r *http.Request) string {
+	return middleware.SafeRedirect(r.FormValue(middleware.NextParam))
+}
+
+// nextField returns the value of the hidden next field of the login forms, empty for the home page
+func nextField(next string) string {
+	if next == "/" {
+		return ""
+	}
+	return next
+}
+
+// withNext adds the page to return to after logging in to a login page URL
+func withNext(path string, next string) string {
+	if next == "/" {
+		return path
+	}
+	return path + "?" + url.Values{middleware.NextParam: {next}}.Encode()
+}
+
 // LogoutHandler handles logout requests
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear the auth cookie
-	middleware.ClearAuthCookie(w)
+	// Delete the session and clear the auth cookie
+	middleware.Logout(w, r)
 	
 	// Redirect to home page
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
-
-// verifyPassword verifies the provided password
-func verifyPassword(password string) bool {
-	// Use the middleware function to verify the password
-	return middleware.VerifyPassword(password)
-}
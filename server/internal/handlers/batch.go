@@ -0,0 +1,165 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+const (
+	// DefaultBatchMaxSize is the default maximum number of items in a batch
+	DefaultBatchMaxSize = 100
+	// DefaultBatchConcurrency is the default number of batch items executed at the same time
+	DefaultBatchConcurrency = 4
+	// maxBatchBodySize is the maximum size of a batch request body in bytes
+	maxBatchBodySize = 10 << 20
+)
+
+// BatchConfig holds the limits for batch execution
+type BatchConfig struct {
+	MaxSize     int // Maximum number of items in a single batch
+	Concurrency int // Maximum number of items executed at the same time
+}
+
+// BatchRequest is the body of a batch request
+// Each item is a set of parameters for one execution of the tool
+type BatchRequest struct {
+	Items []map[string]interface{} `json:"items"`
+}
+
+// BatchItemResult is the outcome of a single batch item
+type BatchItemResult struct {
+	Index   int                `json:"index"`
+	Success bool               `json:"success"`
+	Result  any                `json:"result,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Code    tools.ErrorCode    `json:"code,omitempty"`
+	Errors  []tools.FieldError `json:"errors,omitempty"`
+}
+
+// BatchResult is the data returned by a batch
+type BatchResult struct {
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Items     []BatchItemResult `json:"items"`
+}
+
+// BatchHandler returns a handler that runs a public tool once for every parameter set in the request
+// Items run concurrently within the configured limit and report their own success or error
+func BatchHandler(config BatchConfig) http.HandlerFunc {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultBatchMaxSize
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultBatchConcurrency
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		toolName := mux.Vars(r)["tool_name"]
+
+		tool, err := models.GetTool(toolName)
+		if err != nil {
+			writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Tool not found: %s", toolName), nil)
+			return
+		}
+
+		var batch BatchRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+		decoder.UseNumber()
+		if err := decoder.Decode(&batch); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("error parsing batch: %v", err), nil)
+			return
+		}
+		if len(batch.Items) == 0 {
+			writeErrorResponse(w, http.StatusBadRequest, "batch has no items", nil)
+			return
+		}
+		if len(batch.Items) > config.MaxSize {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("batch has %d items, the maximum is %d", len(batch.Items), config.MaxSize), nil)
+			return
+		}
+
+		items := runBatch(r, tool, batch.Items, config.Concurrency)
+
+		result := BatchResult{Total: len(items), Items: items}
+		for _, item := range items {
+			if item.Success {
+				result.Succeeded++
+			} else {
+				result.Failed++
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ToolResponse{
+			Success: true,
+			Message: fmt.Sprintf("Tool '%s' executed for %d items, %d failed", toolName, result.Total, result.Failed),
+			Data:    result,
+		})
+	}
+}
+
+// runBatch executes the tool for every item with at most concurrency items running at once
+// Results are returned in the order of the items
+func runBatch(r *http.Request, tool models.Tool, items []map[string]interface{}, concurrency int) []BatchItemResult {
+	results := make([]BatchItemResult, len(items))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, params := range items {
+		// Stop starting new items once the client has gone away
+		select {
+		case semaphore <- struct{}{}:
+		case <-r.Context().Done():
+			results[i] = BatchItemResult{Index: i, Error: r.Context().Err().Error()}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, params map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			// net/http only recovers panics on the handler goroutine,
+			// a panicking tool here would otherwise stop the server
+			defer func() {
+				if p := recover(); p != nil {
+					err := tools.InternalError("tool failed", fmt.Errorf("panic in batch item %d: %v", i, p))
+					results[i] = BatchItemResult{Index: i, Error: errorMessage(err), Code: tools.CodeOf(err)}
+				}
+			}()
+			results[i] = runBatchItem(r, tool, i, params)
+		}(i, params)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// runBatchItem executes the tool for a single batch item
+func runBatchItem(r *http.Request, tool models.Tool, index int, params map[string]interface{}) BatchItemResult {
+	item := BatchItemResult{Index: index}
+
+	toolReq, err := NewToolRequest(r, params)
+	if err == nil {
+		item.Result, err = tool.Execute(toolReq)
+	}
+	if err != nil {
+		item.Result = nil
+		item.Error = errorMessage(err)
+		item.Code = tools.CodeOf(err)
+		item.Errors = fieldErrors(err)
+		return item
+	}
+
+	item.Success = true
+	return item
+}
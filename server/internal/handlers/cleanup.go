@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/audit"
 	"github.com/CJFEdu/allmitools/server/internal/database"
 	"github.com/CJFEdu/allmitools/server/internal/logging"
 	"github.com/CJFEdu/allmitools/server/internal/middleware"
@@ -24,6 +25,7 @@ type CleanupResult struct {
 
 // DatabaseCleanupHandler handles requests to clean up the database
 // This handler is protected by the auth middleware
+// Each cleanup is recorded as an audit event
 func DatabaseCleanupHandler(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
@@ -53,9 +55,10 @@ func DatabaseCleanupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete expired text entries (older than 7 days with save_flag=false)
-	textEntriesRemoved, err := dao.DeleteExpiredEntries(7 * 24 * time.Hour)
+	// Delete expired text entries (expires_at has passed and save_flag=false)
+	textEntriesRemoved, err := dao.DeleteExpiredEntries()
 	if err != nil {
+		middleware.RecordEvent(r, audit.Event{Type: audit.EventCleanup, Outcome: audit.OutcomeFailure, Details: err.Error()})
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"error": fmt.Sprintf("Failed to clean up text entries: %v", err),
@@ -79,6 +82,11 @@ func DatabaseCleanupHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Database cleanup completed: %d expired text entries and %d request logs removed", 
 		textEntriesRemoved, logEntriesRemoved)
 
+	middleware.RecordEvent(r, audit.Event{
+		Type:    audit.EventCleanup,
+		Details: fmt.Sprintf("text_entries_removed=%d log_entries_removed=%d", textEntriesRemoved, logEntriesRemoved),
+	})
+
 	// Create the result
 	result := CleanupResult{
 		Success:            true,
@@ -101,6 +109,9 @@ func ScheduledDatabaseCleanup() {
 
 	// Clean up old request logs
 	cleanupRequestLogs()
+
+	// Clean up expired sessions
+	cleanupSessions()
 }
 
 // cleanupTextEntries removes expired text entries from the database
@@ -112,8 +123,8 @@ func cleanupTextEntries() {
 		return
 	}
 
-	// Delete expired text entries (older than 7 days with save_flag=false)
-	entriesRemoved, err := dao.DeleteExpiredEntries(7 * 24 * time.Hour)
+	// Delete expired text entries (expires_at has passed and save_flag=false)
+	entriesRemoved, err := dao.DeleteExpiredEntries()
 	if err != nil {
 		log.Printf("Scheduled cleanup error: Failed to clean up text entries: %v", err)
 		return
@@ -142,3 +153,27 @@ func cleanupRequestLogs() {
 	// Log the cleanup operation
 	log.Printf("Scheduled request logs cleanup completed: %d logs removed", logsRemoved)
 }
+
+// cleanupSessions removes expired sessions when the server-side session store is enabled
+func cleanupSessions() {
+	if middleware.Sessions() == nil {
+		return
+	}
+
+	// Get the session DAO
+	dao, err := database.GetSessionDAO()
+	if err != nil {
+		log.Printf("Scheduled cleanup error: Failed to get database connection: %v", err)
+		return
+	}
+
+	// Delete expired sessions
+	sessionsRemoved, err := dao.DeleteExpiredSessions()
+	if err != nil {
+		log.Printf("Scheduled cleanup error: Failed to clean up sessions: %v", err)
+		return
+	}
+
+	// Log the cleanup operation
+	log.Printf("Scheduled sessions cleanup completed: %d expired sessions removed", sessionsRemoved)
+}
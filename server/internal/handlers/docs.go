@@ -44,7 +44,7 @@ func DocsBaseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Render the template
-	err := templates.TemplateManager.RenderTemplate(w, "docs_base", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, docsBaseTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
@@ -90,7 +90,7 @@ func DocsToolHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		
 		// Render the 404 template
-		err := templates.TemplateManager.RenderTemplate(w, "404", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, notFoundTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -122,7 +122,7 @@ func DocsToolHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Render the template
-	err = templates.TemplateManager.RenderTemplate(w, "docs_tool", data)
+	err = templates.TemplateManager.RenderTemplate(w, r, docsToolTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
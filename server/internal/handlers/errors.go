@@ -3,11 +3,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
 )
 
 // NotFoundHandler handles 404 errors
@@ -21,6 +24,7 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
 			"error":   fmt.Sprintf("404 - Page Not Found: %s", r.URL.Path),
+			"code":    tools.CodeNotFound,
 		})
 		return
 	}
@@ -33,10 +37,97 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Render the template
-	err := templates.TemplateManager.RenderTemplate(w, "404", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, notFoundTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
 		fmt.Fprintf(w, "404 - Page Not Found: %s", r.URL.Path)
 	}
 }
+
+// errorStatuses maps error codes to HTTP status codes
+var errorStatuses = map[tools.ErrorCode]int{
+	tools.CodeValidation:         http.StatusUnprocessableEntity,
+	tools.CodeNotFound:           http.StatusNotFound,
+	tools.CodeGone:               http.StatusGone,
+	tools.CodeUnauthorized:       http.StatusUnauthorized,
+	tools.CodeForbidden:          http.StatusForbidden,
+	tools.CodeConflict:           http.StatusConflict,
+	tools.CodePreconditionFailed: http.StatusPreconditionFailed,
+	tools.CodeUnavailable:        http.StatusServiceUnavailable,
+	tools.CodeInternal:           http.StatusInternalServerError,
+	tools.CodeBadRequest:         http.StatusBadRequest,
+	tools.CodeNotAcceptable:      http.StatusNotAcceptable,
+	tools.CodeRequestTooLarge:    http.StatusRequestEntityTooLarge,
+	tools.CodeTooManyRequests:    http.StatusTooManyRequests,
+}
+
+// errorStatus returns the HTTP status code for an error code
+func errorStatus(code tools.ErrorCode) int {
+	if status, ok := errorStatuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// statusCode returns the error code for an HTTP status code
+func statusCode(status int) tools.ErrorCode {
+	for code, s := range errorStatuses {
+		if s == status {
+			return code
+		}
+	}
+	return tools.CodeInternal
+}
+
+// fieldErrors returns the rejected parameters of a validation error, or nil for other errors
+func fieldErrors(err error) []tools.FieldError {
+	var validationErr *tools.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	return nil
+}
+
+// errorMessage returns the message of an error sent to the client
+// Errors with a 5xx status wrap the errors of the database and other upstream services,
+// which can reveal queries and connection details, so they are logged and only their public message is sent
+func errorMessage(err error) string {
+	if errorStatus(tools.CodeOf(err)) < http.StatusInternalServerError {
+		return err.Error()
+	}
+	log.Printf("Error handling request: %v", err)
+	var toolErr *tools.Error
+	if errors.As(err, &toolErr) {
+		return toolErr.Message
+	}
+	return "internal error"
+}
+
+// writeToolError writes a failed tool execution as a JSON error response
+// The status code follows from the error code, see tools.CodeOf
+func writeToolError(w http.ResponseWriter, err error) {
+	code := tools.CodeOf(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorStatus(code))
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: false,
+		Error:   errorMessage(err),
+		Code:    code,
+		Errors:  fieldErrors(err),
+	})
+}
+
+// writeErrorResponse writes a failed request as a JSON ToolResponse with optional data
+// The error code follows from the status code
+func writeErrorResponse(w http.ResponseWriter, status int, message string, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: false,
+		Error:   message,
+		Code:    statusCode(status),
+		Data:    data,
+	})
+}
@@ -25,7 +25,7 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	tools := models.ListTools()
 	
 	// Check if the user is authenticated
-	isAuthenticated := middleware.IsAuthenticated(r)
+	user, isAuthenticated := middleware.CurrentUser(r)
 	
 	// Check if the client accepts JSON
 	if strings.Contains(r.Header.Get("Accept"), "application/json") {
@@ -48,10 +48,11 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		"CurrentPage":     "home",
 		"Tools":           tools,
 		"IsAuthenticated": isAuthenticated,
+		"Username":        user.Username,
 	}
 	
 	// Render the template
-	err := templates.TemplateManager.RenderTemplate(w, "home", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, homeTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
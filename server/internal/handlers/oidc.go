@@ -0,0 +1,87 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/oidc"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+)
+
+// OIDCLoginHandler starts a single sign-on login by redirecting to the identity provider
+// The page in the next parameter is kept with the login and returned to afterwards
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := loginNext(r)
+	authURL, err := middleware.StartOIDCLogin(w, r, next)
+	if errors.Is(err, middleware.ErrOIDCDisabled) {
+		NotFoundHandler(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting single sign-on login: %v", err)
+		renderLoginError(w, r, http.StatusBadGateway, next, "Single sign-on is not available right now. Please try again later.")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// OIDCCallbackHandler completes a single sign-on login when the identity provider redirects back
+// The user of the ID token is logged in and returns to the page the login started from
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	identity, next, err := middleware.FinishOIDCLogin(w, r)
+	switch {
+	case err == nil:
+	case errors.Is(err, middleware.ErrOIDCDisabled):
+		NotFoundHandler(w, r)
+		return
+	case errors.Is(err, middleware.ErrOIDCLoginExpired):
+		renderLoginError(w, r, http.StatusBadRequest, next, "The single sign-on login expired. Please try again.")
+		return
+	case errors.Is(err, middleware.ErrOIDCNotAllowed):
+		renderLoginError(w, r, http.StatusForbidden, next, "Your account is not allowed to use AllMiTools.")
+		return
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		log.Printf("Rejected single sign-on login: %v", err)
+		renderLoginError(w, r, http.StatusUnauthorized, next, "The single sign-on login could not be verified. Please try again.")
+		return
+	default:
+		log.Printf("Error completing single sign-on login: %v", err)
+		renderLoginError(w, r, http.StatusBadGateway, next, "Single sign-on failed. Please try again later.")
+		return
+	}
+
+	// Start a session and return to the requested page
+	if _, err := middleware.Login(w, r, identity); err != nil {
+		log.Printf("Error starting session for user %s: %v", identity.Username, err)
+		http.Error(w, "Error starting session", http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// renderLoginError renders the login page with an error message
+func renderLoginError(w http.ResponseWriter, r *http.Request, status int, next string, message string) {
+	data := map[string]interface{}{
+		"Title":       "Login",
+		"CurrentPage": "login",
+		"Error":       message,
+		"Next":        nextField(next),
+		"OIDC":        middleware.OIDCEnabled(),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.TemplateManager.RenderTemplate(w, r, loginTemplate, data); err != nil {
+		// Fallback if template rendering fails
+		fmt.Fprintf(w, "<html><body>")
+		fmt.Fprintf(w, "<h1>Login</h1>")
+		fmt.Fprintf(w, "<p style='color: red;'>%s</p>", html.EscapeString(message))
+		fmt.Fprintf(w, "<p><a href='/login'>Back to the login page</a></p>")
+		fmt.Fprintf(w, "</body></html>")
+	}
+}
@@ -0,0 +1,79 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/openapi"
+)
+
+// OpenAPIHandler serves the OpenAPI 3 document describing the public tools
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeOpenAPIDocument(w, BuildOpenAPIDocument(false))
+}
+
+// PrivateOpenAPIHandler serves the OpenAPI 3 document describing the public and private tools
+// It is meant to be mounted behind the AuthMiddleware
+func PrivateOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeOpenAPIDocument(w, BuildOpenAPIDocument(true))
+}
+
+// BuildOpenAPIDocument builds the OpenAPI document from the tool registry
+// The private document also includes the private tools and their security scheme
+func BuildOpenAPIDocument(includePrivate bool) *openapi.Document {
+	title := "AllMiTools API"
+	description := "No-code automation tools served by AllMiTools"
+	if includePrivate {
+		title = "AllMiTools Private API"
+		description = "Public and private tools served by AllMiTools. Private tools require authentication."
+	}
+
+	doc := openapi.NewDocument(title, description)
+	for _, info := range models.ListTools() {
+		tool, err := models.GetTool(info.Name)
+		if err != nil {
+			continue
+		}
+		_, isFile := tool.(models.FileTool)
+		doc.AddTool(openapi.Tool{
+			Info: info,
+			Path: "/tools/" + info.Name,
+			File: isFile,
+		})
+	}
+
+	if !includePrivate {
+		return doc
+	}
+
+	doc.AddCookieAuth(middleware.CookieName)
+	doc.AddBearerAuth()
+	for _, info := range models.GetAllPrivateTools() {
+		tool, err := models.GetPrivateTool(info.Name)
+		if err != nil {
+			continue
+		}
+		_, isFile := tool.(models.FileTool)
+		doc.AddTool(openapi.Tool{
+			Info:    info.ToolInfo,
+			Path:    "/private/tools/" + info.Name,
+			File:    isFile,
+			Private: true,
+			Scopes:  info.Scopes,
+		})
+	}
+	return doc
+}
+
+// writeOpenAPIDocument writes an OpenAPI document as JSON
+func writeOpenAPIDocument(w http.ResponseWriter, doc *openapi.Document) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		http.Error(w, "Error encoding OpenAPI document", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,210 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+const (
+	// MaxPipelineSteps is the maximum number of steps in a single pipeline
+	MaxPipelineSteps = 20
+	// maxPipelineBodySize is the maximum size of a pipeline request body in bytes
+	maxPipelineBodySize = 1 << 20
+)
+
+// stepReference matches references to earlier step results such as {{steps.0.result}}
+var stepReference = regexp.MustCompile(`\{\{\s*steps\.(\d+)\.result\s*\}\}`)
+
+// PipelineRequest is the body of a pipeline request
+type PipelineRequest struct {
+	Steps []PipelineStep `json:"steps"`
+}
+
+// PipelineStep is a single tool invocation in a pipeline
+// String parameter values may reference the results of earlier steps with {{steps.N.result}}
+type PipelineStep struct {
+	Tool   string                 `json:"tool"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// PipelineStepResult is the outcome of a single pipeline step
+type PipelineStepResult struct {
+	Step    int                `json:"step"`
+	Tool    string             `json:"tool"`
+	Success bool               `json:"success"`
+	Result  any                `json:"result,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Code    tools.ErrorCode    `json:"code,omitempty"`
+	Errors  []tools.FieldError `json:"errors,omitempty"`
+}
+
+// PipelineResult is the data returned by a pipeline
+type PipelineResult struct {
+	Steps []PipelineStepResult `json:"steps"`
+}
+
+// pipelineStepTool is a resolved pipeline step
+type pipelineStepTool struct {
+	tool    models.Tool
+	private bool
+}
+
+// PipelineHandler runs an ordered list of tool invocations in a single request
+// Steps run in order and stop at the first failure. Private tools only run for authenticated callers with their scopes.
+func PipelineHandler(w http.ResponseWriter, r *http.Request) {
+	var pipeline PipelineRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPipelineBodySize))
+	decoder.UseNumber()
+	if err := decoder.Decode(&pipeline); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("error parsing pipeline: %v", err), nil)
+		return
+	}
+
+	// Resolve and check every step before running anything
+	resolved, status, err := resolvePipeline(r, pipeline)
+	if err != nil {
+		writeErrorResponse(w, status, err.Error(), nil)
+		return
+	}
+
+	results := make([]PipelineStepResult, 0, len(pipeline.Steps))
+	outputs := make([]string, 0, len(pipeline.Steps))
+	for i, step := range pipeline.Steps {
+		stepResult := PipelineStepResult{Step: i, Tool: step.Tool}
+
+		result, err := runPipelineStep(r, resolved[i], substituteStepResults(step.Params, outputs))
+		if err != nil {
+			stepResult.Error = errorMessage(err)
+			stepResult.Code = tools.CodeOf(err)
+			stepResult.Errors = fieldErrors(err)
+			results = append(results, stepResult)
+
+			writeErrorResponse(w, errorStatus(stepResult.Code),
+				fmt.Sprintf("step %d (%s) failed: %s", i, step.Tool, stepResult.Error), PipelineResult{Steps: results})
+			return
+		}
+
+		stepResult.Success = true
+		stepResult.Result = result
+		results = append(results, stepResult)
+		outputs = append(outputs, models.ResultString(result))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("Pipeline with %d steps executed successfully", len(results)),
+		Data:    PipelineResult{Steps: results},
+	})
+}
+
+// resolvePipeline looks up the tool of every step and checks the step references
+// It returns the HTTP status to use when the pipeline cannot run
+func resolvePipeline(r *http.Request, pipeline PipelineRequest) ([]pipelineStepTool, int, error) {
+	if len(pipeline.Steps) == 0 {
+		return nil, http.StatusBadRequest, errors.New("pipeline has no steps")
+	}
+	if len(pipeline.Steps) > MaxPipelineSteps {
+		return nil, http.StatusBadRequest, fmt.Errorf("pipeline has %d steps, the maximum is %d", len(pipeline.Steps), MaxPipelineSteps)
+	}
+
+	resolved := make([]pipelineStepTool, len(pipeline.Steps))
+	authenticated := middleware.IsAuthenticated(r)
+	for i, step := range pipeline.Steps {
+		// Public tools take precedence over private tools with the same name
+		if tool, err := models.GetTool(step.Tool); err == nil {
+			resolved[i] = pipelineStepTool{tool: tool}
+		} else if tool, err := models.GetPrivateTool(step.Tool); err == nil {
+			if !authenticated {
+				return nil, http.StatusUnauthorized, fmt.Errorf("step %d (%s) is a private tool and requires authentication", i, step.Tool)
+			}
+			info, _ := models.GetPrivateToolInfo(step.Tool)
+			if err := authorizePrivateTool(r, info); err != nil {
+				return nil, errorStatus(tools.CodeOf(err)), fmt.Errorf("step %d: %v", i, err)
+			}
+			resolved[i] = pipelineStepTool{tool: tool, private: true}
+		} else {
+			return nil, http.StatusBadRequest, fmt.Errorf("step %d: tool not found: %s", i, step.Tool)
+		}
+
+		// Steps can only reference the results of earlier steps
+		for name, value := range step.Params {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			for _, match := range stepReference.FindAllStringSubmatch(s, -1) {
+				ref, err := strconv.Atoi(match[1])
+				if err != nil || ref >= i {
+					return nil, http.StatusBadRequest, fmt.Errorf("step %d: parameter %s references step %s, which has not run yet", i, name, match[1])
+				}
+			}
+		}
+	}
+
+	return resolved, http.StatusOK, nil
+}
+
+// substituteStepResults replaces the step references in string parameters with the text form of earlier results
+func substituteStepResults(params map[string]interface{}, outputs []string) map[string]interface{} {
+	substituted := make(map[string]interface{}, len(params))
+	for name, value := range params {
+		s, ok := value.(string)
+		if !ok {
+			substituted[name] = value
+			continue
+		}
+		substituted[name] = stepReference.ReplaceAllStringFunc(s, func(match string) string {
+			ref, _ := strconv.Atoi(stepReference.FindStringSubmatch(match)[1])
+			return outputs[ref]
+		})
+	}
+	return substituted
+}
+
+// runPipelineStep executes a single resolved step with the given parameters
+func runPipelineStep(r *http.Request, step pipelineStepTool, params map[string]interface{}) (any, error) {
+	if step.private {
+		// Private tools need the database
+		if _, err := database.GetManager(); err != nil {
+			return nil, tools.UnavailableError("database connection error", err)
+		}
+	}
+
+	toolReq, err := NewToolRequest(r, params)
+	if err != nil {
+		return nil, err
+	}
+	return step.tool.Execute(toolReq)
+}
+
+// NewToolRequest builds a JSON POST request carrying the given tool parameters
+// The request keeps the context, headers and remote address of the original request,
+// so tools see the same client, but its body and content headers are replaced
+func NewToolRequest(r *http.Request, params map[string]interface{}) (*http.Request, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding tool parameters: %v", err)
+	}
+
+	toolReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, r.URL.Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	toolReq.Header = r.Header.Clone()
+	toolReq.Header.Del("Content-Length")
+	toolReq.Header.Set("Content-Type", "application/json")
+	toolReq.RemoteAddr = r.RemoteAddr
+	return toolReq, nil
+}
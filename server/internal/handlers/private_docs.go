@@ -14,7 +14,7 @@ import (
 // PrivateDocsBaseHandler handles requests to the private documentation base page
 func PrivateDocsBaseHandler(w http.ResponseWriter, r *http.Request) {
 	// Get all private tools
-	privateTools := models.GetAllPrivateTools()
+	privateTools := allowedPrivateTools(r)
 
 	// Prepare data for the template
 	data := map[string]interface{}{
@@ -24,7 +24,7 @@ func PrivateDocsBaseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the template
-	err := templates.TemplateManager.RenderTemplate(w, "private_docs_base", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, privateDocsBaseTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
@@ -56,7 +56,7 @@ func PrivateDocsToolHandler(w http.ResponseWriter, r *http.Request) {
 			"CurrentPage": "private-docs",
 		}
 		
-		err := templates.TemplateManager.RenderTemplate(w, "404", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, notFoundTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -78,7 +78,7 @@ func PrivateDocsToolHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the template
-	err = templates.TemplateManager.RenderTemplate(w, "tool_docs", data)
+	err = templates.TemplateManager.RenderTemplate(w, r, privateDocsToolTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
@@ -10,7 +10,9 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
 	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/render"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
 	"github.com/CJFEdu/allmitools/server/internal/tools"
 )
@@ -32,6 +34,7 @@ func PrivateToolsHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(ToolResponse{
 				Success: false,
 				Error:   fmt.Sprintf("Private tool not found: %s", toolName),
+				Code:    tools.CodeNotFound,
 			})
 			return
 		}
@@ -43,7 +46,7 @@ func PrivateToolsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Render the 404 template
-		err := templates.TemplateManager.RenderTemplate(w, "404", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, notFoundTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -56,6 +59,12 @@ func PrivateToolsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check that the caller has the scopes the tool requires
+	if err := authorizePrivateTool(r, toolInfo); err != nil {
+		writeToolError(w, err)
+		return
+	}
+
 	// Determine if we should execute the tool or show the form
 	// Execute if: POST request OR GET request with parameters
 	// Show form if: GET request without parameters
@@ -105,12 +114,14 @@ func PrivateToolsHandler(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
 			"Title":       toolInfo.Name,
 			"CurrentPage": "private-tools",
-			"Tool":        toolInfo,
-			"IsPrivate":   true,
+			"Tool":          toolInfo,
+			"IsPrivate":     true,
+			"Values":        map[string]string{},
+			"OutputFormats": render.Names(),
 		}
 
 		// Render the template
-		err := templates.TemplateManager.RenderTemplate(w, "tool", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, toolTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -125,94 +136,36 @@ func PrivateToolsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Execute the tool (for POST or GET with parameters)
 	// Parse query parameters and execute the appropriate tool
-	var result string
+	var result any
 	var toolErr error
 
 	// Initialize database connection if needed
 	if _, err := database.GetManager(); err != nil {
-		toolErr = fmt.Errorf("database connection error: %w", err)
-		result = ""
+		toolErr = tools.UnavailableError("database connection error", err)
+		result = nil
 	} else {
-		// Execute the appropriate tool based on the tool name
-		switch toolName {
-		case "text-storage":
-			result, toolErr = tools.ExecuteTextStorage(r)
-		case "text-retrieval":
-			result, toolErr = tools.ExecuteTextRetrieval(r)
-		default:
-			// For unknown tools, return an error
-			toolErr = fmt.Errorf("unknown private tool: %s", toolName)
-			result = ""
+		// Execute the registered private tool
+		tool, err := models.GetPrivateTool(toolName)
+		if err != nil {
+			toolErr = tools.NotFoundError(fmt.Sprintf("unknown private tool: %s", toolName))
+		} else {
+			result, toolErr = tool.Execute(r)
 		}
 	}
 
 	// Handle tool execution error
 	if toolErr != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ToolResponse{
-			Success: false,
-			Error:   toolErr.Error(),
-		})
+		writeToolError(w, toolErr)
 		return
 	}
 
-	// Check if the user specified an output format in the form, query parameter, or Accept header
-	outputFormat := ""
-
-	// 1. Check form parameter (highest priority)
-	if r.Method == http.MethodPost {
-		outputFormat = r.FormValue("output_format")
-	}
-
-	// 2. Check query parameter if no form parameter
-	if outputFormat == "" {
-		outputFormat = r.URL.Query().Get("output_format")
-	}
-
-	// 3. Check Accept header if no form or query parameter
-	if outputFormat == "" && strings.Contains(r.Header.Get("Accept"), "application/json") {
-		outputFormat = "json"
-	} else if outputFormat == "" && strings.Contains(r.Header.Get("Accept"), "text/plain") {
-		outputFormat = "raw"
-	}
-
-	// Determine the appropriate output format
-	// Priority: 1. Form parameter, 2. Query parameter, 3. Accept header, 4. Tool's default output type
-	if outputFormat != "" {
-		// Use the format specified by the user (form, query parameter, or Accept header)
-		switch outputFormat {
-		case "json":
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(ToolResponse{
-				Success: true,
-				Message: fmt.Sprintf("Private tool '%s' executed successfully", toolName),
-				Data:    result,
-			})
-			return
-		case "html":
-			w.Header().Set("Content-Type", "text/html")
-			generateHTMLResponse(w, result)
-			return
-		case "raw":
-			w.Header().Set("Content-Type", "text/plain")
-			generateRawResponse(w, result)
-			return
-		}
-	}
-
-	// If no form parameter or invalid format, default to JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ToolResponse{
-		Success: true,
-		Message: fmt.Sprintf("Private tool '%s' executed successfully", toolName),
-		Data:    result,
-	})
+	writeToolResult(w, r, toolInfo.ToolInfo, true, fmt.Sprintf("Private tool '%s' executed successfully", toolName), result)
 }
 
-// PrivateToolsListHandler handles requests to list all available private tools
+// PrivateToolsListHandler handles requests to list the private tools the caller may use
 func PrivateToolsListHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all private tools
-	privateTools := models.GetAllPrivateTools()
+	// Get the private tools the caller has the scopes for
+	privateTools := allowedPrivateTools(r)
 
 	// Check if the client accepts JSON
 	if strings.Contains(r.Header.Get("Accept"), "application/json") {
@@ -233,7 +186,7 @@ func PrivateToolsListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the template
-	err := templates.TemplateManager.RenderTemplate(w, "private_tools_list", data)
+	err := templates.TemplateManager.RenderTemplate(w, r, privateToolsListTemplate, data)
 	if err != nil {
 		// Fallback if template rendering fails
 		w.Header().Set("Content-Type", "text/html")
@@ -247,3 +200,28 @@ func PrivateToolsListHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "</body></html>")
 	}
 }
+
+// authorizePrivateTool checks that the caller of a request may use a private tool
+// It returns an unauthorized error without a user and a forbidden error when scopes are missing
+func authorizePrivateTool(r *http.Request, info models.PrivateToolInfo) error {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		return tools.UnauthorizedError(fmt.Sprintf("private tool %s requires authentication", info.Name))
+	}
+	if !user.HasScopes(info.Scopes...) {
+		return tools.ForbiddenError(fmt.Sprintf("private tool %s requires the scopes: %s", info.Name, strings.Join(info.Scopes, ", ")))
+	}
+	return nil
+}
+
+// allowedPrivateTools returns the private tools the caller of a request has the scopes for
+func allowedPrivateTools(r *http.Request) []models.PrivateToolInfo {
+	user, _ := middleware.CurrentUser(r)
+	allowed := []models.PrivateToolInfo{}
+	for _, info := range models.GetAllPrivateTools() {
+		if user.HasScopes(info.Scopes...) {
+			allowed = append(allowed, info)
+		}
+	}
+	return allowed
+}
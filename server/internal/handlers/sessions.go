@@ -0,0 +1,103 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+// SessionInfo describes a login session of the current user
+type SessionInfo struct {
+	*database.Session
+	// Current is set for the session of the request
+	Current bool `json:"current"`
+}
+
+// SessionsHandler lists the login sessions of the current user
+// This handler is protected by the auth middleware and needs the server-side session store
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := sessionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := store.ListSessions(user.ID)
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error listing sessions", err))
+		return
+	}
+
+	infos := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = SessionInfo{Session: session, Current: session.ID == user.SessionID}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d active sessions", len(infos)),
+		Data:    infos,
+	})
+}
+
+// RevokeSessionHandler logs out one of the sessions of the current user
+// Revoking the session of the request also clears its cookie
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := sessionRequest(w, r)
+	if !ok {
+		return
+	}
+	sessionID := mux.Vars(r)["session_id"]
+
+	// Users can only revoke their own sessions, other sessions are reported as not found
+	session, err := store.GetSession(sessionID)
+	if err == nil && session.UserID != user.ID {
+		err = database.ErrNotFound
+	}
+	if err == nil {
+		err = store.DeleteSession(sessionID)
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("session not found: %s", sessionID), nil)
+		return
+	}
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error revoking session", err))
+		return
+	}
+
+	if sessionID == user.SessionID {
+		middleware.ClearAuthCookie(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: fmt.Sprintf("Session %s revoked", sessionID),
+	})
+}
+
+// sessionRequest returns the session store and the user of a session request
+// It writes an error response and returns false when the store is disabled or the user is unknown
+func sessionRequest(w http.ResponseWriter, r *http.Request) (middleware.SessionStore, middleware.Identity, bool) {
+	store := middleware.Sessions()
+	if store == nil {
+		writeErrorResponse(w, http.StatusNotFound, "server-side sessions are not enabled, set SESSION_STORE=database", nil)
+		return nil, middleware.Identity{}, false
+	}
+
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		writeErrorResponse(w, http.StatusUnauthorized, "authentication required", nil)
+		return nil, middleware.Identity{}, false
+	}
+	return store, user, true
+}
@@ -0,0 +1,19 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import "github.com/CJFEdu/allmitools/server/internal/templates"
+
+// Page templates rendered by the handlers
+// Declaring them with templates.Require makes a missing template fail at startup
+var (
+	homeTemplate             = templates.Require("home")
+	docsBaseTemplate         = templates.Require("docs_base")
+	docsToolTemplate         = templates.Require("docs_tool")
+	toolTemplate             = templates.Require("tool")
+	notFoundTemplate         = templates.Require("404")
+	loginTemplate            = templates.Require("login")
+	loginTOTPTemplate        = templates.Require("login_totp")
+	privateToolsListTemplate = templates.Require("private_tools_list")
+	privateDocsBaseTemplate  = templates.Require("private_docs_base")
+	privateDocsToolTemplate  = templates.Require("private_docs_tool")
+)
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,7 +10,9 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
 	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/render"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
 	"github.com/CJFEdu/allmitools/server/internal/tools"
 )
@@ -20,6 +23,10 @@ type ToolResponse struct {
 	Message string `json:"message,omitempty"`
 	Data    any    `json:"data,omitempty"`
 	Error   string `json:"error,omitempty"`
+	// Code is the machine readable error code of a failed request
+	Code tools.ErrorCode `json:"code,omitempty"`
+	// Errors lists the rejected parameters when the request failed validation
+	Errors []tools.FieldError `json:"errors,omitempty"`
 }
 
 // ToolsHandler handles requests to use specific tools
@@ -27,8 +34,8 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	toolName := vars["tool_name"]
 
-	// Get tool info
-	toolInfo, err := models.GetToolInfo(toolName)
+	// Look up the registered tool
+	tool, err := models.GetTool(toolName)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 
@@ -38,6 +45,7 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(ToolResponse{
 				Success: false,
 				Error:   fmt.Sprintf("Tool not found: %s", toolName),
+				Code:    tools.CodeNotFound,
 			})
 			return
 		}
@@ -49,7 +57,7 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Render the 404 template
-		err := templates.TemplateManager.RenderTemplate(w, "404", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, notFoundTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -61,6 +69,7 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	toolInfo := tool.Info()
 
 	// Determine if we should execute the tool or show the form
 	// Execute if: POST request OR GET request with parameters
@@ -110,12 +119,14 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 		// Default to HTML response using template
 		data := map[string]interface{}{
 			"Title":       toolInfo.Name,
-			"CurrentPage": "tools",
-			"Tool":        toolInfo,
+			"CurrentPage":   "tools",
+			"Tool":          toolInfo,
+			"Values":        map[string]string{},
+			"OutputFormats": render.Names(),
 		}
 
 		// Render the template
-		err := templates.TemplateManager.RenderTemplate(w, "tool", data)
+		err := templates.TemplateManager.RenderTemplate(w, r, toolTemplate, data)
 		if err != nil {
 			// Fallback if template rendering fails
 			w.Header().Set("Content-Type", "text/html")
@@ -129,40 +140,12 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute the tool (for POST or GET with parameters)
-	// Parse query parameters and execute the appropriate tool
-	var result string
-	var toolErr error
-
-	switch toolName {
-	case "random-number":
-		result, toolErr = tools.ExecuteRandomNumber(r)
-	case "random-string":
-		result, toolErr = tools.ExecuteRandomString(r)
-	case "date":
-		result, toolErr = tools.ExecuteDateFormatter(r)
-	case "day":
-		result, toolErr = tools.ExecuteDateComponent("day")
-	case "month":
-		result, toolErr = tools.ExecuteDateComponent("month")
-	case "year":
-		result, toolErr = tools.ExecuteDateComponent("year")
-	case "text-formatter":
-		result, toolErr = tools.ExecuteTextFormatter(r)
-	case "url-encoder":
-		result, toolErr = tools.ExecuteURLEncoder(r)
-	case "sha256-hasher":
-		result, toolErr = tools.ExecuteSHA256Hasher(r)
-	case "text-file":
-		// Special handling for text file tool (returns a file download)
-		fileContent, fileName, err := tools.ExecuteTextFile(r)
+	// Tools that produce a file are served as a download instead of a rendered result
+	if fileTool, ok := tool.(models.FileTool); ok {
+		fileContent, fileName, err := fileTool.ExecuteFile(r)
 		if err != nil {
 			// Return error as JSON
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(ToolResponse{
-				Success: false,
-				Error:   err.Error(),
-			})
+			writeToolError(w, err)
 		} else {
 			// Set headers for file download
 			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
@@ -173,89 +156,63 @@ func ToolsHandler(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(fileContent))
 		}
 		return // Early return as we've already written the response
-	default:
-		// For unknown tools, return an error
-		toolErr = fmt.Errorf("unknown tool: %s", toolName)
-		result = ""
 	}
 
+	result, toolErr := tool.Execute(r)
+
 	// Handle tool execution error
 	if toolErr != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ToolResponse{
-			Success: false,
-			Error:   toolErr.Error(),
-		})
+		writeToolError(w, toolErr)
 		return
 	}
 
-	// Check if the user specified an output format in the form, query parameter, or Accept header
-	outputFormat := ""
+	writeToolResult(w, r, toolInfo, false, fmt.Sprintf("Tool '%s' executed successfully", toolName), result)
+}
 
-	// 1. Check form parameter (highest priority)
+// requestedOutputFormat returns the output format requested in the form or query string
+// The form parameter takes precedence over the query parameter
+func requestedOutputFormat(r *http.Request) string {
 	if r.Method == http.MethodPost {
-		outputFormat = r.FormValue("output_format")
-	}
-
-	// 2. Check query parameter if no form parameter
-	if outputFormat == "" {
-		outputFormat = r.URL.Query().Get("output_format")
-	}
-
-	// 3. Check Accept header if no form or query parameter
-	if outputFormat == "" && strings.Contains(r.Header.Get("Accept"), "application/json") {
-		outputFormat = "json"
-	} else if outputFormat == "" && strings.Contains(r.Header.Get("Accept"), "text/plain") {
-		outputFormat = "raw"
-	}
-
-	// Determine the appropriate output format
-	// Priority: 1. Form parameter, 2. Query parameter, 3. Accept header, 4. Tool's default output type
-	if outputFormat != "" {
-		// Use the format specified by the user (form, query parameter, or Accept header)
-		switch outputFormat {
-		case "json":
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(ToolResponse{
-				Success: true,
-				Message: fmt.Sprintf("Tool '%s' executed successfully", toolName),
-				Data:    result,
-			})
-			return
-		case "html":
-			w.Header().Set("Content-Type", "text/html")
-			generateHTMLResponse(w, result)
-			return
-		case "raw":
-			w.Header().Set("Content-Type", "text/plain")
-			generateRawResponse(w, result)
-			return
+		if outputFormat := r.FormValue("output_format"); outputFormat != "" {
+			return outputFormat
 		}
 	}
-
-	// If no form parameter or invalid format, default to JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ToolResponse{
-		Success: true,
-		Message: fmt.Sprintf("Tool '%s' executed successfully", toolName),
-		Data:    result,
-	})
+	return r.URL.Query().Get("output_format")
 }
 
-// generateHTMLResponse generates an HTML response based on the tool result
-func generateHTMLResponse(w http.ResponseWriter, result string) {
-	fmt.Fprintf(w, "<html><body>")
+// writeToolResult renders a successful tool result in the format requested by the client
+// The tool info and the request parameters are passed on for renderers that show a result page
+// The output_format parameter takes precedence over the Accept header; unknown formats get 406 Not Acceptable
+func writeToolResult(w http.ResponseWriter, r *http.Request, toolInfo models.ToolInfo, private bool, message string, result any) {
+	w.Header().Add("Vary", "Accept")
 
-	// Just display the result in a paragraph
-	fmt.Fprintf(w, "<p>%s</p>", result)
+	renderer, err := render.Select(requestedOutputFormat(r), r.Header.Get("Accept"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusNotAcceptable, err.Error(), map[string]interface{}{
+			"formats": render.Names(),
+		})
+		return
+	}
 
-	fmt.Fprintf(w, "</body></html>")
-}
+	// Render into a buffer so a failing renderer can still report an error
+	var body bytes.Buffer
+	if err := renderer.Render(&body, render.Result{
+		Message:       message,
+		Data:          result,
+		Text:          models.ResultString(result),
+		Tool:          toolInfo,
+		Values:        tools.ParamValues(r, toolInfo.Parameters),
+		Private:       private,
+		Authenticated: middleware.IsAuthenticated(r),
+		Request:       r,
+	}); err != nil {
+		writeToolError(w, tools.InternalError(fmt.Sprintf("error rendering result as %s", renderer.Name()), err))
+		return
+	}
 
-// generateRawResponse generates a raw text response based on the tool result
-func generateRawResponse(w http.ResponseWriter, result string) {
-	// Just write the result string directly
-	fmt.Fprintf(w, "%s", result)
+	if versioned, ok := result.(models.VersionedResult); ok {
+		w.Header().Set("ETag", versioned.ETag())
+	}
+	w.Header().Set("Content-Type", renderer.MediaTypes()[0])
+	w.Write(body.Bytes())
 }
-
-
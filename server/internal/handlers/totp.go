@@ -0,0 +1,219 @@
+// Package handlers contains HTTP handlers for the AllMiTools server
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+// maxCodeRequestSize is the maximum size of a request sending a TOTP or recovery code
+const maxCodeRequestSize = 1024
+
+// TOTPEnrollment is the response to a request starting two-factor authentication
+type TOTPEnrollment struct {
+	// Secret is the base32 TOTP secret, for entering it into an authenticator app by hand
+	Secret string `json:"secret"`
+	// URI is the otpauth URI of the secret, usually shown as a QR code
+	URI string `json:"uri"`
+}
+
+// RecoveryCodes is the response to a request creating recovery codes
+type RecoveryCodes struct {
+	// Codes can each be used once instead of a TOTP code, and are only shown once
+	Codes []string `json:"recovery_codes"`
+}
+
+// TOTPEnrollHandler creates a TOTP secret for the current user
+// Two-factor authentication is enabled once a code of the secret is sent to TOTPConfirmHandler
+func TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := totpRequest(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := middleware.EnrollTOTP(store, user.ID)
+	if errors.Is(err, middleware.ErrTOTPEnabled) {
+		writeErrorResponse(w, http.StatusConflict, "two-factor authentication is already enabled, disable it first", nil)
+		return
+	}
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error enrolling TOTP", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: "Add the secret to an authenticator app and confirm a code to enable two-factor authentication",
+		Data:    TOTPEnrollment{Secret: secret, URI: uri},
+	})
+}
+
+// TOTPConfirmHandler enables two-factor authentication for the current user with a code of the enrolled secret
+// The recovery codes are returned once in the response and only their hashes are stored
+func TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := totpRequest(w, r)
+	if !ok {
+		return
+	}
+
+	code, err := readCode(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	codes, err := middleware.ConfirmTOTP(store, user.ID, code)
+	switch {
+	case errors.Is(err, middleware.ErrTOTPEnabled):
+		writeErrorResponse(w, http.StatusConflict, err.Error(), nil)
+		return
+	case errors.Is(err, middleware.ErrTOTPNotEnrolled), errors.Is(err, middleware.ErrInvalidTOTP):
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	case err != nil:
+		writeToolError(w, tools.UnavailableError("error enabling TOTP", err))
+		return
+	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventTOTPEnabled, UserID: user.ID, Username: user.Username})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: "Two-factor authentication enabled, store the recovery codes now as they cannot be shown again",
+		Data:    RecoveryCodes{Codes: codes},
+	})
+}
+
+// TOTPDisableHandler disables two-factor authentication for the current user
+// A current TOTP code or a recovery code is required, so a stolen session cannot turn it off
+func TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := totpSecondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := store.DisableTOTP(user.ID); err != nil {
+		writeToolError(w, tools.UnavailableError("error disabling TOTP", err))
+		return
+	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventTOTPDisabled, UserID: user.ID, Username: user.Username})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// TOTPRecoveryCodesHandler replaces the recovery codes of the current user
+// A current TOTP code or a recovery code is required
+func TOTPRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	store, user, ok := totpSecondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := middleware.RegenerateRecoveryCodes(store, user.ID)
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error creating recovery codes", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ToolResponse{
+		Success: true,
+		Message: "Recovery codes replaced, store them now as they cannot be shown again",
+		Data:    RecoveryCodes{Codes: codes},
+	})
+}
+
+// totpSecondFactorRequest returns the TOTP store and the user of a request changing two-factor authentication
+// The request must send a current TOTP code or a recovery code of the user, checked like the second step of a login
+func totpSecondFactorRequest(w http.ResponseWriter, r *http.Request) (middleware.TOTPStore, middleware.Identity, bool) {
+	store, user, ok := totpRequest(w, r)
+	if !ok {
+		return nil, middleware.Identity{}, false
+	}
+
+	code, err := readCode(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return nil, middleware.Identity{}, false
+	}
+
+	_, err = middleware.AuthenticateTOTP(r, user, code)
+	var throttled *middleware.ThrottledError
+	switch {
+	case err == nil:
+		return store, user, true
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.RetryAfterSeconds())
+		writeErrorResponse(w, http.StatusTooManyRequests, err.Error(), nil)
+	case errors.Is(err, middleware.ErrTOTPNotEnrolled):
+		writeErrorResponse(w, http.StatusConflict, "two-factor authentication is not enabled", nil)
+	case errors.Is(err, middleware.ErrInvalidTOTP):
+		writeErrorResponse(w, http.StatusBadRequest, err.Error(), nil)
+	default:
+		writeToolError(w, tools.UnavailableError("error checking code", err))
+	}
+	return nil, middleware.Identity{}, false
+}
+
+// totpRequest returns the TOTP store and the user of a request managing two-factor authentication
+// Like API key management, it is only available to logged in users and not to API keys
+func totpRequest(w http.ResponseWriter, r *http.Request) (middleware.TOTPStore, middleware.Identity, bool) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		writeErrorResponse(w, http.StatusUnauthorized, "authentication required", nil)
+		return nil, middleware.Identity{}, false
+	}
+	if user.APIKeyID != "" {
+		writeErrorResponse(w, http.StatusForbidden, "API keys cannot manage two-factor authentication, log in to manage it", nil)
+		return nil, middleware.Identity{}, false
+	}
+
+	store, err := middleware.TOTPs()
+	if err != nil {
+		writeToolError(w, tools.UnavailableError("error accessing users", err))
+		return nil, middleware.Identity{}, false
+	}
+	return store, user, true
+}
+
+// readCode reads the TOTP or recovery code of the JSON or form body of a request
+func readCode(r *http.Request) (string, error) {
+	var code string
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		var request struct {
+			Code string `json:"code"`
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCodeRequestSize))
+		if err != nil {
+			return "", fmt.Errorf("error reading request body: %w", err)
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return "", fmt.Errorf("error parsing JSON data: %w", err)
+		}
+		code = request.Code
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return "", fmt.Errorf("error parsing form data: %w", err)
+		}
+		code = r.PostForm.Get("code")
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", errors.New("code is required")
+	}
+	return code, nil
+}
@@ -2,13 +2,17 @@ package logging
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
 )
 
 // responseWriter is a custom response writer that captures the status code
@@ -71,6 +75,10 @@ func RequestLoggerMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
+		// Track the user authenticated while handling the request
+		ctx, authenticatedUser := middleware.TrackIdentity(r.Context())
+		r = r.WithContext(ctx)
+
 		// Create a custom response writer to capture the status code
 		rw := newResponseWriter(w)
 
@@ -87,12 +95,19 @@ func RequestLoggerMiddleware(next http.Handler) http.Handler {
 			Endpoint:       r.URL.Path,
 			Method:         r.Method,
 			ContentType:    r.Header.Get("Content-Type"),
-			RequestBody:    sanitizeRequestBody(requestBody, r.URL.Path),
-			QueryParams:    r.URL.RawQuery,
+			RequestBody:    sanitizeRequestBody(requestBody, r.URL.Path, r.Header.Get("Content-Type")),
+			QueryParams:    sanitizeQueryParams(r.URL.RawQuery),
 			ResponseStatus: rw.statusCode,
 			ResponseTimeMs: responseTimeMs,
 			UserAgent:      r.Header.Get("User-Agent"),
-			IPAddress:      getClientIP(r),
+			IPAddress:      middleware.ClientIP(r),
+		}
+
+		// Record the user of authenticated requests
+		if user, ok := authenticatedUser(); ok {
+			log.UserID = user.ID
+		} else if user, ok := middleware.CurrentUser(r); ok {
+			log.UserID = user.ID
 		}
 
 		// Save the log entry asynchronously
@@ -115,37 +130,91 @@ func shouldSkipLogging(path string) bool {
 	return false
 }
 
+// redacted replaces the values of sensitive parameters in the request log
+const redacted = "[REDACTED]"
+
+// sensitiveParams are the parameters whose values are never logged
+var sensitiveParams = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"api_key":       true,
+	"apikey":        true,
+	"secret":        true,
+	"client_secret": true,
+}
+
+// isSensitiveParam reports whether the value of a parameter must not be logged
+func isSensitiveParam(name string) bool {
+	return sensitiveParams[strings.ToLower(name)]
+}
+
+// sanitizeQueryParams redacts the values of sensitive parameters in a query string
+func sanitizeQueryParams(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// Query strings that cannot be parsed are not logged, they may hide a sensitive value
+		return redacted
+	}
+	return redactValues(values)
+}
+
+// redactValues redacts the sensitive parameters of parsed query or form values
+func redactValues(values url.Values) string {
+	for name := range values {
+		if isSensitiveParam(name) {
+			values[name] = []string{redacted}
+		}
+	}
+	return values.Encode()
+}
+
 // sanitizeRequestBody removes sensitive information from request bodies
-func sanitizeRequestBody(body string, path string) string {
+// Sensitive fields of form and JSON bodies are redacted
+func sanitizeRequestBody(body string, path string, contentType string) string {
 	// Skip logging request bodies for authentication endpoints
 	if strings.Contains(path, "/auth/") || strings.Contains(path, "/login") {
 		return "[REDACTED - AUTH ENDPOINT]"
 	}
-
-	// For all other endpoints, return the body as is
-	// In a production environment, you might want to implement more sophisticated
-	// sanitization to remove sensitive data like passwords, tokens, etc.
-	return body
-}
-
-// getClientIP extracts the client IP address from the request
-func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header (common when behind a proxy)
-	forwardedFor := r.Header.Get("X-Forwarded-For")
-	if forwardedFor != "" {
-		// The client IP is the first address in the list
-		ips := strings.Split(forwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+	if body == "" {
+		return body
 	}
 
-	// Check for X-Real-IP header (used by some proxies)
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	switch {
+	case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+		values, err := url.ParseQuery(body)
+		if err != nil {
+			return redacted
+		}
+		return redactValues(values)
+
+	case strings.Contains(contentType, "application/json"):
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &fields); err != nil {
+			// Bodies other than JSON objects have no named fields to redact
+			return body
+		}
+		changed := false
+		for name := range fields {
+			if isSensitiveParam(name) {
+				fields[name] = json.RawMessage(`"` + redacted + `"`)
+				changed = true
+			}
+		}
+		if !changed {
+			return body
+		}
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return redacted
+		}
+		return string(encoded)
 	}
 
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	return body
 }
 
 // saveRequestLog saves a request log entry to the database
@@ -2,6 +2,7 @@
 package logging
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -21,6 +22,7 @@ type RequestLog struct {
 	ResponseTimeMs int       `json:"response_time_ms"`
 	UserAgent      string    `json:"user_agent,omitempty"`
 	IPAddress      string    `json:"ip_address,omitempty"`
+	UserID         string    `json:"user_id,omitempty"`
 }
 
 // RequestLogDAO provides database operations for request logs
@@ -54,10 +56,11 @@ func (dao *RequestLogDAO) InsertRequestLog(log *RequestLog) error {
 	query := `
 		INSERT INTO request_logs (
 			timestamp, endpoint, method, content_type, request_body, 
-			query_params, response_status, response_time_ms, user_agent, ip_address
+			query_params, response_status, response_time_ms, user_agent, ip_address,
+			user_id
 		)
 		VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 		)
 		RETURNING id
 	`
@@ -76,6 +79,7 @@ func (dao *RequestLogDAO) InsertRequestLog(log *RequestLog) error {
 		log.ResponseTimeMs,
 		log.UserAgent,
 		log.IPAddress,
+		database.NullString(log.UserID),
 	).Scan(&id)
 
 	if err != nil {
@@ -94,7 +98,7 @@ func (dao *RequestLogDAO) GetRequestLogs(limit int, offset int) ([]RequestLog, e
 		SELECT 
 			id, timestamp, endpoint, method, content_type, 
 			request_body, query_params, response_status, response_time_ms, 
-			user_agent, ip_address
+			user_agent, ip_address, user_id
 		FROM request_logs
 		ORDER BY timestamp DESC
 		LIMIT $1 OFFSET $2
@@ -111,6 +115,7 @@ func (dao *RequestLogDAO) GetRequestLogs(limit int, offset int) ([]RequestLog, e
 	logs := []RequestLog{}
 	for rows.Next() {
 		var log RequestLog
+		var userID sql.NullString
 		err := rows.Scan(
 			&log.ID,
 			&log.Timestamp,
@@ -123,10 +128,12 @@ func (dao *RequestLogDAO) GetRequestLogs(limit int, offset int) ([]RequestLog, e
 			&log.ResponseTimeMs,
 			&log.UserAgent,
 			&log.IPAddress,
+			&userID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan request log row: %w", err)
 		}
+		log.UserID = userID.String
 		logs = append(logs, log)
 	}
 
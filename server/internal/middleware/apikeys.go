@@ -0,0 +1,184 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so leaked keys are easy to recognize
+	APIKeyPrefix = "amt_"
+	// apiKeyBytes is the number of random bytes in an API key
+	apiKeyBytes = 32
+	// apiKeyDisplayLength is the number of characters of a key stored to recognize it
+	apiKeyDisplayLength = len(APIKeyPrefix) + 8
+)
+
+// ErrInvalidAPIKey is returned when a bearer token is unknown, expired or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyStore keeps the API keys used as bearer tokens
+// The database APIKeyDAO is the default store
+type APIKeyStore interface {
+	CreateAPIKey(userID string, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (string, error)
+	GetAPIKeyByHash(keyHash string) (*database.APIKey, error)
+	GetAPIKeyByID(id string) (*database.APIKey, error)
+	ListAPIKeys(userID string) ([]*database.APIKey, error)
+	RevokeAPIKey(id string) error
+	TouchAPIKey(id string) error
+}
+
+var (
+	// Store used to look up API keys, nil to use the database
+	apiKeyStore APIKeyStore
+	// Mutex protecting apiKeyStore
+	apiKeyStoreMutex sync.RWMutex
+)
+
+// SetAPIKeyStore replaces the store used to look up API keys
+// Passing nil restores the database store
+func SetAPIKeyStore(store APIKeyStore) {
+	apiKeyStoreMutex.Lock()
+	defer apiKeyStoreMutex.Unlock()
+	apiKeyStore = store
+}
+
+// APIKeys returns the configured API key store, or the database store
+func APIKeys() (APIKeyStore, error) {
+	apiKeyStoreMutex.RLock()
+	store := apiKeyStore
+	apiKeyStoreMutex.RUnlock()
+	if store != nil {
+		return store, nil
+	}
+	return database.GetAPIKeyDAO()
+}
+
+// GenerateAPIKey returns a new random API key and the prefix stored to recognize it
+func GenerateAPIKey() (key string, prefix string, err error) {
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", fmt.Errorf("error generating API key: %w", err)
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	return key, key[:apiKeyDisplayLength], nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+// API keys are long random strings, so a fast hash is enough to protect them
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey generates and stores a new API key for a user with the given scopes
+// expiresAt is nil for keys that do not expire
+// Returns the stored key and the key itself, which is not stored and cannot be shown again
+func CreateAPIKey(store APIKeyStore, userID string, name string, scopes []string, expiresAt *time.Time) (*database.APIKey, string, error) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := store.CreateAPIKey(userID, name, prefix, HashAPIKey(key), scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey, err := store.GetAPIKeyByID(id)
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ParseAPIKeyExpiry returns the expiry time of a key valid for a duration such as "720h"
+// An empty duration means the key does not expire
+func ParseAPIKeyExpiry(expiresIn string) (*time.Time, error) {
+	expiresIn = strings.TrimSpace(expiresIn)
+	if expiresIn == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(expiresIn)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid expires_in %q, use a positive duration such as 720h", expiresIn)
+	}
+	expiresAt := time.Now().Add(duration)
+	return &expiresAt, nil
+}
+
+// AuthenticateAPIKey checks an API key and returns the identity of its user
+// The identity has the scopes of the key that the user still has
+// Unknown, expired and revoked keys return ErrInvalidAPIKey
+// The time the key was used is recorded
+func AuthenticateAPIKey(key string) (Identity, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return Identity{}, ErrInvalidAPIKey
+	}
+
+	store, err := APIKeys()
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up API key: %w", err)
+	}
+
+	apiKey, err := store.GetAPIKeyByHash(HashAPIKey(key))
+	if errors.Is(err, database.ErrNotFound) {
+		return Identity{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up API key: %w", err)
+	}
+	if !apiKey.Active(time.Now()) {
+		return Identity{}, ErrInvalidAPIKey
+	}
+
+	if err := store.TouchAPIKey(apiKey.ID); err != nil {
+		log.Printf("Error recording use of API key %s: %v", apiKey.Prefix, err)
+	}
+	return Identity{
+		ID:       apiKey.UserID,
+		Username: apiKey.Username,
+		Scopes:   models.LimitScopes(apiKey.Scopes, apiKey.UserScopes),
+		APIKeyID: apiKey.ID,
+	}, nil
+}
+
+// BearerToken returns the token of the Authorization header of a request
+// The second result is false when the request has no bearer token
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// writeUnauthorized rejects a request with an invalid bearer token
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	writeAuthError(w, http.StatusUnauthorized, "unauthorized", message)
+}
+
+// writeAuthError writes a rejected request in the JSON error format of the handlers
+func writeAuthError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+		"code":    code,
+	})
+}
@@ -3,16 +3,16 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/database"
 	"github.com/gorilla/securecookie"
 )
 
@@ -23,105 +23,246 @@ const (
 	CookieMaxAge = 86400
 )
 
-var (
-	// Initialize secure cookie with random keys
-	cookieHandler = securecookie.New(
-		securecookie.GenerateRandomKey(64),
-		securecookie.GenerateRandomKey(32),
-	)
-)
-
 // AuthMiddleware is middleware that checks if the user is authenticated
+// Requests are authenticated with an API key sent as a bearer token, the authentication cookie,
+// or a username and password in the body of a POST request, which is throttled like the login page
+// The authenticated user is added to the request context, see CurrentUser
+// Other requests are redirected to the login page, or get 401 Unauthorized when they come from a JSON client
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Check if the request carries an API key, which is never redirected to the login page
+		if key, ok := BearerToken(r); ok {
+			if identity, ok := authenticateBearer(w, key); ok {
+				next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+			}
+			return
+		}
+
 		// Check if the user is authenticated via cookie
-		if IsAuthenticated(r) {
+		if identity, ok := identityFromCookie(r); ok {
 			// User is authenticated, proceed to the next handler
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
 			return
 		}
 
-		// Check if the user provided a password in the request
-		password := ""
-		if r.Method == http.MethodPost {
-			// Check Content-Type header to determine how to parse the data
-			contentType := r.Header.Get("Content-Type")
-
-			// If it's a form submission, parse form data
-			if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-			   strings.Contains(contentType, "multipart/form-data") {
-				if err := r.ParseForm(); err == nil {
-					password = r.FormValue("password")
-				}
-			} else if strings.Contains(contentType, "application/json") {
-				// Parse JSON data
-				var loginData struct {
-					Password string `json:"password"`
-				}
-
-				// Limit request body size to prevent DoS attacks
-				body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
-				if err == nil {
-					// Restore the request body so it can be read by other handlers
-					r.Body.Close()
-					r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-					// Decode JSON
-					if err := json.Unmarshal(body, &loginData); err == nil {
-						password = loginData.Password
-					}
-				}
-			} else {
-				// Default to form parsing for backward compatibility
-				if err := r.ParseForm(); err == nil {
-					password = r.FormValue("password")
+		// Check if the user provided a username and password in the request
+		credentials, err := ReadCredentials(r)
+		if err == nil && credentials.Username != "" && credentials.Password != "" {
+			identity, err := AuthenticateLogin(r, credentials)
+			if err == nil {
+				// Credentials are correct, start a session and proceed
+				if identity, err = Login(w, r, identity); err != nil {
+					log.Printf("Error starting session for user %s: %v", credentials.Username, err)
+					http.Error(w, "Error starting session", http.StatusServiceUnavailable)
+					return
 				}
+				next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+				return
+			}
+			var throttled *ThrottledError
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", throttled.RetryAfterSeconds())
+				writeAuthError(w, http.StatusTooManyRequests, "too_many_requests", throttled.Error())
+				return
+			}
+			// Users with two-factor authentication must log in on the login page
+			if !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrTOTPRequired) {
+				log.Printf("Error authenticating user %s: %v", credentials.Username, err)
 			}
-		} else {
-			password = r.URL.Query().Get("password")
 		}
 
-		// If password is provided, verify it
-		if password != "" && VerifyPassword(password) {
-			// Password is correct, set cookie and proceed
-			SetAuthCookie(w)
-			next.ServeHTTP(w, r)
+		// User is not authenticated, JSON clients cannot follow the login page
+		if WantsJSON(r) {
+			writeAuthError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
 			return
 		}
 
-		// User is not authenticated, redirect to login page
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		// Redirect browsers to the login page, which returns to the requested page
+		http.Redirect(w, r, LoginURL(r), http.StatusSeeOther)
+	})
+}
+
+// OptionalAuthMiddleware is middleware that authenticates requests carrying an API key or the
+// authentication cookie like AuthMiddleware, and lets other requests through unauthenticated
+// It is used on public routes that can also run private tools, such as pipelines
+func OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := BearerToken(r); ok {
+			if identity, ok := authenticateBearer(w, key); ok {
+				next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+			}
+			return
+		}
+		if identity, ok := identityFromCookie(r); ok {
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
-// IsAuthenticated checks if the user is authenticated via cookie
+// authenticateBearer returns the identity of an API key sent as a bearer token
+// Invalid keys are rejected with 401 Unauthorized, and the second result is false
+func authenticateBearer(w http.ResponseWriter, key string) (Identity, bool) {
+	identity, err := AuthenticateAPIKey(key)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidAPIKey) {
+			log.Printf("Error authenticating API key: %v", err)
+			http.Error(w, "Error checking API key", http.StatusServiceUnavailable)
+			return Identity{}, false
+		}
+		writeUnauthorized(w, "invalid or expired API key")
+		return Identity{}, false
+	}
+	return identity, true
+}
+
+// RequireScopes is middleware that rejects requests whose user lacks one of the scopes with 403 Forbidden
+// It must run after AuthMiddleware
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := CurrentUser(r)
+			if !ok {
+				writeAuthError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+				return
+			}
+			if !identity.HasScopes(scopes...) {
+				writeAuthError(w, http.StatusForbidden, "forbidden", MissingScopesMessage(scopes))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MissingScopesMessage describes the scopes a rejected request needs
+func MissingScopesMessage(scopes []string) string {
+	return fmt.Sprintf("this request requires the scopes: %s", strings.Join(scopes, ", "))
+}
+
+// Credentials are the username and password sent to log in
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ReadCredentials reads the username and password of a request
+// Only POST requests send them, as form data or JSON; the query string is ignored
+// so passwords do not end up in URLs and request logs
+// The body of a JSON request is restored so it can be read by other handlers
+func ReadCredentials(r *http.Request) (Credentials, error) {
+	if r.Method != http.MethodPost {
+		return Credentials{}, nil
+	}
+
+	// Check Content-Type header to determine how to parse the data
+	contentType := r.Header.Get("Content-Type")
+	if strings.Contains(contentType, "application/json") {
+		// Limit request body size to prevent DoS attacks
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+		if err != nil {
+			return Credentials{}, fmt.Errorf("error reading request body: %w", err)
+		}
+
+		// Restore the request body so it can be read by other handlers
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		// Decode JSON
+		var credentials Credentials
+		if err := json.Unmarshal(body, &credentials); err != nil {
+			return Credentials{}, fmt.Errorf("error parsing JSON data: %w", err)
+		}
+		return credentials, nil
+	}
+
+	// Form submissions, and other content types for backward compatibility
+	if err := r.ParseForm(); err != nil {
+		return Credentials{}, fmt.Errorf("error parsing form data: %w", err)
+	}
+	return Credentials{Username: r.PostForm.Get("username"), Password: r.PostForm.Get("password")}, nil
+}
+
+// IsAuthenticated checks if the user is authenticated
 func IsAuthenticated(r *http.Request) bool {
+	_, ok := CurrentUser(r)
+	return ok
+}
+
+// identityFromCookie returns the user stored in the authentication cookie
+// With a session store, the session named by the cookie must also still exist
+// The user must still exist too, and the identity gets the current scopes of the user,
+// so changed scopes apply to the sessions that are already logged in
+func identityFromCookie(r *http.Request) (Identity, bool) {
 	// Get the cookie
 	cookie, err := r.Cookie(CookieName)
 	if err != nil {
-		return false
+		return Identity{}, false
 	}
 
-	// Decode the cookie value
+	// Decode the cookie value with the current or a previous key
 	value := make(map[string]string)
-	if err = cookieHandler.Decode(CookieName, cookie.Value, &value); err != nil {
-		return false
+	if err = securecookie.DecodeMulti(CookieName, cookie.Value, &value, cookieCodecs()...); err != nil {
+		return Identity{}, false
+	}
+
+	// Check if the authenticated flag and the user are set
+	if value["authenticated"] != "true" || value["user_id"] == "" {
+		return Identity{}, false
+	}
+	identity := Identity{
+		ID:        value["user_id"],
+		Username:  value["username"],
+		SessionID: value["session_id"],
 	}
 
-	// Check if the authenticated flag is set
-	return value["authenticated"] == "true"
+	if !validSession(identity) {
+		return Identity{}, false
+	}
+	scopes, ok := currentScopes(identity)
+	if !ok {
+		return Identity{}, false
+	}
+	identity.Scopes = scopes
+	return identity, true
 }
 
-// SetAuthCookie sets the authentication cookie
-func SetAuthCookie(w http.ResponseWriter) {
+// currentScopes returns the scopes the user of a session has now
+// The second result is false when the user no longer exists or cannot be looked up
+func currentScopes(identity Identity) ([]string, bool) {
+	store, err := getUserStore()
+	if err != nil {
+		log.Printf("Error looking up user %s: %v", identity.Username, err)
+		return nil, false
+	}
+	user, err := store.GetUserByUsername(identity.Username)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Error looking up user %s: %v", identity.Username, err)
+		}
+		return nil, false
+	}
+	if user.ID != identity.ID {
+		return nil, false
+	}
+	return user.Scopes, true
+}
+
+// SetAuthCookie sets the authentication cookie for a user
+// The cookie is signed and encrypted with the current session key
+func SetAuthCookie(w http.ResponseWriter, identity Identity) {
 	// Create a map to store in the cookie
 	value := map[string]string{
 		"authenticated": "true",
+		"user_id":       identity.ID,
+		"username":      identity.Username,
+		"session_id":    identity.SessionID,
 		"timestamp":     fmt.Sprintf("%d", time.Now().Unix()),
 	}
 
 	// Encode the cookie value
-	if encoded, err := cookieHandler.Encode(CookieName, value); err == nil {
+	if encoded, err := securecookie.EncodeMulti(CookieName, value, cookieCodecs()...); err == nil {
 		// Create a new cookie
 		cookie := &http.Cookie{
 			Name:     CookieName,
@@ -154,29 +295,3 @@ func ClearAuthCookie(w http.ResponseWriter) {
 	// Set the cookie
 	http.SetCookie(w, cookie)
 }
-
-// VerifyPassword verifies the provided password against the hash in the .env file
-func VerifyPassword(password string) bool {
-	// Get the password hash from the environment
-	storedHash := os.Getenv("PRIVATE_USE_PASSWORD")
-	if storedHash == "" {
-		// If no password hash is set, authentication fails
-		return false
-	}
-
-	// Hash the provided password
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	computedHash := hex.EncodeToString(hasher.Sum(nil))
-
-	// Compare the hashes
-	return computedHash == storedHash
-}
-
-// HashPassword generates a SHA-256 hash for a password
-// This is a utility function that can be used to generate a hash for the .env file
-func HashPassword(password string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	return hex.EncodeToString(hasher.Sum(nil))
-}
@@ -0,0 +1,253 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	// CSRFCookieName is the name of the cookie carrying the CSRF secret of a browser
+	CSRFCookieName = "allmitools_csrf"
+	// CSRFFieldName is the form field carrying the CSRF token
+	CSRFFieldName = "csrf_token"
+	// CSRFHeaderName is the header carrying the CSRF token of script requests
+	CSRFHeaderName = "X-CSRF-Token"
+	// csrfSecretBytes is the number of random bytes of a CSRF secret
+	csrfSecretBytes = 32
+)
+
+// csrfKey is the context key of the csrfState of a request
+type csrfKey struct{}
+
+// csrfState is the CSRF secret of a request handled by CSRFMiddleware
+// Browsers without a secret get one when a page first renders a token
+type csrfState struct {
+	mutex  sync.Mutex
+	w      http.ResponseWriter // Response that sets the cookie of a new secret
+	secret []byte              // Secret of the browser, nil until one is created
+}
+
+// getSecret returns the CSRF secret of the browser, creating it and setting its cookie if there is none
+func (s *csrfState) getSecret() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.secret == nil {
+		secret, err := newCSRFSecret(s.w)
+		if err != nil {
+			return nil, err
+		}
+		s.secret = secret
+	}
+	return s.secret, nil
+}
+
+// CSRFMiddleware is middleware protecting browser requests against cross-site request forgery
+// Browsers get a secret in a signed cookie the first time a page renders a form, and pages carry
+// tokens derived from it in their forms; API calls and other responses without a token set no cookie
+// State changing requests that act with the browser's session, or log in with a form, must send a token
+// in the csrf_token form field or the X-CSRF-Token header
+// Requests with a bearer token and JSON requests without cookies come from API clients and are exempt
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &csrfState{}
+		if secret, ok := csrfSecretFromCookie(r); ok {
+			state.secret = secret
+		}
+
+		if csrfRequired(r) && !validCSRFToken(state.secret, submittedCSRFToken(r)) {
+			writeAuthError(w, http.StatusForbidden, "forbidden", "invalid or missing CSRF token, reload the page and try again")
+			return
+		}
+
+		cw := &csrfResponseWriter{ResponseWriter: w}
+		state.w = cw
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), csrfKey{}, state)))
+		cw.flush()
+	})
+}
+
+// csrfResponseWriter holds back the status line until the body is written, so the cookie of a secret
+// created while rendering a page is sent even when the handler set the status first
+type csrfResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	flushed bool
+}
+
+// WriteHeader records the status of the response
+func (w *csrfResponseWriter) WriteHeader(status int) {
+	if !w.flushed && w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write sends the status line and headers before the first part of the body
+func (w *csrfResponseWriter) Write(b []byte) (int, error) {
+	w.flush()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the response so far to the client
+func (w *csrfResponseWriter) Flush() {
+	w.flush()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped response writer
+func (w *csrfResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush sends the recorded status, if any, once
+func (w *csrfResponseWriter) flush() {
+	if w.flushed {
+		return
+	}
+	w.flushed = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// CSRFToken returns a CSRF token for the forms of a page rendered for a request, empty without a CSRF secret
+// Behind CSRFMiddleware, browsers without a secret get one, and its cookie is set on the response
+// Each call returns a different token for the same secret, so the token cannot be recovered from compressed pages
+func CSRFToken(r *http.Request) string {
+	if state, ok := r.Context().Value(csrfKey{}).(*csrfState); ok {
+		secret, err := state.getSecret()
+		if err != nil {
+			log.Printf("Error creating CSRF secret: %v", err)
+			return ""
+		}
+		return maskCSRFSecret(secret)
+	}
+	if secret, ok := csrfSecretFromCookie(r); ok {
+		return maskCSRFSecret(secret)
+	}
+	return ""
+}
+
+// CSRFFormFields returns the hidden form field carrying the CSRF token of a request
+// It is set as the form fields of the template manager, so every form of the pages carries the token
+func CSRFFormFields(r *http.Request) map[string]string {
+	token := CSRFToken(r)
+	if token == "" {
+		return nil
+	}
+	return map[string]string{CSRFFieldName: token}
+}
+
+// csrfRequired reports whether a request must carry a CSRF token
+func csrfRequired(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if _, ok := BearerToken(r); ok {
+		return false
+	}
+
+	// Requests acting with the session, or the pending login, of the browser
+	if hasCookie(r, CookieName) || hasCookie(r, PendingLoginCookieName) {
+		return true
+	}
+
+	// Browsers cannot send JSON to another site without its consent, but can post forms,
+	// so a form sending a password could log the browser in as someone else
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		return false
+	}
+	return r.PostFormValue("password") != ""
+}
+
+// submittedCSRFToken returns the CSRF token sent with a request
+func submittedCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(CSRFHeaderName); token != "" {
+		return token
+	}
+	return r.PostFormValue(CSRFFieldName)
+}
+
+// validCSRFToken reports whether a token was derived from the secret
+func validCSRFToken(secret []byte, token string) bool {
+	if len(secret) != csrfSecretBytes {
+		return false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) != 2*csrfSecretBytes {
+		return false
+	}
+	pad, masked := decoded[:csrfSecretBytes], decoded[csrfSecretBytes:]
+	unmasked := make([]byte, csrfSecretBytes)
+	for i := range unmasked {
+		unmasked[i] = pad[i] ^ masked[i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+// maskCSRFSecret returns a token for a secret: a random pad followed by the secret XORed with the pad
+func maskCSRFSecret(secret []byte) string {
+	token := make([]byte, 2*csrfSecretBytes)
+	if _, err := rand.Read(token[:csrfSecretBytes]); err != nil {
+		return ""
+	}
+	for i := 0; i < csrfSecretBytes; i++ {
+		token[csrfSecretBytes+i] = token[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// csrfSecretFromCookie returns the CSRF secret in the cookie of a request
+func csrfSecretFromCookie(r *http.Request) ([]byte, bool) {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil {
+		return nil, false
+	}
+	var secret []byte
+	if err := securecookie.DecodeMulti(CSRFCookieName, cookie.Value, &secret, cookieCodecs()...); err != nil {
+		return nil, false
+	}
+	return secret, len(secret) == csrfSecretBytes
+}
+
+// newCSRFSecret creates a CSRF secret and sets it in the cookie of the response
+// The cookie is signed with the session keys and is sent on links from other sites (SameSite=Lax),
+// so following a link does not replace the secret of forms open in other tabs
+func newCSRFSecret(w http.ResponseWriter) ([]byte, error) {
+	secret := make([]byte, csrfSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encoded, err := securecookie.EncodeMulti(CSRFCookieName, secret, cookieCodecs()...)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   CookieMaxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return secret, nil
+}
+
+// hasCookie reports whether a request carries a cookie
+func hasCookie(r *http.Request, name string) bool {
+	_, err := r.Cookie(name)
+	return err == nil
+}
@@ -0,0 +1,360 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/oidc"
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// OIDCCookieName is the name of the cookie keeping a single sign-on login while the user is at the identity provider
+	OIDCCookieName = "allmitools_oidc"
+	// oidcLoginMaxAge is how long a single sign-on login can take at the identity provider
+	oidcLoginMaxAge = 10 * time.Minute
+	// oidcCookiePath limits the cookie to the single sign-on pages
+	oidcCookiePath = "/login/oidc"
+)
+
+var (
+	// ErrOIDCDisabled is returned when single sign-on is not configured
+	ErrOIDCDisabled = errors.New("single sign-on is not configured")
+	// ErrOIDCLoginExpired is returned for a callback that does not belong to a login started by the browser
+	ErrOIDCLoginExpired = errors.New("the single sign-on login expired or was started elsewhere, please try again")
+	// ErrOIDCNotAllowed is returned when the identity provider's user cannot be mapped to a local user
+	ErrOIDCNotAllowed = errors.New("this account is not allowed to log in")
+)
+
+// OIDCMapping maps the claims of ID tokens to local users and scopes
+type OIDCMapping struct {
+	// UsernameClaim is the claim holding the local username, such as email
+	UsernameClaim string
+	// GroupsClaim is the claim holding the groups of the user
+	GroupsClaim string
+	// GroupScopes grants scopes to the members of groups. When set, the scopes of a user are replaced
+	// by those of their groups at every login, and users in none of the groups cannot log in
+	GroupScopes map[string][]string
+	// CreateUsers creates local users on their first login instead of rejecting unknown users
+	CreateUsers bool
+}
+
+// DefaultOIDCMapping maps the email claim to the username and reads groups from the groups claim
+var DefaultOIDCMapping = OIDCMapping{UsernameClaim: "email", GroupsClaim: "groups"}
+
+// OIDCUserStore is a user store that can also create users and update their scopes
+// The database UserDAO is one; other stores cannot create users or update scopes from groups
+type OIDCUserStore interface {
+	UserStore
+	CreateUser(username string, passwordHash string, scopes []string) (string, error)
+	UpdateScopes(id string, scopes []string) error
+}
+
+var (
+	// Identity provider of single sign-on logins, nil when disabled
+	oidcProvider *oidc.Provider
+	// Mapping of the identity provider's claims to local users
+	oidcMapping OIDCMapping
+	// Mutex protecting oidcProvider and oidcMapping
+	oidcMutex sync.RWMutex
+)
+
+// ConfigureOIDC enables single sign-on with an identity provider
+// Passing a nil provider disables it
+func ConfigureOIDC(provider *oidc.Provider, mapping OIDCMapping) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	if mapping.UsernameClaim == "" {
+		mapping.UsernameClaim = DefaultOIDCMapping.UsernameClaim
+	}
+	if mapping.GroupsClaim == "" {
+		mapping.GroupsClaim = DefaultOIDCMapping.GroupsClaim
+	}
+	oidcProvider = provider
+	oidcMapping = mapping
+}
+
+// OIDCEnabled reports whether single sign-on is configured
+func OIDCEnabled() bool {
+	provider, _ := getOIDC()
+	return provider != nil
+}
+
+// getOIDC returns the identity provider and the claim mapping
+func getOIDC() (*oidc.Provider, OIDCMapping) {
+	oidcMutex.RLock()
+	defer oidcMutex.RUnlock()
+	return oidcProvider, oidcMapping
+}
+
+// ParseGroupScopes parses the OIDC_GROUP_SCOPES setting
+// Groups are separated by semicolons, each is a group name, an equals sign and space separated scopes,
+// e.g. "allmitools-admins=admin;allmitools-users=storage:read storage:write"
+// The scopes of each group are sorted, and unknown scopes are an error
+func ParseGroupScopes(value string) (map[string][]string, error) {
+	groupScopes := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, scopes, found := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !found || group == "" {
+			return nil, fmt.Errorf("invalid group mapping %q, use group=scope scope", entry)
+		}
+		parsed, err := models.ParseScopes(scopes)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", group, err)
+		}
+		if groupScopes[group], err = models.NormalizeScopes(append(groupScopes[group], parsed...)); err != nil {
+			return nil, fmt.Errorf("group %s: %w", group, err)
+		}
+	}
+	return groupScopes, nil
+}
+
+// StartOIDCLogin starts a single sign-on login and returns the identity provider's login page to redirect to
+// The state, nonce and PKCE code verifier of the login are kept in a cookie until the callback,
+// along with the page to return to afterwards
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request, next string) (string, error) {
+	provider, _ := getOIDC()
+	if provider == nil {
+		return "", ErrOIDCDisabled
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	value := map[string]string{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"next":     SafeRedirect(next),
+		"expires":  strconv.FormatInt(time.Now().Add(oidcLoginMaxAge).Unix(), 10),
+	}
+	encoded, err := securecookie.EncodeMulti(OIDCCookieName, value, cookieCodecs()...)
+	if err != nil {
+		return "", fmt.Errorf("error encoding single sign-on login: %w", err)
+	}
+
+	// The identity provider redirects back with a top-level GET, which carries Lax but not Strict cookies
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCCookieName,
+		Value:    encoded,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcLoginMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, nil
+}
+
+// FinishOIDCLogin completes a single sign-on login on the callback page
+// It checks the state of the login, redeems the authorization code and maps the ID token to a local user
+// Returns the user and the page to return to
+func FinishOIDCLogin(w http.ResponseWriter, r *http.Request) (Identity, string, error) {
+	provider, mapping := getOIDC()
+	if provider == nil {
+		return Identity{}, "/", ErrOIDCDisabled
+	}
+
+	login, ok := oidcLogin(r)
+	clearOIDCLogin(w)
+	if !ok || r.URL.Query().Get("state") != login["state"] {
+		return Identity{}, "/", ErrOIDCLoginExpired
+	}
+	next := SafeRedirect(login["next"])
+
+	// The identity provider reports refused logins instead of a code
+	if providerError := r.URL.Query().Get("error"); providerError != "" {
+		description := r.URL.Query().Get("error_description")
+		RecordEvent(r, audit.Event{Type: audit.EventLoginFailed, Details: strings.TrimSpace("oidc: " + providerError + " " + description)})
+		return Identity{}, next, fmt.Errorf("the identity provider refused the login: %s", providerError)
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return Identity{}, next, ErrOIDCLoginExpired
+	}
+
+	claims, err := provider.Exchange(r.Context(), code, login["verifier"], login["nonce"])
+	if err != nil {
+		RecordEvent(r, audit.Event{Type: audit.EventLoginFailed, Details: "oidc: " + err.Error()})
+		return Identity{}, next, err
+	}
+
+	identity, err := AuthenticateOIDC(claims, mapping)
+	if err != nil {
+		RecordEvent(r, audit.Event{
+			Type:     audit.EventLoginFailed,
+			Username: claims.String(mapping.UsernameClaim),
+			Details:  "oidc: " + err.Error(),
+		})
+		return Identity{}, next, err
+	}
+	return identity, next, nil
+}
+
+// AuthenticateOIDC maps the claims of a verified ID token to a local user
+// The username claim names the user, and with group scopes the groups claim decides the scopes
+// Two-factor authentication of local users is not asked, the identity provider is trusted to have done it
+func AuthenticateOIDC(claims oidc.Claims, mapping OIDCMapping) (Identity, error) {
+	username := strings.TrimSpace(claims.String(mapping.UsernameClaim))
+	if username == "" {
+		return Identity{}, fmt.Errorf("%w: the ID token has no %s claim", ErrOIDCNotAllowed, mapping.UsernameClaim)
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && mapping.UsernameClaim == "email" {
+		return Identity{}, fmt.Errorf("%w: the email address is not verified", ErrOIDCNotAllowed)
+	}
+
+	var scopes []string
+	if len(mapping.GroupScopes) > 0 {
+		var member bool
+		scopes, member = groupScopes(claims.Strings(mapping.GroupsClaim), mapping.GroupScopes)
+		if !member {
+			return Identity{}, fmt.Errorf("%w: not a member of any allowed group", ErrOIDCNotAllowed)
+		}
+	}
+
+	store, err := getUserStore()
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+	user, err := store.GetUserByUsername(username)
+	switch {
+	case errors.Is(err, database.ErrNotFound) && mapping.CreateUsers:
+		return createOIDCUser(store, username, scopes)
+	case errors.Is(err, database.ErrNotFound):
+		return Identity{}, fmt.Errorf("%w: no local user %s", ErrOIDCNotAllowed, username)
+	case err != nil:
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+
+	if len(mapping.GroupScopes) == 0 {
+		return Identity{ID: user.ID, Username: user.Username, Scopes: user.Scopes}, nil
+	}
+
+	// Keep the stored scopes in line with the groups, for API keys that act with the user's scopes
+	if strings.Join(scopes, " ") != strings.Join(user.Scopes, " ") {
+		if updater, ok := store.(OIDCUserStore); ok {
+			if err := updater.UpdateScopes(user.ID, scopes); err != nil {
+				log.Printf("Error updating the scopes of user %s: %v", user.Username, err)
+			}
+		}
+	}
+	return Identity{ID: user.ID, Username: user.Username, Scopes: scopes}, nil
+}
+
+// createOIDCUser creates the local user of a first single sign-on login
+// The user gets an unusable random password, so they can only log in through the identity provider
+func createOIDCUser(store UserStore, username string, scopes []string) (Identity, error) {
+	creator, ok := store.(OIDCUserStore)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: no local user %s and the user store cannot create users", ErrOIDCNotAllowed, username)
+	}
+
+	password, err := randomToken()
+	if err != nil {
+		return Identity{}, err
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return Identity{}, err
+	}
+	id, err := creator.CreateUser(username, passwordHash, scopes)
+	if err != nil {
+		return Identity{}, fmt.Errorf("error creating user: %w", err)
+	}
+	log.Printf("Created user %s on their first single sign-on login", username)
+	return Identity{ID: id, Username: username, Scopes: scopes}, nil
+}
+
+// groupScopes returns the scopes granted to the groups, sorted like the scopes of users,
+// and whether any group has a mapping
+func groupScopes(groups []string, mapping map[string][]string) ([]string, bool) {
+	var scopes []string
+	member := false
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		granted, ok := mapping[group]
+		if !ok {
+			continue
+		}
+		member = true
+		for _, scope := range granted {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, member
+}
+
+// oidcLogin returns the single sign-on login kept in the cookie of a request
+func oidcLogin(r *http.Request) (map[string]string, bool) {
+	cookie, err := r.Cookie(OIDCCookieName)
+	if err != nil {
+		return nil, false
+	}
+
+	value := make(map[string]string)
+	if err := securecookie.DecodeMulti(OIDCCookieName, cookie.Value, &value, cookieCodecs()...); err != nil {
+		return nil, false
+	}
+	expires, err := strconv.ParseInt(value["expires"], 10, 64)
+	if err != nil || time.Now().Unix() > expires || value["state"] == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+// clearOIDCLogin clears the cookie of a single sign-on login, which can only be completed once
+func clearOIDCLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCCookieName,
+		Value:    "",
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomToken returns 32 random bytes encoded as base64url
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
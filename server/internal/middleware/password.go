@@ -0,0 +1,151 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters of new argon2id password hashes
+const (
+	argon2Time    = 3         // Number of passes over the memory
+	argon2Memory  = 64 * 1024 // Memory in KiB
+	argon2Threads = 2         // Degree of parallelism
+	argon2KeyLen  = 32        // Length of the hash in bytes
+	argon2SaltLen = 16        // Length of the random salt in bytes
+)
+
+// argon2Params are the cost parameters of an argon2id hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// currentArgon2Params are the parameters of new hashes
+var currentArgon2Params = argon2Params{memory: argon2Memory, time: argon2Time, threads: argon2Threads}
+
+// dummyPasswordHash is verified for unknown users, so they take as long to reject as wrong passwords
+// and the time of a login does not reveal whether the username exists
+var dummyPasswordHash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+	argon2.Version, argon2Memory, argon2Time, argon2Threads,
+	base64.RawStdEncoding.EncodeToString(make([]byte, argon2SaltLen)),
+	base64.RawStdEncoding.EncodeToString(make([]byte, argon2KeyLen)),
+)
+
+// errMalformedHash is returned when a stored argon2id hash cannot be parsed
+var errMalformedHash = errors.New("malformed argon2id hash")
+
+// HashPassword hashes a password with argon2id and a random salt
+// The hash is returned in PHC string format, for example
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, and is stored in the password_hash column of the users table
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword verifies a password against the hash stored for a user
+// It accepts argon2id and bcrypt hashes, and the unsalted SHA-256 hex hashes of earlier versions
+// so existing users can still log in; see NeedsRehash
+func VerifyPassword(password string, storedHash string) bool {
+	switch {
+	case storedHash == "":
+		// Users without a password hash cannot log in
+		return false
+	case strings.HasPrefix(storedHash, "$argon2id$"):
+		return verifyArgon2id(password, storedHash)
+	case isBcryptHash(storedHash):
+		return bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)) == nil
+	case isLegacyHash(storedHash):
+		sum := sha256.Sum256([]byte(password))
+		computed := hex.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(strings.ToLower(storedHash))) == 1
+	default:
+		return false
+	}
+}
+
+// NeedsRehash reports whether a stored hash should be replaced by a new HashPassword hash
+// This is the case for legacy SHA-256 and bcrypt hashes, and for argon2id hashes with other parameters
+func NeedsRehash(storedHash string) bool {
+	params, _, _, err := parseArgon2id(storedHash)
+	if err != nil {
+		return true
+	}
+	return params != currentArgon2Params
+}
+
+// verifyArgon2id recomputes an argon2id hash with the stored salt and parameters and compares it in constant time
+func verifyArgon2id(password string, storedHash string) bool {
+	params, salt, key, err := parseArgon2id(storedHash)
+	if err != nil {
+		return false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+// parseArgon2id parses an argon2id hash in PHC string format into its parameters, salt and key
+func parseArgon2id(storedHash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(storedHash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	if params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, errMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errMalformedHash
+	}
+
+	return params, salt, key, nil
+}
+
+// isBcryptHash reports whether a stored hash is a bcrypt hash
+func isBcryptHash(storedHash string) bool {
+	return strings.HasPrefix(storedHash, "$2a$") || strings.HasPrefix(storedHash, "$2b$") || strings.HasPrefix(storedHash, "$2y$")
+}
+
+// isLegacyHash reports whether a stored hash is an unsalted SHA-256 hex hash
+func isLegacyHash(storedHash string) bool {
+	if len(storedHash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(storedHash)
+	return err == nil
+}
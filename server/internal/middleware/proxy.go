@@ -0,0 +1,114 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// Networks of the reverse proxies whose forwarding headers are honoured
+	trustedProxies []*net.IPNet
+	// Mutex protecting trustedProxies
+	trustedProxiesMutex sync.RWMutex
+)
+
+// ConfigureTrustedProxies sets the reverse proxies whose X-Forwarded-For and X-Real-IP headers are honoured
+// Without trusted proxies the client of a request is always its remote address
+func ConfigureTrustedProxies(proxies []*net.IPNet) {
+	trustedProxiesMutex.Lock()
+	defer trustedProxiesMutex.Unlock()
+	trustedProxies = proxies
+}
+
+// ParseTrustedProxies parses the TRUSTED_PROXIES setting
+// Proxies are separated by commas or spaces, each an IP address or a CIDR network such as 10.0.0.0/8
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, use an IP address or a CIDR network", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, use an IP address or a CIDR network", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trustedProxy reports whether an IP address belongs to a trusted proxy
+func trustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	trustedProxiesMutex.RLock()
+	defer trustedProxiesMutex.RUnlock()
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client of a request
+// Forwarding headers are only honoured when the request comes from a trusted proxy,
+// so clients cannot choose the address their logins are throttled and audited under
+func ClientIP(r *http.Request) string {
+	if !trustedProxy(hostOnly(r.RemoteAddr)) {
+		return r.RemoteAddr
+	}
+
+	// The client is the last address in X-Forwarded-For not added by a trusted proxy,
+	// earlier addresses are sent by the client and may be forged
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		ips := strings.Split(forwardedFor, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !trustedProxy(ip) {
+				return ip
+			}
+		}
+		return r.RemoteAddr
+	}
+
+	// Check for X-Real-IP header (used by some proxies)
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	// Fall back to RemoteAddr
+	return r.RemoteAddr
+}
+
+// clientHost returns the IP address of the client of a request without the port
+func clientHost(r *http.Request) string {
+	return hostOnly(ClientIP(r))
+}
+
+// hostOnly returns an address without its port
+func hostOnly(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
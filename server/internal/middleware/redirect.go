@@ -0,0 +1,53 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NextParam is the parameter carrying the page to return to after logging in
+const NextParam = "next"
+
+// SafeRedirect returns the page to return to after logging in, or "/" when next is not a page of this server
+// Only absolute paths are accepted, so a crafted login link cannot send the user to another site
+func SafeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\r\n\t") {
+		return "/"
+	}
+	target, err := url.Parse(next)
+	if err != nil || target.Scheme != "" || target.Host != "" || target.User != nil {
+		return "/"
+	}
+
+	// Returning to the login or logout pages would ask to log in again, or log out right away
+	if target.Path == "/login" || strings.HasPrefix(target.Path, "/login/") || target.Path == "/logout" {
+		return "/"
+	}
+	return next
+}
+
+// LoginURL returns the login page for a request that must log in first
+// The login page returns to the requested page afterwards; other methods than GET cannot be repeated
+// by a redirect and return to the home page
+func LoginURL(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "/login"
+	}
+	next := SafeRedirect(r.URL.RequestURI())
+	if next == "/" {
+		return "/login"
+	}
+	return "/login?" + url.Values{NextParam: {next}}.Encode()
+}
+
+// WantsJSON reports whether a request comes from a JSON client rather than a browser,
+// so it is answered with a JSON error instead of a redirect to an HTML page
+func WantsJSON(r *http.Request) bool {
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
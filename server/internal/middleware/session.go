@@ -0,0 +1,188 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/gorilla/securecookie"
+)
+
+// SessionKey is a key pair for signing and encrypting the session cookie
+type SessionKey struct {
+	HashKey  []byte // Signs the cookie, 32 or 64 bytes are recommended
+	BlockKey []byte // Encrypts the cookie with AES, 16, 24 or 32 bytes, or empty to only sign it
+}
+
+// SessionStore keeps the server-side sessions so they can be listed and revoked
+// The database SessionDAO is the store used in production
+type SessionStore interface {
+	CreateSession(userID string, expiresAt time.Time, userAgent string, ipAddress string) (string, error)
+	GetSession(id string) (*database.Session, error)
+	ListSessions(userID string) ([]*database.Session, error)
+	DeleteSession(id string) error
+}
+
+// SessionConfig configures how sessions are kept
+type SessionConfig struct {
+	// Keys sign and encrypt the session cookie
+	// The first key encodes new cookies, the others still decode cookies issued before a key rotation
+	// Random keys are generated when empty, so sessions do not survive a restart
+	Keys []SessionKey
+	// Store keeps the sessions on the server, nil to keep them in the cookie only
+	Store SessionStore
+}
+
+var (
+	// Codecs of the session keys, the first one encodes new cookies
+	codecs = newCodecs(nil)
+	// Store of the server-side sessions, nil when disabled
+	sessionStore SessionStore
+	// Mutex protecting codecs and sessionStore
+	sessionMutex sync.RWMutex
+)
+
+// ConfigureSessions sets the session keys and the session store
+func ConfigureSessions(config SessionConfig) error {
+	for i, key := range config.Keys {
+		if len(key.HashKey) < 32 {
+			return fmt.Errorf("session key %d: hash key must be at least 32 bytes", i+1)
+		}
+		switch len(key.BlockKey) {
+		case 0, 16, 24, 32:
+		default:
+			return fmt.Errorf("session key %d: block key must be 16, 24 or 32 bytes", i+1)
+		}
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	codecs = newCodecs(config.Keys)
+	sessionStore = config.Store
+	return nil
+}
+
+// ParseSessionKeys parses the SESSION_KEYS setting
+// Keys are separated by commas, newest first; each key is a base64 hash key,
+// optionally followed by a colon and a base64 block key
+func ParseSessionKeys(value string) ([]SessionKey, error) {
+	var keys []SessionKey
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		hashPart, blockPart, _ := strings.Cut(entry, ":")
+		hashKey, err := base64.StdEncoding.DecodeString(hashPart)
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: invalid base64 hash key: %w", i+1, err)
+		}
+		var blockKey []byte
+		if blockPart != "" {
+			if blockKey, err = base64.StdEncoding.DecodeString(blockPart); err != nil {
+				return nil, fmt.Errorf("session key %d: invalid base64 block key: %w", i+1, err)
+			}
+		}
+		keys = append(keys, SessionKey{HashKey: hashKey, BlockKey: blockKey})
+	}
+	return keys, nil
+}
+
+// newCodecs creates the cookie codecs for the session keys, or for random keys when there are none
+func newCodecs(keys []SessionKey) []securecookie.Codec {
+	if len(keys) == 0 {
+		keys = []SessionKey{{
+			HashKey:  securecookie.GenerateRandomKey(64),
+			BlockKey: securecookie.GenerateRandomKey(32),
+		}}
+	}
+
+	result := make([]securecookie.Codec, len(keys))
+	for i, key := range keys {
+		codec := securecookie.New(key.HashKey, key.BlockKey)
+		codec.MaxAge(CookieMaxAge)
+		result[i] = codec
+	}
+	return result
+}
+
+// cookieCodecs returns the codecs of the session keys
+func cookieCodecs() []securecookie.Codec {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return codecs
+}
+
+// Sessions returns the session store, or nil when sessions are kept in the cookie only
+func Sessions() SessionStore {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return sessionStore
+}
+
+// validSession reports whether the session of a cookie is still valid
+// Without a session store every cookie that decodes is valid
+func validSession(identity Identity) bool {
+	store := Sessions()
+	if store == nil {
+		return true
+	}
+	if identity.SessionID == "" {
+		return false
+	}
+
+	session, err := store.GetSession(identity.SessionID)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Error looking up session: %v", err)
+		}
+		return false
+	}
+	return session.UserID == identity.ID
+}
+
+// Login starts the session of an authenticated user by setting the authentication cookie
+// With a session store the session is recorded on the server first
+// The login is recorded as an audit event
+// Returns the identity with its session ID
+func Login(w http.ResponseWriter, r *http.Request, identity Identity) (Identity, error) {
+	if store := Sessions(); store != nil {
+		expiresAt := time.Now().Add(CookieMaxAge * time.Second)
+		sessionID, err := store.CreateSession(identity.ID, expiresAt, r.UserAgent(), ClientIP(r))
+		if err != nil {
+			return Identity{}, err
+		}
+		identity.SessionID = sessionID
+	}
+
+	SetAuthCookie(w, identity)
+	trackIdentity(r.Context(), identity)
+	RecordEvent(r, audit.Event{Type: audit.EventLogin, UserID: identity.ID, Username: identity.Username})
+	return identity, nil
+}
+
+// Logout ends the session of a request by clearing the authentication cookie
+// With a session store the session is deleted, so a copy of the cookie can no longer be used
+func Logout(w http.ResponseWriter, r *http.Request) {
+	identity, ok := identityFromCookie(r)
+	if ok && identity.SessionID != "" {
+		if store := Sessions(); store != nil {
+			if err := store.DeleteSession(identity.SessionID); err != nil && !errors.Is(err, database.ErrNotFound) {
+				log.Printf("Error deleting session: %v", err)
+			}
+		}
+	}
+	if ok {
+		RecordEvent(r, audit.Event{Type: audit.EventLogout, UserID: identity.ID, Username: identity.Username})
+	}
+	ClearAuthCookie(w)
+}
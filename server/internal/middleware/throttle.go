@@ -0,0 +1,350 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+)
+
+// ThrottlePolicy limits the failed logins of one account or IP address
+type ThrottlePolicy struct {
+	// FreeAttempts is the number of failures allowed without delay
+	FreeAttempts int
+	// MaxFailures is the number of failures after which logins are locked out, 0 to never lock out
+	MaxFailures int
+	// BaseDelay is the wait after the first failure beyond FreeAttempts, doubled after each further failure
+	BaseDelay time.Duration
+	// Lockout is how long logins are locked out
+	// Failures are also forgotten after this long without a new failure
+	Lockout time.Duration
+}
+
+// ThrottleConfig configures the login throttle
+type ThrottleConfig struct {
+	Account ThrottlePolicy // Failures of one username, whatever the IP address
+	IP      ThrottlePolicy // Failures from one IP address, whatever the username
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// DefaultThrottleConfig is the login throttle used unless ConfigureLoginThrottle is called
+var DefaultThrottleConfig = ThrottleConfig{
+	Account: ThrottlePolicy{FreeAttempts: 3, MaxFailures: 10, BaseDelay: time.Second, Lockout: 15 * time.Minute},
+	IP:      ThrottlePolicy{FreeAttempts: 10, MaxFailures: 50, BaseDelay: time.Second, Lockout: 15 * time.Minute},
+}
+
+// ThrottledError is returned for a login attempted too soon after failed attempts
+type ThrottledError struct {
+	// RetryAfter is how long the client must wait before trying again
+	RetryAfter time.Duration
+}
+
+// Error describes the rejected login
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %s seconds", e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds returns the value of the Retry-After header for the rejected login
+func (e *ThrottledError) RetryAfterSeconds() string {
+	return strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// failedAttempts are the recent failed logins of one account or IP address
+type failedAttempts struct {
+	failures int
+	last     time.Time
+	// inFlight is the number of logins whose credentials are being checked
+	inFlight int
+}
+
+// delay returns how long the policy makes a client wait after a number of failures
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	switch {
+	case p.MaxFailures > 0 && failures >= p.MaxFailures:
+		return p.Lockout
+	case failures > p.FreeAttempts:
+		// Double the delay for each failure, up to the lockout
+		if doublings := failures - p.FreeAttempts - 1; doublings < 32 && p.BaseDelay<<doublings < p.Lockout {
+			return p.BaseDelay << doublings
+		}
+		return p.Lockout
+	}
+	return 0
+}
+
+// retryAfter returns how long the policy makes a client wait after the failed attempts, 0 if it may try now
+func (p ThrottlePolicy) retryAfter(attempts *failedAttempts, now time.Time) time.Duration {
+	if attempts == nil {
+		return 0
+	}
+
+	var wait time.Duration
+	failures := 0
+	if now.Sub(attempts.last) < p.Lockout {
+		failures = attempts.failures
+		if remaining := attempts.last.Add(p.delay(failures)).Sub(now); remaining > 0 {
+			wait = remaining
+		}
+	}
+	// Logins still being checked count as failures from now,
+	// so parallel guesses cannot all start before the first failure is recorded
+	if attempts.inFlight > 0 {
+		if inFlightWait := p.delay(failures + attempts.inFlight); inFlightWait > wait {
+			wait = inFlightWait
+		}
+	}
+	return wait
+}
+
+// LoginThrottle tracks failed logins per account and per IP address in memory
+type LoginThrottle struct {
+	mutex     sync.Mutex
+	config    ThrottleConfig
+	accounts  map[string]*failedAttempts
+	ips       map[string]*failedAttempts
+	lastPrune time.Time
+}
+
+// NewLoginThrottle creates a login throttle
+func NewLoginThrottle(config ThrottleConfig) *LoginThrottle {
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &LoginThrottle{
+		config:    config,
+		accounts:  make(map[string]*failedAttempts),
+		ips:       make(map[string]*failedAttempts),
+		lastPrune: config.Now(),
+	}
+}
+
+// Check returns how long a login for the username from the IP address must wait, 0 if it may be attempted now
+func (t *LoginThrottle) Check(ip string, username string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.config.Now()
+
+	wait := t.config.IP.retryAfter(t.ips[ip], now)
+	if username != "" {
+		if accountWait := t.config.Account.retryAfter(t.accounts[username], now); accountWait > wait {
+			wait = accountWait
+		}
+	}
+	return wait
+}
+
+// Reserve starts a login for the username from the IP address unless it must wait, like Check
+// A started login counts towards the limits until Release is called,
+// after Failure or Success has recorded its outcome
+func (t *LoginThrottle) Reserve(ip string, username string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.config.Now()
+
+	wait := t.config.IP.retryAfter(t.ips[ip], now)
+	if username != "" {
+		if accountWait := t.config.Account.retryAfter(t.accounts[username], now); accountWait > wait {
+			wait = accountWait
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	reserve(t.ips, ip)
+	if username != "" {
+		reserve(t.accounts, username)
+	}
+	return 0
+}
+
+// Release ends a login started by Reserve
+func (t *LoginThrottle) Release(ip string, username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	release(t.ips, ip)
+	if username != "" {
+		release(t.accounts, username)
+	}
+}
+
+// Failure records a failed login for the username from the IP address
+// Returns whether this failure locked out the account and the IP address
+func (t *LoginThrottle) Failure(ip string, username string) (accountLocked bool, ipLocked bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.config.Now()
+	t.prune(now)
+
+	ipLocked = recordFailure(t.ips, ip, t.config.IP, now)
+	if username != "" {
+		accountLocked = recordFailure(t.accounts, username, t.config.Account, now)
+	}
+	return accountLocked, ipLocked
+}
+
+// Success forgets the failed logins of the username after a successful login
+// Failures of the IP address are kept, so one known password does not reset guesses at other accounts
+func (t *LoginThrottle) Success(ip string, username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if entry, ok := t.accounts[username]; ok {
+		entry.failures = 0
+		entry.last = time.Time{}
+		if entry.inFlight == 0 {
+			delete(t.accounts, username)
+		}
+	}
+}
+
+// reserve counts a started login in the attempts of a key
+func reserve(attempts map[string]*failedAttempts, key string) {
+	entry, ok := attempts[key]
+	if !ok {
+		entry = &failedAttempts{}
+		attempts[key] = entry
+	}
+	entry.inFlight++
+}
+
+// release removes a finished login from the attempts of a key, forgetting the key once nothing is left
+func release(attempts map[string]*failedAttempts, key string) {
+	entry, ok := attempts[key]
+	if !ok || entry.inFlight == 0 {
+		return
+	}
+	entry.inFlight--
+	if entry.inFlight == 0 && entry.failures == 0 {
+		delete(attempts, key)
+	}
+}
+
+// recordFailure adds a failure to the attempts of a key and reports whether it reached the lockout
+func recordFailure(attempts map[string]*failedAttempts, key string, policy ThrottlePolicy, now time.Time) bool {
+	entry, ok := attempts[key]
+	if !ok {
+		entry = &failedAttempts{}
+		attempts[key] = entry
+	}
+	if now.Sub(entry.last) >= policy.Lockout {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.last = now
+	return policy.MaxFailures > 0 && entry.failures == policy.MaxFailures
+}
+
+// prune forgets the failures that no longer delay logins, at most once per lockout period
+func (t *LoginThrottle) prune(now time.Time) {
+	period := t.config.Account.Lockout
+	if t.config.IP.Lockout > period {
+		period = t.config.IP.Lockout
+	}
+	if now.Sub(t.lastPrune) < period {
+		return
+	}
+	t.lastPrune = now
+
+	for key, entry := range t.accounts {
+		if entry.inFlight == 0 && now.Sub(entry.last) >= t.config.Account.Lockout {
+			delete(t.accounts, key)
+		}
+	}
+	for key, entry := range t.ips {
+		if entry.inFlight == 0 && now.Sub(entry.last) >= t.config.IP.Lockout {
+			delete(t.ips, key)
+		}
+	}
+}
+
+var (
+	// Throttle of the logins
+	loginThrottle = NewLoginThrottle(DefaultThrottleConfig)
+	// Mutex protecting loginThrottle
+	loginThrottleMutex sync.RWMutex
+)
+
+// ConfigureLoginThrottle replaces the login throttle, forgetting all failed logins
+func ConfigureLoginThrottle(config ThrottleConfig) {
+	loginThrottleMutex.Lock()
+	defer loginThrottleMutex.Unlock()
+	loginThrottle = NewLoginThrottle(config)
+}
+
+// getLoginThrottle returns the login throttle
+func getLoginThrottle() *LoginThrottle {
+	loginThrottleMutex.RLock()
+	defer loginThrottleMutex.RUnlock()
+	return loginThrottle
+}
+
+// AuthenticateLogin checks the username and password of a login request, like Authenticate,
+// unless the account or the IP address of the request has too many failed logins,
+// in which case the password is not checked and a *ThrottledError is returned
+// A correct password of a user with two-factor authentication returns ErrTOTPRequired with the identity,
+// and the login continues with AuthenticateTOTP
+// Failed and rejected logins are recorded as audit events
+func AuthenticateLogin(r *http.Request, credentials Credentials) (Identity, error) {
+	throttle := getLoginThrottle()
+	ip := clientHost(r)
+
+	if wait := throttle.Reserve(ip, credentials.Username); wait > 0 {
+		err := &ThrottledError{RetryAfter: wait}
+		recordLoginEvent(r, audit.EventLoginThrottled, credentials.Username, err.Error())
+		return Identity{}, err
+	}
+	defer throttle.Release(ip, credentials.Username)
+
+	identity, err := Authenticate(credentials.Username, credentials.Password)
+	switch {
+	case err == nil:
+		throttle.Success(ip, credentials.Username)
+		return identity, nil
+	case errors.Is(err, ErrTOTPRequired):
+		// The failures are only forgotten once the second factor is correct too
+		return identity, err
+	case errors.Is(err, ErrInvalidCredentials):
+		recordFailedLogin(r, throttle, audit.EventLoginFailed, credentials.Username)
+	}
+	return Identity{}, err
+}
+
+// recordFailedLogin counts a failed login in the throttle and records it as an audit event,
+// along with the lockouts it causes
+func recordFailedLogin(r *http.Request, throttle *LoginThrottle, eventType string, username string) {
+	recordLoginEvent(r, eventType, username, "")
+	accountLocked, ipLocked := throttle.Failure(clientHost(r), username)
+	if accountLocked {
+		recordLoginEvent(r, audit.EventLockout, username, "account locked for "+throttle.config.Account.Lockout.String())
+	}
+	if ipLocked {
+		recordLoginEvent(r, audit.EventLockout, username, "IP address locked for "+throttle.config.IP.Lockout.String())
+	}
+}
+
+// recordLoginEvent records an audit event about a login request
+func recordLoginEvent(r *http.Request, eventType string, username string, details string) {
+	RecordEvent(r, audit.Event{Type: eventType, Username: username, Details: details})
+}
+
+// RecordEvent records an audit event caused by a request, with the IP address and user agent of the request
+// Events that name no user are recorded with the authenticated user of the request, if any
+func RecordEvent(r *http.Request, event audit.Event) {
+	if event.UserID == "" && event.Username == "" {
+		if identity, ok := CurrentUser(r); ok {
+			event.UserID = identity.ID
+			event.Username = identity.Username
+		}
+	}
+	event.IPAddress = clientHost(r)
+	event.UserAgent = r.UserAgent()
+	audit.Record(event)
+}
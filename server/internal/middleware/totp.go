@@ -0,0 +1,398 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	// TOTPIssuer names the server in authenticator apps
+	TOTPIssuer = "AllMiTools"
+	// TOTPDigits is the number of digits of a TOTP code
+	TOTPDigits = 6
+	// TOTPPeriod is how long a TOTP code is valid
+	TOTPPeriod = 30 * time.Second
+	// totpSkew is the number of periods before and after the current one whose codes are accepted,
+	// so codes still work when the clocks of the server and the device differ slightly
+	totpSkew = 1
+	// totpSecretBytes is the number of random bytes of a TOTP secret, 160 bits as recommended by RFC 4226
+	totpSecretBytes = 20
+	// RecoveryCodeCount is the number of recovery codes generated for a user
+	RecoveryCodeCount = 10
+	// recoveryCodeBytes is the number of random bytes of a recovery code
+	recoveryCodeBytes = 5
+	// PendingLoginCookieName is the name of the cookie carrying a login waiting for its second factor
+	PendingLoginCookieName = "allmitools_login"
+	// pendingLoginMaxAge is how long the second factor of a login can be entered
+	pendingLoginMaxAge = 5 * time.Minute
+)
+
+var (
+	// ErrTOTPRequired is returned by Authenticate for a correct password of a user with two-factor authentication
+	ErrTOTPRequired = errors.New("two-factor authentication code required")
+	// ErrInvalidTOTP is returned for a wrong, reused or expired TOTP code or recovery code
+	ErrInvalidTOTP = errors.New("invalid authentication code")
+	// ErrTOTPEnabled is returned when enrolling a user who already uses two-factor authentication
+	ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnrolled is returned when confirming or using TOTP for a user without a TOTP secret
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not set up")
+)
+
+// secretEncoding encodes TOTP secrets as authenticator apps expect them
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPStore keeps the TOTP secrets and recovery codes of the users
+// The database UserDAO is the default store
+type TOTPStore interface {
+	GetUserByID(id string) (*database.User, error)
+	SetTOTPSecret(id string, secret string) error
+	EnableTOTP(id string, recoveryCodeHashes []string) error
+	DisableTOTP(id string) error
+	ReplaceRecoveryCodes(userID string, codeHashes []string) error
+	UseTOTPStep(id string, step int64) (bool, error)
+	UseRecoveryCode(userID string, codeHash string) error
+}
+
+var (
+	// Store of the TOTP secrets, nil to use the database
+	totpStore TOTPStore
+	// Mutex protecting totpStore
+	totpStoreMutex sync.RWMutex
+)
+
+// SetTOTPStore replaces the store of the TOTP secrets and recovery codes
+// Passing nil restores the database store
+func SetTOTPStore(store TOTPStore) {
+	totpStoreMutex.Lock()
+	defer totpStoreMutex.Unlock()
+	totpStore = store
+}
+
+// TOTPs returns the configured TOTP store, or the database store
+func TOTPs() (TOTPStore, error) {
+	totpStoreMutex.RLock()
+	store := totpStore
+	totpStoreMutex.RUnlock()
+	if store != nil {
+		return store, nil
+	}
+	return database.GetUserDAO()
+}
+
+// GenerateTOTPSecret returns a new random base32 TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	random := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("error generating TOTP secret: %w", err)
+	}
+	return secretEncoding.EncodeToString(random), nil
+}
+
+// TOTPCode returns the RFC 6238 code of a base32 secret at a time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// TOTPURI returns the otpauth URI that enrolls a secret in an authenticator app, usually shown as a QR code
+func TOTPURI(username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(TOTPDigits))
+	query.Set("period", strconv.Itoa(int(TOTPPeriod/time.Second)))
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+username) + "?" + query.Encode()
+}
+
+// GenerateRecoveryCodes returns new random recovery codes and the hashes under which they are stored
+func GenerateRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %w", err)
+		}
+		code := strings.ToLower(secretEncoding.EncodeToString(random))
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash under which a recovery code is stored
+// Case, spaces and dashes are ignored so codes can be typed loosely
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashAPIKey(normalized)
+}
+
+// EnrollTOTP stores a new TOTP secret for a user and returns it with its enrollment URI
+// Two-factor authentication is enabled once the user confirms a code with ConfirmTOTP
+func EnrollTOTP(store TOTPStore, userID string) (secret string, uri string, err error) {
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPEnabled
+	}
+
+	if secret, err = GenerateTOTPSecret(); err != nil {
+		return "", "", err
+	}
+	if err := store.SetTOTPSecret(user.ID, secret); err != nil {
+		return "", "", err
+	}
+	return secret, TOTPURI(user.Username, secret), nil
+}
+
+// ConfirmTOTP enables two-factor authentication for a user once a code of the enrolled secret is correct
+// Returns the recovery codes of the user, which are not stored and cannot be shown again
+func ConfirmTOTP(store TOTPStore, userID string, code string) ([]string, error) {
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err := verifyTOTPCode(store, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.EnableTOTP(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user and returns the new codes
+func RegenerateRecoveryCodes(store TOTPStore, userID string) ([]string, error) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or a recovery code of a user with two-factor authentication
+// Each TOTP code and recovery code is only accepted once
+// Returns whether a recovery code was used, or ErrInvalidTOTP when the code is not accepted
+func VerifySecondFactor(store TOTPStore, user *database.User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, ErrTOTPNotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == TOTPDigits {
+		return false, verifyTOTPCode(store, user, code)
+	}
+
+	err := store.UseRecoveryCode(user.ID, HashRecoveryCode(code))
+	if errors.Is(err, database.ErrNotFound) {
+		return false, ErrInvalidTOTP
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyTOTPCode checks a TOTP code against the secret of a user and records its time step,
+// so the same code cannot be used again
+func verifyTOTPCode(store TOTPStore, user *database.User, code string) error {
+	key, err := decodeTOTPSecret(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := matchTOTP(key, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return ErrInvalidTOTP
+	}
+	fresh, err := store.UseTOTPStep(user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidTOTP
+	}
+	return nil
+}
+
+// AuthenticateTOTP checks the second factor of a login waiting for it, see StartPendingLogin
+// Like AuthenticateLogin it is throttled, and failures are recorded as audit events
+// Returns ErrInvalidTOTP for a wrong code, or a *ThrottledError after too many failed attempts
+func AuthenticateTOTP(r *http.Request, identity Identity, code string) (Identity, error) {
+	throttle := getLoginThrottle()
+	ip := clientHost(r)
+
+	if wait := throttle.Reserve(ip, identity.Username); wait > 0 {
+		err := &ThrottledError{RetryAfter: wait}
+		recordLoginEvent(r, audit.EventLoginThrottled, identity.Username, err.Error())
+		return Identity{}, err
+	}
+	defer throttle.Release(ip, identity.Username)
+
+	store, err := TOTPs()
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+	user, err := store.GetUserByID(identity.ID)
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+
+	recovery, err := VerifySecondFactor(store, user, code)
+	if errors.Is(err, ErrInvalidTOTP) {
+		recordFailedLogin(r, throttle, audit.EventTOTPFailed, identity.Username)
+		return Identity{}, err
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	throttle.Success(ip, identity.Username)
+	if recovery {
+		recordLoginEvent(r, audit.EventRecoveryCodeUsed, identity.Username, "")
+	}
+	return identity, nil
+}
+
+// StartPendingLogin sets the cookie of a login whose password is correct but whose second factor is still needed
+// The cookie is signed and encrypted like the authentication cookie and expires after a few minutes
+func StartPendingLogin(w http.ResponseWriter, identity Identity) error {
+	value := map[string]string{
+		"user_id":  identity.ID,
+		"username": identity.Username,
+		"scopes":   strings.Join(identity.Scopes, " "),
+		"expires":  strconv.FormatInt(time.Now().Add(pendingLoginMaxAge).Unix(), 10),
+	}
+	encoded, err := securecookie.EncodeMulti(PendingLoginCookieName, value, cookieCodecs()...)
+	if err != nil {
+		return fmt.Errorf("error encoding pending login: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     PendingLoginCookieName,
+		Value:    encoded,
+		Path:     "/login",
+		MaxAge:   int(pendingLoginMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// PendingLogin returns the user of a login waiting for its second factor
+func PendingLogin(r *http.Request) (Identity, bool) {
+	cookie, err := r.Cookie(PendingLoginCookieName)
+	if err != nil {
+		return Identity{}, false
+	}
+
+	value := make(map[string]string)
+	if err := securecookie.DecodeMulti(PendingLoginCookieName, cookie.Value, &value, cookieCodecs()...); err != nil {
+		return Identity{}, false
+	}
+	expires, err := strconv.ParseInt(value["expires"], 10, 64)
+	if err != nil || time.Now().Unix() > expires || value["user_id"] == "" {
+		return Identity{}, false
+	}
+
+	return Identity{
+		ID:       value["user_id"],
+		Username: value["username"],
+		Scopes:   strings.Fields(value["scopes"]),
+	}, true
+}
+
+// ClearPendingLogin clears the cookie of a login waiting for its second factor
+func ClearPendingLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     PendingLoginCookieName,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// decodeTOTPSecret decodes a base32 secret, ignoring case, spaces and padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.NewReplacer(" ", "", "=", "").Replace(secret))
+	key, err := secretEncoding.DecodeString(normalized)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("invalid TOTP secret")
+	}
+	return key, nil
+}
+
+// totpStep returns the RFC 6238 time step of a time
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// matchTOTP returns the time step of the code around the given time that equals the code, if any
+func matchTOTP(key []byte, code string, now time.Time) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp returns the RFC 4226 HMAC-SHA1 code of a key and counter
+func hotp(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulus)
+}
@@ -0,0 +1,181 @@
+// Package middleware contains HTTP middleware for the AllMiTools server
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+// ErrInvalidCredentials is returned when a username and password do not match a user
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Identity is the authenticated user of a request
+type Identity struct {
+	ID       string   `json:"id"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes,omitempty"` // Scopes granted to the request, see HasScopes
+	// SessionID is the server-side session of the login, empty without a session store
+	SessionID string `json:"-"`
+	// APIKeyID is the API key the request was authenticated with, empty for logins
+	APIKeyID string `json:"-"`
+}
+
+// UserStore looks up the users that can log in
+// The database UserDAO is the default store
+type UserStore interface {
+	GetUserByUsername(username string) (*database.User, error)
+	UpdatePasswordHash(id string, passwordHash string) error
+}
+
+var (
+	// Store used by Authenticate, nil to use the database
+	userStore UserStore
+	// Mutex protecting userStore
+	userStoreMutex sync.RWMutex
+)
+
+// SetUserStore replaces the store used to look up users
+// Passing nil restores the database store
+func SetUserStore(store UserStore) {
+	userStoreMutex.Lock()
+	defer userStoreMutex.Unlock()
+	userStore = store
+}
+
+// getUserStore returns the configured store, or the database store
+func getUserStore() (UserStore, error) {
+	userStoreMutex.RLock()
+	store := userStore
+	userStoreMutex.RUnlock()
+	if store != nil {
+		return store, nil
+	}
+	return database.GetUserDAO()
+}
+
+// Authenticate checks a username and password and returns the identity of the user
+// Unknown users and wrong passwords both return ErrInvalidCredentials
+// Users with two-factor authentication get their identity with ErrTOTPRequired, as the password alone does not log them in
+func Authenticate(username string, password string) (Identity, error) {
+	if username == "" || password == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	store, err := getUserStore()
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+
+	user, err := store.GetUserByUsername(username)
+	if errors.Is(err, database.ErrNotFound) {
+		VerifyPassword(password, dummyPasswordHash)
+		return Identity{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up user: %w", err)
+	}
+
+	if !VerifyPassword(password, user.PasswordHash) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	// Replace legacy and outdated hashes now that the password is known
+	if NeedsRehash(user.PasswordHash) {
+		if err := rehashPassword(store, user, password); err != nil {
+			log.Printf("Error upgrading the password hash of user %s: %v", user.Username, err)
+		}
+	}
+	identity := Identity{ID: user.ID, Username: user.Username, Scopes: user.Scopes}
+	if user.TOTPEnabled {
+		return identity, ErrTOTPRequired
+	}
+	return identity, nil
+}
+
+// rehashPassword stores a new hash of the password of a user
+func rehashPassword(store UserStore, user *database.User, password string) error {
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return store.UpdatePasswordHash(user.ID, passwordHash)
+}
+
+// HasScopes reports whether the user has every given scope
+func (identity Identity) HasScopes(scopes ...string) bool {
+	return models.HasScopes(identity.Scopes, scopes...)
+}
+
+// identityKey is the context key of the authenticated user
+type identityKey struct{}
+
+// trackerKey is the context key of the identityTracker of a request
+type trackerKey struct{}
+
+// identityTracker records the user authenticated further down the handler chain
+type identityTracker struct {
+	mutex    sync.Mutex
+	identity Identity
+	ok       bool
+}
+
+// set records the authenticated user
+func (t *identityTracker) set(identity Identity) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.identity = identity
+	t.ok = true
+}
+
+// get returns the recorded user
+func (t *identityTracker) get() (Identity, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.identity, t.ok
+}
+
+// TrackIdentity returns a copy of the context that records the user authenticated while handling the request,
+// and a function returning that user once the request is handled
+// It lets middleware in front of AuthMiddleware, such as the request logger, see who made the request
+func TrackIdentity(ctx context.Context) (context.Context, func() (Identity, bool)) {
+	tracker := &identityTracker{}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		tracker.set(identity)
+	}
+	return context.WithValue(ctx, trackerKey{}, tracker), tracker.get
+}
+
+// trackIdentity records the authenticated user in the tracker of the context, if any
+func trackIdentity(ctx context.Context, identity Identity) {
+	if tracker, ok := ctx.Value(trackerKey{}).(*identityTracker); ok {
+		tracker.set(identity)
+	}
+}
+
+// WithIdentity returns a copy of the context carrying the authenticated user
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	trackIdentity(ctx, identity)
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the authenticated user carried by the context
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// CurrentUser returns the authenticated user of a request
+// The user set by AuthMiddleware in the request context takes precedence over the session cookie
+func CurrentUser(r *http.Request) (Identity, bool) {
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return identity, true
+	}
+	return identityFromCookie(r)
+}
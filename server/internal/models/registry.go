@@ -0,0 +1,124 @@
+// Package models contains the data structures for the AllMiTools server
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Tool is the interface implemented by every tool served by AllMiTools
+// Info describes the tool and its parameters, Execute runs it against a request
+// The result of Execute is encoded as is in JSON responses, see ResultString for its text form
+type Tool interface {
+	Info() ToolInfo
+	Execute(r *http.Request) (any, error)
+}
+
+// FileTool is implemented by tools whose result is served as a file download
+// instead of being rendered in one of the regular output formats
+type FileTool interface {
+	Tool
+	ExecuteFile(r *http.Request) (content string, filename string, err error)
+}
+
+// ResultString returns the text form of a tool result used by the raw and html output formats
+// Results implementing fmt.Stringer control their own text form
+func ResultString(result any) string {
+	switch v := result.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// VersionedResult is implemented by tool results describing a version of a stored resource
+// ETag returns the quoted entity tag of the version, which is sent in the ETag header
+// so clients can make conditional changes with If-Match
+type VersionedResult interface {
+	ETag() string
+}
+
+var (
+	// Registered public tools keyed by name
+	registeredTools = make(map[string]Tool)
+	// Registered private tools keyed by name
+	registeredPrivateTools = make(map[string]Tool)
+	// Mutex protecting the registries and the available* maps
+	registryMutex sync.RWMutex
+)
+
+// RegisterTool makes a public tool available under /tools/{name}
+// It is intended to be called from the init function of the tool's file
+// and panics if the tool info is invalid or a tool with the same name is already registered
+func RegisterTool(tool Tool) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	info := tool.Info()
+	if err := info.Validate(); err != nil {
+		panic(fmt.Sprintf("models: invalid tool %s: %v", info.Name, err))
+	}
+	if _, exists := registeredTools[info.Name]; exists {
+		panic(fmt.Sprintf("models: tool %s registered twice", info.Name))
+	}
+
+	registeredTools[info.Name] = tool
+	availableTools[info.Name] = info
+}
+
+// RegisterPrivateTool makes a tool available under /private/tools/{name}
+// Private tools always require authentication, and callers also need the given scopes
+// It panics if the tool info or a scope is invalid, or a private tool with the same name is already registered
+func RegisterPrivateTool(tool Tool, scopes ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	info := tool.Info()
+	if err := info.Validate(); err != nil {
+		panic(fmt.Sprintf("models: invalid private tool %s: %v", info.Name, err))
+	}
+	scopes, err := NormalizeScopes(scopes)
+	if err != nil {
+		panic(fmt.Sprintf("models: invalid private tool %s: %v", info.Name, err))
+	}
+	if _, exists := registeredPrivateTools[info.Name]; exists {
+		panic(fmt.Sprintf("models: private tool %s registered twice", info.Name))
+	}
+
+	registeredPrivateTools[info.Name] = tool
+	availablePrivateTools[info.Name] = PrivateToolInfo{
+		ToolInfo:     info,
+		RequiresAuth: true,
+		Scopes:       scopes,
+	}
+}
+
+// GetTool returns the registered public tool with the given name
+func GetTool(toolName string) (Tool, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	tool, exists := registeredTools[toolName]
+	if !exists {
+		return nil, fmt.Errorf("tool not found: %s", toolName)
+	}
+	return tool, nil
+}
+
+// GetPrivateTool returns the registered private tool with the given name
+func GetPrivateTool(toolName string) (Tool, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	tool, exists := registeredPrivateTools[toolName]
+	if !exists {
+		return nil, fmt.Errorf("tool not found: %s", toolName)
+	}
+	return tool, nil
+}
@@ -0,0 +1,90 @@
+// Package models contains the data structures for the AllMiTools server
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scopes grant users and API keys access to private tools and endpoints
+const (
+	ScopeStorageRead  = "storage:read"  // Read stored text
+	ScopeStorageWrite = "storage:write" // Store text
+	ScopeAdmin        = "admin"         // Maintenance endpoints, implies every other scope
+)
+
+// KnownScopes lists every scope that can be granted
+var KnownScopes = []string{ScopeStorageRead, ScopeStorageWrite, ScopeAdmin}
+
+// ParseScopes parses a list of scopes separated by spaces or commas
+// The result is sorted and free of duplicates; unknown scopes are an error
+func ParseScopes(value string) ([]string, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	return NormalizeScopes(fields)
+}
+
+// NormalizeScopes sorts a list of scopes and removes duplicates
+// Unknown scopes are an error
+func NormalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q, known scopes are %s", scope, strings.Join(KnownScopes, ", "))
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// isKnownScope reports whether a scope is one of KnownScopes
+func isKnownScope(scope string) bool {
+	for _, known := range KnownScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopes reports whether the granted scopes include every required scope
+// The admin scope includes every other scope
+func HasScopes(granted []string, required ...string) bool {
+	for _, scope := range required {
+		if !hasScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasScope reports whether the granted scopes include a scope
+func hasScope(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope || g == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitScopes returns the requested scopes that are included in the granted scopes
+// It gives an API key no more access than the user it acts as
+func LimitScopes(requested []string, granted []string) []string {
+	result := []string{}
+	for _, scope := range requested {
+		if hasScope(granted, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result
+}
@@ -4,15 +4,38 @@ package models
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // ToolParameter represents a parameter for a tool
+// The constraint fields are optional and are enforced by the server before a tool runs
 type ToolParameter struct {
-	Name        string `json:"name"`        // Name of the parameter
-	Type        string `json:"type"`        // Type of the parameter (string, int, bool, etc.)
-	Description string `json:"description"` // Description of the parameter
-	Required    bool   `json:"required"`    // Whether the parameter is required
-	Default     any    `json:"default"`     // Default value for the parameter (if any)
+	Name        string   `json:"name"`                 // Name of the parameter
+	Type        string   `json:"type"`                 // Type of the parameter (string, int, bool, etc.)
+	Description string   `json:"description"`          // Description of the parameter
+	Required    bool     `json:"required"`             // Whether the parameter is required
+	Default     any      `json:"default"`              // Default value for the parameter (if any)
+	Enum        []string `json:"enum,omitempty"`       // Allowed values (if restricted)
+	Min         *float64 `json:"min,omitempty"`        // Minimum value for int and float parameters
+	Max         *float64 `json:"max,omitempty"`        // Maximum value for int and float parameters
+	MinLength   *int     `json:"min_length,omitempty"` // Minimum length for string parameters
+	MaxLength   *int     `json:"max_length,omitempty"` // Maximum length for string parameters
+	Pattern     string   `json:"pattern,omitempty"`    // Regular expression string values must match
+	Multiline   bool     `json:"multiline,omitempty"`  // Whether a string parameter holds multiple lines of text
+}
+
+// Float returns a pointer to v, for use in the Min and Max constraints of a ToolParameter
+func Float(v float64) *float64 {
+	return &v
+}
+
+// Int returns a pointer to v, for use in the MinLength and MaxLength constraints of a ToolParameter
+func Int(v int) *int {
+	return &v
 }
 
 // Validate checks if the parameter is valid
@@ -38,10 +61,90 @@ func (p *ToolParameter) Validate() error {
 	if !validTypes[p.Type] {
 		return fmt.Errorf("invalid parameter type: %s", p.Type)
 	}
+
+	// Validate constraints against the parameter type
+	isNumber := p.Type == "int" || p.Type == "float"
+	if (p.Min != nil || p.Max != nil) && !isNumber {
+		return fmt.Errorf("min and max only apply to int and float parameters, not %s", p.Type)
+	}
+	if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+		return errors.New("min cannot be greater than max")
+	}
+
+	isString := p.Type == "string"
+	if (p.MinLength != nil || p.MaxLength != nil || p.Pattern != "" || p.Multiline) && !isString {
+		return fmt.Errorf("length, pattern and multiline only apply to string parameters, not %s", p.Type)
+	}
+	if p.MinLength != nil && *p.MinLength < 0 {
+		return errors.New("min length cannot be negative")
+	}
+	if p.MinLength != nil && p.MaxLength != nil && *p.MinLength > *p.MaxLength {
+		return errors.New("min length cannot be greater than max length")
+	}
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+
+	if len(p.Enum) > 0 && !isString && !isNumber {
+		return fmt.Errorf("enum only applies to string, int and float parameters, not %s", p.Type)
+	}
 	
 	return nil
 }
 
+// CheckValue checks a bound parameter value against the parameter's constraints
+// It returns a message describing the first violated constraint, or an empty string
+func (p *ToolParameter) CheckValue(value any) string {
+	if len(p.Enum) > 0 {
+		valueStr := fmt.Sprint(value)
+		allowed := false
+		for _, option := range p.Enum {
+			if option == valueStr {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("must be one of: %s", strings.Join(p.Enum, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		return p.checkRange(float64(v))
+	case float64:
+		return p.checkRange(v)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if p.MinLength != nil && length < *p.MinLength {
+			return fmt.Sprintf("must be at least %d characters long", *p.MinLength)
+		}
+		if p.MaxLength != nil && length > *p.MaxLength {
+			return fmt.Sprintf("must be at most %d characters long", *p.MaxLength)
+		}
+		if p.Pattern != "" {
+			if matched, err := regexp.MatchString(p.Pattern, v); err != nil || !matched {
+				return fmt.Sprintf("must match the pattern %s", p.Pattern)
+			}
+		}
+	}
+
+	return ""
+}
+
+// checkRange checks a numeric value against the Min and Max constraints
+func (p *ToolParameter) checkRange(v float64) string {
+	if p.Min != nil && v < *p.Min {
+		return "must be at least " + strconv.FormatFloat(*p.Min, 'f', -1, 64)
+	}
+	if p.Max != nil && v > *p.Max {
+		return "must be at most " + strconv.FormatFloat(*p.Max, 'f', -1, 64)
+	}
+	return ""
+}
+
 // ToolInfo represents information about a tool
 type ToolInfo struct {
 	Name        string          `json:"name"`        // Name of the tool
@@ -77,34 +180,34 @@ func (t *ToolInfo) Validate() error {
 	return nil
 }
 
-// AvailableTools is a map of available tools
-var AvailableTools = map[string]ToolInfo{
-	"random-number":  RandomNumberTool(),
-	"random-string":  RandomStringTool(),
-	"text-file":      TextFileTool(),
-	"text-formatter": TextFormatterTool(),
-	"date":           DateFormatterTool(),
-	"day":            DayTool(),
-	"month":          MonthTool(),
-	"year":           YearTool(),
-	"url-encoder":    URLEncoderTool(),
-	"sha256-hasher":  SHA256HasherTool(),
-}
+// availableTools is a map of available tools
+// It is populated by RegisterTool, usually from the init functions in the tools package,
+// and read with GetToolInfo and ListTools under registryMutex
+var availableTools = map[string]ToolInfo{}
 
 // GetToolInfo returns information about a tool
 func GetToolInfo(toolName string) (ToolInfo, error) {
-	tool, exists := AvailableTools[toolName]
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	tool, exists := availableTools[toolName]
 	if !exists {
 		return ToolInfo{}, fmt.Errorf("tool not found: %s", toolName)
 	}
 	return tool, nil
 }
 
-// ListTools returns a list of all available tools
+// ListTools returns a list of all available tools sorted by name
 func ListTools() []ToolInfo {
-	tools := make([]ToolInfo, 0, len(AvailableTools))
-	for _, tool := range AvailableTools {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	tools := make([]ToolInfo, 0, len(availableTools))
+	for _, tool := range availableTools {
 		tools = append(tools, tool)
 	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
 	return tools
 }
@@ -0,0 +1,380 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE against an identity provider
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// clockSkew is the difference between the clocks of the server and the identity provider that is tolerated
+const clockSkew = time.Minute
+
+// maxResponseSize is the maximum size of a response of the identity provider
+const maxResponseSize = 1 << 20
+
+// ErrInvalidIDToken is returned when the ID token of a login is not valid for this client
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Config is the configuration of an OpenID Connect client
+type Config struct {
+	Issuer       string   // Issuer URL of the identity provider exactly as in its ID tokens, its discovery document is at /.well-known/openid-configuration
+	ClientID     string   // Client ID registered with the identity provider
+	ClientSecret string   // Client secret, empty for public clients
+	RedirectURL  string   // URL of the callback page, registered with the identity provider
+	Scopes       []string // Scopes to request, openid is always requested
+
+	// HTTPClient is used to talk to the identity provider, nil for http.DefaultClient
+	HTTPClient *http.Client
+	// Now returns the current time, nil for time.Now
+	Now func() time.Time
+}
+
+// Claims are the claims of a verified ID token
+type Claims map[string]interface{}
+
+// String returns a string claim, empty if it is missing or not a string
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns a claim holding a list of strings, such as groups
+// A single string claim is split on spaces and commas
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Subject returns the subject of the ID token, the identifier of the user at the identity provider
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// discovery is the part of the discovery document of an identity provider used by the client
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is an RSA key of the key set of an identity provider
+type jsonWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// Provider is an identity provider
+// The discovery document and the signing keys are fetched on first use and cached
+type Provider struct {
+	config Config
+
+	mutex     sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewProvider creates a provider from the configuration
+func NewProvider(config Config) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OpenID Connect needs an issuer, a client ID and a redirect URL")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Provider{config: config}, nil
+}
+
+// Config returns the configuration of the provider
+func (p *Provider) Config() Config {
+	return p.config
+}
+
+// AuthCodeURL returns the URL of the identity provider's login page
+// The state and nonce must be random and kept with the login, and the verifier is the PKCE code verifier
+// whose S256 challenge is sent to the identity provider
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	oauthConfig, err := p.oauthConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauthConfig.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange redeems the authorization code of a login with the PKCE code verifier
+// and returns the claims of the verified ID token, which must carry the nonce of the login
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	oauthConfig, err := p.oauthConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauthConfig.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.config.HTTPClient), code,
+		oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("error redeeming authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: the token response has no ID token", ErrInvalidIDToken)
+	}
+
+	claims, err := p.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.String("nonce") != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match the login", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// Verify checks the signature, issuer, audience and expiry of an ID token and returns its claims
+// Tokens must be signed with RS256 by a key of the provider's key set
+func (p *Provider) Verify(ctx context.Context, rawIDToken string) (Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidIDToken, err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported signing algorithm %q", ErrInvalidIDToken, header.Algorithm)
+	}
+
+	key, err := p.signingKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidIDToken, err)
+	}
+	if err := p.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks the registered claims of an ID token
+func (p *Provider) validateClaims(claims Claims) error {
+	if claims.String("iss") != p.config.Issuer {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.String("iss"))
+	}
+	if claims.Subject() == "" {
+		return fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	audiences := claims.Strings("aud")
+	if !contains(audiences, p.config.ClientID) {
+		return fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+	if len(audiences) > 1 && claims.String("azp") != p.config.ClientID {
+		return fmt.Errorf("%w: authorized party is not this client", ErrInvalidIDToken)
+	}
+
+	expiry, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidIDToken)
+	}
+	if p.config.Now().Add(-clockSkew).After(time.Unix(int64(expiry), 0)) {
+		return fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// oauthConfig returns the OAuth 2.0 configuration of the provider's endpoints
+func (p *Provider) oauthConfig(ctx context.Context) (*oauth2.Config, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range p.config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}, nil
+}
+
+// getDiscovery returns the discovery document of the provider, fetching it on first use
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discovery
+	// The issuer is compared exactly, as some providers end it with a slash, but the slash is not doubled in the URL
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("error fetching the OpenID configuration: %w", err)
+	}
+	if doc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("the OpenID configuration is for issuer %q instead of %q", doc.Issuer, p.config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("the OpenID configuration lacks the authorization, token or key set endpoint")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// signingKey returns a signing key of the provider by key ID
+// The key set is fetched again for unknown keys, as providers rotate their keys
+func (p *Provider) signingKey(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key := p.findKey(keyID); key != nil {
+		return key, nil
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, doc.JWKSURI, &keySet); err != nil {
+		return nil, fmt.Errorf("error fetching the signing keys: %w", err)
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.KeyID] = key
+	}
+
+	if key := p.findKey(keyID); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, keyID)
+}
+
+// findKey returns a cached signing key, any key when the token names none and there is only one
+// The caller must hold the mutex
+func (p *Provider) findKey(keyID string) *rsa.PublicKey {
+	if keyID == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[keyID]
+}
+
+// getJSON fetches a JSON document of the provider
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// publicKey converts a JSON web key to an RSA public key
+func (jwk jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// contains reports whether a list of strings contains a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
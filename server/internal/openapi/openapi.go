@@ -0,0 +1,473 @@
+// Package openapi builds OpenAPI 3 descriptions of the AllMiTools tool endpoints
+package openapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/render"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+)
+
+// envelopeFormats are the output formats that wrap the result in the ToolResponse envelope
+var envelopeFormats = map[string]bool{"json": true, "xml": true, "yaml": true}
+
+// Version is the OpenAPI specification version of the generated documents
+const Version = "3.0.3"
+
+// Names of the security schemes used by private tools
+const (
+	CookieAuthScheme = "cookieAuth" // Session cookie set by logging in
+	BearerAuthScheme = "bearerAuth" // API key sent as a bearer token
+)
+
+// Document is the root object of an OpenAPI document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Components holds the reusable schemas, responses and security schemes
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	Responses       map[string]*Response       `json:"responses,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// PathItem describes the operations available on a single path
+type PathItem struct {
+	Get  *Operation `json:"get,omitempty"`
+	Post *Operation `json:"post,omitempty"`
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter describes a query or path parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body accepted by an operation
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a single response of an operation
+// Responses that only reference a shared response set Ref
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType describes the schema of a request or response body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used by the generated documents
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	Default     any                `json:"default,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+}
+
+// SecurityScheme describes how requests are authenticated
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Tool describes a registered tool to add to a document
+type Tool struct {
+	Info    models.ToolInfo
+	Path    string   // Path of the tool endpoint, e.g. /tools/random-number
+	File    bool     // Whether the tool result is served as a file download
+	Private bool     // Whether the tool requires authentication
+	Scopes  []string // Scopes a private tool requires
+}
+
+// NewDocument creates a document with the shared ToolResponse schemas and responses
+func NewDocument(title, description string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       title,
+			Description: description,
+			Version:     "1.0.0",
+		},
+		Paths: make(map[string]*PathItem),
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"ToolResponse": {
+					Type:        "object",
+					Description: "Envelope returned by every tool in the json output format and for all errors",
+					Required:    []string{"success"},
+					Properties: map[string]*Schema{
+						"success": {Type: "boolean", Description: "Whether the tool executed successfully"},
+						"message": {Type: "string", Description: "Human readable summary of the result"},
+						"data":    {Description: "The tool result"},
+						"error":   {Type: "string", Description: "Error message when success is false"},
+						"code": {
+							Type:        "string",
+							Description: "Machine readable error code when success is false",
+							Enum: []any{
+								tools.CodeValidation, tools.CodeNotFound, tools.CodeGone, tools.CodePreconditionFailed, tools.CodeUnauthorized, tools.CodeForbidden, tools.CodeUnavailable,
+								tools.CodeInternal, tools.CodeBadRequest, tools.CodeNotAcceptable, tools.CodeRequestTooLarge,
+							},
+						},
+						"errors": {
+							Type:        "array",
+							Description: "Rejected parameters when the request failed validation",
+							Items:       &Schema{Ref: "#/components/schemas/FieldError"},
+						},
+					},
+				},
+				"FieldError": {
+					Type:     "object",
+					Required: []string{"field", "message"},
+					Properties: map[string]*Schema{
+						"field":   {Type: "string", Description: "Name of the rejected parameter"},
+						"message": {Type: "string", Description: "Why the value was rejected"},
+					},
+				},
+			},
+			Responses: map[string]*Response{
+				"ValidationError": {
+					Description: "One or more parameters are missing or invalid",
+					Content:     jsonContent(toolResponseRef()),
+				},
+				"NotFound": {
+					Description: "The tool does not exist",
+					Content:     jsonContent(toolResponseRef()),
+				},
+				"NotAcceptable": {
+					Description: "The requested output format or Accept header cannot be served",
+					Content:     jsonContent(toolResponseRef()),
+				},
+				"Unavailable": {
+					Description: "A service the tool depends on, such as the database, is unavailable",
+					Content:     jsonContent(toolResponseRef()),
+				},
+				"InternalError": {
+					Description: "The tool failed unexpectedly",
+					Content:     jsonContent(toolResponseRef()),
+				},
+			},
+		},
+	}
+}
+
+// AddCookieAuth registers the cookie based security scheme used by private tools
+func (d *Document) AddCookieAuth(cookieName string) {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = make(map[string]*SecurityScheme)
+	}
+	d.Components.SecuritySchemes[CookieAuthScheme] = &SecurityScheme{
+		Type:        "apiKey",
+		In:          "cookie",
+		Name:        cookieName,
+		Description: "Session cookie set by POST /login",
+	}
+	d.Components.Responses["Unauthenticated"] = &Response{
+		Description: "The request is not authenticated and is redirected to the login page",
+		Headers: map[string]*Header{
+			"Location": {Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+// AddBearerAuth registers the API key security scheme used by private tools
+func (d *Document) AddBearerAuth() {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = make(map[string]*SecurityScheme)
+	}
+	d.Components.SecuritySchemes[BearerAuthScheme] = &SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "API key sent as 'Authorization: Bearer <key>'",
+	}
+	d.Components.Responses["InvalidAPIKey"] = &Response{
+		Description: "The API key is unknown, expired or revoked",
+		Content:     jsonContent(toolResponseRef()),
+	}
+	d.Components.Responses["Forbidden"] = &Response{
+		Description: "The user or API key does not have the scopes the tool requires",
+		Content:     jsonContent(toolResponseRef()),
+	}
+}
+
+// AddTool adds the GET and POST operations of a tool to the document
+func (d *Document) AddTool(tool Tool) {
+	info := tool.Info
+	operationID := operationID(info.Name)
+
+	tags := []string{"tools"}
+	if tool.Private {
+		tags = []string{"private-tools"}
+	}
+
+	// GET takes every parameter from the query string
+	var queryParams []*Parameter
+	for _, param := range info.Parameters {
+		queryParams = append(queryParams, &Parameter{
+			Name:        param.Name,
+			In:          "query",
+			Description: param.Description,
+			Required:    param.Required,
+			Schema:      ParameterSchema(param),
+		})
+	}
+	if !tool.File {
+		queryParams = append(queryParams, outputFormatParameter())
+	}
+
+	get := &Operation{
+		OperationID: "get" + operationID,
+		Summary:     info.Description,
+		Description: "Runs the tool with parameters from the query string. Without any parameters the tool page (or, for JSON clients, the tool info) is returned instead.",
+		Tags:        tags,
+		Parameters:  queryParams,
+		Responses:   d.toolResponses(tool),
+	}
+
+	// POST accepts the same parameters as a JSON object or form body
+	body := bodySchema(info.Parameters, !tool.File)
+	post := &Operation{
+		OperationID: "post" + operationID,
+		Summary:     info.Description,
+		Description: "Runs the tool with parameters from a JSON, urlencoded or multipart body.",
+		Tags:        tags,
+		RequestBody: &RequestBody{
+			Required: len(body.Required) > 0,
+			Content: map[string]*MediaType{
+				"application/json":                  {Schema: body},
+				"application/x-www-form-urlencoded": {Schema: body},
+				"multipart/form-data":               {Schema: body},
+			},
+		},
+		Responses: d.toolResponses(tool),
+	}
+
+	if tool.Private {
+		// Either scheme authenticates the request
+		security := []map[string][]string{{CookieAuthScheme: {}}, {BearerAuthScheme: {}}}
+		get.Security = security
+		post.Security = security
+
+		if len(tool.Scopes) > 0 {
+			requires := fmt.Sprintf(" Requires the scopes: %s.", strings.Join(tool.Scopes, ", "))
+			get.Description += requires
+			post.Description += requires
+		}
+	}
+
+	d.Paths[tool.Path] = &PathItem{Get: get, Post: post}
+}
+
+// toolResponses returns the responses of a tool operation
+func (d *Document) toolResponses(tool Tool) map[string]*Response {
+	responses := map[string]*Response{
+		"404": {Ref: "#/components/responses/NotFound"},
+		"422": {Ref: "#/components/responses/ValidationError"},
+		"500": {Ref: "#/components/responses/InternalError"},
+	}
+
+	if tool.File {
+		responses["200"] = &Response{
+			Description: "The generated file",
+			Headers: map[string]*Header{
+				"Content-Disposition": {
+					Description: "attachment with the file name",
+					Schema:      &Schema{Type: "string"},
+				},
+			},
+			Content: map[string]*MediaType{
+				"text/plain": {Schema: &Schema{Type: "string"}},
+			},
+		}
+	} else {
+		content := make(map[string]*MediaType)
+		for _, renderer := range render.All() {
+			schema := &Schema{Type: "string"}
+			if envelopeFormats[renderer.Name()] {
+				schema = toolResponseRef()
+			}
+			content[renderer.MediaTypes()[0]] = &MediaType{Schema: schema}
+		}
+		responses["200"] = &Response{
+			Description: "The tool result in the requested output format",
+			Content:     content,
+		}
+		responses["406"] = &Response{Ref: "#/components/responses/NotAcceptable"}
+	}
+
+	if tool.Private {
+		responses["303"] = &Response{Ref: "#/components/responses/Unauthenticated"}
+		responses["401"] = &Response{Ref: "#/components/responses/InvalidAPIKey"}
+		responses["403"] = &Response{Ref: "#/components/responses/Forbidden"}
+		responses["503"] = &Response{Ref: "#/components/responses/Unavailable"}
+	}
+	return responses
+}
+
+// ParameterSchema converts a tool parameter and its constraints to a schema
+func ParameterSchema(param models.ToolParameter) *Schema {
+	schema := &Schema{
+		Description: param.Description,
+		Default:     param.Default,
+		Minimum:     param.Min,
+		Maximum:     param.Max,
+		MinLength:   param.MinLength,
+		MaxLength:   param.MaxLength,
+		Pattern:     param.Pattern,
+	}
+
+	switch param.Type {
+	case "int":
+		schema.Type = "integer"
+	case "float":
+		schema.Type = "number"
+	case "bool":
+		schema.Type = "boolean"
+	case "array":
+		schema.Type = "array"
+		schema.Items = &Schema{}
+	case "object":
+		schema.Type = "object"
+	default:
+		schema.Type = "string"
+	}
+
+	// Required parameters have no meaningful default
+	if param.Required {
+		schema.Default = nil
+	}
+	if s, ok := schema.Default.(string); ok && s == "" {
+		schema.Default = nil
+	}
+
+	for _, value := range param.Enum {
+		schema.Enum = append(schema.Enum, enumValue(param.Type, value))
+	}
+
+	return schema
+}
+
+// enumValue converts an enum value to the JSON type of the parameter
+func enumValue(paramType, value string) any {
+	switch paramType {
+	case "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// bodySchema returns the object schema of a POST body for the given parameters
+func bodySchema(params []models.ToolParameter, withOutputFormat bool) *Schema {
+	schema := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	for _, param := range params {
+		schema.Properties[param.Name] = ParameterSchema(param)
+		if param.Required {
+			schema.Required = append(schema.Required, param.Name)
+		}
+	}
+	if withOutputFormat {
+		schema.Properties["output_format"] = outputFormatParameter().Schema
+	}
+	return schema
+}
+
+// outputFormatParameter describes the output_format query parameter
+func outputFormatParameter() *Parameter {
+	var formats []any
+	for _, name := range render.Names() {
+		formats = append(formats, name)
+	}
+	return &Parameter{
+		Name:        "output_format",
+		In:          "query",
+		Description: "Format of the result, overrides the Accept header. Without either the result is returned as JSON.",
+		Schema: &Schema{
+			Type:    "string",
+			Enum:    formats,
+			Default: render.DefaultFormat,
+		},
+	}
+}
+
+// toolResponseRef returns a reference to the ToolResponse schema
+func toolResponseRef() *Schema {
+	return &Schema{Ref: "#/components/schemas/ToolResponse"}
+}
+
+// jsonContent returns an application/json content map for a schema
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// operationID converts a tool name such as random-number to RandomNumber
+func operationID(toolName string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(toolName, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.' || r == ' '
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,256 @@
+// Package render contains the output renderers for tool results and the content negotiation between them
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+// DefaultFormat is the output format used when the client does not ask for one
+const DefaultFormat = "json"
+
+// ErrNotAcceptable is returned when none of the registered renderers can produce the requested format
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// Result is a successful tool result to render
+type Result struct {
+	Message string // Human readable summary of the result
+	Data    any    // The typed tool result
+	Text    string // The text form of the result
+
+	// Context for renderers that show the result as a page
+	Tool          models.ToolInfo   // The tool that produced the result
+	Values        map[string]string // The parameter values provided in the request
+	Private       bool              // Whether the tool is a private tool
+	Authenticated bool              // Whether the client is authenticated
+	Request       *http.Request     // The request, for the hidden form fields of the page
+}
+
+// Renderer writes tool results in one output format
+type Renderer interface {
+	// Name is the value of the output_format parameter selecting the renderer
+	Name() string
+	// MediaTypes are the media types the renderer produces, the first one is used as Content-Type
+	MediaTypes() []string
+	// Render writes the result
+	Render(w io.Writer, result Result) error
+}
+
+var (
+	// Registered renderers in registration order
+	renderers []Renderer
+	// Registered renderers keyed by name
+	renderersByName = make(map[string]Renderer)
+	// Mutex protecting the registry
+	registryMutex sync.RWMutex
+)
+
+// Register makes a renderer available by name and media type
+// It panics if a renderer with the same name is already registered
+func Register(renderer Renderer) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := renderersByName[renderer.Name()]; exists {
+		panic(fmt.Sprintf("render: renderer %s registered twice", renderer.Name()))
+	}
+	if len(renderer.MediaTypes()) == 0 {
+		panic(fmt.Sprintf("render: renderer %s has no media types", renderer.Name()))
+	}
+
+	renderers = append(renderers, renderer)
+	renderersByName[renderer.Name()] = renderer
+}
+
+// Get returns the renderer registered under the given output format name
+func Get(name string) (Renderer, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	renderer, exists := renderersByName[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown output format %s, available formats are %s",
+			ErrNotAcceptable, name, strings.Join(namesLocked(), ", "))
+	}
+	return renderer, nil
+}
+
+// Names returns the names of all registered renderers in registration order
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return namesLocked()
+}
+
+// namesLocked returns the renderer names, the caller must hold the registry lock
+func namesLocked() []string {
+	names := make([]string, len(renderers))
+	for i, renderer := range renderers {
+		names[i] = renderer.Name()
+	}
+	return names
+}
+
+// All returns all registered renderers in registration order
+func All() []Renderer {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return append([]Renderer(nil), renderers...)
+}
+
+// Select returns the renderer for a request
+// An explicit output format takes precedence over the Accept header; without either the default format is used
+func Select(outputFormat, accept string) (Renderer, error) {
+	if outputFormat != "" {
+		return Get(outputFormat)
+	}
+	if strings.TrimSpace(accept) == "" {
+		return Get(DefaultFormat)
+	}
+	return Negotiate(accept)
+}
+
+// acceptRange is a single media range of an Accept header
+type acceptRange struct {
+	mediaType string  // e.g. text/html, text/* or */*
+	quality   float64 // the q parameter, 1 when missing
+	index     int     // position in the header
+}
+
+// specificity returns how specific a media range is: 2 for type/subtype, 1 for type/*, 0 for */*
+func (a acceptRange) specificity() int {
+	switch {
+	case a.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(a.mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matches reports whether the media range includes the media type
+func (a acceptRange) matches(mediaType string) bool {
+	switch a.specificity() {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*"))
+	default:
+		return a.mediaType == mediaType
+	}
+}
+
+// parseAccept parses an Accept header into its media ranges
+// Ranges with an invalid quality value are ignored
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for i, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		if mediaType == "*" {
+			mediaType = "*/*"
+		}
+
+		quality := 1.0
+		valid := true
+		for _, param := range fields[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality, index: i})
+		}
+	}
+	return ranges
+}
+
+// candidate is a renderer with the Accept range that selected it
+type candidate struct {
+	renderer Renderer
+	order    int
+	match    acceptRange
+}
+
+// Negotiate returns the renderer that best matches an Accept header
+// Each media type is weighed by the most specific range that includes it. The highest quality wins,
+// ties go to the more specific range, then to the range listed first, then to the renderer registered first.
+func Negotiate(accept string) (Renderer, error) {
+	ranges := parseAccept(accept)
+
+	var candidates []candidate
+	for order, renderer := range All() {
+		best, found := bestMatch(renderer, ranges)
+		if found && best.quality > 0 {
+			candidates = append(candidates, candidate{renderer: renderer, order: order, match: best})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: none of the available formats (%s) matches %s",
+			ErrNotAcceptable, strings.Join(Names(), ", "), accept)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.match.quality != b.match.quality {
+			return a.match.quality > b.match.quality
+		}
+		if a.match.specificity() != b.match.specificity() {
+			return a.match.specificity() > b.match.specificity()
+		}
+		if a.match.index != b.match.index {
+			return a.match.index < b.match.index
+		}
+		return a.order < b.order
+	})
+	return candidates[0].renderer, nil
+}
+
+// bestMatch returns the Accept range that applies to the renderer
+// For every media type the most specific matching range applies, and the renderer takes its best media type
+func bestMatch(renderer Renderer, ranges []acceptRange) (acceptRange, bool) {
+	var best acceptRange
+	found := false
+	for _, mediaType := range renderer.MediaTypes() {
+		var applied acceptRange
+		matched := false
+		for _, r := range ranges {
+			if !r.matches(mediaType) {
+				continue
+			}
+			if !matched || r.specificity() > applied.specificity() ||
+				(r.specificity() == applied.specificity() && r.quality > applied.quality) {
+				applied = r
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		if !found || applied.quality > best.quality ||
+			(applied.quality == best.quality && applied.specificity() > best.specificity()) {
+			best = applied
+			found = true
+		}
+	}
+	return best, found
+}
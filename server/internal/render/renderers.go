@@ -0,0 +1,387 @@
+// Package render contains the output renderers for tool results and the content negotiation between them
+package render
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+)
+
+func init() {
+	Register(jsonRenderer{})
+	Register(htmlRenderer{})
+	Register(rawRenderer{})
+	Register(csvRenderer{})
+	Register(xmlRenderer{})
+	Register(yamlRenderer{})
+	Register(markdownRenderer{})
+}
+
+// envelope is the ToolResponse shape used by the json, xml and yaml renderers
+type envelope struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// jsonRenderer renders the result in the ToolResponse JSON envelope
+type jsonRenderer struct{}
+
+func (jsonRenderer) Name() string         { return "json" }
+func (jsonRenderer) MediaTypes() []string { return []string{"application/json"} }
+
+// Render writes the JSON envelope
+func (jsonRenderer) Render(w io.Writer, result Result) error {
+	return json.NewEncoder(w).Encode(envelope{Success: true, Message: result.Message, Data: result.Data})
+}
+
+// toolResultTemplate is the page template of the html renderer
+var toolResultTemplate = templates.Require("tool_result")
+
+// htmlRenderer renders the result page with the tool_result template
+type htmlRenderer struct{}
+
+func (htmlRenderer) Name() string         { return "html" }
+func (htmlRenderer) MediaTypes() []string { return []string{"text/html"} }
+
+// Render writes the result page, or a minimal page with the escaped text form
+// when the templates are not available
+func (htmlRenderer) Render(w io.Writer, result Result) error {
+	if templates.TemplateManager != nil {
+		currentPage := "tools"
+		if result.Private {
+			currentPage = "private-tools"
+		}
+		values := result.Values
+		if values == nil {
+			values = map[string]string{}
+		}
+
+		err := templates.TemplateManager.ExecuteTemplate(w, result.Request, toolResultTemplate, map[string]interface{}{
+			"Title":           result.Tool.Name,
+			"CurrentPage":     currentPage,
+			"Tool":            result.Tool,
+			"Result":          result.Text,
+			"Data":            result.Data,
+			"Values":          values,
+			"IsPrivate":       result.Private,
+			"IsAuthenticated": result.Authenticated,
+			"OutputFormats":   Names(),
+		})
+		if err == nil {
+			return nil
+		}
+		log.Printf("Error rendering tool_result template: %v", err)
+	}
+
+	// Fallback if template rendering fails
+	_, err := fmt.Fprintf(w, "<html><body><p>%s</p></body></html>", html.EscapeString(result.Text))
+	return err
+}
+
+// rawRenderer renders the text form of the result as is
+type rawRenderer struct{}
+
+func (rawRenderer) Name() string         { return "raw" }
+func (rawRenderer) MediaTypes() []string { return []string{"text/plain"} }
+
+// Render writes the text form
+func (rawRenderer) Render(w io.Writer, result Result) error {
+	_, err := io.WriteString(w, result.Text)
+	return err
+}
+
+// csvRenderer renders the result as a CSV table with a header row
+type csvRenderer struct{}
+
+func (csvRenderer) Name() string         { return "csv" }
+func (csvRenderer) MediaTypes() []string { return []string{"text/csv"} }
+
+// Render writes the table rows
+func (csvRenderer) Render(w io.Writer, result Result) error {
+	header, rows, err := table(result.Data)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// markdownRenderer renders the result as a Markdown table
+type markdownRenderer struct{}
+
+func (markdownRenderer) Name() string         { return "markdown" }
+func (markdownRenderer) MediaTypes() []string { return []string{"text/markdown"} }
+
+// Render writes the Markdown table
+func (markdownRenderer) Render(w io.Writer, result Result) error {
+	header, rows, err := table(result.Data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	writeMarkdownRow(&b, header)
+	separators := make([]string, len(header))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	writeMarkdownRow(&b, separators)
+	for _, row := range rows {
+		writeMarkdownRow(&b, row)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeMarkdownRow writes one table row, escaping pipes and line breaks in the cells
+func writeMarkdownRow(b *strings.Builder, cells []string) {
+	replacer := strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+	b.WriteString("|")
+	for _, cell := range cells {
+		b.WriteString(" " + replacer.Replace(cell) + " |")
+	}
+	b.WriteString("\n")
+}
+
+// yamlRenderer renders the result in the ToolResponse envelope as YAML
+type yamlRenderer struct{}
+
+func (yamlRenderer) Name() string { return "yaml" }
+func (yamlRenderer) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml"}
+}
+
+// Render writes the YAML document
+func (yamlRenderer) Render(w io.Writer, result Result) error {
+	data, err := normalize(envelope{Success: true, Message: result.Message, Data: result.Data})
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(data); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// xmlRenderer renders the result in the ToolResponse envelope as XML
+type xmlRenderer struct{}
+
+func (xmlRenderer) Name() string         { return "xml" }
+func (xmlRenderer) MediaTypes() []string { return []string{"application/xml", "text/xml"} }
+
+// Render writes the XML document with a <response> root element
+func (xmlRenderer) Render(w io.Writer, result Result) error {
+	data, err := normalize(envelope{Success: true, Message: result.Message, Data: result.Data})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encodeXML(encoder, "response", data); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// encodeXML writes a normalized value as an element
+// Objects become child elements, arrays repeat an <item> element
+func encodeXML(encoder *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(v) {
+			if err := encodeXML(encoder, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXML(encoder, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := encoder.EncodeToken(xml.CharData(cellString(v))); err != nil {
+			return err
+		}
+	}
+
+	return encoder.EncodeToken(start.End())
+}
+
+// xmlName turns a JSON key into a valid XML element name
+func xmlName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		valid := r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9')
+		if valid {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// normalize converts a value to the generic maps, slices and scalars of its JSON form
+// so that every renderer uses the same field names as the JSON output
+func normalize(value any) (any, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding result: %v", err)
+	}
+
+	var normalized any
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&normalized); err != nil {
+		return nil, fmt.Errorf("error encoding result: %v", err)
+	}
+	return jsonNumbers(normalized), nil
+}
+
+// jsonNumbers replaces json.Number values with int64 or float64 so they encode as numbers
+func jsonNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return value
+}
+
+// table converts a result to a header and rows
+// Objects become a single row with a column per field, arrays a row per item,
+// and scalars a single "result" column
+func table(data any) ([]string, [][]string, error) {
+	normalized, err := normalize(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch v := normalized.(type) {
+	case map[string]any:
+		header := sortedKeys(v)
+		return header, [][]string{rowFor(header, v)}, nil
+
+	case []any:
+		// Arrays of objects get a column for every field found in any item
+		columns := make(map[string]bool)
+		objects := true
+		for _, item := range v {
+			object, ok := item.(map[string]any)
+			if !ok {
+				objects = false
+				break
+			}
+			for key := range object {
+				columns[key] = true
+			}
+		}
+
+		if objects && len(v) > 0 {
+			header := sortedKeys(columns)
+			rows := make([][]string, len(v))
+			for i, item := range v {
+				rows[i] = rowFor(header, item.(map[string]any))
+			}
+			return header, rows, nil
+		}
+
+		rows := make([][]string, len(v))
+		for i, item := range v {
+			rows[i] = []string{cellString(item)}
+		}
+		return []string{"result"}, rows, nil
+
+	default:
+		return []string{"result"}, [][]string{{cellString(v)}}, nil
+	}
+}
+
+// rowFor returns the cells of an object in column order
+func rowFor(header []string, object map[string]any) []string {
+	row := make([]string, len(header))
+	for i, key := range header {
+		row[i] = cellString(object[key])
+	}
+	return row
+}
+
+// cellString returns the text of a table cell or XML element
+// Nested objects and arrays are written as JSON
+func cellString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]any, []any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sortedKeys returns the keys of a map in alphabetical order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
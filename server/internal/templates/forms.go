@@ -0,0 +1,99 @@
+// Package templates provides template management for the AllMiTools server
+package templates
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FormFieldsFunc returns the hidden fields added to the forms of a page rendered for a request
+type FormFieldsFunc func(r *http.Request) map[string]string
+
+var (
+	// Function returning the hidden form fields of a request, nil for none
+	formFields FormFieldsFunc
+	// Mutex protecting formFields
+	formFieldsMutex sync.RWMutex
+)
+
+// SetFormFields sets the function returning the hidden fields added to every POST form of the rendered pages,
+// such as the CSRF token of the request
+// Only forms posting to this server get the fields, so they are not sent to other sites
+func SetFormFields(fields FormFieldsFunc) {
+	formFieldsMutex.Lock()
+	defer formFieldsMutex.Unlock()
+	formFields = fields
+}
+
+// hasFormFields reports whether the pages rendered for a request may get hidden form fields
+func hasFormFields(r *http.Request) bool {
+	formFieldsMutex.RLock()
+	defer formFieldsMutex.RUnlock()
+	return formFields != nil && r != nil
+}
+
+// requestFormFields returns the hidden form fields of a request
+func requestFormFields(r *http.Request) map[string]string {
+	formFieldsMutex.RLock()
+	fields := formFields
+	formFieldsMutex.RUnlock()
+	if fields == nil || r == nil {
+		return nil
+	}
+	return fields(r)
+}
+
+var (
+	// formTag matches the opening tag of a form
+	formTag = regexp.MustCompile(`(?is)<form\b[^>]*>`)
+	// postMethod matches the method attribute of a POST form
+	postMethod = regexp.MustCompile(`(?i)\smethod\s*=\s*["']?post\b`)
+	// actionAttribute matches the action attribute of a form and captures its URL
+	actionAttribute = regexp.MustCompile(`(?i)\saction\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]*))`)
+)
+
+// injectFormFields adds hidden inputs for the fields after the opening tag of every POST form of a page
+// that posts to this server
+// The fields are requested once, for the first such form, so pages without one do not create them
+func injectFormFields(page []byte, getFields func() map[string]string) []byte {
+	var inputs *strings.Builder
+	return formTag.ReplaceAllFunc(page, func(tag []byte) []byte {
+		if !postMethod.Match(tag) || !localAction(tag) {
+			return tag
+		}
+		if inputs == nil {
+			inputs = formFieldInputs(getFields())
+		}
+		return append(append([]byte{}, tag...), inputs.String()...)
+	})
+}
+
+// formFieldInputs returns the hidden inputs of the fields, sorted by name
+func formFieldInputs(fields map[string]string) *strings.Builder {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var inputs strings.Builder
+	for _, name := range names {
+		inputs.WriteString(`<input type="hidden" name="` + html.EscapeString(name) + `" value="` + html.EscapeString(fields[name]) + `">`)
+	}
+	return &inputs
+}
+
+// localAction reports whether a form tag posts to this server
+// Forms without an action post to the page itself
+func localAction(tag []byte) bool {
+	match := actionAttribute.FindSubmatch(tag)
+	if match == nil {
+		return true
+	}
+	action := string(match[1]) + string(match[2]) + string(match[3])
+	return action == "" || (strings.HasPrefix(action, "/") && !strings.HasPrefix(action, "//"))
+}
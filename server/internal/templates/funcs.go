@@ -0,0 +1,194 @@
+// Package templates provides template management for the AllMiTools server
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Funcs returns the custom functions available in every template
+//   - json: the value encoded as indented JSON
+//   - markdown: Markdown text rendered as HTML, see Markdown
+//   - join: the elements of a slice joined with a separator
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"json":     toJSON,
+		"markdown": Markdown,
+		"join":     join,
+	}
+}
+
+// toJSON returns the value encoded as indented JSON
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding JSON: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// join returns the elements of a slice or array joined with a separator
+// Elements that are not strings are formatted with fmt.Sprint
+func join(items interface{}, separator string) (string, error) {
+	if items == nil {
+		return "", nil
+	}
+	if values, ok := items.([]string); ok {
+		return strings.Join(values, separator), nil
+	}
+
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: cannot join %T", items)
+	}
+	values := make([]string, value.Len())
+	for i := range values {
+		values[i] = fmt.Sprint(value.Index(i).Interface())
+	}
+	return strings.Join(values, separator), nil
+}
+
+var (
+	// Block level Markdown syntax
+	markdownHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	markdownListItem    = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	markdownOrderedItem = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+	// Inline Markdown syntax, applied to text that is already HTML escaped
+	markdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrong   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownEmphasis = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// Markdown renders a small, safe subset of Markdown as HTML
+// It supports paragraphs, headings, unordered and ordered lists, fenced code blocks,
+// inline code, strong and emphasized text, and links. The text is HTML escaped first,
+// so raw HTML in the input is shown as text, and links only allow http, https, mailto
+// and relative URLs.
+func Markdown(text string) template.HTML {
+	var b strings.Builder
+	var paragraph []string
+	list := ""
+	inCode := false
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + markdownInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		// Fenced code blocks are copied as escaped text
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				b.WriteString("</code></pre>\n")
+			} else {
+				flushParagraph()
+				closeList()
+				b.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			b.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+		case markdownHeading.MatchString(trimmed):
+			flushParagraph()
+			closeList()
+			match := markdownHeading.FindStringSubmatch(trimmed)
+			level := len(match[1])
+			fmt.Fprintf(&b, "<h%d>%s</h%d>\n", level, markdownInline(match[2]), level)
+		case markdownListItem.MatchString(trimmed):
+			flushParagraph()
+			openList("ul")
+			b.WriteString("<li>" + markdownInline(markdownListItem.FindStringSubmatch(trimmed)[1]) + "</li>\n")
+		case markdownOrderedItem.MatchString(trimmed):
+			flushParagraph()
+			openList("ol")
+			b.WriteString("<li>" + markdownInline(markdownOrderedItem.FindStringSubmatch(trimmed)[1]) + "</li>\n")
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+
+	if inCode {
+		b.WriteString("</code></pre>\n")
+	}
+	flushParagraph()
+	closeList()
+
+	return template.HTML(b.String())
+}
+
+// markdownInline renders the inline syntax of a line of text
+// Code spans are escaped but otherwise left alone
+func markdownInline(text string) string {
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		escaped := html.EscapeString(part)
+		// Odd parts are between backticks, an unmatched last backtick is kept as text
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = "<code>" + escaped + "</code>"
+			continue
+		}
+		if i%2 == 1 {
+			escaped = "`" + escaped
+		}
+		parts[i] = markdownSpans(escaped)
+	}
+	return strings.Join(parts, "")
+}
+
+// markdownSpans renders links, strong and emphasized text in escaped text
+func markdownSpans(text string) string {
+	text = markdownLink.ReplaceAllStringFunc(text, func(match string) string {
+		groups := markdownLink.FindStringSubmatch(match)
+		if !safeMarkdownURL(html.UnescapeString(groups[2])) {
+			return groups[1]
+		}
+		return `<a href="` + groups[2] + `">` + groups[1] + `</a>`
+	})
+	text = markdownStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = markdownEmphasis.ReplaceAllString(text, "<em>$1$2</em>")
+	return text
+}
+
+// safeMarkdownURL reports whether a link target can be used in an href
+func safeMarkdownURL(url string) bool {
+	lower := strings.ToLower(url)
+	for _, prefix := range []string{"http://", "https://", "mailto:", "/", "#"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return !strings.Contains(lower, ":")
+}
@@ -2,18 +2,42 @@
 package templates
 
 import (
+	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+
+	embedded "github.com/CJFEdu/allmitools/server/templates"
 )
 
 // Global template manager instance
 var TemplateManager *Manager
 
-// Initialize initializes the template manager
+// Config holds the template configuration
+type Config struct {
+	Dir    string // Directory to load the templates from, the embedded templates are used when empty
+	Reload bool   // Re-parse the templates on every render, for editing them while the server runs
+}
+
+// Initialize initializes the template manager with the templates directory of the server root on disk
 func Initialize(serverRoot string) error {
+	return Setup(Config{Dir: filepath.Join(serverRoot, "templates")})
+}
+
+// Setup initializes the template manager from the configuration
+func Setup(config Config) error {
 	// Create the template manager
-	templatesDir := filepath.Join(serverRoot, "templates")
-	TemplateManager = NewManager(templatesDir)
+	if config.Dir == "" {
+		TemplateManager = NewManagerFS(embedded.FS)
+		log.Println("Using embedded templates")
+	} else {
+		if info, err := os.Stat(config.Dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("templates directory %s does not exist", config.Dir)
+		}
+		TemplateManager = NewManager(config.Dir)
+		log.Printf("Using templates from %s", config.Dir)
+	}
+	TemplateManager.SetReload(config.Reload)
 
 	// Load templates
 	if err := TemplateManager.LoadTemplates(); err != nil {
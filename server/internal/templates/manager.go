@@ -2,85 +2,166 @@
 package templates
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"net/http"
-	"path/filepath"
+	"os"
+	"strings"
 	"sync"
 )
 
 // Manager handles template loading and rendering
 type Manager struct {
-	templatesDir string
-	templates    map[string]*template.Template
-	mutex        sync.RWMutex
+	fsys      fs.FS
+	reload    bool
+	templates map[string]*template.Template
+	mutex     sync.RWMutex
 }
 
-// NewManager creates a new template manager
+// NewManager creates a new template manager for the templates in a directory on disk
 func NewManager(templatesDir string) *Manager {
+	return NewManagerFS(os.DirFS(templatesDir))
+}
+
+// NewManagerFS creates a new template manager for the templates in a file system
+func NewManagerFS(fsys fs.FS) *Manager {
 	return &Manager{
-		templatesDir: templatesDir,
-		templates:    make(map[string]*template.Template),
+		fsys:      fsys,
+		templates: make(map[string]*template.Template),
 	}
 }
 
-// LoadTemplates loads all templates from the templates directory
-func (m *Manager) LoadTemplates() error {
+// SetReload enables or disables re-parsing the templates on every render
+// This allows editing the templates while the server is running
+func (m *Manager) SetReload(reload bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	m.reload = reload
+}
+
+const (
+	// layoutFile is the base layout every page template is rendered in
+	layoutFile = "layout.html"
+	// partialsDir contains the partial templates available to every page template
+	partialsDir = "partials"
+)
+
+var (
+	// Names of the page templates used by the handlers
+	requiredTemplates []string
+	// Mutex protecting requiredTemplates
+	requiredMutex sync.Mutex
+)
 
-	// Define the templates to load
-	templateNames := []string{
-		"home",
-		"docs_base",
-		"docs_tool",
-		"tool",
-		"404",
-		"login",
-		"private_tools_list",
-		"private_docs_base",
+// Require declares a page template that is rendered by a handler and returns its name
+// Loading the templates fails when a required template is missing, so it is reported at startup
+// rather than on the first request
+func Require(name string) string {
+	requiredMutex.Lock()
+	defer requiredMutex.Unlock()
+	requiredTemplates = append(requiredTemplates, name)
+	return name
+}
+
+// LoadTemplates loads every page template from the file system
+// Every .html file next to the layout is a page template named after the file, for example home.html is "home"
+func (m *Manager) LoadTemplates() error {
+	pages, err := fs.Glob(m.fsys, "*.html")
+	if err != nil {
+		return fmt.Errorf("error listing templates: %w", err)
 	}
 
 	// Load each template
-	for _, name := range templateNames {
-		// Parse the layout template and the specific template
-		tmpl, err := template.ParseFiles(
-			filepath.Join(m.templatesDir, "layout.html"),
-			filepath.Join(m.templatesDir, name+".html"),
-		)
+	loaded := make(map[string]*template.Template)
+	for _, page := range pages {
+		if page == layoutFile {
+			continue
+		}
+		name := strings.TrimSuffix(page, ".html")
+
+		tmpl, err := m.parseTemplate(name)
 		if err != nil {
-			return fmt.Errorf("error loading template %s: %w", name, err)
+			return err
 		}
+		loaded[name] = tmpl
+	}
 
-		// Store the template
-		m.templates[name] = tmpl
+	// Check that every template a handler renders exists
+	requiredMutex.Lock()
+	defer requiredMutex.Unlock()
+	for _, name := range requiredTemplates {
+		if _, exists := loaded[name]; !exists {
+			return fmt.Errorf("template %s is rendered by a handler but %s.html does not exist", name, name)
+		}
 	}
 
+	m.mutex.Lock()
+	m.templates = loaded
+	m.mutex.Unlock()
 	return nil
 }
 
-// RenderTemplate renders a template with the given data
-func (m *Manager) RenderTemplate(w http.ResponseWriter, name string, data interface{}) error {
-	m.mutex.RLock()
-	tmpl, exists := m.templates[name]
-	m.mutex.RUnlock()
+// parseTemplate parses the layout template, a page template and all partials
+func (m *Manager) parseTemplate(name string) (*template.Template, error) {
+	partials, err := fs.Glob(m.fsys, partialsDir+"/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("error listing partial templates: %w", err)
+	}
+	files := append([]string{layoutFile, name + ".html"}, partials...)
 
-	if !exists {
-		return fmt.Errorf("template %s does not exist", name)
+	// The template is named after the layout so that executing it renders the layout
+	tmpl, err := template.New(layoutFile).Funcs(Funcs()).ParseFS(m.fsys, files...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading template %s: %w", name, err)
 	}
+	return tmpl, nil
+}
 
+// RenderTemplate renders a template with the given data for a request
+// The form fields of the request are added to the forms of the page, see SetFormFields
+func (m *Manager) RenderTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
 	// Set the content type
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// Execute the template
-	return tmpl.Execute(w, data)
+	return m.ExecuteTemplate(w, r, name, data)
+}
+
+// ExecuteTemplate renders a template with the given data for a request to any writer
+// Unlike RenderTemplate it does not set any headers; the request may be nil when the page has no forms
+func (m *Manager) ExecuteTemplate(w io.Writer, r *http.Request, name string, data interface{}) error {
+	tmpl, err := m.GetTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	if !hasFormFields(r) {
+		return tmpl.Execute(w, data)
+	}
+
+	// Render the page first so the fields can be added to its forms
+	// The fields are only requested for pages with a form that gets them
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, data); err != nil {
+		return err
+	}
+	_, err = w.Write(injectFormFields(page.Bytes(), func() map[string]string { return requestFormFields(r) }))
+	return err
 }
 
 // GetTemplate returns a template by name
+// In reload mode the template is parsed again from the file system, so new pages are found as well
 func (m *Manager) GetTemplate(name string) (*template.Template, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
+	if m.reload {
+		return m.parseTemplate(name)
+	}
+
 	tmpl, exists := m.templates[name]
 	if !exists {
 		return nil, fmt.Errorf("template %s does not exist", name)
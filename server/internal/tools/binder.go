@@ -0,0 +1,294 @@
+// Package tools contains the implementation of various tools for the AllMiTools server
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+// maxMultipartMemory is the amount of a multipart body kept in memory while parsing
+const maxMultipartMemory = 32 << 20
+
+// BindParams fills the struct pointed to by dst with the tool parameters found in the request
+//
+// Values are read from the query string for GET requests, and from the body for POST
+// requests (urlencoded and multipart forms, or JSON objects). Query string values are
+// used as a fallback for POST requests. Each parameter is matched to the struct field
+// whose json tag has the same name and converted to the field's type. Missing optional
+// parameters receive their declared default.
+//
+// Provided values are checked against the parameter's constraints (enum, min/max,
+// length and pattern). Missing required parameters, values that cannot be converted
+// and constraint violations are all reported together in a *ValidationError.
+func BindParams(r *http.Request, params []models.ToolParameter, dst interface{}) error {
+	target := reflect.ValueOf(dst)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return errors.New("bind target must be a pointer to a struct")
+	}
+	fields := fieldsByParamName(target.Elem())
+
+	// Collect the raw values from the request
+	values, err := requestValues(r)
+	if err != nil {
+		return newValidationError(err.Error())
+	}
+
+	validationErr := &ValidationError{}
+	for _, param := range params {
+		raw, present := values[param.Name]
+		if !present {
+			if param.Required {
+				validationErr.add(param.Name, "is required")
+				continue
+			}
+			raw = param.Default
+		}
+
+		field, ok := fields[param.Name]
+		if !ok || raw == nil {
+			continue
+		}
+
+		if err := setField(field, raw); err != nil {
+			validationErr.add(param.Name, err.Error())
+			continue
+		}
+
+		// Only values provided by the caller are checked against the constraints
+		if present {
+			if message := param.CheckValue(field.Interface()); message != "" {
+				validationErr.add(param.Name, message)
+			}
+		}
+	}
+
+	if len(validationErr.Fields) > 0 {
+		return validationErr
+	}
+	return nil
+}
+
+// ParamValues returns the text form of the tool parameters provided in the request keyed by name
+// Boolean parameters are normalized to "true" or "false", parameters that were not provided are left out
+func ParamValues(r *http.Request, params []models.ToolParameter) map[string]string {
+	provided := make(map[string]string)
+
+	values, err := requestValues(r)
+	if err != nil {
+		return provided
+	}
+
+	for _, param := range params {
+		raw, present := values[param.Name]
+		if !present {
+			continue
+		}
+
+		value := fmt.Sprint(raw)
+		if param.Type == "bool" {
+			b, err := parseBool(value)
+			if err != nil {
+				continue
+			}
+			value = strconv.FormatBool(b)
+		}
+		provided[param.Name] = value
+	}
+	return provided
+}
+
+// requestValues returns the non-empty parameter values found in the request keyed by name
+// Values are strings, except for JSON bodies where they keep their JSON type
+func requestValues(r *http.Request) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+
+	// Query string values are always available, body values take precedence over them
+	for name, list := range r.URL.Query() {
+		if len(list) > 0 && list[0] != "" {
+			values[name] = list[0]
+		}
+	}
+
+	if r.Method != http.MethodPost || r.Body == nil || r.Body == http.NoBody {
+		return values, nil
+	}
+
+	// Check Content-Type header to determine how to parse the data
+	contentType := r.Header.Get("Content-Type")
+
+	if strings.Contains(contentType, "application/json") {
+		// Read the body and restore it so it can be read by other handlers
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		if len(bytes.TrimSpace(body)) == 0 {
+			return values, nil
+		}
+
+		// Decode the JSON object, keeping numbers exact
+		var jsonData map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&jsonData); err != nil {
+			return nil, fmt.Errorf("error parsing JSON data: %v", err)
+		}
+
+		for name, value := range jsonData {
+			if value == nil {
+				continue
+			}
+			if s, ok := value.(string); ok && s == "" {
+				continue
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+
+	// Everything else is parsed as form data for backward compatibility
+	if strings.Contains(contentType, "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+			return nil, fmt.Errorf("error parsing form data: %v", err)
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("error parsing form data: %v", err)
+	}
+
+	for name, list := range r.PostForm {
+		if len(list) > 0 && list[0] != "" {
+			values[name] = list[0]
+		}
+	}
+	return values, nil
+}
+
+// fieldsByParamName maps the json tag names of a struct's fields to the settable fields
+func fieldsByParamName(v reflect.Value) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if structField.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = structField.Name
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+// setField converts a raw request value or parameter default to the field's type
+func setField(field reflect.Value, raw interface{}) error {
+	switch field.Kind() {
+	case reflect.String:
+		switch v := raw.(type) {
+		case string:
+			field.SetString(v)
+		case json.Number:
+			field.SetString(v.String())
+		case bool:
+			field.SetString(strconv.FormatBool(v))
+		case int:
+			field.SetString(strconv.Itoa(v))
+		default:
+			return errors.New("must be a string")
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		var err error
+		switch v := raw.(type) {
+		case string:
+			n, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		case json.Number:
+			n, err = strconv.ParseInt(v.String(), 10, 64)
+		case int:
+			n = int64(v)
+		case float64:
+			n = int64(v)
+			if float64(n) != v {
+				err = errors.New("not an integer")
+			}
+		default:
+			err = errors.New("not an integer")
+		}
+		if err != nil || field.OverflowInt(n) {
+			return fmt.Errorf("must be an integer, got %v", raw)
+		}
+		field.SetInt(n)
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		var err error
+		switch v := raw.(type) {
+		case string:
+			f, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+		case json.Number:
+			f, err = v.Float64()
+		case int:
+			f = float64(v)
+		case float64:
+			f = v
+		default:
+			err = errors.New("not a number")
+		}
+		if err != nil {
+			return fmt.Errorf("must be a number, got %v", raw)
+		}
+		field.SetFloat(f)
+
+	case reflect.Bool:
+		var b bool
+		var err error
+		switch v := raw.(type) {
+		case bool:
+			b = v
+		case string:
+			b, err = parseBool(v)
+		case json.Number:
+			b, err = parseBool(v.String())
+		case int:
+			b = v != 0
+		default:
+			err = errors.New("not a boolean")
+		}
+		if err != nil {
+			return fmt.Errorf("must be a boolean (true, false, on, off, 1 or 0), got %v", raw)
+		}
+		field.SetBool(b)
+
+	default:
+		return fmt.Errorf("has unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// parseBool parses a boolean the way HTML forms and API clients send them
+// In addition to strconv.ParseBool it accepts "on"/"off" and "yes"/"no"
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "yes":
+		return true, nil
+	case "off", "no":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(s))
+}
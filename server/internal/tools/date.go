@@ -2,14 +2,78 @@
 package tools
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(dateFormatterTool{})
+	models.RegisterTool(dateComponentTool{component: "day", description: "Get the current day of the month"})
+	models.RegisterTool(dateComponentTool{component: "month", description: "Get the current month as a string"})
+	models.RegisterTool(dateComponentTool{component: "year", description: "Get the current year"})
+}
+
+// dateFormatterTool is the date formatter registered as "date"
+type dateFormatterTool struct{}
+
+// Info returns the tool info for the date formatter
+func (dateFormatterTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "date",
+		Description: "Format the current date with optional offset",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "format",
+				Type:        "string",
+				Description: "Date format string (e.g., '2006-01-02' for YYYY-MM-DD)",
+				Required:    false,
+				Default:     "2006-01-02",
+				MaxLength:   models.Int(100),
+			},
+			{
+				Name:        "offset",
+				Type:        "int",
+				Description: "Offset in days (can be negative)",
+				Required:    false,
+				Default:     0,
+			},
+		},
+	}
+}
+
+// Execute runs the date formatter against the request
+func (dateFormatterTool) Execute(r *http.Request) (any, error) {
+	return ExecuteDateFormatter(r)
+}
+
+// dateComponentTool returns a single component of the current date
+// It is registered once for each of "day", "month" and "year"
+type dateComponentTool struct {
+	component   string
+	description string
+}
+
+// Info returns the tool info for the date component
+func (t dateComponentTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        t.component,
+		Description: t.description,
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters:  []models.ToolParameter{}, // No parameters needed
+	}
+}
+
+// Execute returns the current value of the date component
+func (t dateComponentTool) Execute(r *http.Request) (any, error) {
+	return ExecuteDateComponent(t.component)
+}
+
 // DateFormatterParams represents the parameters for the date formatter
 type DateFormatterParams struct {
 	Format string `json:"format"` // Format string (e.g., "2006-01-02")
@@ -81,97 +145,57 @@ func GetDateComponent(component string, now time.Time) (interface{}, error) {
 // ParseDateFormatterParams parses the date formatter parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseDateFormatterParams(r *http.Request) (DateFormatterParams, error) {
-	// Parse parameters from either POST or GET
-	var format, offsetStr string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return DateFormatterParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			format = r.FormValue("format")
-			offsetStr = r.FormValue("offset")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params DateFormatterParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return DateFormatterParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// Return the parsed parameters directly
-			return params, nil
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return DateFormatterParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			format = r.FormValue("format")
-			offsetStr = r.FormValue("offset")
-		}
-	} else {
-		// Get parameters from query string
-		format = r.URL.Query().Get("format")
-		offsetStr = r.URL.Query().Get("offset")
+	var params DateFormatterParams
+	if err := BindParams(r, dateFormatterTool{}.Info().Parameters, &params); err != nil {
+		return DateFormatterParams{}, err
 	}
+	return params, nil
+}
 
-	// Set default values
-	offset := 0
-
-	// Parse offset parameter if provided
-	if offsetStr != "" {
-		parsedOffset, err := strconv.Atoi(offsetStr)
-		if err != nil {
-			return DateFormatterParams{}, fmt.Errorf("invalid offset parameter: %s", offsetStr)
-		}
-		offset = parsedOffset
-	}
+// DateResult is the result of the date formatter
+type DateResult struct {
+	Date      string    `json:"date"`      // The formatted date
+	Format    string    `json:"format"`    // The format used
+	Offset    int       `json:"offset"`    // The offset in days that was applied
+	Timestamp time.Time `json:"timestamp"` // The date with the offset applied
+}
 
-	// Create and return parameters
-	return DateFormatterParams{
-		Format: format,
-		Offset: offset,
-	}, nil
+// String returns the formatted date
+func (d DateResult) String() string {
+	return d.Date
 }
 
 // ExecuteDateFormatter executes the date formatter with the given HTTP request
-// It parses parameters from the request and returns the formatted date as a string
-func ExecuteDateFormatter(r *http.Request) (string, error) {
+// It parses parameters from the request and returns the formatted date with the format and offset used
+func ExecuteDateFormatter(r *http.Request) (DateResult, error) {
 	// Parse parameters from the request
 	params, err := ParseDateFormatterParams(r)
 	if err != nil {
-		return "", err
+		return DateResult{}, err
 	}
 
 	// Format date
-	formattedDate, err := FormatDate(params, time.Now())
+	now := time.Now()
+	formattedDate, err := FormatDate(params, now)
 	if err != nil {
-		return "", err
+		return DateResult{}, err
+	}
+
+	format := params.Format
+	if format == "" {
+		format = DefaultDateFormat
 	}
 
-	// Return the formatted date as a string
-	return formattedDate, nil
+	return DateResult{
+		Date:      formattedDate,
+		Format:    format,
+		Offset:    params.Offset,
+		Timestamp: now.AddDate(0, 0, params.Offset),
+	}, nil
 }
 
 // ExecuteDateComponent executes a date component request (day, month, or year)
-// It returns the component value as a string and any error that occurred
-func ExecuteDateComponent(component string) (string, error) {
-	// Get the specified date component
-	result, err := GetDateComponent(component, time.Now())
-	if err != nil {
-		return "", err
-	}
-
-	// Convert the result to a string and return it
-	return fmt.Sprintf("%v", result), nil
+// It returns the component value (a number for day and year, the month name for month)
+func ExecuteDateComponent(component string) (interface{}, error) {
+	return GetDateComponent(component, time.Now())
 }
@@ -2,14 +2,142 @@
 package tools
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+)
+
+// ErrorCode is a stable machine readable code describing why a request failed
+type ErrorCode string
+
+// Error codes reported in error responses
+const (
+	CodeValidation         ErrorCode = "validation_error"    // Parameters are missing or invalid
+	CodeNotFound           ErrorCode = "not_found"           // The requested tool or resource does not exist
+	CodeGone               ErrorCode = "gone"                // The requested resource existed but has expired
+	CodeUnauthorized       ErrorCode = "unauthorized"        // The request requires authentication
+	CodeForbidden          ErrorCode = "forbidden"           // The authenticated user may not make the request
+	CodeConflict           ErrorCode = "conflict"            // The request conflicts with the current state of the resource
+	CodePreconditionFailed ErrorCode = "precondition_failed" // The resource changed since the version named by If-Match
+	CodeUnavailable        ErrorCode = "unavailable"         // An upstream service such as the database is unavailable
+	CodeInternal           ErrorCode = "internal_error"      // Anything else that went wrong
+
+	// Codes of requests rejected by the handlers before a tool runs
+	CodeBadRequest      ErrorCode = "bad_request"       // The request body cannot be parsed
+	CodeNotAcceptable   ErrorCode = "not_acceptable"    // None of the output formats matches the request
+	CodeRequestTooLarge ErrorCode = "request_too_large" // The request exceeds a size limit
+	CodeTooManyRequests ErrorCode = "too_many_requests" // The client must wait before trying again
 )
 
 // Common error functions for parameter validation
 func ErrMissingRequiredParameter(paramName string) error {
-	return fmt.Errorf("missing required parameter: %s", paramName)
+	return newValidationError(fmt.Sprintf("missing required parameter: %s", paramName),
+		FieldError{Field: paramName, Message: "is required"})
 }
 
 func ErrInvalidParameter(message string) error {
-	return fmt.Errorf("invalid parameter: %s", message)
+	return newValidationError(fmt.Sprintf("invalid parameter: %s", message))
+}
+
+// FieldError describes why a single tool parameter was rejected
+type FieldError struct {
+	Field   string `json:"field"`   // Name of the parameter
+	Message string `json:"message"` // What is wrong with the value
+}
+
+// ValidationError is returned when one or more tool parameters are missing or invalid
+type ValidationError struct {
+	Message string // Summary of the error, built from the fields when empty
+	Fields  []FieldError
+}
+
+// newValidationError returns a validation error with a summary and the rejected fields
+func newValidationError(message string, fields ...FieldError) *ValidationError {
+	return &ValidationError{Message: message, Fields: fields}
+}
+
+// Error returns the summary, or all field errors in a single message
+func (e *ValidationError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field.Field, field.Message))
+	}
+	return "invalid parameters: " + strings.Join(messages, "; ")
+}
+
+// add records a field error
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Error is a failed request with an error code other than a validation error
+type Error struct {
+	Code    ErrorCode // Machine readable error code
+	Message string    // Human readable description
+	Err     error     // Underlying error, if any
+}
+
+// Error returns the message followed by the underlying error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError returns an error for a tool or resource that does not exist
+func NotFoundError(message string) error {
+	return &Error{Code: CodeNotFound, Message: message}
+}
+
+// GoneError returns an error for a resource that has expired
+func GoneError(message string) error {
+	return &Error{Code: CodeGone, Message: message}
+}
+
+// PreconditionFailedError returns an error for a change made against an outdated version of a resource
+func PreconditionFailedError(message string) error {
+	return &Error{Code: CodePreconditionFailed, Message: message}
+}
+
+// UnauthorizedError returns an error for a request that requires authentication
+func UnauthorizedError(message string) error {
+	return &Error{Code: CodeUnauthorized, Message: message}
+}
+
+// ForbiddenError returns an error for a request the authenticated user may not make
+func ForbiddenError(message string) error {
+	return &Error{Code: CodeForbidden, Message: message}
+}
+
+// UnavailableError returns an error for a failing upstream service such as the database
+func UnavailableError(message string, err error) error {
+	return &Error{Code: CodeUnavailable, Message: message, Err: err}
+}
+
+// InternalError returns an error for an unexpected failure
+func InternalError(message string, err error) error {
+	return &Error{Code: CodeInternal, Message: message, Err: err}
+}
+
+// CodeOf returns the error code of an error
+// Errors without a code are internal errors
+func CodeOf(err error) ErrorCode {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return CodeValidation
+	}
+	var toolErr *Error
+	if errors.As(err, &toolErr) {
+		return toolErr.Code
+	}
+	return CodeInternal
 }
@@ -2,16 +2,63 @@
 package tools
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+// Bounds of the min and max parameters of the random number generator
+// They keep the size of the range, max - min + 1, within an int
+const (
+	minRandomNumber = -1_000_000_000
+	maxRandomNumber = 1_000_000_000
 )
 
+func init() {
+	models.RegisterTool(randomNumberTool{})
+}
+
+// randomNumberTool is the random number generator registered as "random-number"
+type randomNumberTool struct{}
+
+// Info returns the tool info for the random number generator
+func (randomNumberTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "random-number",
+		Description: "Generate a random number within a specified range",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "min",
+				Type:        "int",
+				Description: "Minimum value (inclusive)",
+				Required:    false,
+				Default:     1,
+				Min:         models.Float(minRandomNumber),
+				Max:         models.Float(maxRandomNumber),
+			},
+			{
+				Name:        "max",
+				Type:        "int",
+				Description: "Maximum value (inclusive)",
+				Required:    false,
+				Default:     100,
+				Min:         models.Float(minRandomNumber),
+				Max:         models.Float(maxRandomNumber),
+			},
+		},
+	}
+}
+
+// Execute runs the random number generator against the request
+func (randomNumberTool) Execute(r *http.Request) (any, error) {
+	return ExecuteRandomNumber(r)
+}
+
 // RandomNumberParams represents the parameters for the random number generator
 type RandomNumberParams struct {
 	Min int `json:"min"` // Minimum value (inclusive)
@@ -20,8 +67,17 @@ type RandomNumberParams struct {
 
 // ValidateRandomNumberParams validates the parameters for the random number generator
 func ValidateRandomNumberParams(params RandomNumberParams) error {
+	if params.Min < minRandomNumber || params.Min > maxRandomNumber {
+		return newValidationError("minimum value is out of range",
+			FieldError{Field: "min", Message: fmt.Sprintf("must be between %d and %d", minRandomNumber, maxRandomNumber)})
+	}
+	if params.Max < minRandomNumber || params.Max > maxRandomNumber {
+		return newValidationError("maximum value is out of range",
+			FieldError{Field: "max", Message: fmt.Sprintf("must be between %d and %d", minRandomNumber, maxRandomNumber)})
+	}
 	if params.Min > params.Max {
-		return errors.New("minimum value cannot be greater than maximum value")
+		return newValidationError("minimum value cannot be greater than maximum value",
+			FieldError{Field: "min", Message: "cannot be greater than max"})
 	}
 	return nil
 }
@@ -45,96 +101,22 @@ func GenerateRandomNumber(params RandomNumberParams) (int, error) {
 // ParseRandomNumberParams parses the random number generator parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseRandomNumberParams(r *http.Request) (RandomNumberParams, error) {
-	// Parse parameters from either POST or GET
-	var minStr, maxStr string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return RandomNumberParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			minStr = r.FormValue("min")
-			maxStr = r.FormValue("max")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params RandomNumberParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return RandomNumberParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// If JSON values are provided, use them directly
-			if params.Min != 0 || params.Max != 0 {
-				return params, nil
-			}
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return RandomNumberParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			minStr = r.FormValue("min")
-			maxStr = r.FormValue("max")
-		}
-	} else {
-		// Get parameters from query string
-		minStr = r.URL.Query().Get("min")
-		maxStr = r.URL.Query().Get("max")
-	}
-
-	// Set default values
-	min := 1
-	max := 100
-
-	// Parse min parameter if provided
-	if minStr != "" {
-		parsedMin, err := strconv.Atoi(minStr)
-		if err != nil {
-			return RandomNumberParams{}, fmt.Errorf("invalid min parameter: %s", minStr)
-		}
-		min = parsedMin
-	}
-
-	// Parse max parameter if provided
-	if maxStr != "" {
-		parsedMax, err := strconv.Atoi(maxStr)
-		if err != nil {
-			return RandomNumberParams{}, fmt.Errorf("invalid max parameter: %s", maxStr)
-		}
-		max = parsedMax
+	var params RandomNumberParams
+	if err := BindParams(r, randomNumberTool{}.Info().Parameters, &params); err != nil {
+		return RandomNumberParams{}, err
 	}
-
-	// Create and return parameters
-	return RandomNumberParams{
-		Min: min,
-		Max: max,
-	}, nil
+	return params, nil
 }
 
 // ExecuteRandomNumber executes the random number generator with the given HTTP request
-// It parses parameters from the request and returns the generated random number as a string
-func ExecuteRandomNumber(r *http.Request) (string, error) {
+// It parses parameters from the request and returns the generated random number
+func ExecuteRandomNumber(r *http.Request) (int, error) {
 	// Parse parameters from the request
 	params, err := ParseRandomNumberParams(r)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
 	// Generate random number
-	randNum, err := GenerateRandomNumber(params)
-	if err != nil {
-		return "", err
-	}
-
-	// Return the number as a string
-	return fmt.Sprintf("%d", randNum), nil
+	return GenerateRandomNumber(params)
 }
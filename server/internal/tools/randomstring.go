@@ -2,15 +2,53 @@
 package tools
 
 import (
-	"encoding/json"
-	"fmt"
 	"math/rand"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(randomStringTool{})
+}
+
+// randomStringTool is the random string generator registered as "random-string"
+type randomStringTool struct{}
+
+// Info returns the tool info for the random string generator
+func (randomStringTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "random-string",
+		Description: "Generate a random string of specified length",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "length",
+				Type:        "int",
+				Description: "Length of the random string",
+				Required:    false,
+				Default:     10,
+				Min:         models.Float(1),
+				Max:         models.Float(1000),
+			},
+			{
+				Name:        "mixedCase",
+				Type:        "bool",
+				Description: "Include both uppercase and lowercase letters (default: false, lowercase only)",
+				Required:    false,
+				Default:     false,
+			},
+		},
+	}
+}
+
+// Execute runs the random string generator against the request
+func (randomStringTool) Execute(r *http.Request) (any, error) {
+	return ExecuteRandomString(r)
+}
+
 // RandomStringParams represents the parameters for the random string generator
 type RandomStringParams struct {
 	Length     int  `json:"length"`     // Length of the random string
@@ -20,10 +58,10 @@ type RandomStringParams struct {
 // ValidateRandomStringParams validates the parameters for the random string generator
 func ValidateRandomStringParams(params RandomStringParams) error {
 	if params.Length <= 0 {
-		return fmt.Errorf("length must be greater than 0")
+		return newValidationError("length must be greater than 0", FieldError{Field: "length", Message: "must be at least 1"})
 	}
 	if params.Length > 1000 {
-		return fmt.Errorf("length must be less than or equal to 1000")
+		return newValidationError("length must be less than or equal to 1000", FieldError{Field: "length", Message: "must be at most 1000"})
 	}
 	return nil
 }
@@ -31,87 +69,11 @@ func ValidateRandomStringParams(params RandomStringParams) error {
 // ParseRandomStringParams parses the random string generator parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseRandomStringParams(r *http.Request) (RandomStringParams, error) {
-	// Parse parameters from either POST or GET
-	var lengthStr, mixedCaseStr string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return RandomStringParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			lengthStr = r.FormValue("length")
-			mixedCaseStr = r.FormValue("mixedCase")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params RandomStringParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return RandomStringParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// If JSON values are provided, use them directly
-			// Validate that length is set (default to 10 if not)
-			if params.Length <= 0 {
-				params.Length = 10
-			}
-			return params, nil
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return RandomStringParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			lengthStr = r.FormValue("length")
-			mixedCaseStr = r.FormValue("mixedCase")
-		}
-	} else {
-		// Get parameters from query string
-		lengthStr = r.URL.Query().Get("length")
-		mixedCaseStr = r.URL.Query().Get("mixedCase")
+	var params RandomStringParams
+	if err := BindParams(r, randomStringTool{}.Info().Parameters, &params); err != nil {
+		return RandomStringParams{}, err
 	}
-
-	// Set default values
-	length := 10
-	mixedCase := false
-
-	// Parse length parameter if provided
-	if lengthStr != "" {
-		parsedLength, err := strconv.Atoi(lengthStr)
-		if err != nil {
-			return RandomStringParams{}, fmt.Errorf("invalid length parameter: %s", lengthStr)
-		}
-		length = parsedLength
-	}
-
-	// Parse mixedCase parameter if provided
-	if mixedCaseStr != "" {
-		// Handle HTML form checkbox which sends "on" when checked
-		if mixedCaseStr == "on" {
-			mixedCase = true
-		} else {
-			// Try to parse as boolean
-			parsedMixedCase, err := strconv.ParseBool(mixedCaseStr)
-			if err != nil {
-				return RandomStringParams{}, fmt.Errorf("invalid mixedCase parameter: %s (use true, false, or on)", mixedCaseStr)
-			}
-			mixedCase = parsedMixedCase
-		}
-	}
-
-	// Create and return parameters
-	return RandomStringParams{
-		Length:    length,
-		MixedCase: mixedCase,
-	}, nil
+	return params, nil
 }
 
 // GenerateRandomString generates a random string of the specified length
@@ -4,12 +4,41 @@ package tools
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(sha256HasherTool{})
+}
+
+// sha256HasherTool is the SHA-256 hasher registered as "sha256-hasher"
+type sha256HasherTool struct{}
+
+// Info returns the tool info for the SHA-256 hasher
+func (sha256HasherTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "sha256-hasher",
+		Description: "Convert a text string into a SHA-256 hash",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "text",
+				Type:        "string",
+				Description: "Text to hash",
+				Required:    true,
+			},
+		},
+	}
+}
+
+// Execute runs the SHA-256 hasher against the request
+func (sha256HasherTool) Execute(r *http.Request) (any, error) {
+	return ExecuteSHA256Hasher(r)
+}
+
 // SHA256HasherParams represents the parameters for the SHA-256 hasher
 type SHA256HasherParams struct {
 	Text string `json:"text"` // Text to hash
@@ -18,7 +47,7 @@ type SHA256HasherParams struct {
 // ValidateSHA256HasherParams validates the parameters for the SHA-256 hasher
 func ValidateSHA256HasherParams(params SHA256HasherParams) error {
 	if params.Text == "" {
-		return fmt.Errorf("text parameter is required")
+		return newValidationError("text parameter is required", FieldError{Field: "text", Message: "is required"})
 	}
 	return nil
 }
@@ -26,72 +55,37 @@ func ValidateSHA256HasherParams(params SHA256HasherParams) error {
 // ParseSHA256HasherParams parses the SHA-256 hasher parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseSHA256HasherParams(r *http.Request) (SHA256HasherParams, error) {
-	// Parse parameters from either POST or GET
-	var text string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return SHA256HasherParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			text = r.FormValue("text")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params SHA256HasherParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return SHA256HasherParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// If JSON text is provided, use it directly
-			if params.Text != "" {
-				return params, nil
-			}
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return SHA256HasherParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			text = r.FormValue("text")
-		}
-	} else {
-		// Get parameters from query string
-		text = r.URL.Query().Get("text")
+	var params SHA256HasherParams
+	if err := BindParams(r, sha256HasherTool{}.Info().Parameters, &params); err != nil {
+		return SHA256HasherParams{}, err
 	}
+	return params, nil
+}
 
-	// Validate parameters
-	if text == "" {
-		return SHA256HasherParams{}, fmt.Errorf("text parameter is required")
-	}
+// HashResult is the result of the SHA-256 hasher
+type HashResult struct {
+	Algorithm string `json:"algorithm"` // Name of the hash algorithm
+	Encoding  string `json:"encoding"`  // Encoding of the hash
+	Hash      string `json:"hash"`      // The encoded hash
+}
 
-	// Create and return parameters
-	return SHA256HasherParams{
-		Text: text,
-	}, nil
+// String returns the encoded hash
+func (h HashResult) String() string {
+	return h.Hash
 }
 
 // ExecuteSHA256Hasher executes the SHA-256 hasher with the given HTTP request
-// It parses parameters from the request and returns the SHA-256 hash as a string
-func ExecuteSHA256Hasher(r *http.Request) (string, error) {
+// It parses parameters from the request and returns the hex encoded SHA-256 hash
+func ExecuteSHA256Hasher(r *http.Request) (HashResult, error) {
 	// Parse parameters from the request
 	params, err := ParseSHA256HasherParams(r)
 	if err != nil {
-		return "", err
+		return HashResult{}, err
 	}
 
 	// Validate parameters
 	if err := ValidateSHA256HasherParams(params); err != nil {
-		return "", err
+		return HashResult{}, err
 	}
 
 	// Create a new SHA-256 hash
@@ -106,6 +100,10 @@ func ExecuteSHA256Hasher(r *http.Request) (string, error) {
 	// Convert the hash to a hexadecimal string
 	hashString := hex.EncodeToString(hashBytes)
 
-	// Return the hash string
-	return hashString, nil
+	// Return the hash with its metadata
+	return HashResult{
+		Algorithm: "sha256",
+		Encoding:  "hex",
+		Hash:      hashString,
+	}, nil
 }
@@ -0,0 +1,90 @@
+// Package tools contains the implementation of various tools for the AllMiTools server
+package tools
+
+import (
+	"net/http"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+func init() {
+	models.RegisterPrivateTool(textDeleteTool{}, models.ScopeStorageWrite)
+}
+
+// textDeleteTool is the private text delete tool registered as "text-delete"
+type textDeleteTool struct{}
+
+// Info returns the tool info for the text delete tool
+func (textDeleteTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-delete",
+		Description: "Deletes a stored text",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			textIDParameter,
+			ifMatchParameter,
+		},
+	}
+}
+
+// Execute runs the text delete tool against the request
+func (textDeleteTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextDelete(r)
+}
+
+// TextDeleteParams represents the parameters for the text delete tool
+type TextDeleteParams struct {
+	ID      string `json:"id"`       // The unique ID of the text to delete
+	IfMatch string `json:"if_match"` // ETag of the version to delete, empty for any version
+}
+
+// TextDeleteResult is the result of the text delete tool
+type TextDeleteResult struct {
+	ID      string `json:"id"`      // Unique ID of the deleted text
+	Deleted bool   `json:"deleted"` // Whether the text was deleted
+}
+
+// String returns the ID of the deleted text
+func (d TextDeleteResult) String() string {
+	return d.ID
+}
+
+// ExecuteTextDelete executes the text delete tool
+// This tool deletes a text entry, expired entries are gone already and cannot be deleted
+// Parameters:
+//   - id: The unique ID of the text to delete (required)
+//   - if_match: ETag of the version to delete (optional, the If-Match header can be sent instead)
+func ExecuteTextDelete(r *http.Request) (TextDeleteResult, error) {
+	// Parse parameters
+	var params TextDeleteParams
+	if err := BindParams(r, textDeleteTool{}.Info().Parameters, &params); err != nil {
+		return TextDeleteResult{}, err
+	}
+	version, err := ifMatchVersion(r, params.IfMatch)
+	if err != nil {
+		return TextDeleteResult{}, err
+	}
+
+	// Get the DAO
+	dao, err := database.GetTextStorageDAO()
+	if err != nil {
+		return TextDeleteResult{}, UnavailableError("database error", err)
+	}
+
+	// Check that the text exists and has not expired
+	if _, err := findTextEntry(r, dao, audit.EventTextDeleted, params.ID); err != nil {
+		return TextDeleteResult{}, err
+	}
+
+	// Delete the text at the requested version
+	if err := dao.DeleteTextByID(params.ID, version); err != nil {
+		return TextDeleteResult{}, textChangeError(r, audit.EventTextDeleted, params.ID, "failed to delete text", err)
+	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventTextDeleted, TargetID: params.ID})
+
+	return TextDeleteResult{ID: params.ID, Deleted: true}, nil
+}
@@ -2,13 +2,77 @@
 package tools
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
-	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(textFileTool{})
+}
+
+// textFileTool is the text file generator registered as "text-file"
+// Its result is served as a file download, see ExecuteFile
+type textFileTool struct{}
+
+// Info returns the tool info for the text file generator
+func (textFileTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-file",
+		Description: "Generate a downloadable text file from provided content",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "content",
+				Type:        "string",
+				Description: "Content to be saved as a text file",
+				Required:    true,
+				Multiline:   true,
+			},
+			{
+				Name:        "filename",
+				Type:        "string",
+				Description: "Optional filename for the text file",
+				Required:    false,
+				Default:     "download.txt",
+				Pattern:     `^[A-Za-z0-9._-]+$`,
+				MaxLength:   models.Int(255),
+			},
+		},
+	}
+}
+
+// Execute runs the text file generator and returns the file content with its name and size
+func (t textFileTool) Execute(r *http.Request) (any, error) {
+	content, filename, err := t.ExecuteFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return TextFileResult{
+		Filename: filename,
+		Content:  content,
+		Size:     len(content),
+	}, nil
+}
+
+// TextFileResult is the result of the text file tool when it is not served as a download
+type TextFileResult struct {
+	Filename string `json:"filename"` // Name of the file
+	Content  string `json:"content"`  // Content of the file
+	Size     int    `json:"size"`     // Size of the content in bytes
+}
+
+// String returns the file content
+func (f TextFileResult) String() string {
+	return f.Content
+}
+
+// ExecuteFile runs the text file generator and returns the file content and name
+func (textFileTool) ExecuteFile(r *http.Request) (string, string, error) {
+	return ExecuteTextFile(r)
+}
+
 // TextFileParams represents the parameters for the text file tool
 type TextFileParams struct {
 	Content string `json:"content"` // Content to be saved as a text file
@@ -18,7 +82,7 @@ type TextFileParams struct {
 // ValidateTextFileParams validates the parameters for the text file tool
 func ValidateTextFileParams(params TextFileParams) error {
 	if params.Content == "" {
-		return errors.New("content cannot be empty")
+		return newValidationError("content cannot be empty", FieldError{Field: "content", Message: "cannot be empty"})
 	}
 	
 	return nil
@@ -42,72 +106,18 @@ func GenerateTextFile(params TextFileParams) (string, string, error) {
 }
 
 // ExecuteTextFile handles the text file tool which generates a downloadable text file
-// from the provided content. It parses the request, generates the text file, and writes
-// the response with appropriate headers for file download.
+// from the provided content. It parses the request and generates the text file.
 //
-// Parameters are extracted from the request based on the Content-Type:
-// - For form submissions: content and filename form fields
-// - For JSON submissions: Content and Filename JSON properties
-// - For GET requests: content and filename query parameters
+// Parameters are bound from the query string, form data or JSON body (see BindParams):
+//   - content: Content to be saved as a text file (required)
+//   - filename: Optional filename for the text file (default: "download.txt")
 //
-// Returns the file content, filename, and response headers for the caller to use.
+// Returns the file content and filename for the caller to use.
 func ExecuteTextFile(r *http.Request) (string, string, error) {
-	// Parse parameters from either POST or GET
-	var content, filename string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return "", "", fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			content = r.FormValue("content")
-			filename = r.FormValue("filename")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params TextFileParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return "", "", fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// Use the values from JSON
-			content = params.Content
-			filename = params.Filename
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return "", "", fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			content = r.FormValue("content")
-			filename = r.FormValue("filename")
-		}
-	} else {
-		// Get parameters from query string
-		content = r.URL.Query().Get("content")
-		filename = r.URL.Query().Get("filename")
-	}
-
-	// Validate parameters
-	if content == "" {
-		return "", "", fmt.Errorf("content parameter is required")
-	}
-
-	// Create parameters for the text file tool
-	params := TextFileParams{
-		Content:  content,
-		Filename: filename,
+	// Parse parameters from the request
+	var params TextFileParams
+	if err := BindParams(r, textFileTool{}.Info().Parameters, &params); err != nil {
+		return "", "", err
 	}
 
 	// Generate text file
@@ -2,12 +2,50 @@
 package tools
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(textFormatterTool{})
+}
+
+// textFormatterTool is the text formatter registered as "text-formatter"
+type textFormatterTool struct{}
+
+// Info returns the tool info for the text formatter
+func (textFormatterTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-formatter",
+		Description: "Format text with various options",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "text",
+				Type:        "string",
+				Description: "Text to format",
+				Required:    true,
+				Multiline:   true,
+			},
+			{
+				Name:        "uppercase",
+				Type:        "bool",
+				Description: "Convert text to uppercase (if false, converts to lowercase)",
+				Required:    false,
+				Default:     false,
+			},
+		},
+	}
+}
+
+// Execute runs the text formatter against the request
+func (textFormatterTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextFormatter(r)
+}
+
 // TextFormatterParams represents the parameters for the text formatter
 type TextFormatterParams struct {
 	Text      string `json:"text"`      // Text to format
@@ -56,62 +94,9 @@ func ExecuteTextFormatter(r *http.Request) (string, error) {
 // ParseTextFormatterParams parses the text formatter parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseTextFormatterParams(r *http.Request) (TextFormatterParams, error) {
-	// Parse parameters from either POST or GET
-	var text string
-	var uppercaseStr string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return TextFormatterParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			text = r.FormValue("text")
-			uppercaseStr = r.FormValue("uppercase")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params TextFormatterParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return TextFormatterParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// If JSON values are provided, use them directly
-			if params.Text != "" {
-				return params, nil
-			}
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return TextFormatterParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			text = r.FormValue("text")
-			uppercaseStr = r.FormValue("uppercase")
-		}
-	} else {
-		// Get parameters from query string
-		text = r.URL.Query().Get("text")
-		uppercaseStr = r.URL.Query().Get("uppercase")
+	var params TextFormatterParams
+	if err := BindParams(r, textFormatterTool{}.Info().Parameters, &params); err != nil {
+		return TextFormatterParams{}, err
 	}
-
-	// Parse boolean parameters
-	uppercase := false
-	if uppercaseStr == "true" || uppercaseStr == "on" || uppercaseStr == "1" {
-		uppercase = true
-	}
-
-	// Create and return parameters
-	return TextFormatterParams{
-		Text:      text,
-		Uppercase: uppercase,
-	}, nil
+	return params, nil
 }
@@ -2,82 +2,118 @@
 package tools
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/audit"
 	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterPrivateTool(textRetrievalTool{}, models.ScopeStorageRead)
+}
+
+// textRetrievalTool is the private text retrieval tool registered as "text-retrieval"
+type textRetrievalTool struct{}
+
+// Info returns the tool info for the text retrieval tool
+func (textRetrievalTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-retrieval",
+		Description: "Retrieves text content from the database using a unique ID",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "id",
+				Description: "The unique ID of the text to retrieve",
+				Type:        "string",
+				Required:    true,
+				Default:     "",
+			},
+		},
+	}
+}
+
+// Execute runs the text retrieval tool against the request
+func (textRetrievalTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextRetrieval(r)
+}
+
+// TextRetrievalParams represents the parameters for the text retrieval tool
+type TextRetrievalParams struct {
+	ID string `json:"id"` // The unique ID of the text to retrieve
+}
+
+// TextRetrievalResult is the result of the text retrieval tool
+type TextRetrievalResult struct {
+	ID        string     `json:"id"`                   // Unique ID of the text
+	Content   string     `json:"content"`              // The stored text
+	Saved     bool       `json:"saved"`                // Whether the text is saved permanently
+	CreatedAt time.Time  `json:"created_at"`           // When the text was stored
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When the text expires, nil if it does not expire
+	Version   int64      `json:"version"`              // Version of the text, see ETag
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // When the text last changed, nil if it never changed
+}
+
+// String returns the stored text
+func (t TextRetrievalResult) String() string {
+	return t.Content
+}
+
+// ETag returns the entity tag of the retrieved version, for changing the text with If-Match
+func (t TextRetrievalResult) ETag() string {
+	return TextETag(t.Version)
+}
+
 // ExecuteTextRetrieval executes the text retrieval tool
 // This tool retrieves text content from the database using a unique ID
+// Expired entries that were not cleaned up yet return a gone error
+// Retrievals, including of missing and expired entries, are recorded as audit events
 // Parameters:
 //   - id: The unique ID of the text to retrieve (required)
-func ExecuteTextRetrieval(r *http.Request) (string, error) {
+func ExecuteTextRetrieval(r *http.Request) (TextRetrievalResult, error) {
 	// Parse parameters
-	id := ""
-
-	// Handle both GET and POST requests
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse form data for POST requests
-			if err := r.ParseForm(); err != nil {
-				return "", fmt.Errorf("failed to parse form data: %w", err)
-			}
-			id = r.FormValue("id")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data using a map to accept any fields
-			var jsonData map[string]interface{}
-			
-			// Read the entire body
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&jsonData); err != nil {
-				return "", fmt.Errorf("failed to parse JSON data: %w", err)
-			}
-			defer r.Body.Close()
-			
-			// Extract the ID field
-			if idVal, ok := jsonData["id"]; ok {
-				if idStr, ok := idVal.(string); ok {
-					id = idStr
-				}
-			}
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return "", fmt.Errorf("failed to parse form data: %w", err)
-			}
-			id = r.FormValue("id")
-		}
-	} else {
-		// Parse query parameters for GET requests
-		id = r.URL.Query().Get("id")
-	}
-
-	// Validate parameters
-	if id == "" {
-		return "", errors.New("id parameter is required")
+	var params TextRetrievalParams
+	if err := BindParams(r, textRetrievalTool{}.Info().Parameters, &params); err != nil {
+		return TextRetrievalResult{}, err
 	}
 
 	// Get the DAO
 	dao, err := database.GetTextStorageDAO()
 	if err != nil {
-		return "", fmt.Errorf("database error: %w", err)
+		return TextRetrievalResult{}, UnavailableError("database error", err)
 	}
 
 	// Retrieve the text
-	entry, err := dao.GetTextByID(id)
+	entry, err := dao.GetTextByID(params.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		middleware.RecordEvent(r, audit.Event{Type: audit.EventTextRetrieved, Outcome: audit.OutcomeFailure, TargetID: params.ID, Details: "not found"})
+		return TextRetrievalResult{}, NotFoundError(fmt.Sprintf("text entry with ID %s not found", params.ID))
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve text: %w", err)
+		return TextRetrievalResult{}, UnavailableError("failed to retrieve text", err)
+	}
+
+	// Entries that expired before the cleanup removed them are gone
+	if entry.Expired(time.Now()) {
+		middleware.RecordEvent(r, audit.Event{Type: audit.EventTextRetrieved, Outcome: audit.OutcomeFailure, TargetID: entry.ID, Details: "expired"})
+		return TextRetrievalResult{}, GoneError(fmt.Sprintf("text entry with ID %s has expired", params.ID))
 	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventTextRetrieved, TargetID: entry.ID})
 
-	// Return the content
-	return entry.Content, nil
+	// Return the entry
+	return TextRetrievalResult{
+		ID:        entry.ID,
+		Content:   entry.Content,
+		Saved:     entry.SaveFlag,
+		CreatedAt: entry.CreatedAt,
+		ExpiresAt: entry.ExpiresAt,
+		Version:   entry.Version,
+		UpdatedAt: entry.UpdatedAt,
+	}, nil
 }
@@ -0,0 +1,108 @@
+// Package tools contains the implementation of various tools for the AllMiTools server
+package tools
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+func init() {
+	models.RegisterPrivateTool(textSaveTool{}, models.ScopeStorageWrite)
+}
+
+// textSaveTool is the private tool saving or unsaving a text entry, registered as "text-save"
+type textSaveTool struct{}
+
+// Info returns the tool info for the text save tool
+func (textSaveTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-save",
+		Description: "Saves a stored text permanently, or lets it expire again",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			textIDParameter,
+			{
+				Name:        "save",
+				Description: "Whether to save the text permanently, unsaved text expires after the ttl",
+				Type:        "bool",
+				Required:    false,
+				Default:     false,
+			},
+			{
+				Name:        "ttl",
+				Description: "How long unsaved text is kept from now, such as 1h, 30d or never; defaults to the server's default TTL",
+				Type:        "string",
+				Required:    false,
+				Default:     "",
+			},
+			ifMatchParameter,
+		},
+	}
+}
+
+// Execute runs the text save tool against the request
+func (textSaveTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextSave(r)
+}
+
+// TextSaveParams represents the parameters for the text save tool
+type TextSaveParams struct {
+	ID      string `json:"id"`       // The unique ID of the text to change
+	Save    bool   `json:"save"`     // Whether to save the text permanently
+	TTL     string `json:"ttl"`      // How long unsaved text is kept, empty for the default
+	IfMatch string `json:"if_match"` // ETag of the version to change, empty for any version
+}
+
+// ExecuteTextSave executes the text save tool
+// This tool sets the save flag of a text entry; unsaved text expires after the ttl
+// Parameters:
+//   - id: The unique ID of the text to change (required)
+//   - save: Whether to save the text permanently (optional, default: false)
+//   - ttl: How long unsaved text is kept from now, such as 1h, 30d or never (optional, default: the server's default TTL)
+//   - if_match: ETag of the version to change (optional, the If-Match header can be sent instead)
+func ExecuteTextSave(r *http.Request) (TextUpdateResult, error) {
+	// Parse parameters
+	var params TextSaveParams
+	if err := BindParams(r, textSaveTool{}.Info().Parameters, &params); err != nil {
+		return TextUpdateResult{}, err
+	}
+	version, err := ifMatchVersion(r, params.IfMatch)
+	if err != nil {
+		return TextUpdateResult{}, err
+	}
+	expiresAt, err := TextExpiry(params.TTL, params.Save, time.Now())
+	if err != nil {
+		return TextUpdateResult{}, err
+	}
+
+	// Get the DAO
+	dao, err := database.GetTextStorageDAO()
+	if err != nil {
+		return TextUpdateResult{}, UnavailableError("database error", err)
+	}
+
+	// Check that the text exists and has not expired
+	if _, err := findTextEntry(r, dao, audit.EventTextUpdated, params.ID); err != nil {
+		return TextUpdateResult{}, err
+	}
+
+	// Change the save flag at the requested version
+	entry, err := dao.UpdateTextSaveFlag(params.ID, params.Save, expiresAt, version)
+	if err != nil {
+		return TextUpdateResult{}, textChangeError(r, audit.EventTextUpdated, params.ID, "failed to update text", err)
+	}
+	middleware.RecordEvent(r, audit.Event{
+		Type:     audit.EventTextUpdated,
+		TargetID: entry.ID,
+		Details:  fmt.Sprintf("save=%t version=%d", entry.SaveFlag, entry.Version),
+	})
+
+	return newTextUpdateResult(entry), nil
+}
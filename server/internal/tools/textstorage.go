@@ -2,126 +2,237 @@
 package tools
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/audit"
 	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterPrivateTool(textStorageTool{}, models.ScopeStorageWrite)
+}
+
+// textStorageTool is the private text storage tool registered as "text-storage"
+type textStorageTool struct{}
+
+// Info returns the tool info for the text storage tool
+func (textStorageTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "text-storage",
+		Description: "Stores text content in the database with a unique ID",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "content",
+				Description: "The text content to store",
+				Type:        "string",
+				Required:    true,
+				Default:     "",
+				Multiline:   true,
+			},
+			{
+				Name:        "save",
+				Description: "Whether to save the text permanently",
+				Type:        "bool",
+				Required:    false,
+				Default:     false,
+			},
+			{
+				Name:        "ttl",
+				Description: "How long the text is kept, such as 1h, 30d or never; defaults to the server's default TTL, or never for saved text",
+				Type:        "string",
+				Required:    false,
+				Default:     "",
+			},
+		},
+	}
+}
+
+// TTLNever is the ttl of text that does not expire
+const TTLNever = "never"
+
+// TextStorageConfig holds the expiry settings of stored text
+type TextStorageConfig struct {
+	// DefaultTTL is how long unsaved text is kept when the request sets no ttl
+	DefaultTTL time.Duration
+	// MaxTTL is the longest ttl a request may set, 0 for no limit
+	// With a limit, text cannot be saved permanently or kept forever
+	MaxTTL time.Duration
+}
+
+// DefaultTextStorageConfig keeps unsaved text for 7 days without a limit on the ttl
+var DefaultTextStorageConfig = TextStorageConfig{DefaultTTL: 7 * 24 * time.Hour}
+
+// textStorageConfig holds the expiry settings used by the text storage tool
+var (
+	textStorageConfig      = DefaultTextStorageConfig
+	textStorageConfigMutex sync.RWMutex
+)
+
+// ConfigureTextStorage sets the expiry settings of stored text
+// The default ttl is reduced to the maximum ttl when it is longer
+func ConfigureTextStorage(config TextStorageConfig) {
+	if config.DefaultTTL <= 0 {
+		config.DefaultTTL = DefaultTextStorageConfig.DefaultTTL
+	}
+	if config.MaxTTL > 0 && config.DefaultTTL > config.MaxTTL {
+		config.DefaultTTL = config.MaxTTL
+	}
+
+	textStorageConfigMutex.Lock()
+	defer textStorageConfigMutex.Unlock()
+	textStorageConfig = config
+}
+
+// getTextStorageConfig returns the expiry settings of stored text
+func getTextStorageConfig() TextStorageConfig {
+	textStorageConfigMutex.RLock()
+	defer textStorageConfigMutex.RUnlock()
+	return textStorageConfig
+}
+
+// maxTTLDays is the longest time to live in days that fits in a time.Duration, about 292 years
+const maxTTLDays = math.MaxInt64 / int64(24*time.Hour)
+
+// ParseTTL parses a time to live such as "90m", "1h" or "30d"
+// Days are written with a d suffix, other values are Go durations
+// "never" returns 0, which means the text does not expire
+func ParseTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == TTLNever {
+		return 0, nil
+	}
+
+	var ttl time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q, use a duration such as 1h or 30d, or never", value)
+		}
+		if int64(n) > maxTTLDays {
+			return 0, fmt.Errorf("ttl %q is too long, the maximum is %dd, or never", value, maxTTLDays)
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q, use a duration such as 1h or 30d, or never", value)
+		}
+		ttl = parsed
+	}
+
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// TextExpiry returns when text stored at now with the ttl and save parameters expires, nil if it does not expire
+// Saved text does not expire, so save cannot be combined with a ttl other than never
+func TextExpiry(ttlParam string, save bool, now time.Time) (*time.Time, error) {
+	config := getTextStorageConfig()
+
+	var ttl time.Duration
+	switch {
+	case ttlParam == "" && !save:
+		ttl = config.DefaultTTL
+	case ttlParam != "":
+		parsed, err := ParseTTL(ttlParam)
+		if err != nil {
+			return nil, ttlError("must be a positive duration such as 1h or 30d, or never")
+		}
+		if save && parsed != 0 {
+			return nil, ttlError("must be never or left out, saved text does not expire")
+		}
+		ttl = parsed
+	}
+
+	if ttl == 0 {
+		if config.MaxTTL > 0 {
+			return nil, ttlError(fmt.Sprintf("must be at most %s, text cannot be kept forever", config.MaxTTL))
+		}
+		return nil, nil
+	}
+	if config.MaxTTL > 0 && ttl > config.MaxTTL {
+		return nil, ttlError(fmt.Sprintf("must be at most %s", config.MaxTTL))
+	}
+
+	expiresAt := now.Add(ttl)
+	return &expiresAt, nil
+}
+
+// ttlError returns a validation error of the ttl parameter
+func ttlError(message string) error {
+	return newValidationError("", FieldError{Field: "ttl", Message: message})
+}
+
+// Execute runs the text storage tool against the request
+func (textStorageTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextStorage(r)
+}
+
+// TextStorageParams represents the parameters for the text storage tool
+type TextStorageParams struct {
+	Content string `json:"content"` // The text content to store
+	Save    bool   `json:"save"`    // Whether to save the text permanently
+	TTL     string `json:"ttl"`     // How long the text is kept, empty for the default
+}
+
+// TextStorageResult is the result of the text storage tool
+type TextStorageResult struct {
+	ID        string     `json:"id"`                   // Unique ID of the stored text
+	Saved     bool       `json:"saved"`                // Whether the text is saved permanently
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When the text expires, nil if it does not expire
+}
+
+// String returns the ID of the stored text
+func (s TextStorageResult) String() string {
+	return s.ID
+}
+
 // ExecuteTextStorage executes the text storage tool
 // This tool stores text content in the database and returns a unique ID
+// Stored texts are recorded as audit events
 // Parameters:
 //   - content: The text content to store (required)
 //   - save: Whether to save the text permanently (optional, default: false)
-func ExecuteTextStorage(r *http.Request) (string, error) {
+//   - ttl: How long the text is kept, such as 1h, 30d or never (optional, default: the server's default TTL)
+func ExecuteTextStorage(r *http.Request) (TextStorageResult, error) {
 	// Parse parameters
-	content := ""
-	saveFlag := false
-	var err error
-
-	// Handle both GET and POST requests
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") ||
-			strings.Contains(contentType, "multipart/form-data") {
-			// Parse form data for POST requests
-			if err := r.ParseForm(); err != nil {
-				return "", fmt.Errorf("failed to parse form data: %w", err)
-			}
-			content = r.FormValue("content")
-			saveFlagStr := r.FormValue("save")
-			if saveFlagStr != "" {
-				saveFlag, err = strconv.ParseBool(saveFlagStr)
-				if err != nil {
-					return "", fmt.Errorf("invalid value for 'save' parameter: %w", err)
-				}
-			}
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data using a map to accept any fields
-			var jsonData map[string]interface{}
-
-			// Make sure to close the body when we're done
-			defer r.Body.Close()
-			
-			// Check if body is nil
-			if r.Body == nil {
-				return "", fmt.Errorf("request body is empty")
-			}
-			
-			// Read the entire body
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&jsonData); err != nil {
-				return "", fmt.Errorf("failed to parse JSON data: %w", err)
-			}
-
-			// Extract the content field
-			if contentVal, ok := jsonData["content"]; ok {
-				if contentStr, ok := contentVal.(string); ok {
-					content = contentStr
-				}
-			}
-
-			// Extract the save field if present
-			if saveVal, ok := jsonData["save"]; ok {
-				switch v := saveVal.(type) {
-				case bool:
-					saveFlag = v
-				case string:
-					saveFlag, _ = strconv.ParseBool(v)
-				case float64: // JSON numbers are decoded as float64
-					saveFlag = v != 0
-				}
-			}
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return "", fmt.Errorf("failed to parse form data: %w", err)
-			}
-			content = r.FormValue("content")
-			saveFlagStr := r.FormValue("save")
-			if saveFlagStr != "" {
-				saveFlag, err = strconv.ParseBool(saveFlagStr)
-				if err != nil {
-					return "", fmt.Errorf("invalid value for 'save' parameter: %w", err)
-				}
-			}
-		}
-	} else {
-		// Parse query parameters for GET requests
-		content = r.URL.Query().Get("content")
-		saveFlagStr := r.URL.Query().Get("save")
-		if saveFlagStr != "" {
-			saveFlag, err = strconv.ParseBool(saveFlagStr)
-			if err != nil {
-				return "", fmt.Errorf("invalid value for 'save' parameter: %w", err)
-			}
-		}
+	var params TextStorageParams
+	if err := BindParams(r, textStorageTool{}.Info().Parameters, &params); err != nil {
+		return TextStorageResult{}, err
 	}
-
-	// Validate parameters
-	if content == "" {
-		return "", errors.New("content parameter is required")
+	expiresAt, err := TextExpiry(params.TTL, params.Save, time.Now())
+	if err != nil {
+		return TextStorageResult{}, err
 	}
 
 	// Get the DAO
 	dao, err := database.GetTextStorageDAO()
 	if err != nil {
-		return "", fmt.Errorf("database error: %w", err)
+		return TextStorageResult{}, UnavailableError("database error", err)
 	}
 
-	// Store the text
-	id, err := dao.StoreText(content, saveFlag)
+	// Store the text, recording the logged in user as its creator
+	user, _ := middleware.CurrentUser(r)
+	id, err := dao.StoreText(params.Content, params.Save, user.ID, expiresAt)
 	if err != nil {
-		return "", fmt.Errorf("failed to store text: %w", err)
+		middleware.RecordEvent(r, audit.Event{Type: audit.EventTextStored, Outcome: audit.OutcomeFailure, Details: err.Error()})
+		return TextStorageResult{}, UnavailableError("failed to store text", err)
 	}
+	middleware.RecordEvent(r, audit.Event{Type: audit.EventTextStored, TargetID: id, Details: fmt.Sprintf("saved=%t", params.Save)})
 
 	// Return the ID
-	return fmt.Sprintf("%s", id), nil
+	return TextStorageResult{ID: id, Saved: params.Save, ExpiresAt: expiresAt}, nil
 }
@@ -0,0 +1,237 @@
+// Package tools contains the implementation of various tools for the AllMiTools server
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+)
+
+func init() {
+	models.RegisterPrivateTool(textUpdateTool{name: "text-replace", append: false}, models.ScopeStorageWrite)
+	models.RegisterPrivateTool(textUpdateTool{name: "text-append", append: true}, models.ScopeStorageWrite)
+}
+
+// textIDPattern matches the IDs of text entries
+const textIDPattern = "^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$"
+
+// textIDParameter is the id parameter of the tools changing a text entry
+var textIDParameter = models.ToolParameter{
+	Name:        "id",
+	Description: "The unique ID of the text to change",
+	Type:        "string",
+	Required:    true,
+	Default:     "",
+	Pattern:     textIDPattern,
+}
+
+// ifMatchParameter is the if_match parameter of the tools changing a text entry
+var ifMatchParameter = models.ToolParameter{
+	Name:        "if_match",
+	Description: "ETag of the version to change, such as \"3\"; the change fails if the text changed since. The If-Match header can be sent instead",
+	Type:        "string",
+	Required:    false,
+	Default:     "",
+}
+
+// textUpdateTool is the private tool replacing the content of a text entry, registered as "text-replace",
+// or appending to it, registered as "text-append"
+type textUpdateTool struct {
+	name   string
+	append bool
+}
+
+// Info returns the tool info for the text replace or append tool
+func (t textUpdateTool) Info() models.ToolInfo {
+	description := "Replaces the content of a stored text"
+	contentDescription := "The new text content"
+	if t.append {
+		description = "Appends content to a stored text"
+		contentDescription = "The text content to append"
+	}
+	return models.ToolInfo{
+		Name:        t.name,
+		Description: description,
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			textIDParameter,
+			{
+				Name:        "content",
+				Description: contentDescription,
+				Type:        "string",
+				Required:    true,
+				Default:     "",
+				Multiline:   true,
+			},
+			ifMatchParameter,
+		},
+	}
+}
+
+// Execute runs the text replace or append tool against the request
+func (t textUpdateTool) Execute(r *http.Request) (any, error) {
+	return ExecuteTextUpdate(r, t.append)
+}
+
+// TextUpdateParams represents the parameters for the text replace and append tools
+type TextUpdateParams struct {
+	ID      string `json:"id"`       // The unique ID of the text to change
+	Content string `json:"content"`  // The new or appended text content
+	IfMatch string `json:"if_match"` // ETag of the version to change, empty for any version
+}
+
+// TextUpdateResult is the result of the tools changing a text entry
+type TextUpdateResult struct {
+	ID        string     `json:"id"`                   // Unique ID of the text
+	Version   int64      `json:"version"`              // Version of the text after the change
+	Saved     bool       `json:"saved"`                // Whether the text is saved permanently
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // When the text was changed
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When the text expires, nil if it does not expire
+}
+
+// String returns the ID of the changed text
+func (u TextUpdateResult) String() string {
+	return u.ID
+}
+
+// ETag returns the entity tag of the changed text
+func (u TextUpdateResult) ETag() string {
+	return TextETag(u.Version)
+}
+
+// newTextUpdateResult returns the result of a change of a text entry
+func newTextUpdateResult(entry *database.TextEntry) TextUpdateResult {
+	return TextUpdateResult{
+		ID:        entry.ID,
+		Version:   entry.Version,
+		Saved:     entry.SaveFlag,
+		UpdatedAt: entry.UpdatedAt,
+		ExpiresAt: entry.ExpiresAt,
+	}
+}
+
+// ExecuteTextUpdate executes the text replace tool, or the text append tool when appending
+// This tool changes the content of a text entry and returns its new version
+// Parameters:
+//   - id: The unique ID of the text to change (required)
+//   - content: The new or appended text content (required)
+//   - if_match: ETag of the version to change (optional, the If-Match header can be sent instead)
+func ExecuteTextUpdate(r *http.Request, appendContent bool) (TextUpdateResult, error) {
+	// Parse parameters
+	var params TextUpdateParams
+	info := textUpdateTool{append: appendContent}.Info()
+	if err := BindParams(r, info.Parameters, &params); err != nil {
+		return TextUpdateResult{}, err
+	}
+	version, err := ifMatchVersion(r, params.IfMatch)
+	if err != nil {
+		return TextUpdateResult{}, err
+	}
+	operation := "replace"
+	if appendContent {
+		operation = "append"
+	}
+
+	// Get the DAO
+	dao, err := database.GetTextStorageDAO()
+	if err != nil {
+		return TextUpdateResult{}, UnavailableError("database error", err)
+	}
+
+	// Check that the text exists and has not expired
+	if _, err := findTextEntry(r, dao, audit.EventTextUpdated, params.ID); err != nil {
+		return TextUpdateResult{}, err
+	}
+
+	// Change the content at the requested version
+	var entry *database.TextEntry
+	if appendContent {
+		entry, err = dao.AppendText(params.ID, params.Content, version)
+	} else {
+		entry, err = dao.ReplaceText(params.ID, params.Content, version)
+	}
+	if err != nil {
+		return TextUpdateResult{}, textChangeError(r, audit.EventTextUpdated, params.ID, "failed to update text", err)
+	}
+	middleware.RecordEvent(r, audit.Event{
+		Type:     audit.EventTextUpdated,
+		TargetID: entry.ID,
+		Details:  fmt.Sprintf("%s version=%d", operation, entry.Version),
+	})
+
+	return newTextUpdateResult(entry), nil
+}
+
+// TextETag returns the entity tag of a version of a text entry
+func TextETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ParseTextETag returns the version of a text entry named by an entity tag such as "3"
+// The quotes may be left out; an empty tag or * returns 0, which matches any version
+func ParseTextETag(etag string) (int64, error) {
+	etag = strings.TrimSpace(etag)
+	if etag == "" || etag == "*" {
+		return 0, nil
+	}
+	if strings.HasPrefix(etag, "W/") {
+		return 0, errors.New("weak entity tags cannot be used to change text")
+	}
+	version, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid entity tag %s", etag)
+	}
+	return version, nil
+}
+
+// ifMatchVersion returns the version a change of a text entry is made against,
+// from the if_match parameter or else the If-Match header, 0 for any version
+func ifMatchVersion(r *http.Request, param string) (int64, error) {
+	value := param
+	if value == "" {
+		value = r.Header.Get("If-Match")
+	}
+	version, err := ParseTextETag(value)
+	if err != nil {
+		return 0, newValidationError("", FieldError{Field: "if_match", Message: "must be the ETag of a version of the text, such as \"3\""})
+	}
+	return version, nil
+}
+
+// findTextEntry retrieves a text entry that is about to change
+// Missing and expired entries are recorded as failed audit events of the change
+func findTextEntry(r *http.Request, dao *database.TextStorageDAO, eventType string, id string) (*database.TextEntry, error) {
+	entry, err := dao.GetTextByID(id)
+	if err != nil {
+		return nil, textChangeError(r, eventType, id, "failed to retrieve text", err)
+	}
+	if entry.Expired(time.Now()) {
+		middleware.RecordEvent(r, audit.Event{Type: eventType, Outcome: audit.OutcomeFailure, TargetID: id, Details: "expired"})
+		return nil, GoneError(fmt.Sprintf("text entry with ID %s has expired", id))
+	}
+	return entry, nil
+}
+
+// textChangeError returns the tool error of a failed change of a text entry
+// Missing entries and version conflicts are recorded as failed audit events
+func textChangeError(r *http.Request, eventType string, id string, message string, err error) error {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		middleware.RecordEvent(r, audit.Event{Type: eventType, Outcome: audit.OutcomeFailure, TargetID: id, Details: "not found"})
+		return NotFoundError(fmt.Sprintf("text entry with ID %s not found", id))
+	case errors.Is(err, database.ErrVersionConflict):
+		middleware.RecordEvent(r, audit.Event{Type: eventType, Outcome: audit.OutcomeFailure, TargetID: id, Details: err.Error()})
+		return PreconditionFailedError(fmt.Sprintf("text entry with ID %s changed since the version in If-Match, retrieve it again", id))
+	default:
+		return UnavailableError(message, err)
+	}
+}
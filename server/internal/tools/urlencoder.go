@@ -2,13 +2,42 @@
 package tools
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/url"
-	"strings"
+
+	"github.com/CJFEdu/allmitools/server/internal/models"
 )
 
+func init() {
+	models.RegisterTool(urlEncoderTool{})
+}
+
+// urlEncoderTool is the URL encoder registered as "url-encoder"
+type urlEncoderTool struct{}
+
+// Info returns the tool info for the URL encoder
+func (urlEncoderTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "url-encoder",
+		Description: "Convert a string to a URL-encoded string",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+		Parameters: []models.ToolParameter{
+			{
+				Name:        "text",
+				Type:        "string",
+				Description: "Text to URL encode",
+				Required:    true,
+			},
+		},
+	}
+}
+
+// Execute runs the URL encoder against the request
+func (urlEncoderTool) Execute(r *http.Request) (any, error) {
+	return ExecuteURLEncoder(r)
+}
+
 // URLEncoderParams represents the parameters for the URL encoder
 type URLEncoderParams struct {
 	Text string `json:"text"` // Text to encode
@@ -17,7 +46,7 @@ type URLEncoderParams struct {
 // ValidateURLEncoderParams validates the parameters for the URL encoder
 func ValidateURLEncoderParams(params URLEncoderParams) error {
 	if params.Text == "" {
-		return fmt.Errorf("text parameter is required")
+		return newValidationError("text parameter is required", FieldError{Field: "text", Message: "is required"})
 	}
 	return nil
 }
@@ -25,56 +54,11 @@ func ValidateURLEncoderParams(params URLEncoderParams) error {
 // ParseURLEncoderParams parses the URL encoder parameters from an HTTP request
 // It handles both POST and GET requests
 func ParseURLEncoderParams(r *http.Request) (URLEncoderParams, error) {
-	// Parse parameters from either POST or GET
-	var text string
-
-	// Check if this is a POST request
-	if r.Method == http.MethodPost {
-		// Check Content-Type header to determine how to parse the data
-		contentType := r.Header.Get("Content-Type")
-
-		// If it's a form submission, parse form data
-		if strings.Contains(contentType, "application/x-www-form-urlencoded") || 
-		   strings.Contains(contentType, "multipart/form-data") {
-			// Parse the form data
-			if err := r.ParseForm(); err != nil {
-				return URLEncoderParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-
-			// Get parameters from form data
-			text = r.FormValue("text")
-		} else if strings.Contains(contentType, "application/json") {
-			// Parse JSON data
-			var params URLEncoderParams
-			decoder := json.NewDecoder(r.Body)
-			if err := decoder.Decode(&params); err != nil {
-				return URLEncoderParams{}, fmt.Errorf("error parsing JSON data: %v", err)
-			}
-			defer r.Body.Close()
-			
-			// Extract text from parsed JSON
-			text = params.Text
-		} else {
-			// Default to form parsing for backward compatibility
-			if err := r.ParseForm(); err != nil {
-				return URLEncoderParams{}, fmt.Errorf("error parsing form data: %v", err)
-			}
-			text = r.FormValue("text")
-		}
-	} else {
-		// Get parameters from query string
-		text = r.URL.Query().Get("text")
+	var params URLEncoderParams
+	if err := BindParams(r, urlEncoderTool{}.Info().Parameters, &params); err != nil {
+		return URLEncoderParams{}, err
 	}
-
-	// Validate parameters
-	if text == "" {
-		return URLEncoderParams{}, fmt.Errorf("text parameter is required")
-	}
-
-	// Create and return parameters
-	return URLEncoderParams{
-		Text: text,
-	}, nil
+	return params, nil
 }
 
 // ExecuteURLEncoder executes the URL encoder with the given HTTP request
@@ -9,14 +9,19 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/audit"
 	"github.com/CJFEdu/allmitools/server/internal/database"
 	"github.com/CJFEdu/allmitools/server/internal/handlers"
 	"github.com/CJFEdu/allmitools/server/internal/logging"
 	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/oidc"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -25,7 +30,27 @@ import (
 type serverConfig struct {
 	Port              int
 	TemplatesDir      string
+	TemplatesReload       bool
 	RequestLoggingEnabled bool
+	BatchMaxSize          int
+	BatchConcurrency      int
+	SessionKeys           string
+	SessionStore          string
+	LoginMaxFailures      int
+	LoginIPMaxFailures    int
+	LoginLockout          time.Duration
+	TrustedProxies        string
+	OIDCIssuer            string
+	OIDCClientID          string
+	OIDCClientSecret      string
+	OIDCRedirectURL       string
+	OIDCScopes            string
+	OIDCUsernameClaim     string
+	OIDCGroupsClaim       string
+	OIDCGroupScopes       string
+	OIDCCreateUsers       bool
+	TextDefaultTTL        string
+	TextMaxTTL            string
 }
 
 // newRouter creates and configures a new router with all the routes
@@ -39,6 +64,9 @@ func newRouter(config serverConfig) *mux.Router {
 		r.Use(logging.RequestLoggerMiddleware)
 	}
 
+	// Protect browser form posts against cross-site request forgery
+	r.Use(middleware.CSRFMiddleware)
+
 	// Register routes
 	// Homepage route
 	r.HandleFunc("/", handlers.HomeHandler).Methods("GET")
@@ -50,9 +78,23 @@ func newRouter(config serverConfig) *mux.Router {
 
 	// Tools routes
 	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler).Methods("GET", "POST")
+	r.Handle("/tools/{tool_name}/batch", middleware.OptionalAuthMiddleware(handlers.BatchHandler(handlers.BatchConfig{
+		MaxSize:     config.BatchMaxSize,
+		Concurrency: config.BatchConcurrency,
+	}))).Methods("POST")
+
+	// Pipeline route for chaining tools in a single request
+	// API keys and the session cookie authenticate the private steps
+	r.Handle("/pipeline", middleware.OptionalAuthMiddleware(http.HandlerFunc(handlers.PipelineHandler))).Methods("POST")
+
+	// OpenAPI document describing the tools
+	r.HandleFunc("/openapi.json", handlers.OpenAPIHandler).Methods("GET")
 
 	// Authentication routes
 	r.HandleFunc("/login", handlers.LoginHandler).Methods("GET", "POST")
+	r.HandleFunc("/login/totp", handlers.LoginTOTPHandler).Methods("GET", "POST")
+	r.HandleFunc("/login/oidc", handlers.OIDCLoginHandler).Methods("GET")
+	r.HandleFunc("/login/oidc/callback", handlers.OIDCCallbackHandler).Methods("GET")
 	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("GET")
 
 	// Private tools routes (protected by auth middleware)
@@ -71,8 +113,31 @@ func newRouter(config serverConfig) *mux.Router {
 	privateRouter.HandleFunc("/docs/", handlers.PrivateDocsBaseHandler).Methods("GET")
 	privateRouter.HandleFunc("/docs/{tool_name}", handlers.PrivateDocsToolHandler).Methods("GET")
 
-	// Database maintenance routes (protected by auth middleware)
-	privateRouter.HandleFunc("/maintenance/cleanup", handlers.DatabaseCleanupHandler).Methods("POST")
+	// OpenAPI document including the private tools
+	privateRouter.HandleFunc("/openapi.json", handlers.PrivateOpenAPIHandler).Methods("GET")
+
+	// Session management routes
+	privateRouter.HandleFunc("/sessions", handlers.SessionsHandler).Methods("GET")
+	privateRouter.HandleFunc("/sessions/{session_id}", handlers.RevokeSessionHandler).Methods("DELETE")
+
+	// API key management routes
+	privateRouter.HandleFunc("/api-keys", handlers.APIKeysHandler).Methods("GET")
+	privateRouter.HandleFunc("/api-keys", handlers.CreateAPIKeyHandler).Methods("POST")
+	privateRouter.HandleFunc("/api-keys/{key_id}", handlers.RevokeAPIKeyHandler).Methods("DELETE")
+
+	// Two-factor authentication routes
+	privateRouter.HandleFunc("/totp", handlers.TOTPEnrollHandler).Methods("POST")
+	privateRouter.HandleFunc("/totp", handlers.TOTPDisableHandler).Methods("DELETE")
+	privateRouter.HandleFunc("/totp/confirm", handlers.TOTPConfirmHandler).Methods("POST")
+	privateRouter.HandleFunc("/totp/recovery-codes", handlers.TOTPRecoveryCodesHandler).Methods("POST")
+
+	// Database maintenance routes (protected by auth middleware, admin only)
+	privateRouter.Handle("/maintenance/cleanup",
+		middleware.RequireScopes(models.ScopeAdmin)(http.HandlerFunc(handlers.DatabaseCleanupHandler))).Methods("POST")
+
+	// Audit log of logins and privileged actions (admin only)
+	privateRouter.Handle("/audit-events",
+		middleware.RequireScopes(models.ScopeAdmin)(http.HandlerFunc(handlers.AuditEventsHandler))).Methods("GET")
 
 	// Set custom 404 handler
 	r.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
@@ -116,6 +181,22 @@ func getEnvString(key string, defaultVal string) string {
 	return val
 }
 
+// getEnvDuration gets a duration environment variable such as "15m" or returns the default value
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	valStr := os.Getenv(key)
+	if valStr == "" {
+		return defaultVal
+	}
+
+	val, err := time.ParseDuration(valStr)
+	if err != nil || val <= 0 {
+		log.Printf("Warning: Invalid value for %s, using default: %s\n", key, defaultVal)
+		return defaultVal
+	}
+
+	return val
+}
+
 // getEnvBool gets a boolean environment variable or returns the default value
 func getEnvBool(key string, defaultVal bool) bool {
 	valStr := os.Getenv(key)
@@ -132,6 +213,95 @@ func getEnvBool(key string, defaultVal bool) bool {
 	return val
 }
 
+// configureSessions sets up the session keys and the session store from the configuration
+func configureSessions(config serverConfig) error {
+	keys, err := middleware.ParseSessionKeys(config.SessionKeys)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		log.Println("Warning: SESSION_KEYS is not set, sessions will not survive a restart")
+	}
+
+	sessionConfig := middleware.SessionConfig{Keys: keys}
+	switch config.SessionStore {
+	case "cookie":
+	case "database":
+		store, err := database.GetSessionDAO()
+		if err != nil {
+			return err
+		}
+		sessionConfig.Store = store
+	default:
+		return fmt.Errorf("unknown SESSION_STORE %q, use cookie or database", config.SessionStore)
+	}
+
+	return middleware.ConfigureSessions(sessionConfig)
+}
+
+// configureLoginThrottle sets the limits of failed logins from the configuration
+func configureLoginThrottle(config serverConfig) {
+	throttleConfig := middleware.DefaultThrottleConfig
+	throttleConfig.Account.MaxFailures = config.LoginMaxFailures
+	throttleConfig.Account.Lockout = config.LoginLockout
+	throttleConfig.IP.MaxFailures = config.LoginIPMaxFailures
+	throttleConfig.IP.Lockout = config.LoginLockout
+	middleware.ConfigureLoginThrottle(throttleConfig)
+}
+
+// configureOIDC enables single sign-on when an identity provider is configured
+func configureOIDC(config serverConfig) error {
+	if config.OIDCIssuer == "" {
+		return nil
+	}
+
+	groupScopes, err := middleware.ParseGroupScopes(config.OIDCGroupScopes)
+	if err != nil {
+		return err
+	}
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer:       config.OIDCIssuer,
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		Scopes:       strings.Fields(config.OIDCScopes),
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		return err
+	}
+
+	middleware.ConfigureOIDC(provider, middleware.OIDCMapping{
+		UsernameClaim: config.OIDCUsernameClaim,
+		GroupsClaim:   config.OIDCGroupsClaim,
+		GroupScopes:   groupScopes,
+		CreateUsers:   config.OIDCCreateUsers,
+	})
+	log.Printf("Single sign-on is enabled with issuer %s", config.OIDCIssuer)
+	return nil
+}
+
+// configureTextStorage sets the default and maximum time to live of stored text
+func configureTextStorage(config serverConfig) error {
+	textConfig := tools.DefaultTextStorageConfig
+	defaultTTL, err := tools.ParseTTL(config.TextDefaultTTL)
+	if err != nil || defaultTTL == 0 {
+		return fmt.Errorf("invalid TEXT_DEFAULT_TTL %q, use a duration such as 1h or 30d", config.TextDefaultTTL)
+	}
+	textConfig.DefaultTTL = defaultTTL
+
+	if config.TextMaxTTL != "" {
+		maxTTL, err := tools.ParseTTL(config.TextMaxTTL)
+		if err != nil {
+			return fmt.Errorf("invalid TEXT_MAX_TTL %q, use a duration such as 1h or 30d, or never", config.TextMaxTTL)
+		}
+		textConfig.MaxTTL = maxTTL
+	}
+
+	tools.ConfigureTextStorage(textConfig)
+	return nil
+}
+
 // scheduleCleanup runs the database cleanup task on a schedule
 func scheduleCleanup() {
 	cleanupInterval := 24 * time.Hour // Run once per day
@@ -159,18 +329,42 @@ func main() {
 	// Define the server configuration
 	config := serverConfig{
 		Port:                 getEnvInt("PORT", 3000),
-		TemplatesDir:         getEnvString("TEMPLATES_DIR", "templates"),
+		TemplatesDir:         getEnvString("TEMPLATES_DIR", ""),
+		TemplatesReload:      getEnvBool("TEMPLATES_RELOAD", false),
 		RequestLoggingEnabled: getEnvBool("REQUEST_LOGGING_ENABLED", false),
+		BatchMaxSize:         getEnvInt("BATCH_MAX_SIZE", handlers.DefaultBatchMaxSize),
+		BatchConcurrency:     getEnvInt("BATCH_CONCURRENCY", handlers.DefaultBatchConcurrency),
+		SessionKeys:          getEnvString("SESSION_KEYS", ""),
+		SessionStore:         getEnvString("SESSION_STORE", "cookie"),
+		LoginMaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", middleware.DefaultThrottleConfig.Account.MaxFailures),
+		LoginIPMaxFailures:   getEnvInt("LOGIN_IP_MAX_FAILURES", middleware.DefaultThrottleConfig.IP.MaxFailures),
+		LoginLockout:         getEnvDuration("LOGIN_LOCKOUT", middleware.DefaultThrottleConfig.Account.Lockout),
+		TrustedProxies:       getEnvString("TRUSTED_PROXIES", ""),
+		OIDCIssuer:           getEnvString("OIDC_ISSUER", ""),
+		OIDCClientID:         getEnvString("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:     getEnvString("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:      getEnvString("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:           getEnvString("OIDC_SCOPES", "openid email profile"),
+		OIDCUsernameClaim:    getEnvString("OIDC_USERNAME_CLAIM", middleware.DefaultOIDCMapping.UsernameClaim),
+		OIDCGroupsClaim:      getEnvString("OIDC_GROUPS_CLAIM", middleware.DefaultOIDCMapping.GroupsClaim),
+		OIDCGroupScopes:      getEnvString("OIDC_GROUP_SCOPES", ""),
+		OIDCCreateUsers:      getEnvBool("OIDC_CREATE_USERS", false),
+		TextDefaultTTL:       getEnvString("TEXT_DEFAULT_TTL", "7d"),
+		TextMaxTTL:           getEnvString("TEXT_MAX_TTL", ""),
 	}
 	
-	log.Printf("Using configuration: Port=%d, TemplatesDir=%s, RequestLoggingEnabled=%v\n", 
-		config.Port, config.TemplatesDir, config.RequestLoggingEnabled)
+	log.Printf("Using configuration: Port=%d, TemplatesDir=%s, TemplatesReload=%v, RequestLoggingEnabled=%v, BatchMaxSize=%d, BatchConcurrency=%d, SessionStore=%s, LoginMaxFailures=%d, LoginIPMaxFailures=%d, LoginLockout=%s\n", 
+		config.Port, config.TemplatesDir, config.TemplatesReload, config.RequestLoggingEnabled, config.BatchMaxSize, config.BatchConcurrency, config.SessionStore,
+		config.LoginMaxFailures, config.LoginIPMaxFailures, config.LoginLockout)
 
 	// Initialize the template manager
 	log.Println("Initializing template manager...")
-	if err := templates.Initialize("."); err != nil {
+	// The embedded templates are used unless TEMPLATES_DIR points to a directory on disk
+	if err := templates.Setup(templates.Config{Dir: config.TemplatesDir, Reload: config.TemplatesReload}); err != nil {
 		log.Fatalf("Error initializing template manager: %v", err)
 	}
+	// Add the CSRF token of the request to the forms of every page
+	templates.SetFormFields(middleware.CSRFFormFields)
 
 	// Initialize the database connection
 	log.Println("Initializing database connection...")
@@ -178,6 +372,39 @@ func main() {
 		log.Fatalf("Error initializing database connection: %v", err)
 	}
 
+	// Record audit events in the database
+	auditDAO, err := database.GetAuditDAO()
+	if err != nil {
+		log.Fatalf("Error initializing audit log: %v", err)
+	}
+	audit.SetRecorder(auditDAO)
+
+	// Configure the session keys and store
+	log.Println("Configuring sessions...")
+	if err := configureSessions(config); err != nil {
+		log.Fatalf("Error configuring sessions: %v", err)
+	}
+
+	// Limit failed logins
+	configureLoginThrottle(config)
+
+	// Honour the forwarding headers of trusted proxies
+	trustedProxies, err := middleware.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Error configuring trusted proxies: %v", err)
+	}
+	middleware.ConfigureTrustedProxies(trustedProxies)
+
+	// Enable single sign-on
+	if err := configureOIDC(config); err != nil {
+		log.Fatalf("Error configuring single sign-on: %v", err)
+	}
+
+	// Set the expiry of stored text
+	if err := configureTextStorage(config); err != nil {
+		log.Fatalf("Error configuring text storage: %v", err)
+	}
+
 	// Start scheduled database cleanup
 	go scheduleCleanup()
 
@@ -0,0 +1,40 @@
+-- AllMiTools Users Schema
+-- Migration: 003_users.sql
+-- Description: Creates the users table and records which user created text entries and requests
+-- Date: 2026-10-16
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+    -- Unique identifier for the user
+    id VARCHAR(36) PRIMARY KEY,
+
+    -- The name the user logs in with
+    username TEXT NOT NULL UNIQUE,
+
+    -- Hash of the user's password
+    password_hash TEXT NOT NULL,
+
+    -- Timestamp when the user was created
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Record the user that stored each text entry
+ALTER TABLE text_storage
+    ADD COLUMN IF NOT EXISTS created_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL;
+
+-- Record the authenticated user of each request
+ALTER TABLE request_logs
+    ADD COLUMN IF NOT EXISTS user_id VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL;
+
+-- Create indexes for looking up the entries of a user
+CREATE INDEX IF NOT EXISTS idx_text_storage_created_by ON text_storage(created_by);
+CREATE INDEX IF NOT EXISTS idx_request_logs_user_id ON request_logs(user_id);
+
+-- Add comments to table and columns for better documentation
+COMMENT ON TABLE users IS 'Stores the accounts that can log in to use private tools';
+COMMENT ON COLUMN users.id IS 'Unique identifier for the user';
+COMMENT ON COLUMN users.username IS 'The name the user logs in with';
+COMMENT ON COLUMN users.password_hash IS 'Hash of the user''s password';
+COMMENT ON COLUMN users.created_at IS 'Timestamp when the user was created';
+COMMENT ON COLUMN text_storage.created_by IS 'The user that stored the text entry';
+COMMENT ON COLUMN request_logs.user_id IS 'The authenticated user that made the request';
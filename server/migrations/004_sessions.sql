@@ -0,0 +1,40 @@
+-- AllMiTools Sessions Schema
+-- Migration: 004_sessions.sql
+-- Description: Creates the sessions table for the optional server-side session store
+-- Date: 2026-10-16
+
+-- Create sessions table
+CREATE TABLE IF NOT EXISTS sessions (
+    -- Unique identifier for the session, carried in the session cookie
+    id VARCHAR(36) PRIMARY KEY,
+
+    -- The user that logged in
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    -- Timestamp when the user logged in
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    -- Timestamp after which the session is no longer valid
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    -- The user agent string of the login request
+    user_agent TEXT,
+
+    -- The IP address of the login request
+    ip_address TEXT
+);
+
+-- Create index on user_id for listing the sessions of a user
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- Create index on expires_at for efficient cleanup queries
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+-- Add comments to table and columns for better documentation
+COMMENT ON TABLE sessions IS 'Stores the login sessions when the server-side session store is enabled';
+COMMENT ON COLUMN sessions.id IS 'Unique identifier for the session, carried in the session cookie';
+COMMENT ON COLUMN sessions.user_id IS 'The user that logged in';
+COMMENT ON COLUMN sessions.created_at IS 'Timestamp when the user logged in';
+COMMENT ON COLUMN sessions.expires_at IS 'Timestamp after which the session is no longer valid';
+COMMENT ON COLUMN sessions.user_agent IS 'The user agent string of the login request';
+COMMENT ON COLUMN sessions.ip_address IS 'The IP address of the login request';
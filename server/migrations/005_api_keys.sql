@@ -0,0 +1,49 @@
+-- AllMiTools API Keys Schema
+-- Migration: 005_api_keys.sql
+-- Description: Creates the api_keys table for bearer token access to private tools
+-- Date: 2026-10-16
+
+-- Create api_keys table
+CREATE TABLE IF NOT EXISTS api_keys (
+    -- Unique identifier for the API key
+    id VARCHAR(36) PRIMARY KEY,
+
+    -- The user the API key acts as
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    -- A name describing what the key is used for
+    name TEXT NOT NULL,
+
+    -- The first characters of the key, to recognize it without storing it
+    prefix TEXT NOT NULL,
+
+    -- SHA-256 hash of the key; the key itself is only shown when it is created
+    key_hash TEXT NOT NULL UNIQUE,
+
+    -- Timestamp when the key was created
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    -- Timestamp after which the key is no longer valid, NULL if it does not expire
+    expires_at TIMESTAMP WITH TIME ZONE,
+
+    -- Timestamp when the key was last used
+    last_used_at TIMESTAMP WITH TIME ZONE,
+
+    -- Timestamp when the key was revoked, NULL while it is active
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create index on user_id for listing the keys of a user
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+-- Add comments to table and columns for better documentation
+COMMENT ON TABLE api_keys IS 'Stores the API keys used as bearer tokens for private tools';
+COMMENT ON COLUMN api_keys.id IS 'Unique identifier for the API key';
+COMMENT ON COLUMN api_keys.user_id IS 'The user the API key acts as';
+COMMENT ON COLUMN api_keys.name IS 'A name describing what the key is used for';
+COMMENT ON COLUMN api_keys.prefix IS 'The first characters of the key, to recognize it without storing it';
+COMMENT ON COLUMN api_keys.key_hash IS 'SHA-256 hash of the key';
+COMMENT ON COLUMN api_keys.created_at IS 'Timestamp when the key was created';
+COMMENT ON COLUMN api_keys.expires_at IS 'Timestamp after which the key is no longer valid';
+COMMENT ON COLUMN api_keys.last_used_at IS 'Timestamp when the key was last used';
+COMMENT ON COLUMN api_keys.revoked_at IS 'Timestamp when the key was revoked';
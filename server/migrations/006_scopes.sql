@@ -0,0 +1,20 @@
+-- AllMiTools Scopes Schema
+-- Migration: 006_scopes.sql
+-- Description: Adds the scopes granting users and API keys access to private tools
+-- Date: 2026-10-16
+
+-- Scopes granted to each user, separated by spaces
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT '';
+
+-- Scopes granted to each API key, limited to the scopes of its user
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT '';
+
+-- Existing users and API keys keep access to every private tool
+UPDATE users SET scopes = 'admin' WHERE scopes = '';
+UPDATE api_keys SET scopes = 'admin' WHERE scopes = '';
+
+-- Add comments to columns for better documentation
+COMMENT ON COLUMN users.scopes IS 'Scopes granted to the user, separated by spaces';
+COMMENT ON COLUMN api_keys.scopes IS 'Scopes granted to the API key, separated by spaces';
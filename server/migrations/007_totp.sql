@@ -0,0 +1,41 @@
+-- AllMiTools Two-Factor Authentication Schema
+-- Migration: 007_totp.sql
+-- Description: Adds TOTP two-factor authentication and recovery codes to user accounts
+-- Date: 2026-10-16
+
+-- TOTP secret of each user, enabled once the user confirmed a code
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+    ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Create recovery_codes table
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    -- Unique identifier for the recovery code
+    id VARCHAR(36) PRIMARY KEY,
+
+    -- The user the recovery code belongs to
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    -- SHA-256 hash of the code; the code itself is only shown when it is created
+    code_hash TEXT NOT NULL,
+
+    -- Timestamp when the code was created
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    -- Timestamp when the code was used, NULL while it can still be used
+    used_at TIMESTAMP WITH TIME ZONE,
+
+    UNIQUE (user_id, code_hash)
+);
+
+-- Add comments to table and columns for better documentation
+COMMENT ON COLUMN users.totp_secret IS 'Base32 TOTP secret of the user, NULL without two-factor authentication';
+COMMENT ON COLUMN users.totp_enabled IS 'Whether logins need a TOTP or recovery code';
+COMMENT ON COLUMN users.totp_last_step IS 'Time step of the last accepted TOTP code, so a code cannot be used twice';
+COMMENT ON TABLE recovery_codes IS 'Stores the one-time recovery codes used when the TOTP device is lost';
+COMMENT ON COLUMN recovery_codes.id IS 'Unique identifier for the recovery code';
+COMMENT ON COLUMN recovery_codes.user_id IS 'The user the recovery code belongs to';
+COMMENT ON COLUMN recovery_codes.code_hash IS 'SHA-256 hash of the code';
+COMMENT ON COLUMN recovery_codes.created_at IS 'Timestamp when the code was created';
+COMMENT ON COLUMN recovery_codes.used_at IS 'Timestamp when the code was used';
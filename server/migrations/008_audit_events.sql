@@ -0,0 +1,71 @@
+-- AllMiTools Audit Log Schema
+-- Migration: 008_audit_events.sql
+-- Description: Adds an append-only audit log of logins and privileged actions
+-- Date: 2026-10-16
+
+-- Create audit_events table
+CREATE TABLE IF NOT EXISTS audit_events (
+    -- Sequence number of the event
+    id BIGSERIAL PRIMARY KEY,
+
+    -- The action, such as login, text_stored or cleanup
+    event_type VARCHAR(50) NOT NULL,
+
+    -- Whether the action succeeded: success, failure or denied
+    outcome VARCHAR(20) NOT NULL DEFAULT 'success',
+
+    -- The user acting; no foreign key so events outlive deleted users
+    actor_id VARCHAR(36),
+
+    -- Username of the user acting, or the username given to a failed login
+    actor_username VARCHAR(255),
+
+    -- ID of the object acted on, such as a text entry or API key
+    target_id VARCHAR(255),
+
+    -- Client of the request that caused the event
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+
+    -- Free-form details of the event
+    details TEXT,
+
+    -- Timestamp when the event happened
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes for the filters of the audit log endpoint
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_event_type ON audit_events(event_type);
+
+-- Reject changes to recorded events so the log is append-only
+CREATE OR REPLACE FUNCTION reject_audit_event_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE reject_audit_event_change();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE PROCEDURE reject_audit_event_change();
+
+-- Add comments to table and columns for better documentation
+COMMENT ON TABLE audit_events IS 'Append-only log of logins and privileged actions';
+COMMENT ON COLUMN audit_events.id IS 'Sequence number of the event';
+COMMENT ON COLUMN audit_events.event_type IS 'The action, such as login, text_stored or cleanup';
+COMMENT ON COLUMN audit_events.outcome IS 'Whether the action succeeded: success, failure or denied';
+COMMENT ON COLUMN audit_events.actor_id IS 'ID of the user acting, NULL if unknown';
+COMMENT ON COLUMN audit_events.actor_username IS 'Username of the user acting, or the username given to a failed login';
+COMMENT ON COLUMN audit_events.target_id IS 'ID of the object acted on';
+COMMENT ON COLUMN audit_events.ip_address IS 'IP address of the client';
+COMMENT ON COLUMN audit_events.user_agent IS 'User agent of the client';
+COMMENT ON COLUMN audit_events.details IS 'Free-form details of the event';
+COMMENT ON COLUMN audit_events.created_at IS 'Timestamp when the event happened';
@@ -0,0 +1,31 @@
+-- AllMiTools Text Expiry Schema
+-- Migration: 009_text_expiry.sql
+-- Description: Adds a per-entry expiry time to text entries, replacing the fixed 7 day retention
+-- Date: 2026-10-16
+
+-- Expiry time of each entry, NULL for entries that do not expire
+ALTER TABLE text_storage
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Unsaved entries stored before this migration keep the 7 day retention they were stored with
+UPDATE text_storage
+SET expires_at = created_at + INTERVAL '7 days'
+WHERE save_flag = false AND expires_at IS NULL;
+
+-- Create index for faster cleanup of expired entries
+CREATE INDEX IF NOT EXISTS idx_text_storage_expires_at ON text_storage(expires_at);
+
+-- Clean up by expiry time instead of age
+CREATE OR REPLACE FUNCTION cleanup_unsaved_text_entries()
+RETURNS void AS $$
+BEGIN
+    -- Delete unsaved entries whose expiry time has passed
+    DELETE FROM text_storage
+    WHERE save_flag = false
+    AND expires_at <= NOW();
+END;
+$$ LANGUAGE plpgsql;
+
+-- Add comments to columns for better documentation
+COMMENT ON COLUMN text_storage.expires_at IS 'Timestamp after which an unsaved entry is gone and removed by cleanup, NULL if it does not expire';
+COMMENT ON FUNCTION cleanup_unsaved_text_entries() IS 'Deletes unsaved text entries whose expiry time has passed';
@@ -0,0 +1,13 @@
+-- AllMiTools Text Versions Schema
+-- Migration: 010_text_versions.sql
+-- Description: Adds a version to text entries for conditional updates with ETag and If-Match
+-- Date: 2026-10-16
+
+-- Version of each entry, incremented by every change, and when it last changed
+ALTER TABLE text_storage
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+
+-- Add comments to columns for better documentation
+COMMENT ON COLUMN text_storage.version IS 'Version of the entry, incremented by every change and sent as its ETag';
+COMMENT ON COLUMN text_storage.updated_at IS 'Timestamp when the entry last changed, NULL if it never changed';
@@ -0,0 +1,9 @@
+// Package templates embeds the HTML templates of the AllMiTools server into the binary
+package templates
+
+import "embed"
+
+// FS contains the HTML templates of this directory
+//
+//go:embed *.html partials/*.html
+var FS embed.FS
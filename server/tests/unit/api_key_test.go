@@ -0,0 +1,267 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPIKeyStore is an in-memory APIKeyStore
+type fakeAPIKeyStore struct {
+	mutex  sync.Mutex
+	keys   map[string]*database.APIKey
+	nextID int
+	// userScopes are the scopes of the users of the keys, keyed by user ID
+	userScopes map[string][]string
+}
+
+// CreateAPIKey records an API key
+func (s *fakeAPIKeyStore) CreateAPIKey(userID string, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	id := fmt.Sprintf("key-%d", s.nextID)
+	s.keys[id] = &database.APIKey{
+		ID:         id,
+		UserID:     userID,
+		Username:   strings.TrimSuffix(userID, "-id"),
+		Name:       name,
+		Prefix:     prefix,
+		Scopes:     scopes,
+		UserScopes: s.userScopes[userID],
+		KeyHash:    keyHash,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+	return id, nil
+}
+
+// GetAPIKeyByHash returns an API key by the hash of the key
+func (s *fakeAPIKeyStore) GetAPIKeyByHash(keyHash string) (*database.APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range s.keys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// GetAPIKeyByID returns an API key by ID
+func (s *fakeAPIKeyStore) GetAPIKeyByID(id string) (*database.APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns the API keys of a user
+func (s *fakeAPIKeyStore) ListAPIKeys(userID string) ([]*database.APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := []*database.APIKey{}
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks an active API key as revoked
+func (s *fakeAPIKeyStore) RevokeAPIKey(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return database.ErrNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+// TouchAPIKey records the use of an API key
+func (s *fakeAPIKeyStore) TouchAPIKey(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	s.keys[id].LastUsedAt = &now
+	return nil
+}
+
+// useFakeAPIKeys installs an empty API key store for the duration of a test
+// Its users have the scopes of the users of useFakeUsers
+func useFakeAPIKeys(t *testing.T) *fakeAPIKeyStore {
+	store := &fakeAPIKeyStore{
+		keys: make(map[string]*database.APIKey),
+		userScopes: map[string][]string{
+			"alice-id":  aliceScopes,
+			"reader-id": {models.ScopeStorageRead},
+			"bob-id":    aliceScopes,
+		},
+	}
+	middleware.SetAPIKeyStore(store)
+	t.Cleanup(func() { middleware.SetAPIKeyStore(nil) })
+	return store
+}
+
+// bearerRequest returns a request authenticated with an API key
+func bearerRequest(method string, path string, key string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	return req
+}
+
+// TestGenerateAPIKey tests generating and hashing API keys
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := middleware.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, middleware.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(key, prefix))
+	assert.Less(t, len(prefix), len(key))
+
+	other, _, err := middleware.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	assert.Len(t, middleware.HashAPIKey(key), 64)
+	assert.NotEqual(t, middleware.HashAPIKey(key), middleware.HashAPIKey(other))
+
+	expiresAt, err := middleware.ParseAPIKeyExpiry("24h")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *expiresAt, time.Minute)
+	expiresAt, err = middleware.ParseAPIKeyExpiry("")
+	assert.NoError(t, err)
+	assert.Nil(t, expiresAt)
+	_, err = middleware.ParseAPIKeyExpiry("-1h")
+	assert.Error(t, err)
+}
+
+// TestAPIKeyAuthentication tests authenticating private requests with bearer tokens
+func TestAPIKeyAuthentication(t *testing.T) {
+	store := useFakeAPIKeys(t)
+
+	var seen middleware.Identity
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = middleware.CurrentUser(r)
+	}))
+	serve := func(key string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, bearerRequest("GET", "/private/tools", key))
+		return rr
+	}
+
+	apiKey, key, err := middleware.CreateAPIKey(store, "alice-id", "deploy", aliceScopes, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, apiKey.KeyHash)
+
+	// A valid key authenticates the request as its user and records the use
+	rr := serve(key)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, middleware.Identity{ID: "alice-id", Username: "alice", Scopes: aliceScopes, APIKeyID: apiKey.ID}, seen)
+	assert.NotNil(t, apiKey.LastUsedAt)
+	assert.Empty(t, rr.Result().Cookies())
+
+	// Unknown keys get a 401 JSON response instead of a redirect to the login page
+	rr = serve(middleware.APIKeyPrefix + "unknown")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "invalid_token")
+	assert.Contains(t, rr.Body.String(), `"code":"unauthorized"`)
+
+	// Expired and revoked keys are rejected
+	expired := time.Now().Add(-time.Minute)
+	_, expiredKey, err := middleware.CreateAPIKey(store, "alice-id", "old", aliceScopes, &expired)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, serve(expiredKey).Code)
+
+	require.NoError(t, store.RevokeAPIKey(apiKey.ID))
+	assert.Equal(t, http.StatusUnauthorized, serve(key).Code)
+}
+
+// TestAPIKeyHandlers tests creating, listing and revoking API keys through the private endpoints
+func TestAPIKeyHandlers(t *testing.T) {
+	useFakeUsers(t)
+	store := useFakeAPIKeys(t)
+	cookie := loginCookie(t)
+
+	router := mux.NewRouter()
+	private := router.PathPrefix("/private").Subrouter()
+	private.Use(middleware.AuthMiddleware)
+	private.HandleFunc("/tools", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	private.HandleFunc("/api-keys", handlers.APIKeysHandler).Methods("GET")
+	private.HandleFunc("/api-keys", handlers.CreateAPIKeyHandler).Methods("POST")
+	private.HandleFunc("/api-keys/{key_id}", handlers.RevokeAPIKeyHandler).Methods("DELETE")
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Creating a key returns the key once
+	req := httptest.NewRequest("POST", "/private/api-keys", strings.NewReader(`{"name":"deploy","expires_in":"720h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookie)
+	rr := serve(req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		Data struct {
+			ID        string     `json:"id"`
+			Key       string     `json:"key"`
+			ExpiresAt *time.Time `json:"expires_at"`
+			KeyHash   string     `json:"key_hash"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Data.Key, middleware.APIKeyPrefix))
+	assert.NotNil(t, created.Data.ExpiresAt)
+	assert.Empty(t, created.Data.KeyHash)
+
+	// Invalid requests are rejected
+	req = httptest.NewRequest("POST", "/private/api-keys", strings.NewReader(`{"name":"deploy","expires_in":"soon"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookie)
+	assert.Equal(t, http.StatusBadRequest, serve(req).Code)
+
+	// The key reaches the private endpoints but cannot manage keys
+	assert.Equal(t, http.StatusOK, serve(bearerRequest("GET", "/private/tools", created.Data.Key)).Code)
+	assert.Equal(t, http.StatusForbidden, serve(bearerRequest("GET", "/private/api-keys", created.Data.Key)).Code)
+
+	// The keys of the user are listed without the key itself
+	req = httptest.NewRequest("GET", "/private/api-keys", nil)
+	req.AddCookie(cookie)
+	rr = serve(req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), created.Data.ID)
+	assert.NotContains(t, rr.Body.String(), created.Data.Key)
+
+	// Keys of other users cannot be revoked
+	_, _, err := middleware.CreateAPIKey(store, "bob-id", "other", aliceScopes, nil)
+	require.NoError(t, err)
+	req = httptest.NewRequest("DELETE", "/private/api-keys/key-2", nil)
+	req.AddCookie(cookie)
+	assert.Equal(t, http.StatusNotFound, serve(req).Code)
+
+	// Revoked keys no longer work
+	req = httptest.NewRequest("DELETE", "/private/api-keys/"+created.Data.ID, nil)
+	req.AddCookie(cookie)
+	assert.Equal(t, http.StatusOK, serve(req).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(bearerRequest("GET", "/private/tools", created.Data.Key)).Code)
+}
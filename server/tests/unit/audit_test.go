@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAuditLoginLogout tests that logins and logouts are recorded with the user
+func TestAuditLoginLogout(t *testing.T) {
+	useFakeUsers(t)
+	recorder := useFakeAudit(t)
+
+	cookie := loginCookie(t)
+	req := httptest.NewRequest("GET", "/logout", nil)
+	req.AddCookie(cookie)
+	handlers.LogoutHandler(httptest.NewRecorder(), req)
+
+	require.Equal(t, []string{audit.EventLogin, audit.EventLogout}, recorder.types())
+	for _, event := range recorder.events {
+		assert.Equal(t, "alice-id", event.UserID)
+		assert.Equal(t, "alice", event.Username)
+		assert.Equal(t, audit.OutcomeSuccess, event.Outcome)
+		assert.False(t, event.Time.IsZero())
+	}
+}
+
+// TestAuditRecordEvent tests the defaults of events recorded for a request
+func TestAuditRecordEvent(t *testing.T) {
+	recorder := useFakeAudit(t)
+
+	req := httptest.NewRequest("POST", "/private/tools/text-storage", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req = req.WithContext(middleware.WithIdentity(req.Context(), middleware.Identity{ID: "alice-id", Username: "alice"}))
+	middleware.RecordEvent(req, audit.Event{Type: audit.EventTextStored, TargetID: "text-id"})
+	middleware.RecordEvent(req, audit.Event{Type: audit.EventLoginFailed, Username: "mallory"})
+	middleware.RecordEvent(req, audit.Event{Type: audit.EventLockout, Username: "mallory"})
+
+	require.Len(t, recorder.events, 3)
+	assert.Equal(t, audit.OutcomeSuccess, recorder.events[0].Outcome)
+	assert.Equal(t, "alice-id", recorder.events[0].UserID)
+	assert.Equal(t, "text-id", recorder.events[0].TargetID)
+	assert.Equal(t, "192.0.2.1", recorder.events[0].IPAddress)
+
+	// Events that name a user keep it
+	assert.Equal(t, audit.OutcomeFailure, recorder.events[1].Outcome)
+	assert.Equal(t, "mallory", recorder.events[1].Username)
+	assert.Empty(t, recorder.events[1].UserID)
+	assert.Equal(t, audit.OutcomeDenied, recorder.events[2].Outcome)
+}
+
+// TestAuditEventsHandler tests listing audit events with filters
+func TestAuditEventsHandler(t *testing.T) {
+	recorder := useFakeAudit(t)
+	audit.Record(audit.Event{Type: audit.EventLogin, UserID: "alice-id"})
+	audit.Record(audit.Event{Type: audit.EventCleanup, UserID: "alice-id"})
+
+	rr := httptest.NewRecorder()
+	handlers.AuditEventsHandler(rr, httptest.NewRequest("GET", "/private/audit-events?type=login&outcome=success&since=2026-01-01T00:00:00Z&limit=10&before_id=50", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Success bool          `json:"success"`
+		Data    []audit.Event `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.True(t, response.Success)
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, audit.EventLogin, response.Data[0].Type)
+	assert.Equal(t, audit.OutcomeSuccess, recorder.filter.Outcome)
+	assert.Equal(t, 10, recorder.filter.Limit)
+	assert.Equal(t, int64(50), recorder.filter.BeforeID)
+	assert.Equal(t, 2026, recorder.filter.Since.Year())
+
+	for _, query := range []string{"limit=0", "limit=5000", "since=yesterday", "outcome=maybe", "before_id=x"} {
+		rr := httptest.NewRecorder()
+		handlers.AuditEventsHandler(rr, httptest.NewRequest("GET", "/private/audit-events?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	// Events written only to the server log cannot be listed
+	audit.SetRecorder(nil)
+	rr = httptest.NewRecorder()
+	handlers.AuditEventsHandler(rr, httptest.NewRequest("GET", "/private/audit-events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// Database errors are logged but not sent to the client
+	audit.SetRecorder(failingAuditRecorder{})
+	rr = httptest.NewRecorder()
+	handlers.AuditEventsHandler(rr, httptest.NewRequest("GET", "/private/audit-events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"error":"error listing audit events"`)
+	assert.Contains(t, rr.Body.String(), `"code":"unavailable"`)
+	assert.NotContains(t, rr.Body.String(), "audit_events")
+}
+
+// failingAuditRecorder is an audit recorder whose database fails
+type failingAuditRecorder struct{}
+
+// RecordEvent fails to record an audit event
+func (failingAuditRecorder) RecordEvent(event audit.Event) error {
+	return errors.New(`pq: relation "audit_events" does not exist`)
+}
+
+// ListEvents fails to list audit events
+func (failingAuditRecorder) ListEvents(filter audit.Filter) ([]audit.Event, error) {
+	return nil, errors.New(`pq: relation "audit_events" does not exist`)
+}
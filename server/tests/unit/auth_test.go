@@ -0,0 +1,373 @@
+package unit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeUserStore is an in-memory UserStore keyed by username
+type fakeUserStore map[string]*database.User
+
+// GetUserByUsername returns the user with the given username
+func (s fakeUserStore) GetUserByUsername(username string) (*database.User, error) {
+	if username == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	user, ok := s[username]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return user, nil
+}
+
+// UpdatePasswordHash replaces the password hash of the user with the given ID
+func (s fakeUserStore) UpdatePasswordHash(id string, passwordHash string) error {
+	for _, user := range s {
+		if user.ID == id {
+			user.PasswordHash = passwordHash
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+// CreateUser adds a user with the given username
+func (s fakeUserStore) CreateUser(username string, passwordHash string, scopes []string) (string, error) {
+	id := username + "-id"
+	s[username] = &database.User{ID: id, Username: username, PasswordHash: passwordHash, Scopes: scopes}
+	return id, nil
+}
+
+// UpdateScopes replaces the scopes of the user with the given ID
+func (s fakeUserStore) UpdateScopes(id string, scopes []string) error {
+	for _, user := range s {
+		if user.ID == id {
+			user.Scopes = scopes
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+// aliceScopes are the scopes of the user alice
+var aliceScopes = []string{models.ScopeStorageRead, models.ScopeStorageWrite}
+
+// useFakeUsers installs a user store and a fresh login throttle for the duration of a test
+// The users alice, with the storage scopes, and reader, with only storage:read, both have the password "secret"
+func useFakeUsers(t *testing.T) fakeUserStore {
+	passwordHash, err := middleware.HashPassword("secret")
+	require.NoError(t, err)
+	store := fakeUserStore{
+		"alice":  {ID: "alice-id", Username: "alice", PasswordHash: passwordHash, Scopes: aliceScopes},
+		"reader": {ID: "reader-id", Username: "reader", PasswordHash: passwordHash, Scopes: []string{models.ScopeStorageRead}},
+	}
+	middleware.SetUserStore(store)
+	middleware.ConfigureLoginThrottle(middleware.DefaultThrottleConfig)
+	t.Cleanup(func() {
+		middleware.SetUserStore(nil)
+		middleware.ConfigureLoginThrottle(middleware.DefaultThrottleConfig)
+	})
+	return store
+}
+
+// loginCookie logs in as alice and returns the authentication cookie
+func loginCookie(t *testing.T) *http.Cookie {
+	return loginCookieFor(t, "alice")
+}
+
+// loginCookieFor logs in as a user of useFakeUsers and returns the authentication cookie
+func loginCookieFor(t *testing.T, username string) *http.Cookie {
+	form := url.Values{"username": {username}, "password": {"secret"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handlers.LoginHandler(rr, req)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == middleware.CookieName {
+			return cookie
+		}
+	}
+	t.Fatal("login did not set the authentication cookie")
+	return nil
+}
+
+// TestAuthenticate tests checking usernames and passwords against the user store
+func TestAuthenticate(t *testing.T) {
+	useFakeUsers(t)
+
+	identity, err := middleware.Authenticate("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, middleware.Identity{ID: "alice-id", Username: "alice", Scopes: aliceScopes}, identity)
+
+	for _, tc := range []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"bob", "secret"},
+		{"alice", ""},
+		{"", "secret"},
+	} {
+		_, err := middleware.Authenticate(tc.username, tc.password)
+		assert.ErrorIs(t, err, middleware.ErrInvalidCredentials, "%s/%s", tc.username, tc.password)
+	}
+
+	// Store errors are not reported as invalid credentials
+	_, err = middleware.Authenticate("broken", "secret")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, middleware.ErrInvalidCredentials)
+}
+
+// TestAuthenticateUnknownUser tests that unknown users take about as long to reject as wrong passwords
+func TestAuthenticateUnknownUser(t *testing.T) {
+	useFakeUsers(t)
+	elapsed := func(username string) time.Duration {
+		start := time.Now()
+		_, err := middleware.Authenticate(username, "wrong")
+		require.ErrorIs(t, err, middleware.ErrInvalidCredentials)
+		return time.Since(start)
+	}
+
+	// Without a password hash to verify, unknown users would be rejected many times faster
+	wrongPassword := elapsed("alice")
+	assert.Greater(t, elapsed("nobody"), wrongPassword/4)
+}
+
+// TestHashPassword tests hashing passwords with argon2id
+func TestHashPassword(t *testing.T) {
+	hash, err := middleware.HashPassword("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"), hash)
+	assert.True(t, middleware.VerifyPassword("secret", hash))
+	assert.False(t, middleware.VerifyPassword("Secret", hash))
+	assert.False(t, middleware.NeedsRehash(hash))
+
+	// Every hash has its own salt
+	other, err := middleware.HashPassword("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	// Hashes with other parameters still verify but are upgraded
+	weaker := "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$Z1R7iYtJLQ7hjYEzMMPtuYl5uOM3IXlN6F1mVMXWqRM"
+	assert.True(t, middleware.NeedsRehash(weaker))
+}
+
+// TestVerifyPasswordFormats tests verifying bcrypt, legacy SHA-256 and malformed hashes
+func TestVerifyPasswordFormats(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("secret"))
+	legacyHash := hex.EncodeToString(sum[:])
+
+	for name, hash := range map[string]string{"bcrypt": string(bcryptHash), "legacy": legacyHash} {
+		assert.True(t, middleware.VerifyPassword("secret", hash), name)
+		assert.False(t, middleware.VerifyPassword("wrong", hash), name)
+		assert.True(t, middleware.NeedsRehash(hash), name)
+	}
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=65536,t=3,p=2$bm90LWJhc2U2NA",
+		"$argon2id$v=18$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$***",
+	} {
+		assert.False(t, middleware.VerifyPassword("secret", hash), hash)
+	}
+}
+
+// TestAuthenticateUpgradesLegacyHash tests that logging in replaces a legacy hash with an argon2id hash
+func TestAuthenticateUpgradesLegacyHash(t *testing.T) {
+	store := useFakeUsers(t)
+	sum := sha256.Sum256([]byte("secret"))
+	store["alice"].PasswordHash = hex.EncodeToString(sum[:])
+
+	// A wrong password leaves the hash alone
+	_, err := middleware.Authenticate("alice", "wrong")
+	assert.ErrorIs(t, err, middleware.ErrInvalidCredentials)
+	assert.Equal(t, hex.EncodeToString(sum[:]), store["alice"].PasswordHash)
+
+	_, err = middleware.Authenticate("alice", "secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(store["alice"].PasswordHash, "$argon2id$"))
+
+	// The new hash is used from now on
+	_, err = middleware.Authenticate("alice", "secret")
+	assert.NoError(t, err)
+}
+
+// TestLoginHandler tests logging in with a username and password
+func TestLoginHandler(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	useFakeUsers(t)
+
+	// A successful login sets a cookie carrying the user
+	cookie := loginCookie(t)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	identity, ok := middleware.CurrentUser(req)
+	assert.True(t, ok)
+	assert.Equal(t, middleware.Identity{ID: "alice-id", Username: "alice", Scopes: aliceScopes}, identity)
+
+	// The home page shows who is logged in
+	rr := httptest.NewRecorder()
+	handlers.HomeHandler(rr, req)
+	assert.Contains(t, rr.Body.String(), "You are logged in as alice!")
+
+	// A wrong password shows the login page again with the username filled in
+	req = httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"alice","password":"wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handlers.LoginHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid username or password")
+	assert.Contains(t, rr.Body.String(), `value="alice"`)
+	assert.Empty(t, rr.Result().Cookies())
+}
+
+// TestAuthMiddlewareIdentity tests that AuthMiddleware adds the authenticated user to the request context
+func TestAuthMiddlewareIdentity(t *testing.T) {
+	useFakeUsers(t)
+
+	var seen middleware.Identity
+	var body string
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = middleware.IdentityFromContext(r.Context())
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+	}))
+	alice := middleware.Identity{ID: "alice-id", Username: "alice", Scopes: aliceScopes}
+
+	// Session cookie
+	req := httptest.NewRequest("GET", "/private/tools", nil)
+	req.AddCookie(loginCookie(t))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, alice, seen)
+
+	// Credentials in a JSON body, which is still readable by the handler
+	seen = middleware.Identity{}
+	payload := `{"username":"alice","password":"secret","content":"hello"}`
+	req = httptest.NewRequest("POST", "/private/tools/text-storage", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, alice, seen)
+	assert.Equal(t, payload, body)
+	assert.NotEmpty(t, rr.Result().Cookies())
+
+	// Wrong credentials are rejected, JSON clients get a JSON error rather than the login page
+	req = httptest.NewRequest("POST", "/private/tools/text-storage", strings.NewReader(`{"username":"alice","password":"wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.JSONEq(t, `{"success":false,"error":"authentication required","code":"unauthorized"}`, rr.Body.String())
+
+	// Credentials in the query string are ignored, so they do not end up in URLs and logs
+	req = httptest.NewRequest("GET", "/private/tools?username=alice&password=secret", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+}
+
+// TestTrackIdentity tests that middleware in front of AuthMiddleware sees the authenticated user
+func TestTrackIdentity(t *testing.T) {
+	useFakeUsers(t)
+
+	req := httptest.NewRequest("POST", "/private/tools/text-storage", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	ctx, authenticatedUser := middleware.TrackIdentity(req.Context())
+	_, ok := authenticatedUser()
+	assert.False(t, ok)
+
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+	identity, ok := authenticatedUser()
+	assert.True(t, ok)
+	assert.Equal(t, "alice-id", identity.ID)
+}
+
+// TestSafeRedirect tests validating the page to return to after logging in
+func TestSafeRedirect(t *testing.T) {
+	tests := map[string]string{
+		"/private/tools/text-retrieval?id=abc": "/private/tools/text-retrieval?id=abc",
+		"/docs#tools":                          "/docs#tools",
+		"":                                     "/",
+		"private/tools":                        "/",
+		"https://example.com/":                 "/",
+		"//example.com/":                       "/",
+		"/\\example.com/":                      "/",
+		"/%0d%0aSet-Cookie:x":                  "/%0d%0aSet-Cookie:x",
+		"/\r\nSet-Cookie:x":                    "/",
+		"javascript:alert(1)":                  "/",
+		"/login?next=/private/tools":           "/",
+		"/login/totp":                          "/",
+		"/logout":                              "/",
+	}
+	for next, expected := range tests {
+		assert.Equal(t, expected, middleware.SafeRedirect(next), next)
+	}
+}
+
+// TestLoginRedirect tests returning to the requested page after logging in
+func TestLoginRedirect(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	useFakeUsers(t)
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Browsers are sent to the login page, which remembers the requested page
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/private/tools/text-retrieval?id=abc", nil))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	location := rr.Header().Get("Location")
+	assert.Equal(t, "/login?next=%2Fprivate%2Ftools%2Ftext-retrieval%3Fid%3Dabc", location)
+
+	// JSON clients get a JSON error instead
+	req := httptest.NewRequest("GET", "/private/tools/text-retrieval?id=abc", nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	// The login form carries the page, and logging in returns to it
+	rr = httptest.NewRecorder()
+	handlers.LoginHandler(rr, httptest.NewRequest("GET", location, nil))
+	assert.Contains(t, rr.Body.String(), `<input type="hidden" name="next" value="/private/tools/text-retrieval?id=abc">`)
+
+	login := func(next string) string {
+		form := url.Values{"username": {"alice"}, "password": {"secret"}, "next": {next}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handlers.LoginHandler(rr, req)
+		require.Equal(t, http.StatusSeeOther, rr.Code)
+		return rr.Header().Get("Location")
+	}
+	assert.Equal(t, "/private/tools/text-retrieval?id=abc", login("/private/tools/text-retrieval?id=abc"))
+
+	// Other sites are not followed
+	assert.Equal(t, "/", login("https://example.com/phish"))
+	assert.Equal(t, "/", login("//example.com/phish"))
+}
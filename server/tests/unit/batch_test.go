@@ -0,0 +1,163 @@
+// Package unit contains unit tests for the AllMiTools server
+package unit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchTestResponse mirrors the batch response with typed item results
+type batchTestResponse struct {
+	Success bool                 `json:"success"`
+	Error   string               `json:"error"`
+	Code    tools.ErrorCode      `json:"code"`
+	Data    handlers.BatchResult `json:"data"`
+}
+
+func init() {
+	models.RegisterTool(panickingTool{})
+}
+
+// panickingTool is a public tool that panics when executed
+type panickingTool struct{}
+
+// Info returns the tool info for the panicking tool
+func (panickingTool) Info() models.ToolInfo {
+	return models.ToolInfo{
+		Name:        "panicking-test-tool",
+		Description: "Panics when executed",
+		Version:     "1.0.0",
+		Author:      "AllMiTools Team",
+	}
+}
+
+// Execute panics
+func (panickingTool) Execute(r *http.Request) (any, error) {
+	panic("tool failed")
+}
+
+// runBatch posts a batch body for a tool to a batch handler with the given limits
+func runBatch(t *testing.T, config handlers.BatchConfig, toolName, body string) (*httptest.ResponseRecorder, batchTestResponse) {
+	r := mux.NewRouter()
+	r.HandleFunc("/tools/{tool_name}/batch", handlers.BatchHandler(config)).Methods("POST")
+
+	req := httptest.NewRequest("POST", "/tools/"+toolName+"/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var response batchTestResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return rr, response
+}
+
+// TestBatchHandler tests running a tool for many parameter sets
+func TestBatchHandler(t *testing.T) {
+	inputs := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+	items := make([]map[string]string, len(inputs))
+	for i, input := range inputs {
+		items[i] = map[string]string{"text": input}
+	}
+	body, err := json.Marshal(map[string]interface{}{"items": items})
+	require.NoError(t, err)
+
+	rr, response := runBatch(t, handlers.BatchConfig{Concurrency: 2}, "sha256-hasher", string(body))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, response.Success)
+	assert.Equal(t, len(inputs), response.Data.Total)
+	assert.Equal(t, len(inputs), response.Data.Succeeded)
+	require.Len(t, response.Data.Items, len(inputs))
+
+	// Results keep the order of the items
+	for i, input := range inputs {
+		hash := sha256.Sum256([]byte(input))
+		assert.Equal(t, i, response.Data.Items[i].Index)
+		assert.True(t, response.Data.Items[i].Success)
+		assert.Equal(t, hex.EncodeToString(hash[:]), response.Data.Items[i].Result.(map[string]interface{})["hash"])
+	}
+}
+
+// TestBatchHandlerItemErrors tests that failing items do not fail the whole batch
+func TestBatchHandlerItemErrors(t *testing.T) {
+	rr, response := runBatch(t, handlers.BatchConfig{}, "random-number",
+		`{"items": [{"min": 1, "max": 5}, {"min": "one"}, {"min": 10, "max": 1}, {"min": -9223372036854775808, "max": 9223372036854775807}]}`)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 4, response.Data.Total)
+	assert.Equal(t, 1, response.Data.Succeeded)
+	assert.Equal(t, 3, response.Data.Failed)
+
+	assert.True(t, response.Data.Items[0].Success)
+	assert.False(t, response.Data.Items[1].Success)
+	require.Len(t, response.Data.Items[1].Errors, 1)
+	assert.Equal(t, "min", response.Data.Items[1].Errors[0].Field)
+	assert.False(t, response.Data.Items[2].Success)
+	assert.Equal(t, "minimum value cannot be greater than maximum value", response.Data.Items[2].Error)
+	assert.Equal(t, tools.CodeValidation, response.Data.Items[2].Code)
+	assert.Equal(t, []tools.FieldError{{Field: "min", Message: "cannot be greater than max"}}, response.Data.Items[2].Errors)
+
+	// Bounds on min and max keep the size of the range within an int
+	assert.False(t, response.Data.Items[3].Success)
+	assert.Equal(t, tools.CodeValidation, response.Data.Items[3].Code)
+	assert.Equal(t, []tools.FieldError{
+		{Field: "min", Message: "must be at least -1000000000"},
+		{Field: "max", Message: "must be at most 1000000000"},
+	}, response.Data.Items[3].Errors)
+}
+
+// TestBatchHandlerItemPanics tests that a panicking item fails on its own without stopping the server
+func TestBatchHandlerItemPanics(t *testing.T) {
+	rr, response := runBatch(t, handlers.BatchConfig{}, "panicking-test-tool", `{"items": [{}, {}]}`)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2, response.Data.Total)
+	assert.Equal(t, 2, response.Data.Failed)
+	for i, item := range response.Data.Items {
+		assert.Equal(t, i, item.Index)
+		assert.False(t, item.Success)
+		assert.Equal(t, "tool failed", item.Error)
+		assert.Equal(t, tools.CodeInternal, item.Code)
+	}
+}
+
+// TestBatchHandlerRejected tests batches that are rejected before running
+func TestBatchHandlerRejected(t *testing.T) {
+	testCases := []struct {
+		name           string
+		toolName       string
+		body           string
+		expectedStatus int
+		expectedCode   tools.ErrorCode
+		expectedError  string
+	}{
+		{"Unknown tool", "nonexistent", `{"items": [{}]}`, http.StatusNotFound, tools.CodeNotFound, "Tool not found: nonexistent"},
+		{"Private tool", "text-storage", `{"items": [{"content": "x"}]}`, http.StatusNotFound, tools.CodeNotFound, "Tool not found: text-storage"},
+		{"Invalid JSON", "sha256-hasher", `{"items": {}}`, http.StatusBadRequest, tools.CodeBadRequest, "error parsing batch"},
+		{"No items", "sha256-hasher", `{"items": []}`, http.StatusBadRequest, tools.CodeBadRequest, "batch has no items"},
+		{"Too many items", "sha256-hasher", `{"items": [{"text": "a"}, {"text": "b"}, {"text": "c"}]}`, http.StatusRequestEntityTooLarge, tools.CodeRequestTooLarge, "batch has 3 items, the maximum is 2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, response := runBatch(t, handlers.BatchConfig{MaxSize: 2}, tc.toolName, tc.body)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.False(t, response.Success)
+			assert.Contains(t, response.Error, tc.expectedError)
+			assert.Equal(t, tc.expectedCode, response.Code)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// csrfBrowser gets a CSRF cookie and a token of it, like a browser loading a page with a form
+func csrfBrowser(t *testing.T) (*http.Cookie, string) {
+	var token string
+	handler := middleware.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = middleware.CSRFToken(r)
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/login", nil))
+	require.NotEmpty(t, token)
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == middleware.CSRFCookieName {
+			return cookie, token
+		}
+	}
+	t.Fatal("CSRF middleware did not set the CSRF cookie")
+	return nil, ""
+}
+
+// TestCSRFMiddleware tests which requests need a CSRF token
+func TestCSRFMiddleware(t *testing.T) {
+	handler := middleware.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	csrfCookie, token := csrfBrowser(t)
+	_, otherToken := csrfBrowser(t)
+	authCookie := &http.Cookie{Name: middleware.CookieName, Value: "session"}
+	login := url.Values{"username": {"alice"}, "password": {"secret"}}
+
+	serve := func(method, contentType, body string, header http.Header, cookies ...*http.Cookie) int {
+		req := httptest.NewRequest(method, "/private/tools/save_text", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for name, values := range header {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	form := "application/x-www-form-urlencoded"
+
+	// Reading never needs a token
+	assert.Equal(t, http.StatusOK, serve("GET", "", "", nil, authCookie))
+
+	// Posts with the session of the browser and login forms need a token
+	assert.Equal(t, http.StatusForbidden, serve("POST", form, "text=hello", nil, authCookie, csrfCookie))
+	assert.Equal(t, http.StatusForbidden, serve("POST", "application/json", `{"text":"hello"}`, nil, authCookie, csrfCookie))
+	assert.Equal(t, http.StatusForbidden, serve("POST", form, login.Encode(), nil))
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "", "", nil, authCookie, csrfCookie))
+
+	// A token of the browser's secret is accepted in the form or the header
+	withToken := url.Values{"text": {"hello"}, middleware.CSRFFieldName: {token}}
+	assert.Equal(t, http.StatusOK, serve("POST", form, withToken.Encode(), nil, authCookie, csrfCookie))
+	assert.Equal(t, http.StatusOK, serve("DELETE", "", "", http.Header{middleware.CSRFHeaderName: {token}}, authCookie, csrfCookie))
+
+	// A token of another browser is not
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "", "", http.Header{middleware.CSRFHeaderName: {otherToken}}, authCookie, csrfCookie))
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "", "", http.Header{middleware.CSRFHeaderName: {"bogus"}}, authCookie, csrfCookie))
+
+	// API clients are exempt
+	bearer := http.Header{"Authorization": {"Bearer amt_key"}}
+	assert.Equal(t, http.StatusOK, serve("POST", form, "text=hello", bearer, authCookie))
+	assert.Equal(t, http.StatusOK, serve("POST", "application/json", `{"username":"alice","password":"secret"}`, nil))
+	assert.Equal(t, http.StatusOK, serve("POST", form, "text=hello", nil))
+}
+
+// TestCSRFFormFields tests adding the CSRF token to the forms of rendered pages
+func TestCSRFFormFields(t *testing.T) {
+	defer templates.Initialize("../../")
+	templates.SetFormFields(middleware.CSRFFormFields)
+	t.Cleanup(func() { templates.SetFormFields(nil) })
+
+	dir := copyTemplates(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra.html"), []byte(`{{ define "content" }}`+
+		`<form method="post" action="/tools/save_text"></form>`+
+		`<form method="POST"></form>`+
+		`<form method="post" action="https://example.com/collect"></form>`+
+		`<form method="post" action="//example.com/collect"></form>`+
+		`<form method="get" action="/docs"></form>{{ end }}`), 0644))
+	require.NoError(t, templates.Setup(templates.Config{Dir: dir}))
+
+	csrfCookie, _ := csrfBrowser(t)
+	req := httptest.NewRequest("GET", "/extra", nil)
+	req.AddCookie(csrfCookie)
+	rr := httptest.NewRecorder()
+	require.NoError(t, templates.TemplateManager.RenderTemplate(rr, req, "extra", map[string]interface{}{"Title": "Extra"}))
+
+	// Only the POST forms posting to this server get the token
+	page := rr.Body.String()
+	assert.Equal(t, 2, strings.Count(page, `name="`+middleware.CSRFFieldName+`"`))
+	assert.Contains(t, page, `<form method="post" action="/tools/save_text"><input type="hidden" name="csrf_token" value="`)
+	assert.Contains(t, page, `<form method="POST"><input type="hidden" name="csrf_token" value="`)
+	assert.Contains(t, page, `<form method="post" action="https://example.com/collect"></form>`)
+	assert.Contains(t, page, `<form method="post" action="//example.com/collect"></form>`)
+
+	// The login page posts the token back through the middleware
+	useFakeUsers(t)
+	handler := middleware.CSRFMiddleware(http.HandlerFunc(handlers.LoginHandler))
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/login", nil)
+	req.AddCookie(csrfCookie)
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := hiddenCSRFToken(t, rr.Body.String())
+
+	form := url.Values{"username": {"alice"}, "password": {"secret"}, middleware.CSRFFieldName: {token}}
+	req = httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(csrfCookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+}
+
+// hiddenCSRFToken returns the CSRF token of the first form of a page
+func hiddenCSRFToken(t *testing.T, page string) string {
+	marker := `name="` + middleware.CSRFFieldName + `" value="`
+	start := strings.Index(page, marker)
+	require.NotEqual(t, -1, start, "page has no CSRF token")
+	start += len(marker)
+	end := strings.Index(page[start:], `"`)
+	require.NotEqual(t, -1, end)
+	return page[start : start+end]
+}
+
+// TestCSRFCookieOnlyWithToken tests that the CSRF cookie is only set on responses that render a token
+func TestCSRFCookieOnlyWithToken(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	templates.SetFormFields(middleware.CSRFFormFields)
+	t.Cleanup(func() { templates.SetFormFields(nil) })
+	csrfCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == middleware.CSRFCookieName {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// JSON responses, API calls and pages without forms get no cookie
+	for _, handler := range []http.HandlerFunc{
+		handlers.OpenAPIHandler,
+		handlers.NotFoundHandler,
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+	} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/openapi.json", nil)
+		req.Header.Set("Authorization", "Bearer amt_key")
+		middleware.CSRFMiddleware(handler).ServeHTTP(rr, req)
+		assert.Nil(t, csrfCookie(rr))
+	}
+
+	// A page with a form sets the cookie, even after its status was written
+	handler := middleware.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(middleware.CSRFToken(r)))
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/login", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.NotNil(t, csrfCookie(rr))
+
+	// The login page of a new browser sets a cookie that matches its token
+	useFakeUsers(t)
+	login := middleware.CSRFMiddleware(http.HandlerFunc(handlers.LoginHandler))
+	rr = httptest.NewRecorder()
+	login.ServeHTTP(rr, httptest.NewRequest("GET", "/login", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	cookie := csrfCookie(rr)
+	require.NotNil(t, cookie)
+
+	form := url.Values{"username": {"alice"}, "password": {"secret"}, middleware.CSRFFieldName: {hiddenCSRFToken(t, rr.Body.String())}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	login.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Nil(t, csrfCookie(rr))
+}
@@ -2,13 +2,20 @@ package unit
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/audit"
 	"github.com/CJFEdu/allmitools/server/internal/database"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockDB is a mock implementation of sql.DB for testing
@@ -23,7 +30,7 @@ type MockDBManager struct {
 
 // ExecWithRetry mocks the ExecWithRetry method
 func (m *MockDBManager) ExecWithRetry(query string, args ...interface{}) (sql.Result, error) {
-	mockArgs := []interface{}{query}
+	mockArgs := []interface{}{normalizeQuery(query)}
 	for _, arg := range args {
 		mockArgs = append(mockArgs, arg)
 	}
@@ -33,7 +40,7 @@ func (m *MockDBManager) ExecWithRetry(query string, args ...interface{}) (sql.Re
 
 // QueryWithRetry mocks the QueryWithRetry method
 func (m *MockDBManager) QueryWithRetry(query string, args ...interface{}) (*sql.Rows, error) {
-	mockArgs := []interface{}{query}
+	mockArgs := []interface{}{normalizeQuery(query)}
 	for _, arg := range args {
 		mockArgs = append(mockArgs, arg)
 	}
@@ -43,7 +50,7 @@ func (m *MockDBManager) QueryWithRetry(query string, args ...interface{}) (*sql.
 
 // QueryRowWithRetry mocks the QueryRowWithRetry method
 func (m *MockDBManager) QueryRowWithRetry(query string, args ...interface{}) *sql.Row {
-	mockArgs := []interface{}{query}
+	mockArgs := []interface{}{normalizeQuery(query)}
 	for _, arg := range args {
 		mockArgs = append(mockArgs, arg)
 	}
@@ -69,6 +76,114 @@ func (m *MockDBManager) Close() error {
 	return args.Error(0)
 }
 
+// normalizeQuery collapses the whitespace of a query so expectations can be written on one line
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// fakeRowSets holds the rows returned by the fake driver, keyed by data source name
+var (
+	fakeRowSets   sync.Map
+	fakeRowSetSeq int
+	fakeRowMutex  sync.Mutex
+)
+
+func init() {
+	sql.Register("unit-fake", fakeDriver{})
+}
+
+// fakeRowSet is a canned query result
+type fakeRowSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeRow returns a *sql.Row that scans the given row, or fails with sql.ErrNoRows when the row is nil
+func fakeRow(t *testing.T, columns []string, row []driver.Value) *sql.Row {
+	set := fakeRowSet{columns: columns}
+	if row != nil {
+		set.rows = [][]driver.Value{row}
+	}
+	return fakeDB(t, set).QueryRow("SELECT")
+}
+
+// fakeResultRows returns *sql.Rows that iterate over the given rows
+func fakeResultRows(t *testing.T, columns []string, rows ...[]driver.Value) *sql.Rows {
+	result, err := fakeDB(t, fakeRowSet{columns: columns, rows: rows}).Query("SELECT")
+	if err != nil {
+		t.Fatalf("failed to query fake database: %v", err)
+	}
+	t.Cleanup(func() { result.Close() })
+	return result
+}
+
+// fakeDB opens a database of the fake driver whose queries return a row set
+func fakeDB(t *testing.T, set fakeRowSet) *sql.DB {
+	fakeRowMutex.Lock()
+	fakeRowSetSeq++
+	name := fmt.Sprintf("rows-%d", fakeRowSetSeq)
+	fakeRowMutex.Unlock()
+	fakeRowSets.Store(name, set)
+
+	db, err := sql.Open("unit-fake", name)
+	if err != nil {
+		t.Fatalf("failed to open fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// fakeDriver is a database/sql driver whose queries return a canned row set
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	set, ok := fakeRowSets.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake row set %s", name)
+	}
+	return &fakeConn{set: set.(fakeRowSet)}, nil
+}
+
+// fakeConn is a connection of the fake driver
+type fakeConn struct {
+	set fakeRowSet
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{set: c.set}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("transactions are not supported") }
+
+// fakeStmt is a statement of the fake driver
+type fakeStmt struct {
+	set fakeRowSet
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("exec is not supported")
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{set: s.set}, nil
+}
+
+// fakeRows iterates over a canned row set
+type fakeRows struct {
+	set  fakeRowSet
+	next int
+}
+
+func (r *fakeRows) Columns() []string { return r.set.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.set.rows) {
+		return io.EOF
+	}
+	copy(dest, r.set.rows[r.next])
+	r.next++
+	return nil
+}
+
 // MockResult is a mock implementation of sql.Result for testing
 type MockResult struct {
 	mock.Mock
@@ -123,28 +238,32 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	assert.Equal(t, "require", config.SSLMode)
 }
 
+// textEntryColumns are the columns selected for a text entry
+var textEntryColumns = []string{"id", "content", "save_flag", "created_at", "created_by", "expires_at", "version", "updated_at"}
+
 // TestTextStorageDAO_StoreText tests storing text in the database
 func TestTextStorageDAO_StoreText(t *testing.T) {
 	// Create a mock database manager
 	mockDBManager := new(MockDBManager)
 	
 	// Create a mock row
-	mockRow := &sql.Row{}
+	mockRow := fakeRow(t, []string{"id"}, []driver.Value{"generated-id"})
 	
 	// Set up expectations
 	mockDBManager.On("QueryRowWithRetry", 
-		"INSERT INTO text_storage (id, content, save_flag, created_at) VALUES ($1, $2, $3, NOW()) RETURNING id",
-		mock.AnythingOfType("string"), "test content", true).Return(mockRow)
+		"INSERT INTO text_storage (id, content, save_flag, created_at, created_by, expires_at) VALUES ($1, $2, $3, NOW(), $4, $5) RETURNING id",
+		mock.AnythingOfType("string"), "test content", true, sql.NullString{String: "user-id", Valid: true}, sql.NullTime{}).Return(mockRow)
 	
 	// Create a DAO with the mock manager
 	dao := database.NewTextStorageDAO(mockDBManager)
 	
 	// Call the method under test
-	_, err := dao.StoreText("test content", true)
+	id, err := dao.StoreText("test content", true, "user-id", nil)
 	
 	// Verify expectations
 	mockDBManager.AssertExpectations(t)
 	assert.NoError(t, err)
+	assert.Equal(t, "generated-id", id)
 }
 
 // TestTextStorageDAO_GetTextByID tests retrieving text by ID
@@ -152,12 +271,12 @@ func TestTextStorageDAO_GetTextByID(t *testing.T) {
 	// Create a mock database manager
 	mockDBManager := new(MockDBManager)
 	
-	// Create a mock row
-	mockRow := &sql.Row{}
+	// Create a mock row without results
+	mockRow := fakeRow(t, textEntryColumns, nil)
 	
 	// Set up expectations
 	mockDBManager.On("QueryRowWithRetry", 
-		"SELECT id, content, save_flag, created_at FROM text_storage WHERE id = $1",
+		"SELECT id, content, save_flag, created_at, created_by, expires_at, version, updated_at FROM text_storage WHERE id = $1",
 		"test-id").Return(mockRow)
 	
 	// Create a DAO with the mock manager
@@ -168,7 +287,148 @@ func TestTextStorageDAO_GetTextByID(t *testing.T) {
 	
 	// Verify expectations
 	mockDBManager.AssertExpectations(t)
-	assert.Error(t, err) // Error expected because we can't mock the Scan method easily
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	assert.EqualError(t, err, "text entry with ID test-id not found")
+}
+
+// TestTextStorageDAO_GetTextByIDFound tests retrieving an existing text entry
+func TestTextStorageDAO_GetTextByIDFound(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := createdAt.Add(time.Hour)
+	query := "SELECT id, content, save_flag, created_at, created_by, expires_at, version, updated_at FROM text_storage WHERE id = $1"
+	mockDBManager.On("QueryRowWithRetry", query, "test-id").
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"test-id", "hello", true, createdAt, "user-id", nil, int64(1), nil}))
+	mockDBManager.On("QueryRowWithRetry", query, "expiring-id").
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"expiring-id", "hello", false, createdAt, nil, expiresAt, int64(1), nil}))
+
+	dao := database.NewTextStorageDAO(mockDBManager)
+	entry, err := dao.GetTextByID("test-id")
+	assert.NoError(t, err)
+	assert.Equal(t, &database.TextEntry{ID: "test-id", Content: "hello", SaveFlag: true, CreatedAt: createdAt, CreatedBy: "user-id", Version: 1}, entry)
+	assert.False(t, entry.Expired(expiresAt))
+
+	entry, err = dao.GetTextByID("expiring-id")
+	assert.NoError(t, err)
+	assert.Equal(t, &expiresAt, entry.ExpiresAt)
+	assert.False(t, entry.Expired(createdAt))
+	assert.True(t, entry.Expired(expiresAt))
+	mockDBManager.AssertExpectations(t)
+}
+
+// TestTextStorageDAO_StoreTextAnonymous tests that text stored without a user has a NULL creator
+func TestTextStorageDAO_StoreTextAnonymous(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	expiresAt := time.Date(2025, 1, 9, 3, 4, 5, 0, time.UTC)
+	mockRow := fakeRow(t, []string{"id"}, []driver.Value{"generated-id"})
+	mockDBManager.On("QueryRowWithRetry",
+		"INSERT INTO text_storage (id, content, save_flag, created_at, created_by, expires_at) VALUES ($1, $2, $3, NOW(), $4, $5) RETURNING id",
+		mock.AnythingOfType("string"), "test content", false, sql.NullString{}, sql.NullTime{Time: expiresAt, Valid: true}).Return(mockRow)
+
+	dao := database.NewTextStorageDAO(mockDBManager)
+	_, err := dao.StoreText("test content", false, "", &expiresAt)
+
+	mockDBManager.AssertExpectations(t)
+	assert.NoError(t, err)
+}
+
+// TestUserDAO_CreateUser tests creating a user
+func TestUserDAO_CreateUser(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	mockRow := fakeRow(t, []string{"id"}, []driver.Value{"user-id"})
+	mockDBManager.On("QueryRowWithRetry",
+		"INSERT INTO users (id, username, password_hash, scopes, created_at) VALUES ($1, $2, $3, $4, NOW()) RETURNING id",
+		mock.AnythingOfType("string"), "alice", "hash", "storage:read storage:write").Return(mockRow)
+
+	dao := database.NewUserDAO(mockDBManager)
+	id, err := dao.CreateUser(" alice ", "hash", []string{"storage:read", "storage:write"})
+
+	mockDBManager.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", id)
+
+	// Empty usernames and hashes are rejected before querying the database
+	_, err = dao.CreateUser(" ", "hash", nil)
+	assert.EqualError(t, err, "username cannot be empty")
+	_, err = dao.CreateUser("bob", "", nil)
+	assert.EqualError(t, err, "password hash cannot be empty")
+}
+
+// TestUserDAO_UpdatePasswordHash tests replacing the password hash and the scopes of a user
+func TestUserDAO_UpdatePasswordHash(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	query := "UPDATE users SET password_hash = $2 WHERE id = $1"
+	updated := new(MockResult)
+	updated.On("RowsAffected").Return(int64(1), nil)
+	missing := new(MockResult)
+	missing.On("RowsAffected").Return(int64(0), nil)
+	mockDBManager.On("ExecWithRetry", query, "user-id", "new-hash").Return(updated, nil)
+	mockDBManager.On("ExecWithRetry", query, "other-id", "new-hash").Return(missing, nil)
+	mockDBManager.On("ExecWithRetry", "UPDATE users SET scopes = $2 WHERE id = $1", "user-id", "admin storage:read").Return(updated, nil)
+
+	dao := database.NewUserDAO(mockDBManager)
+	assert.NoError(t, dao.UpdatePasswordHash("user-id", "new-hash"))
+	assert.ErrorIs(t, dao.UpdatePasswordHash("other-id", "new-hash"), database.ErrNotFound)
+	assert.NoError(t, dao.UpdateScopes("user-id", []string{"admin", "storage:read"}))
+	mockDBManager.AssertExpectations(t)
+}
+
+// TestUserDAO_GetUserByUsername tests looking up users by username
+func TestUserDAO_GetUserByUsername(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	columns := []string{"id", "username", "password_hash", "scopes", "totp_secret", "totp_enabled", "created_at"}
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	query := "SELECT id, username, password_hash, scopes, totp_secret, totp_enabled, created_at FROM users WHERE username = $1"
+	mockDBManager.On("QueryRowWithRetry", query, "alice").
+		Return(fakeRow(t, columns, []driver.Value{"user-id", "alice", "hash", "admin", nil, false, createdAt}))
+	mockDBManager.On("QueryRowWithRetry", query, "nobody").Return(fakeRow(t, columns, nil))
+
+	dao := database.NewUserDAO(mockDBManager)
+	user, err := dao.GetUserByUsername("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, &database.User{ID: "user-id", Username: "alice", PasswordHash: "hash", Scopes: []string{"admin"}, CreatedAt: createdAt}, user)
+
+	_, err = dao.GetUserByUsername("nobody")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	assert.EqualError(t, err, "user nobody not found")
+	mockDBManager.AssertExpectations(t)
+}
+
+// TestUserDAO_TOTP tests recording used TOTP steps and recovery codes
+func TestUserDAO_TOTP(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	used := new(MockResult)
+	used.On("RowsAffected").Return(int64(1), nil)
+	unused := new(MockResult)
+	unused.On("RowsAffected").Return(int64(0), nil)
+	stepQuery := "UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2"
+	mockDBManager.On("ExecWithRetry", stepQuery, "user-id", int64(100)).Return(used, nil).Once()
+	mockDBManager.On("ExecWithRetry", stepQuery, "user-id", int64(100)).Return(unused, nil).Once()
+	codeQuery := "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+	mockDBManager.On("ExecWithRetry", codeQuery, "user-id", "code-hash").Return(unused, nil)
+	mockDBManager.On("ExecWithRetry", "UPDATE users SET totp_enabled = true WHERE id = $1 AND totp_secret IS NOT NULL", "user-id").Return(used, nil)
+	mockDBManager.On("ExecWithRetry", "DELETE FROM recovery_codes WHERE user_id = $1", "user-id").Return(used, nil)
+	mockDBManager.On("ExecWithRetry", "INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, NOW())",
+		mock.AnythingOfType("string"), "user-id", "new-hash").Return(used, nil)
+
+	dao := database.NewUserDAO(mockDBManager)
+
+	// A time step is only accepted once
+	fresh, err := dao.UseTOTPStep("user-id", 100)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+	fresh, err = dao.UseTOTPStep("user-id", 100)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+
+	// Used or unknown recovery codes are not found
+	assert.ErrorIs(t, dao.UseRecoveryCode("user-id", "code-hash"), database.ErrNotFound)
+
+	// Enabling stores the recovery codes
+	assert.NoError(t, dao.EnableTOTP("user-id", []string{"new-hash"}))
+	mockDBManager.AssertExpectations(t)
 }
 
 // TestTextStorageDAO_DeleteExpiredEntries tests deleting expired entries
@@ -182,14 +442,13 @@ func TestTextStorageDAO_DeleteExpiredEntries(t *testing.T) {
 	
 	// Set up expectations
 	mockDBManager.On("ExecWithRetry", 
-		"DELETE FROM text_storage WHERE save_flag = false AND created_at < $1",
-		mock.AnythingOfType("time.Time")).Return(mockResult, nil)
+		"DELETE FROM text_storage WHERE save_flag = false AND expires_at <= NOW()").Return(mockResult, nil)
 	
 	// Create a DAO with the mock manager
 	dao := database.NewTextStorageDAO(mockDBManager)
 	
 	// Call the method under test
-	count, err := dao.DeleteExpiredEntries(7 * 24 * time.Hour)
+	count, err := dao.DeleteExpiredEntries()
 	
 	// Verify expectations
 	mockDBManager.AssertExpectations(t)
@@ -209,14 +468,14 @@ func TestTextStorageDAO_DeleteTextByID(t *testing.T) {
 	
 	// Set up expectations
 	mockDBManager.On("ExecWithRetry", 
-		"DELETE FROM text_storage WHERE id = $1",
-		"test-id").Return(mockResult, nil)
+		"DELETE FROM text_storage WHERE id = $1 AND ($2 = 0 OR version = $2)",
+		"test-id", int64(0)).Return(mockResult, nil)
 	
 	// Create a DAO with the mock manager
 	dao := database.NewTextStorageDAO(mockDBManager)
 	
 	// Call the method under test
-	err := dao.DeleteTextByID("test-id")
+	err := dao.DeleteTextByID("test-id", 0)
 	
 	// Verify expectations
 	mockDBManager.AssertExpectations(t)
@@ -224,28 +483,203 @@ func TestTextStorageDAO_DeleteTextByID(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// TestTextStorageDAO_DeleteTextByIDVersion tests deleting text at an outdated version
+func TestTextStorageDAO_DeleteTextByIDVersion(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	unchanged := new(MockResult)
+	unchanged.On("RowsAffected").Return(int64(0), nil)
+	mockDBManager.On("ExecWithRetry", "DELETE FROM text_storage WHERE id = $1 AND ($2 = 0 OR version = $2)", "test-id", int64(2)).Return(unchanged, nil)
+	mockDBManager.On("ExecWithRetry", "DELETE FROM text_storage WHERE id = $1 AND ($2 = 0 OR version = $2)", "missing-id", int64(2)).Return(unchanged, nil)
+	query := "SELECT id, content, save_flag, created_at, created_by, expires_at, version, updated_at FROM text_storage WHERE id = $1"
+	mockDBManager.On("QueryRowWithRetry", query, "test-id").
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"test-id", "hello", false, createdAt, nil, nil, int64(3), createdAt}))
+	mockDBManager.On("QueryRowWithRetry", query, "missing-id").Return(fakeRow(t, textEntryColumns, nil))
+
+	dao := database.NewTextStorageDAO(mockDBManager)
+	err := dao.DeleteTextByID("test-id", 2)
+	assert.ErrorIs(t, err, database.ErrVersionConflict)
+	assert.EqualError(t, err, "text entry with ID test-id is at version 3, not 2: version conflict")
+	assert.ErrorIs(t, dao.DeleteTextByID("missing-id", 2), database.ErrNotFound)
+	mockDBManager.AssertExpectations(t)
+}
+
 // TestTextStorageDAO_UpdateTextSaveFlag tests updating the save flag
 func TestTextStorageDAO_UpdateTextSaveFlag(t *testing.T) {
-	// Create a mock database manager
 	mockDBManager := new(MockDBManager)
-	
-	// Create a mock result
-	mockResult := new(MockResult)
-	mockResult.On("RowsAffected").Return(int64(1), nil)
-	
-	// Set up expectations
-	mockDBManager.On("ExecWithRetry", 
-		"UPDATE text_storage SET save_flag = $2 WHERE id = $1",
-		"test-id", true).Return(mockResult, nil)
-	
-	// Create a DAO with the mock manager
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	updatedAt := createdAt.Add(time.Hour)
+	mockDBManager.On("QueryRowWithRetry",
+		"UPDATE text_storage SET save_flag = $2, expires_at = $3, version = version + 1, updated_at = NOW() WHERE id = $1 AND ($4 = 0 OR version = $4) "+
+			"RETURNING id, content, save_flag, created_at, created_by, expires_at, version, updated_at",
+		"test-id", true, sql.NullTime{}, int64(1)).
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"test-id", "hello", true, createdAt, nil, nil, int64(2), updatedAt}))
+
 	dao := database.NewTextStorageDAO(mockDBManager)
-	
-	// Call the method under test
-	err := dao.UpdateTextSaveFlag("test-id", true)
-	
-	// Verify expectations
+	entry, err := dao.UpdateTextSaveFlag("test-id", true, nil, 1)
+
 	mockDBManager.AssertExpectations(t)
-	mockResult.AssertExpectations(t)
+	require.NoError(t, err)
+	assert.True(t, entry.SaveFlag)
+	assert.Equal(t, int64(2), entry.Version)
+	assert.Equal(t, &updatedAt, entry.UpdatedAt)
+}
+
+// TestTextStorageDAO_ReplaceAndAppendText tests changing the content of a text entry
+func TestTextStorageDAO_ReplaceAndAppendText(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	updatedAt := createdAt.Add(time.Hour)
+	returning := " RETURNING id, content, save_flag, created_at, created_by, expires_at, version, updated_at"
+	replace := "UPDATE text_storage SET content = $2, version = version + 1, updated_at = NOW() WHERE id = $1 AND ($3 = 0 OR version = $3)" + returning
+	appendQuery := "UPDATE text_storage SET content = content || $2, version = version + 1, updated_at = NOW() WHERE id = $1 AND ($3 = 0 OR version = $3)" + returning
+	mockDBManager.On("QueryRowWithRetry", replace, "test-id", "new", int64(0)).
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"test-id", "new", false, createdAt, nil, nil, int64(2), updatedAt}))
+	mockDBManager.On("QueryRowWithRetry", appendQuery, "test-id", " more", int64(2)).
+		Return(fakeRow(t, textEntryColumns, []driver.Value{"test-id", "new more", false, createdAt, nil, nil, int64(3), updatedAt}))
+	mockDBManager.On("QueryRowWithRetry", replace, "missing-id", "new", int64(0)).Return(fakeRow(t, textEntryColumns, nil))
+
+	dao := database.NewTextStorageDAO(mockDBManager)
+	entry, err := dao.ReplaceText("test-id", "new", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "new", entry.Content)
+	assert.Equal(t, int64(2), entry.Version)
+
+	entry, err = dao.AppendText("test-id", " more", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "new more", entry.Content)
+	assert.Equal(t, int64(3), entry.Version)
+
+	_, err = dao.ReplaceText("missing-id", "new", 0)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	_, err = dao.AppendText("test-id", "", 0)
+	assert.Error(t, err)
+	mockDBManager.AssertExpectations(t)
+}
+
+// TestSessionDAO tests creating, retrieving and deleting sessions
+func TestSessionDAO(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	expiresAt := time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC)
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"id", "user_id", "created_at", "expires_at", "user_agent", "ip_address"}
+
+	mockDBManager.On("QueryRowWithRetry",
+		"INSERT INTO sessions (id, user_id, created_at, expires_at, user_agent, ip_address) VALUES ($1, $2, NOW(), $3, $4, $5) RETURNING id",
+		mock.AnythingOfType("string"), "user-id", expiresAt, "agent", "127.0.0.1").
+		Return(fakeRow(t, []string{"id"}, []driver.Value{"session-id"}))
+	query := "SELECT id, user_id, created_at, expires_at, user_agent, ip_address FROM sessions WHERE id = $1 AND expires_at > NOW()"
+	mockDBManager.On("QueryRowWithRetry", query, "session-id").
+		Return(fakeRow(t, columns, []driver.Value{"session-id", "user-id", createdAt, expiresAt, nil, "127.0.0.1"}))
+	mockDBManager.On("QueryRowWithRetry", query, "expired-id").Return(fakeRow(t, columns, nil))
+	expired := new(MockResult)
+	expired.On("RowsAffected").Return(int64(2), nil)
+	mockDBManager.On("ExecWithRetry", "DELETE FROM sessions WHERE expires_at <= NOW()").Return(expired, nil)
+
+	dao := database.NewSessionDAO(mockDBManager)
+	id, err := dao.CreateSession("user-id", expiresAt, "agent", "127.0.0.1")
 	assert.NoError(t, err)
+	assert.Equal(t, "session-id", id)
+
+	session, err := dao.GetSession("session-id")
+	assert.NoError(t, err)
+	assert.Equal(t, &database.Session{ID: "session-id", UserID: "user-id", CreatedAt: createdAt, ExpiresAt: expiresAt, IPAddress: "127.0.0.1"}, session)
+
+	_, err = dao.GetSession("expired-id")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	removed, err := dao.DeleteExpiredSessions()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+	mockDBManager.AssertExpectations(t)
+}
+
+// TestAPIKeyDAO tests storing, looking up and revoking API keys
+func TestAPIKeyDAO(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := time.Date(2025, 2, 1, 3, 4, 5, 0, time.UTC)
+	columns := []string{"id", "user_id", "username", "name", "prefix", "scopes", "user_scopes", "key_hash", "created_at", "expires_at", "last_used_at", "revoked_at"}
+
+	mockDBManager.On("QueryRowWithRetry",
+		"INSERT INTO api_keys (id, user_id, name, prefix, scopes, key_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7) RETURNING id",
+		mock.AnythingOfType("string"), "user-id", "deploy", "amt_abcdefgh", "storage:read", "hash", sql.NullTime{Time: expiresAt, Valid: true}).
+		Return(fakeRow(t, []string{"id"}, []driver.Value{"key-id"}))
+	query := "SELECT k.id, k.user_id, u.username, k.name, k.prefix, k.scopes, u.scopes, k.key_hash, k.created_at, k.expires_at, k.last_used_at, k.revoked_at " +
+		"FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.key_hash = $1"
+	mockDBManager.On("QueryRowWithRetry", query, "hash").
+		Return(fakeRow(t, columns, []driver.Value{"key-id", "user-id", "alice", "deploy", "amt_abcdefgh", "storage:read", "admin", "hash", createdAt, expiresAt, nil, nil}))
+	mockDBManager.On("QueryRowWithRetry", query, "unknown").Return(fakeRow(t, columns, nil))
+	revoked := new(MockResult)
+	revoked.On("RowsAffected").Return(int64(0), nil)
+	mockDBManager.On("ExecWithRetry", "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", "key-id").Return(revoked, nil)
+	mockDBManager.On("ExecWithRetry", "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", "key-id").Return(new(MockResult), nil)
+
+	dao := database.NewAPIKeyDAO(mockDBManager)
+	id, err := dao.CreateAPIKey("user-id", " deploy ", "amt_abcdefgh", "hash", []string{"storage:read"}, &expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, "key-id", id)
+
+	_, err = dao.CreateAPIKey("user-id", "", "amt_abcdefgh", "hash", nil, nil)
+	assert.Error(t, err)
+
+	key, err := dao.GetAPIKeyByHash("hash")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", key.Username)
+	assert.Equal(t, []string{"storage:read"}, key.Scopes)
+	assert.Equal(t, []string{"admin"}, key.UserScopes)
+	assert.Equal(t, &expiresAt, key.ExpiresAt)
+	assert.Nil(t, key.LastUsedAt)
+	assert.True(t, key.Active(createdAt))
+	assert.False(t, key.Active(expiresAt))
+
+	_, err = dao.GetAPIKeyByHash("unknown")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Keys that are already revoked are reported as not found
+	assert.ErrorIs(t, dao.RevokeAPIKey("key-id"), database.ErrNotFound)
+	assert.NoError(t, dao.TouchAPIKey("key-id"))
+	mockDBManager.AssertExpectations(t)
+}
+
+func TestAuditDAO(t *testing.T) {
+	mockDBManager := new(MockDBManager)
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "event_type", "outcome", "actor_id", "actor_username", "target_id", "ip_address", "user_agent", "details", "created_at"}
+
+	mockDBManager.On("ExecWithRetry",
+		"INSERT INTO audit_events (event_type, outcome, actor_id, actor_username, target_id, ip_address, user_agent, details, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		"text_stored", "success", sql.NullString{String: "user-id", Valid: true}, sql.NullString{String: "alice", Valid: true},
+		sql.NullString{String: "text-id", Valid: true}, sql.NullString{String: "192.0.2.1", Valid: true}, sql.NullString{}, sql.NullString{}, at).
+		Return(new(MockResult), nil)
+	mockDBManager.On("QueryWithRetry",
+		"SELECT id, event_type, outcome, actor_id, actor_username, target_id, ip_address, user_agent, details, created_at FROM audit_events "+
+			"WHERE event_type = $1 AND actor_id = $2 AND created_at >= $3 ORDER BY id DESC LIMIT $4",
+		"text_stored", "user-id", since, 1000).
+		Return(fakeResultRows(t, columns, []driver.Value{int64(7), "text_stored", "success", "user-id", "alice", "text-id", "192.0.2.1", nil, nil, at}), nil)
+	mockDBManager.On("QueryWithRetry",
+		"SELECT id, event_type, outcome, actor_id, actor_username, target_id, ip_address, user_agent, details, created_at FROM audit_events ORDER BY id DESC LIMIT $1",
+		100).
+		Return(fakeResultRows(t, columns), nil)
+
+	dao := database.NewAuditDAO(mockDBManager)
+	err := dao.RecordEvent(audit.Event{Type: "text_stored", Outcome: "success", UserID: "user-id", Username: "alice", TargetID: "text-id", IPAddress: "192.0.2.1", Time: at})
+	assert.NoError(t, err)
+	assert.Error(t, dao.RecordEvent(audit.Event{}))
+
+	// Limits above the maximum are reduced to it
+	events, err := dao.ListEvents(audit.Filter{Type: "text_stored", UserID: "user-id", Since: since, Limit: 5000})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(7), events[0].ID)
+	assert.Equal(t, "alice", events[0].Username)
+	assert.Equal(t, "text-id", events[0].TargetID)
+	assert.Empty(t, events[0].UserAgent)
+	assert.Equal(t, at, events[0].Time)
+
+	events, err = dao.ListEvents(audit.Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+	mockDBManager.AssertExpectations(t)
 }
@@ -2,6 +2,7 @@
 package unit
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/CJFEdu/allmitools/server/internal/handlers"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
 	"github.com/CJFEdu/allmitools/server/tests/utils"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -147,6 +149,78 @@ func TestToolsHandler(t *testing.T) {
 	assert.Contains(t, body, "\"success\":true", "handler returned unexpected body")
 }
 
+// TestToolsHandlerValidationErrors tests that invalid parameters are reported per field
+func TestToolsHandlerValidationErrors(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/tools/random-string?length=5000&mixedCase=maybe", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "handler returned wrong status code")
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "handler returned wrong content type")
+
+	var response handlers.ToolResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.False(t, response.Success)
+	assert.Equal(t, tools.CodeValidation, response.Code)
+	assert.Equal(t, []tools.FieldError{
+		{Field: "length", Message: "must be at most 1000"},
+		{Field: "mixedCase", Message: "must be a boolean (true, false, on, off, 1 or 0), got maybe"},
+	}, response.Errors)
+}
+
+// TestToolsHandlerTypedResults tests that JSON responses carry typed results and raw responses their text form
+func TestToolsHandlerTypedResults(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler)
+
+	// execute runs a tool and returns the response body
+	execute := func(url, accept string) string {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code for %s", url)
+		return rr.Body.String()
+	}
+
+	// Numbers are JSON numbers
+	var number struct {
+		Data int `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(execute("/tools/random-number?min=7&max=7", "application/json")), &number))
+	assert.Equal(t, 7, number.Data)
+	assert.Equal(t, "7", execute("/tools/random-number?min=7&max=7", "text/plain"))
+
+	// Hashes are objects with their metadata
+	var hash struct {
+		Data tools.HashResult `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(execute("/tools/sha256-hasher?text=abc", "application/json")), &hash))
+	assert.Equal(t, "sha256", hash.Data.Algorithm)
+	assert.Equal(t, "hex", hash.Data.Encoding)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", hash.Data.Hash)
+	assert.Equal(t, hash.Data.Hash, execute("/tools/sha256-hasher?text=abc", "text/plain"))
+
+	// Dates include the applied offset
+	var date struct {
+		Data tools.DateResult `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(execute("/tools/date?offset=-1&format=2006", "application/json")), &date))
+	assert.Equal(t, -1, date.Data.Offset)
+	assert.Equal(t, "2006", date.Data.Format)
+	assert.Equal(t, date.Data.Timestamp.Format("2006"), date.Data.Date)
+}
+
 // TestNotFoundHandler tests the not found handler function
 func TestNotFoundHandler(t *testing.T) {
 	// Create a request to pass to our handler
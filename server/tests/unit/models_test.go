@@ -2,10 +2,12 @@
 package unit
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/CJFEdu/allmitools/server/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestToolParameterValidation tests the validation of tool parameters
@@ -56,6 +58,74 @@ func TestToolParameterValidation(t *testing.T) {
 			},
 			expectedError: "invalid parameter type: invalid",
 		},
+		{
+			name: "Valid constraints",
+			parameter: models.ToolParameter{
+				Name:      "test",
+				Type:      "string",
+				Enum:      []string{"a", "b"},
+				MinLength: models.Int(1),
+				MaxLength: models.Int(10),
+				Pattern:   "^[a-z]+$",
+			},
+			expectedError: "",
+		},
+		{
+			name: "Min on string",
+			parameter: models.ToolParameter{
+				Name: "test",
+				Type: "string",
+				Min:  models.Float(1),
+			},
+			expectedError: "min and max only apply to int and float parameters, not string",
+		},
+		{
+			name: "Min greater than max",
+			parameter: models.ToolParameter{
+				Name: "test",
+				Type: "int",
+				Min:  models.Float(10),
+				Max:  models.Float(1),
+			},
+			expectedError: "min cannot be greater than max",
+		},
+		{
+			name: "Pattern on int",
+			parameter: models.ToolParameter{
+				Name:    "test",
+				Type:    "int",
+				Pattern: "^[0-9]+$",
+			},
+			expectedError: "length, pattern and multiline only apply to string parameters, not int",
+		},
+		{
+			name: "Min length greater than max length",
+			parameter: models.ToolParameter{
+				Name:      "test",
+				Type:      "string",
+				MinLength: models.Int(5),
+				MaxLength: models.Int(2),
+			},
+			expectedError: "min length cannot be greater than max length",
+		},
+		{
+			name: "Invalid pattern",
+			parameter: models.ToolParameter{
+				Name:    "test",
+				Type:    "string",
+				Pattern: "[a-z",
+			},
+			expectedError: "invalid pattern: error parsing regexp: missing closing ]: `[a-z`",
+		},
+		{
+			name: "Enum on bool",
+			parameter: models.ToolParameter{
+				Name: "test",
+				Type: "bool",
+				Enum: []string{"true"},
+			},
+			expectedError: "enum only applies to string, int and float parameters, not bool",
+		},
 	}
 
 	// Run the test cases
@@ -71,6 +141,71 @@ func TestToolParameterValidation(t *testing.T) {
 	}
 }
 
+// TestToolParameterCheckValue tests checking values against parameter constraints
+func TestToolParameterCheckValue(t *testing.T) {
+	testCases := []struct {
+		name            string
+		parameter       models.ToolParameter
+		value           any
+		expectedMessage string
+	}{
+		{
+			name:            "Allowed enum value",
+			parameter:       models.ToolParameter{Name: "unit", Type: "string", Enum: []string{"day", "month"}},
+			value:           "day",
+			expectedMessage: "",
+		},
+		{
+			name:            "Disallowed enum value",
+			parameter:       models.ToolParameter{Name: "unit", Type: "string", Enum: []string{"day", "month"}},
+			value:           "week",
+			expectedMessage: "must be one of: day, month",
+		},
+		{
+			name:            "Numeric enum value",
+			parameter:       models.ToolParameter{Name: "size", Type: "int", Enum: []string{"8", "16"}},
+			value:           16,
+			expectedMessage: "",
+		},
+		{
+			name:            "Below minimum",
+			parameter:       models.ToolParameter{Name: "length", Type: "int", Min: models.Float(1), Max: models.Float(1000)},
+			value:           0,
+			expectedMessage: "must be at least 1",
+		},
+		{
+			name:            "Above maximum",
+			parameter:       models.ToolParameter{Name: "ratio", Type: "float", Max: models.Float(1.5)},
+			value:           2.5,
+			expectedMessage: "must be at most 1.5",
+		},
+		{
+			name:            "Too short",
+			parameter:       models.ToolParameter{Name: "name", Type: "string", MinLength: models.Int(3)},
+			value:           "ab",
+			expectedMessage: "must be at least 3 characters long",
+		},
+		{
+			name:            "Too long counts characters not bytes",
+			parameter:       models.ToolParameter{Name: "name", Type: "string", MaxLength: models.Int(3)},
+			value:           "äöü",
+			expectedMessage: "",
+		},
+		{
+			name:            "Pattern mismatch",
+			parameter:       models.ToolParameter{Name: "filename", Type: "string", Pattern: "^[a-z.]+$"},
+			value:           "../etc/passwd",
+			expectedMessage: "must match the pattern ^[a-z.]+$",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedMessage, tc.parameter.CheckValue(tc.value))
+		})
+	}
+}
+
 // TestToolInfoValidation tests the validation of tool info
 func TestToolInfoValidation(t *testing.T) {
 	// Test cases for tool info validation
@@ -203,16 +338,44 @@ func TestListTools(t *testing.T) {
 	// Get the list of tools
 	tools := models.ListTools()
 
-	// Check that we have the expected number of tools
-	assert.Equal(t, len(models.AvailableTools), len(tools))
+	// Check that we have tools, sorted by name
+	require.NotEmpty(t, tools)
+	assert.True(t, sort.SliceIsSorted(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name }))
 
-	// Check that all tools are present
-	toolMap := make(map[string]bool)
+	// Check that every listed tool has the info it is registered with
 	for _, tool := range tools {
-		toolMap[tool.Name] = true
+		info, err := models.GetToolInfo(tool.Name)
+		assert.NoError(t, err, "Tool %s should be registered", tool.Name)
+		assert.Equal(t, tool, info)
 	}
+}
 
-	for name := range models.AvailableTools {
-		assert.True(t, toolMap[name], "Tool %s should be in the list", name)
+// TestToolRegistry tests that registered tools drive the tool listings
+func TestToolRegistry(t *testing.T) {
+	// Every listed public tool should resolve to a registered tool with the same info
+	for _, info := range models.ListTools() {
+		tool, err := models.GetTool(info.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, info.Name, tool.Info().Name)
 	}
+
+	// Every listed private tool should resolve to a registered private tool
+	for _, info := range models.GetAllPrivateTools() {
+		tool, err := models.GetPrivateTool(info.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, info.Name, tool.Info().Name)
+		assert.True(t, info.RequiresAuth)
+	}
+
+	// Private tools must not be reachable as public tools and vice versa
+	_, err := models.GetTool("text-storage")
+	assert.EqualError(t, err, "tool not found: text-storage")
+	_, err = models.GetPrivateTool("random-number")
+	assert.EqualError(t, err, "tool not found: random-number")
+
+	// The text file tool is served as a download
+	tool, err := models.GetTool("text-file")
+	assert.NoError(t, err)
+	_, isFileTool := tool.(models.FileTool)
+	assert.True(t, isFileTool)
 }
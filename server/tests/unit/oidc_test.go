@@ -0,0 +1,358 @@
+package unit
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/oidc"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oidcClientID is the client ID registered with the stub issuer
+const oidcClientID = "allmitools"
+
+// oidcRedirectURL is the callback page registered with the stub issuer
+const oidcRedirectURL = "https://allmitools.example/login/oidc/callback"
+
+// stubIssuer is a local OpenID Connect identity provider that logs in every user without asking
+type stubIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// issuer is the issuer identifier, the URL of the server with or without a trailing slash
+	issuer string
+
+	mutex sync.Mutex
+	// claims are the claims of the next login, the registered claims are filled in
+	claims map[string]interface{}
+	// tamper changes the claims of an ID token after the registered claims are filled in, if set
+	tamper func(claims map[string]interface{})
+	// logins are the logins waiting to be redeemed, keyed by authorization code
+	logins map[string]url.Values
+}
+
+// newStubIssuer starts a stub identity provider for the duration of a test
+// The issuer identifier is the URL of the server followed by suffix
+func newStubIssuer(t *testing.T, suffix string) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := &stubIssuer{key: key, logins: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.issuer,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub-key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	issuer.issuer = issuer.server.URL + suffix
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// authorize logs the user in and redirects back to the client with an authorization code
+func (s *stubIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != oidcClientID || query.Get("redirect_uri") != oidcRedirectURL ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + query.Get("state")[:8]
+	s.mutex.Lock()
+	s.logins[code] = query
+	s.mutex.Unlock()
+
+	http.Redirect(w, r, oidcRedirectURL+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+}
+
+// token redeems an authorization code for an ID token once the PKCE code verifier matches the challenge
+func (s *stubIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mutex.Lock()
+	login, ok := s.logins[r.PostForm.Get("code")]
+	delete(s.logins, r.PostForm.Get("code"))
+	claims := map[string]interface{}{}
+	for name, value := range s.claims {
+		claims[name] = value
+	}
+	tamper := s.tamper
+	s.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != login.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims["iss"] = s.issuer
+	claims["aud"] = oidcClientID
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["nonce"] = login.Get("nonce")
+	if tamper != nil {
+		tamper(claims)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     s.sign(claims),
+	})
+}
+
+// sign returns an RS256 ID token with the claims
+func (s *stubIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stub-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login sets the claims of the next login
+func (s *stubIssuer) login(claims map[string]interface{}, tamper func(claims map[string]interface{})) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.claims = claims
+	s.tamper = tamper
+}
+
+// useOIDC enables single sign-on with the stub issuer for the duration of a test
+func useOIDC(t *testing.T, issuer *stubIssuer, mapping middleware.OIDCMapping) {
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer:      issuer.issuer,
+		ClientID:    oidcClientID,
+		RedirectURL: oidcRedirectURL,
+		Scopes:      []string{"openid", "email", "groups"},
+	})
+	require.NoError(t, err)
+	middleware.ConfigureOIDC(provider, mapping)
+	t.Cleanup(func() { middleware.ConfigureOIDC(nil, middleware.OIDCMapping{}) })
+}
+
+// oidcLogin logs in through the stub issuer, starting from the given next page, and returns the callback response
+func oidcLogin(t *testing.T, next string) *httptest.ResponseRecorder {
+	// The login page redirects to the identity provider
+	rr := httptest.NewRecorder()
+	handlers.OIDCLoginHandler(rr, httptest.NewRequest("GET", "/login/oidc?"+url.Values{"next": {next}}.Encode(), nil))
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+	// The identity provider redirects back with a code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	handlers.OIDCCallbackHandler(rr, req)
+	return rr
+}
+
+// oidcIdentity returns the user logged in by a callback response
+func oidcIdentity(t *testing.T, rr *httptest.ResponseRecorder) (middleware.Identity, bool) {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == middleware.CookieName && cookie.Value != "" {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(cookie)
+			return middleware.CurrentUser(req)
+		}
+	}
+	return middleware.Identity{}, false
+}
+
+// TestOIDCLogin tests logging in through an identity provider and mapping its claims to local users
+func TestOIDCLogin(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	users := useFakeUsers(t)
+	users["alice@example.com"] = &database.User{ID: "alice-sso-id", Username: "alice@example.com", Scopes: []string{models.ScopeAdmin}}
+	recorder := useFakeAudit(t)
+	issuer := newStubIssuer(t, "")
+	useOIDC(t, issuer, middleware.OIDCMapping{GroupScopes: map[string][]string{
+		"allmitools-admins":  {models.ScopeAdmin},
+		"allmitools-users":   {models.ScopeStorageRead, models.ScopeStorageWrite},
+		"allmitools-writers": {models.ScopeStorageWrite},
+		"allmitools-readers": {models.ScopeStorageRead},
+	}})
+
+	// The login page offers single sign-on and keeps the page to return to
+	rr := httptest.NewRecorder()
+	handlers.LoginHandler(rr, httptest.NewRequest("GET", "/login?next=%2Fprivate%2Ftools", nil))
+	assert.Contains(t, rr.Body.String(), `href="/login/oidc?next=%2fprivate%2ftools"`)
+
+	// The email claim names the user, and the groups decide the scopes
+	claims := map[string]interface{}{
+		"sub":            "user-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"allmitools-users", "unrelated"},
+	}
+	issuer.login(claims, nil)
+	rr = oidcLogin(t, "/private/tools")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/private/tools", rr.Header().Get("Location"))
+	identity, ok := oidcIdentity(t, rr)
+	require.True(t, ok)
+	assert.Equal(t, "alice-sso-id", identity.ID)
+	assert.Equal(t, []string{models.ScopeStorageRead, models.ScopeStorageWrite}, identity.Scopes)
+	assert.Equal(t, []string{models.ScopeStorageRead, models.ScopeStorageWrite}, users["alice@example.com"].Scopes)
+
+	// Scopes merged from several groups are sorted, so unchanged scopes are not stored again
+	stored := users["alice@example.com"].Scopes
+	issuer.login(map[string]interface{}{
+		"sub":            "user-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"allmitools-writers", "allmitools-readers"},
+	}, nil)
+	identity, ok = oidcIdentity(t, oidcLogin(t, "/"))
+	require.True(t, ok)
+	assert.Equal(t, []string{models.ScopeStorageRead, models.ScopeStorageWrite}, identity.Scopes)
+	assert.Same(t, &stored[0], &users["alice@example.com"].Scopes[0])
+
+	// Users outside the mapped groups, unknown users and unverified emails are refused
+	refused := func(claims map[string]interface{}) {
+		issuer.login(claims, nil)
+		rr := oidcLogin(t, "/")
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "not allowed")
+		_, ok := oidcIdentity(t, rr)
+		assert.False(t, ok)
+	}
+	refused(map[string]interface{}{"sub": "user-1", "email": "alice@example.com", "groups": []string{"unrelated"}})
+	refused(map[string]interface{}{"sub": "user-2", "email": "bob@example.com", "groups": []string{"allmitools-users"}})
+	refused(map[string]interface{}{"sub": "user-1", "email": "alice@example.com", "email_verified": false, "groups": []string{"allmitools-users"}})
+	assert.Contains(t, recorder.types(), "login_failed")
+
+	// ID tokens for another client, with another nonce or expired are rejected
+	for _, tamper := range []func(map[string]interface{}){
+		func(c map[string]interface{}) { c["aud"] = "another-client" },
+		func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+	} {
+		issuer.login(claims, tamper)
+		rr := oidcLogin(t, "/")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		_, ok := oidcIdentity(t, rr)
+		assert.False(t, ok)
+	}
+
+	// A callback without the login started by the browser is refused
+	rr = httptest.NewRecorder()
+	handlers.OIDCCallbackHandler(rr, httptest.NewRequest("GET", "/login/oidc/callback?code=code-1&state=forged", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// TestParseGroupScopes tests parsing the scopes of groups from OIDC_GROUP_SCOPES
+func TestParseGroupScopes(t *testing.T) {
+	groupScopes, err := middleware.ParseGroupScopes("admins=admin; staff=storage:write storage:read;staff=storage:read;guests=")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"admins": {models.ScopeAdmin},
+		"staff":  {models.ScopeStorageRead, models.ScopeStorageWrite},
+		"guests": {},
+	}, groupScopes)
+
+	_, err = middleware.ParseGroupScopes("staff=storage:wrte")
+	assert.ErrorContains(t, err, `unknown scope "storage:wrte"`)
+	_, err = middleware.ParseGroupScopes("storage:read")
+	assert.Error(t, err)
+}
+
+// TestOIDCCreateUsers tests creating local users on their first single sign-on login
+func TestOIDCCreateUsers(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	users := useFakeUsers(t)
+	issuer := newStubIssuer(t, "")
+	useOIDC(t, issuer, middleware.OIDCMapping{UsernameClaim: "preferred_username", CreateUsers: true})
+
+	issuer.login(map[string]interface{}{"sub": "user-2", "preferred_username": "bob"}, nil)
+	rr := oidcLogin(t, "/")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	identity, ok := oidcIdentity(t, rr)
+	require.True(t, ok)
+	assert.Equal(t, "bob", identity.Username)
+	assert.Empty(t, identity.Scopes)
+
+	// The user cannot log in with a password
+	require.Contains(t, users, "bob")
+	assert.NotEmpty(t, users["bob"].PasswordHash)
+	_, err := middleware.Authenticate("bob", "")
+	assert.ErrorIs(t, err, middleware.ErrInvalidCredentials)
+}
+
+// TestOIDCIssuerTrailingSlash tests logging in through an identity provider whose issuer ends in a slash
+func TestOIDCIssuerTrailingSlash(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	users := useFakeUsers(t)
+	users["carol"] = &database.User{ID: "carol-id", Username: "carol"}
+	issuer := newStubIssuer(t, "/")
+	useOIDC(t, issuer, middleware.OIDCMapping{UsernameClaim: "preferred_username"})
+
+	issuer.login(map[string]interface{}{"sub": "user-3", "preferred_username": "carol"}, nil)
+	rr := oidcLogin(t, "/")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	identity, ok := oidcIdentity(t, rr)
+	require.True(t, ok)
+	assert.Equal(t, "carol", identity.Username)
+}
+
+// TestOIDCDisabled tests that the single sign-on pages do not exist without an identity provider
+func TestOIDCDisabled(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+
+	rr := httptest.NewRecorder()
+	handlers.OIDCLoginHandler(rr, httptest.NewRequest("GET", "/login/oidc", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handlers.LoginHandler(rr, httptest.NewRequest("GET", "/login", nil))
+	assert.False(t, strings.Contains(rr.Body.String(), "/login/oidc"))
+}
@@ -0,0 +1,114 @@
+// Package unit contains unit tests for the AllMiTools server
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAPIHandler tests that the public OpenAPI document describes every public tool
+func TestOpenAPIHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	handlers.OpenAPIHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+	assert.Contains(t, doc.Components.Schemas, "ToolResponse")
+	assert.Contains(t, doc.Components.Schemas, "FieldError")
+	assert.Empty(t, doc.Components.SecuritySchemes)
+
+	// Every public tool has a path, private tools are not listed
+	for _, tool := range models.ListTools() {
+		assert.Contains(t, doc.Paths, "/tools/"+tool.Name)
+	}
+	for _, tool := range models.GetAllPrivateTools() {
+		assert.NotContains(t, doc.Paths, "/private/tools/"+tool.Name)
+	}
+
+	// Parameters keep their types and constraints
+	item := doc.Paths["/tools/random-string"]
+	require.NotNil(t, item)
+	require.NotNil(t, item.Get)
+	assert.Equal(t, "getRandomString", item.Get.OperationID)
+
+	params := make(map[string]*openapi.Parameter)
+	for _, param := range item.Get.Parameters {
+		params[param.Name] = param
+	}
+	require.Contains(t, params, "length")
+	assert.Equal(t, "integer", params["length"].Schema.Type)
+	assert.Equal(t, 1.0, *params["length"].Schema.Minimum)
+	assert.Equal(t, 1000.0, *params["length"].Schema.Maximum)
+	require.Contains(t, params, "output_format")
+	assert.Equal(t, []any{"json", "html", "raw", "csv", "xml", "yaml", "markdown"}, params["output_format"].Schema.Enum)
+
+	// POST bodies are described for JSON and form clients
+	require.NotNil(t, item.Post)
+	body := item.Post.RequestBody.Content["application/json"].Schema
+	assert.Equal(t, "boolean", body.Properties["mixedCase"].Type)
+	assert.Contains(t, item.Post.RequestBody.Content, "application/x-www-form-urlencoded")
+	assert.Equal(t, "#/components/responses/ValidationError", item.Post.Responses["422"].Ref)
+
+	// File tools are described as downloads
+	fileItem := doc.Paths["/tools/text-file"]
+	require.NotNil(t, fileItem)
+	assert.Contains(t, fileItem.Post.Responses["200"].Headers, "Content-Disposition")
+	assert.Equal(t, []string{"content"}, fileItem.Post.RequestBody.Content["application/json"].Schema.Required)
+}
+
+// TestPrivateOpenAPIDocument tests that the private OpenAPI document includes the private tools
+func TestPrivateOpenAPIDocument(t *testing.T) {
+	doc := handlers.BuildOpenAPIDocument(true)
+
+	assert.Contains(t, doc.Components.SecuritySchemes, openapi.CookieAuthScheme)
+	require.Contains(t, doc.Components.SecuritySchemes, openapi.BearerAuthScheme)
+	assert.Equal(t, "bearer", doc.Components.SecuritySchemes[openapi.BearerAuthScheme].Scheme)
+	assert.Contains(t, doc.Paths, "/tools/random-number")
+
+	item := doc.Paths["/private/tools/text-storage"]
+	require.NotNil(t, item)
+	assert.Equal(t, []map[string][]string{{openapi.CookieAuthScheme: {}}, {openapi.BearerAuthScheme: {}}}, item.Post.Security)
+	assert.Equal(t, "#/components/responses/Unauthenticated", item.Post.Responses["303"].Ref)
+	assert.Equal(t, "#/components/responses/InvalidAPIKey", item.Post.Responses["401"].Ref)
+	assert.Equal(t, "#/components/responses/Unavailable", item.Post.Responses["503"].Ref)
+	assert.Equal(t, []string{"private-tools"}, item.Post.Tags)
+}
+
+// TestParameterSchema tests converting tool parameters to schemas
+func TestParameterSchema(t *testing.T) {
+	schema := openapi.ParameterSchema(models.ToolParameter{
+		Name:    "size",
+		Type:    "int",
+		Enum:    []string{"8", "16"},
+		Default: 8,
+	})
+	assert.Equal(t, "integer", schema.Type)
+	assert.Equal(t, []any{int64(8), int64(16)}, schema.Enum)
+	assert.Equal(t, 8, schema.Default)
+
+	schema = openapi.ParameterSchema(models.ToolParameter{
+		Name:      "name",
+		Type:      "string",
+		Required:  true,
+		Default:   "",
+		MaxLength: models.Int(10),
+		Pattern:   "^[a-z]+$",
+	})
+	assert.Equal(t, "string", schema.Type)
+	assert.Nil(t, schema.Default)
+	assert.Equal(t, 10, *schema.MaxLength)
+	assert.Equal(t, "^[a-z]+$", schema.Pattern)
+}
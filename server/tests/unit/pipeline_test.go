@@ -0,0 +1,148 @@
+// Package unit contains unit tests for the AllMiTools server
+package unit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipelineTestResponse mirrors the pipeline response with typed step results
+type pipelineTestResponse struct {
+	Success bool                    `json:"success"`
+	Error   string                  `json:"error"`
+	Data    handlers.PipelineResult `json:"data"`
+}
+
+// runPipeline posts a pipeline body to the pipeline handler
+func runPipeline(t *testing.T, body string) (*httptest.ResponseRecorder, pipelineTestResponse) {
+	req := httptest.NewRequest("POST", "/pipeline", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handlers.PipelineHandler(rr, req)
+
+	var response pipelineTestResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return rr, response
+}
+
+// TestPipelineHandler tests chaining tools with references to earlier results
+func TestPipelineHandler(t *testing.T) {
+	rr, response := runPipeline(t, `{"steps": [
+		{"tool": "random-string", "params": {"length": 16}},
+		{"tool": "sha256-hasher", "params": {"text": "{{steps.0.result}}"}},
+		{"tool": "url-encoder", "params": {"text": "id={{ steps.0.result }}&hash={{steps.1.result}}"}}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.True(t, response.Success)
+	require.Len(t, response.Data.Steps, 3)
+
+	random, ok := response.Data.Steps[0].Result.(string)
+	require.True(t, ok)
+	assert.Len(t, random, 16)
+
+	// Typed results are referenced by their text form
+	hash := sha256.Sum256([]byte(random))
+	hexHash := hex.EncodeToString(hash[:])
+	assert.Equal(t, map[string]interface{}{
+		"algorithm": "sha256",
+		"encoding":  "hex",
+		"hash":      hexHash,
+	}, response.Data.Steps[1].Result)
+	assert.Equal(t, "id%3D"+random+"%26hash%3D"+hexHash, response.Data.Steps[2].Result)
+	for i, step := range response.Data.Steps {
+		assert.Equal(t, i, step.Step)
+		assert.True(t, step.Success)
+	}
+}
+
+// TestPipelineHandlerErrors tests pipelines that are rejected or fail part way
+func TestPipelineHandlerErrors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedError  string
+		expectedSteps  int
+	}{
+		{
+			name:           "Invalid JSON",
+			body:           `{"steps": [`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "error parsing pipeline",
+		},
+		{
+			name:           "No steps",
+			body:           `{"steps": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "pipeline has no steps",
+		},
+		{
+			name:           "Unknown tool",
+			body:           `{"steps": [{"tool": "nonexistent"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "step 0: tool not found: nonexistent",
+		},
+		{
+			name:           "Forward reference",
+			body:           `{"steps": [{"tool": "sha256-hasher", "params": {"text": "{{steps.1.result}}"}}, {"tool": "random-string"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "step 0: parameter text references step 1, which has not run yet",
+		},
+		{
+			name:           "Private tool without authentication",
+			body:           `{"steps": [{"tool": "random-string"}, {"tool": "text-storage", "params": {"content": "{{steps.0.result}}"}}]}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "step 1 (text-storage) is a private tool and requires authentication",
+		},
+		{
+			name:           "Failing step stops the pipeline",
+			body:           `{"steps": [{"tool": "random-string", "params": {"length": 8}}, {"tool": "random-number", "params": {"min": 10, "max": 1}}, {"tool": "random-string"}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "step 1 (random-number) failed: minimum value cannot be greater than maximum value",
+			expectedSteps:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, response := runPipeline(t, tc.body)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.False(t, response.Success)
+			assert.Contains(t, response.Error, tc.expectedError)
+			assert.Len(t, response.Data.Steps, tc.expectedSteps)
+			if tc.expectedSteps > 0 {
+				assert.True(t, response.Data.Steps[0].Success)
+				assert.False(t, response.Data.Steps[tc.expectedSteps-1].Success)
+			}
+		})
+	}
+}
+
+// TestNewToolRequest tests that the requests of pipeline steps and batch items keep the client of the original request
+func TestNewToolRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", "/pipeline", strings.NewReader(`{"steps":[]}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Authorization", "Bearer amt_test")
+	req.Header.Set("Content-Type", "text/plain")
+
+	toolReq, err := handlers.NewToolRequest(req, map[string]interface{}{"content": "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:1234", toolReq.RemoteAddr)
+	assert.Equal(t, "test-agent", toolReq.UserAgent())
+	assert.Equal(t, "Bearer amt_test", toolReq.Header.Get("Authorization"))
+	assert.Equal(t, "application/json", toolReq.Header.Get("Content-Type"))
+	assert.Equal(t, "text/plain", req.Header.Get("Content-Type"))
+}
@@ -0,0 +1,178 @@
+// Package unit contains unit tests for the AllMiTools server
+package unit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/CJFEdu/allmitools/server/internal/render"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/CJFEdu/allmitools/server/internal/tools"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderSelect tests choosing a renderer from the output format and Accept header
+func TestRenderSelect(t *testing.T) {
+	testCases := []struct {
+		name         string
+		outputFormat string
+		accept       string
+		expected     string
+		expectError  bool
+	}{
+		{"No preference", "", "", "json", false},
+		{"Output format wins over Accept", "csv", "application/json", "csv", false},
+		{"Output format is case insensitive", "YAML", "", "yaml", false},
+		{"Unknown output format", "pdf", "", "", true},
+		{"Exact media type", "", "text/csv", "csv", false},
+		{"Alternative media type", "", "text/xml", "xml", false},
+		{"Wildcard uses the default", "", "*/*", "json", false},
+		{"Highest quality wins", "", "application/json;q=0.5, text/markdown;q=0.9", "markdown", false},
+		{"Equal quality uses header order", "", "application/yaml, application/json", "yaml", false},
+		{"More specific range wins", "", "text/*;q=0.8, text/plain", "raw", false},
+		{"Zero quality excludes", "", "application/json;q=0, */*;q=0.1", "html", false},
+		{"Browser Accept header", "", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "html", false},
+		{"Media type parameters are ignored", "", "application/json; charset=utf-8", "json", false},
+		{"Nothing acceptable", "", "application/pdf", "", true},
+		{"Everything excluded", "", "*/*;q=0", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			renderer, err := render.Select(tc.outputFormat, tc.accept)
+			if tc.expectError {
+				assert.True(t, errors.Is(err, render.ErrNotAcceptable), "expected ErrNotAcceptable, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, renderer.Name())
+		})
+	}
+}
+
+// renderAs renders a result with the named renderer
+func renderAs(t *testing.T, name string, result render.Result) string {
+	renderer, err := render.Get(name)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, renderer.Render(&b, result))
+	return b.String()
+}
+
+// TestRenderers tests the output of the built-in renderers
+func TestRenderers(t *testing.T) {
+	hash := tools.HashResult{Algorithm: "sha256", Encoding: "hex", Hash: "abc"}
+	result := render.Result{Message: "done", Data: hash, Text: hash.String()}
+
+	assert.Equal(t, "{\"success\":true,\"message\":\"done\",\"data\":{\"algorithm\":\"sha256\",\"encoding\":\"hex\",\"hash\":\"abc\"}}\n",
+		renderAs(t, "json", result))
+	assert.Equal(t, "abc", renderAs(t, "raw", result))
+	assert.Equal(t, "algorithm,encoding,hash\nsha256,hex,abc\n", renderAs(t, "csv", result))
+	assert.Equal(t, "| algorithm | encoding | hash |\n| --- | --- | --- |\n| sha256 | hex | abc |\n", renderAs(t, "markdown", result))
+	assert.Equal(t, "data:\n  algorithm: sha256\n  encoding: hex\n  hash: abc\nmessage: done\nsuccess: true\n", renderAs(t, "yaml", result))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<response>
+  <data>
+    <algorithm>sha256</algorithm>
+    <encoding>hex</encoding>
+    <hash>abc</hash>
+  </data>
+  <message>done</message>
+  <success>true</success>
+</response>`, renderAs(t, "xml", result))
+
+	// The HTML renderer escapes the result in the result page
+	page := renderAs(t, "html", render.Result{
+		Data:   "<script>",
+		Text:   "<script>",
+		Tool:   models.ToolInfo{Name: "echo", Parameters: []models.ToolParameter{{Name: "text", Type: "string"}}},
+		Values: map[string]string{"text": "<script>"},
+	})
+	assert.Contains(t, page, "&lt;script&gt;</pre>")
+	assert.NotContains(t, page, "<script></pre>")
+
+	// Without templates it falls back to a minimal page
+	manager := templates.TemplateManager
+	templates.TemplateManager = nil
+	defer func() { templates.TemplateManager = manager }()
+	assert.Equal(t, "<html><body><p>&lt;script&gt;</p></body></html>", renderAs(t, "html", render.Result{Data: "<script>", Text: "<script>"}))
+
+	// Scalars are a single result column, arrays a row per item
+	assert.Equal(t, "result\n42\n", renderAs(t, "csv", render.Result{Data: 42, Text: "42"}))
+	assert.Equal(t, "id,name\n1,\"a,b\"\n2,\n", renderAs(t, "csv", render.Result{Data: []map[string]any{
+		{"id": 1, "name": "a,b"},
+		{"id": 2},
+	}}))
+	assert.Equal(t, "| result |\n| --- |\n| a\\|b<br>c |\n", renderAs(t, "markdown", render.Result{Data: "a|b\nc"}))
+}
+
+// TestToolsHandlerOutputFormats tests content negotiation in the tools handler
+func TestToolsHandlerOutputFormats(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler)
+
+	testCases := []struct {
+		name           string
+		url            string
+		accept         string
+		expectedStatus int
+		expectedType   string
+		expectedBody   string
+	}{
+		{"CSV output format", "/tools/random-number?min=3&max=3&output_format=csv", "", http.StatusOK, "text/csv", "result\n3\n"},
+		{"Markdown Accept header", "/tools/random-number?min=3&max=3", "text/markdown", http.StatusOK, "text/markdown", "| result |\n| --- |\n| 3 |\n"},
+		{"Quality values", "/tools/random-number?min=3&max=3", "application/json;q=0.2, text/plain;q=0.8", http.StatusOK, "text/plain", "3"},
+		{"Unknown output format", "/tools/random-number?min=3&max=3&output_format=pdf", "", http.StatusNotAcceptable, "application/json", "unknown output format pdf"},
+		{"Unacceptable Accept header", "/tools/random-number?min=3&max=3", "image/png", http.StatusNotAcceptable, "application/json", "none of the available formats"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.Equal(t, tc.expectedType, rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), tc.expectedBody)
+		})
+	}
+}
+
+// TestToolsHandlerHTMLResult tests the HTML result page of a tool
+func TestToolsHandlerHTMLResult(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler)
+
+	form := url.Values{"text": {"<b>Hi</b>"}, "uppercase": {"on"}, "output_format": {"html"}}
+	req := httptest.NewRequest("POST", "/tools/text-formatter", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html", rr.Header().Get("Content-Type"))
+
+	body := rr.Body.String()
+	// The result is escaped and can be copied
+	assert.Contains(t, body, "&lt;B&gt;HI&lt;/B&gt;</pre>")
+	assert.NotContains(t, body, "<B>HI</B>")
+	assert.Contains(t, body, "copyResult()")
+	// The form is shown again with the submitted values
+	assert.Contains(t, body, `<form action="/tools/text-formatter" method="post">`)
+	assert.Contains(t, body, "&lt;b&gt;Hi&lt;/b&gt;</textarea>")
+	assert.Contains(t, body, `name="uppercase" checked>`)
+}
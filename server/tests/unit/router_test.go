@@ -24,6 +24,7 @@ func TestRouterConfiguration(t *testing.T) {
 	r.HandleFunc("/docs/", handlers.DocsBaseHandler).Methods("GET")
 	r.HandleFunc("/docs/{tool_name}", handlers.DocsToolHandler).Methods("GET")
 	r.HandleFunc("/tools/{tool_name}", handlers.ToolsHandler).Methods("GET", "POST")
+	r.HandleFunc("/openapi.json", handlers.OpenAPIHandler).Methods("GET")
 	r.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
 	
 	// Test cases for route matching
@@ -41,6 +42,7 @@ func TestRouterConfiguration(t *testing.T) {
 		{"Docs for specific tool", "GET", "/docs/random-number", http.StatusOK, true},
 		{"Tool GET request", "GET", "/tools/random-number", http.StatusOK, true},
 		{"Tool POST request", "POST", "/tools/random-number", http.StatusOK, true},
+		{"OpenAPI document", "GET", "/openapi.json", http.StatusOK, true},
 		// The NotFoundHandler will handle non-existent paths, so all paths will match the router
 		{"Non-existent path", "GET", "/nonexistent", http.StatusNotFound, true},
 	}
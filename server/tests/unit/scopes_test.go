@@ -0,0 +1,179 @@
+package unit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseScopes tests parsing and checking scopes
+func TestParseScopes(t *testing.T) {
+	scopes, err := models.ParseScopes("storage:write, storage:read storage:write")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"storage:read", "storage:write"}, scopes)
+
+	scopes, err = models.ParseScopes("")
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+
+	_, err = models.ParseScopes("storage:read everything")
+	assert.ErrorContains(t, err, `unknown scope "everything"`)
+
+	assert.True(t, models.HasScopes([]string{"storage:read"}, "storage:read"))
+	assert.False(t, models.HasScopes([]string{"storage:read"}, "storage:read", "storage:write"))
+	assert.True(t, models.HasScopes([]string{"admin"}, "storage:read", "storage:write"))
+	assert.True(t, models.HasScopes(nil))
+
+	// API keys never get more scopes than their user
+	assert.Equal(t, []string{"storage:read"}, models.LimitScopes([]string{"admin", "storage:read"}, []string{"storage:read"}))
+	assert.Equal(t, []string{"admin"}, models.LimitScopes([]string{"admin"}, []string{"admin"}))
+}
+
+// TestPrivateToolScopes tests that private tools and endpoints check the scopes of the caller
+func TestPrivateToolScopes(t *testing.T) {
+	useFakeUsers(t)
+	store := useFakeAPIKeys(t)
+
+	router := mux.NewRouter()
+	private := router.PathPrefix("/private").Subrouter()
+	private.Use(middleware.AuthMiddleware)
+	private.HandleFunc("/tools", handlers.PrivateToolsListHandler).Methods("GET")
+	private.HandleFunc("/tools/{tool_name}", handlers.PrivateToolsHandler).Methods("GET", "POST")
+	private.Handle("/maintenance/cleanup",
+		middleware.RequireScopes(models.ScopeAdmin)(http.HandlerFunc(handlers.DatabaseCleanupHandler))).Methods("POST")
+	router.Handle("/pipeline", middleware.OptionalAuthMiddleware(http.HandlerFunc(handlers.PipelineHandler))).Methods("POST")
+	serve := func(req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	reader := loginCookieFor(t, "reader")
+
+	// The tools declare their scopes
+	info, err := models.GetPrivateToolInfo("text-storage")
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.ScopeStorageWrite}, info.Scopes)
+
+	// The listing only shows the tools the caller may use
+	rr := serve(httptest.NewRequest("GET", "/private/tools", nil), reader)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var listing struct {
+		Data []models.PrivateToolInfo `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+	require.Len(t, listing.Data, 1)
+	assert.Equal(t, "text-retrieval", listing.Data[0].Name)
+
+	rr = serve(httptest.NewRequest("GET", "/private/tools", nil), loginCookie(t))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+	assert.Len(t, listing.Data, 6)
+
+	// Tools without the scopes of the caller are forbidden
+	assert.Equal(t, http.StatusOK, serve(httptest.NewRequest("GET", "/private/tools/text-retrieval", nil), reader).Code)
+	rr = serve(httptest.NewRequest("POST", "/private/tools/text-storage?content=hello", nil), reader)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+	assert.Contains(t, rr.Body.String(), models.ScopeStorageWrite)
+	for _, tool := range []string{"text-replace", "text-append", "text-save", "text-delete"} {
+		assert.Equal(t, http.StatusForbidden, serve(httptest.NewRequest("GET", "/private/tools/"+tool, nil), reader).Code, tool)
+	}
+
+	// API keys are limited to their own scopes
+	_, key, err := middleware.CreateAPIKey(store, "alice-id", "read only", []string{models.ScopeStorageRead}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(bearerRequest("GET", "/private/tools/text-retrieval", key), nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(bearerRequest("GET", "/private/tools/text-storage", key), nil).Code)
+
+	// Pipelines check the scopes of their private steps
+	pipeline := `{"steps":[{"tool":"text-storage","params":{"content":"hello"}}]}`
+	req := httptest.NewRequest("POST", "/pipeline", strings.NewReader(pipeline))
+	req.Header.Set("Content-Type", "application/json")
+	rr = serve(req, reader)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// API keys authenticate the private steps of pipelines, invalid keys are rejected
+	req = bearerRequest("POST", "/pipeline", key)
+	req.Body = io.NopCloser(strings.NewReader(pipeline))
+	req.Header.Set("Content-Type", "application/json")
+	rr = serve(req, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.ScopeStorageWrite)
+	req = bearerRequest("POST", "/pipeline", "amt_unknown")
+	req.Body = io.NopCloser(strings.NewReader(pipeline))
+	assert.Equal(t, http.StatusUnauthorized, serve(req, nil).Code)
+	req = httptest.NewRequest("POST", "/pipeline", strings.NewReader(pipeline))
+	req.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, http.StatusUnauthorized, serve(req, nil).Code)
+
+	// Maintenance endpoints need the admin scope
+	rr = serve(httptest.NewRequest("POST", "/private/maintenance/cleanup", nil), loginCookie(t))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.ScopeAdmin)
+}
+
+// TestAPIKeyScopes tests the scopes of API keys created through the private endpoints
+func TestAPIKeyScopes(t *testing.T) {
+	useFakeUsers(t)
+	store := useFakeAPIKeys(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/private/api-keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(loginCookieFor(t, "reader"))
+		rr := httptest.NewRecorder()
+		middleware.AuthMiddleware(http.HandlerFunc(handlers.CreateAPIKeyHandler)).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Keys get the scopes of the user by default
+	require.Equal(t, http.StatusCreated, create(`{"name":"default"}`).Code)
+	assert.Equal(t, []string{models.ScopeStorageRead}, store.keys["key-1"].Scopes)
+
+	// Keys cannot get scopes the user does not have
+	assert.Equal(t, http.StatusForbidden, create(`{"name":"writer","scopes":["storage:write"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"name":"unknown","scopes":["everything"]}`).Code)
+	assert.Len(t, store.keys, 1)
+}
+
+// TestSessionScopesChanged tests that changed scopes apply to sessions that are already logged in
+func TestSessionScopesChanged(t *testing.T) {
+	users := useFakeUsers(t)
+	cookie := loginCookie(t)
+
+	handler := middleware.AuthMiddleware(middleware.RequireScopes(models.ScopeStorageWrite)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	serve := func() int {
+		req := httptest.NewRequest("GET", "/private/tools/text-storage", nil)
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, serve())
+
+	// Revoking a scope applies to the live session
+	require.NoError(t, users.UpdateScopes("alice-id", []string{models.ScopeStorageRead}))
+	assert.Equal(t, http.StatusForbidden, serve())
+
+	// Granting it again does too
+	require.NoError(t, users.UpdateScopes("alice-id", aliceScopes))
+	assert.Equal(t, http.StatusOK, serve())
+
+	// The session of a deleted user ends
+	delete(users, "alice")
+	assert.Equal(t, http.StatusUnauthorized, serve())
+}
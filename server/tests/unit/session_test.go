@@ -0,0 +1,229 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSessionStore is an in-memory SessionStore
+type fakeSessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*database.Session
+	nextID   int
+}
+
+// newFakeSessionStore creates an empty session store
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{sessions: make(map[string]*database.Session)}
+}
+
+// CreateSession records a session
+func (s *fakeSessionStore) CreateSession(userID string, expiresAt time.Time, userAgent string, ipAddress string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	id := fmt.Sprintf("session-%d", s.nextID)
+	s.sessions[id] = &database.Session{ID: id, UserID: userID, CreatedAt: time.Now(), ExpiresAt: expiresAt, UserAgent: userAgent, IPAddress: ipAddress}
+	return id, nil
+}
+
+// GetSession returns a session by ID
+func (s *fakeSessionStore) GetSession(id string) (*database.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions of a user
+func (s *fakeSessionStore) ListSessions(userID string) ([]*database.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions := []*database.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteSession removes a session
+func (s *fakeSessionStore) DeleteSession(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return database.ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+// configureSessions applies a session configuration for the duration of a test
+func configureSessions(t *testing.T, config middleware.SessionConfig) {
+	require.NoError(t, middleware.ConfigureSessions(config))
+	t.Cleanup(func() { middleware.ConfigureSessions(middleware.SessionConfig{}) })
+}
+
+// authenticated reports whether a request with the cookie is authenticated
+func authenticated(cookie *http.Cookie) bool {
+	req := httptest.NewRequest("GET", "/private/tools", nil)
+	req.AddCookie(cookie)
+	return middleware.IsAuthenticated(req)
+}
+
+// sessionKey returns a session key with fixed bytes
+func sessionKey(b byte) middleware.SessionKey {
+	return middleware.SessionKey{HashKey: bytes.Repeat([]byte{b}, 64), BlockKey: bytes.Repeat([]byte{b}, 32)}
+}
+
+// TestParseSessionKeys tests parsing the SESSION_KEYS setting
+func TestParseSessionKeys(t *testing.T) {
+	hashKey := bytes.Repeat([]byte{1}, 64)
+	blockKey := bytes.Repeat([]byte{2}, 32)
+	encodedHash := base64.StdEncoding.EncodeToString(hashKey)
+	encodedBlock := base64.StdEncoding.EncodeToString(blockKey)
+
+	keys, err := middleware.ParseSessionKeys(encodedHash + ":" + encodedBlock + ", " + encodedHash + ",")
+	require.NoError(t, err)
+	assert.Equal(t, []middleware.SessionKey{{HashKey: hashKey, BlockKey: blockKey}, {HashKey: hashKey}}, keys)
+
+	keys, err = middleware.ParseSessionKeys("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = middleware.ParseSessionKeys("not base64!")
+	assert.ErrorContains(t, err, "session key 1: invalid base64 hash key")
+	_, err = middleware.ParseSessionKeys(encodedHash + ":???")
+	assert.ErrorContains(t, err, "session key 1: invalid base64 block key")
+
+	// Keys that are too short are rejected when configuring the sessions
+	err = middleware.ConfigureSessions(middleware.SessionConfig{Keys: []middleware.SessionKey{{HashKey: []byte("short")}}})
+	assert.ErrorContains(t, err, "hash key must be at least 32 bytes")
+	err = middleware.ConfigureSessions(middleware.SessionConfig{Keys: []middleware.SessionKey{{HashKey: hashKey, BlockKey: []byte("short")}}})
+	assert.ErrorContains(t, err, "block key must be 16, 24 or 32 bytes")
+}
+
+// TestSessionKeyRotation tests that cookies survive a restart with the same keys and a key rotation
+func TestSessionKeyRotation(t *testing.T) {
+	useFakeUsers(t)
+	oldKey, newKey := sessionKey(1), sessionKey(2)
+
+	configureSessions(t, middleware.SessionConfig{Keys: []middleware.SessionKey{oldKey}})
+	cookie := loginCookie(t)
+	assert.True(t, authenticated(cookie))
+
+	// A restart with the same keys keeps the session
+	configureSessions(t, middleware.SessionConfig{Keys: []middleware.SessionKey{oldKey}})
+	assert.True(t, authenticated(cookie))
+
+	// After a rotation, cookies of the old key still decode and new cookies use the new key
+	configureSessions(t, middleware.SessionConfig{Keys: []middleware.SessionKey{newKey, oldKey}})
+	assert.True(t, authenticated(cookie))
+	newCookie := loginCookie(t)
+
+	// Once the old key is removed, only the cookies of the new key are accepted
+	configureSessions(t, middleware.SessionConfig{Keys: []middleware.SessionKey{newKey}})
+	assert.False(t, authenticated(cookie))
+	assert.True(t, authenticated(newCookie))
+}
+
+// TestServerSideSessions tests listing, revoking and logging out server-side sessions
+func TestServerSideSessions(t *testing.T) {
+	useFakeUsers(t)
+	store := newFakeSessionStore()
+	configureSessions(t, middleware.SessionConfig{Store: store})
+
+	router := mux.NewRouter()
+	private := router.PathPrefix("/private").Subrouter()
+	private.Use(middleware.AuthMiddleware)
+	private.HandleFunc("/sessions", handlers.SessionsHandler).Methods("GET")
+	private.HandleFunc("/sessions/{session_id}", handlers.RevokeSessionHandler).Methods("DELETE")
+	serve := func(method string, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Every login creates a session
+	first := loginCookie(t)
+	second := loginCookie(t)
+	assert.Len(t, store.sessions, 2)
+	assert.True(t, authenticated(first))
+
+	// The sessions of the user are listed, with the session of the request marked as current
+	rr := serve("GET", "/private/sessions", first)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data []struct {
+			ID      string `json:"id"`
+			UserID  string `json:"user_id"`
+			Current bool   `json:"current"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	current := 0
+	for _, session := range response.Data {
+		assert.Equal(t, "alice-id", session.UserID)
+		if session.Current {
+			current++
+		}
+	}
+	assert.Equal(t, 1, current)
+
+	// Sessions of other users cannot be revoked
+	otherID, _ := store.CreateSession("bob-id", time.Now().Add(time.Hour), "", "")
+	rr = serve("DELETE", "/private/sessions/"+otherID, first)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Len(t, store.sessions, 3)
+
+	// Revoking a session logs it out
+	var secondID string
+	for _, session := range response.Data {
+		if !session.Current {
+			secondID = session.ID
+		}
+	}
+	rr = serve("DELETE", "/private/sessions/"+secondID, first)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, authenticated(second))
+	assert.True(t, authenticated(first))
+
+	// Logging out deletes the session, so a copy of the cookie no longer works
+	req := httptest.NewRequest("GET", "/logout", nil)
+	req.AddCookie(first)
+	handlers.LogoutHandler(httptest.NewRecorder(), req)
+	assert.False(t, authenticated(first))
+	assert.Len(t, store.sessions, 1)
+}
+
+// TestSessionsDisabled tests the session endpoints without a session store
+func TestSessionsDisabled(t *testing.T) {
+	useFakeUsers(t)
+	req := httptest.NewRequest("GET", "/private/sessions", nil)
+	req.AddCookie(loginCookie(t))
+	rr := httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(handlers.SessionsHandler)).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "SESSION_STORE=database")
+}
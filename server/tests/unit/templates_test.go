@@ -2,15 +2,22 @@
 package unit
 
 import (
+	"html/template"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/CJFEdu/allmitools/server/internal/handlers"
 	"github.com/CJFEdu/allmitools/server/internal/models"
 	"github.com/CJFEdu/allmitools/server/internal/templates"
+	embedded "github.com/CJFEdu/allmitools/server/templates"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestTemplateInitialization tests the initialization of the template manager
@@ -234,3 +241,139 @@ func TestContentTypeNegotiation(t *testing.T) {
 		})
 	}
 }
+
+// copyTemplates copies the embedded templates to a temporary directory and returns it
+func copyTemplates(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "partials"), 0755))
+	files, err := fs.Glob(embedded.FS, "*.html")
+	require.NoError(t, err)
+	partials, err := fs.Glob(embedded.FS, "partials/*.html")
+	require.NoError(t, err)
+	for _, name := range append(files, partials...) {
+		content, err := fs.ReadFile(embedded.FS, name)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0644))
+	}
+	return dir
+}
+
+// renderHome renders the home page with the current template manager
+func renderHome(t *testing.T) string {
+	rr := httptest.NewRecorder()
+	handlers.HomeHandler(rr, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+// TestTemplateSetup tests loading the embedded templates and overriding them from disk
+func TestTemplateSetup(t *testing.T) {
+	defer templates.Initialize("../../")
+
+	// The embedded templates do not depend on the working directory
+	require.NoError(t, templates.Setup(templates.Config{}))
+	assert.Contains(t, renderHome(t), "Welcome to AllMiTools")
+
+	// A templates directory overrides the embedded templates
+	dir := copyTemplates(t)
+	homePath := filepath.Join(dir, "home.html")
+	require.NoError(t, os.WriteFile(homePath, []byte(`{{ define "content" }}<p>Custom home</p>{{ end }}`), 0644))
+	require.NoError(t, templates.Setup(templates.Config{Dir: dir}))
+	assert.Contains(t, renderHome(t), "Custom home")
+
+	// Without reload, edits are picked up on the next start only
+	require.NoError(t, os.WriteFile(homePath, []byte(`{{ define "content" }}<p>Edited home</p>{{ end }}`), 0644))
+	assert.Contains(t, renderHome(t), "Custom home")
+
+	// With reload, every render parses the templates again
+	require.NoError(t, templates.Setup(templates.Config{Dir: dir, Reload: true}))
+	require.NoError(t, os.WriteFile(homePath, []byte(`{{ define "content" }}<p>Live home</p>{{ end }}`), 0644))
+	assert.Contains(t, renderHome(t), "Live home")
+
+	// A missing directory fails the setup
+	err := templates.Setup(templates.Config{Dir: filepath.Join(dir, "missing")})
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+// TestTemplateDiscovery tests discovering page templates and partials from the templates directory
+func TestTemplateDiscovery(t *testing.T) {
+	defer templates.Initialize("../../")
+
+	// A new page and a new partial are picked up without registering them
+	dir := copyTemplates(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "greeting.html"),
+		[]byte(`{{ define "greeting" }}<p>Hello {{ . }}</p>{{ end }}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra.html"),
+		[]byte(`{{ define "content" }}{{ template "greeting" .Name }}{{ end }}`), 0644))
+	require.NoError(t, templates.Setup(templates.Config{Dir: dir}))
+
+	rr := httptest.NewRecorder()
+	require.NoError(t, templates.TemplateManager.RenderTemplate(rr, nil, "extra", map[string]interface{}{"Title": "Extra", "Name": "World"}))
+	assert.Contains(t, rr.Body.String(), "<p>Hello World</p>")
+
+	// An unknown template is reported when rendering
+	err := templates.TemplateManager.RenderTemplate(httptest.NewRecorder(), nil, "missing", nil)
+	assert.ErrorContains(t, err, "template missing does not exist")
+
+	// A template rendered by a handler that does not exist fails the setup
+	require.NoError(t, os.Remove(filepath.Join(dir, "home.html")))
+	err = templates.Setup(templates.Config{Dir: dir})
+	assert.ErrorContains(t, err, "home.html does not exist")
+}
+
+// TestTemplateFuncs tests the custom template functions
+func TestTemplateFuncs(t *testing.T) {
+	execute := func(text string, data interface{}) string {
+		tmpl := template.Must(template.New("test").Funcs(templates.Funcs()).Parse(text))
+		var b strings.Builder
+		require.NoError(t, tmpl.Execute(&b, data))
+		return b.String()
+	}
+
+	// json encodes the value as indented JSON and escapes it for HTML
+	encoded := execute(`{{ json . }}`, map[string]string{"name": "<b>"})
+	assert.Contains(t, encoded, "\n  &#34;name&#34;: ")
+	assert.NotContains(t, encoded, "<b>")
+
+	// join accepts string slices and other slices
+	assert.Equal(t, "a, b", execute(`{{ join . ", " }}`, []string{"a", "b"}))
+	assert.Equal(t, "1|2", execute(`{{ join . "|" }}`, []int{1, 2}))
+
+	// markdown renders the supported syntax
+	markdown := string(templates.Markdown("# Title\n\nSome **bold** and *italic* `code`\n\n- one\n- two\n\n[link](https://example.com)"))
+	assert.Contains(t, markdown, "<h1>Title</h1>")
+	assert.Contains(t, markdown, "<strong>bold</strong>")
+	assert.Contains(t, markdown, "<em>italic</em>")
+	assert.Contains(t, markdown, "<code>code</code>")
+	assert.Contains(t, markdown, "<ul>\n<li>one</li>\n<li>two</li>\n</ul>")
+	assert.Contains(t, markdown, `<a href="https://example.com">link</a>`)
+
+	// markdown escapes raw HTML and drops unsafe links
+	markdown = string(templates.Markdown("<script>alert(1)</script> [click](javascript:alert(1))"))
+	assert.NotContains(t, markdown, "<script>")
+	assert.Contains(t, markdown, "&lt;script&gt;")
+	assert.NotContains(t, markdown, "javascript:")
+	assert.Contains(t, markdown, "click")
+}
+
+// TestPrivateDocsToolHandlerTemplateRendering tests the template rendering in the PrivateDocsToolHandler
+func TestPrivateDocsToolHandlerTemplateRendering(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+
+	privateTools := models.GetAllPrivateTools()
+	if len(privateTools) == 0 {
+		t.Skip("No private tools available for testing")
+	}
+	toolName := privateTools[0].Name
+
+	router := mux.NewRouter()
+	router.HandleFunc("/private/docs/{tool_name}", handlers.PrivateDocsToolHandler)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/private/docs/"+toolName, nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, toolName+" Documentation (Private)")
+	assert.Contains(t, body, "/private/tools/"+toolName)
+	assert.Contains(t, body, "Parameters")
+}
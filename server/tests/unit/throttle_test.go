@@ -0,0 +1,278 @@
+package unit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAuditRecorder keeps the recorded audit events in memory
+type fakeAuditRecorder struct {
+	mutex  sync.Mutex
+	events []audit.Event
+	filter audit.Filter // Filter of the last query
+}
+
+// RecordEvent records an audit event
+func (r *fakeAuditRecorder) RecordEvent(event audit.Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// ListEvents returns the recorded events of the type of the filter, newest first
+func (r *fakeAuditRecorder) ListEvents(filter audit.Filter) ([]audit.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.filter = filter
+	events := []audit.Event{}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if filter.Type == "" || r.events[i].Type == filter.Type {
+			events = append(events, r.events[i])
+		}
+	}
+	return events, nil
+}
+
+// types returns the types of the recorded events
+func (r *fakeAuditRecorder) types() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	types := make([]string, len(r.events))
+	for i, event := range r.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+// useFakeAudit installs an audit recorder for the duration of a test
+func useFakeAudit(t *testing.T) *fakeAuditRecorder {
+	recorder := &fakeAuditRecorder{}
+	audit.SetRecorder(recorder)
+	t.Cleanup(func() { audit.SetRecorder(nil) })
+	return recorder
+}
+
+// TestLoginThrottle tests the delays and lockouts of failed logins
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := middleware.NewLoginThrottle(middleware.ThrottleConfig{
+		Account: middleware.ThrottlePolicy{FreeAttempts: 2, MaxFailures: 5, BaseDelay: time.Second, Lockout: time.Minute},
+		IP:      middleware.ThrottlePolicy{FreeAttempts: 10, MaxFailures: 20, BaseDelay: time.Second, Lockout: time.Minute},
+		Now:     func() time.Time { return now },
+	})
+
+	// The first failures are free
+	for i := 0; i < 2; i++ {
+		throttle.Failure("192.0.2.1", "alice")
+	}
+	assert.Zero(t, throttle.Check("192.0.2.1", "alice"))
+
+	// Then the delay doubles with each failure
+	throttle.Failure("192.0.2.1", "alice")
+	assert.Equal(t, time.Second, throttle.Check("192.0.2.1", "alice"))
+	throttle.Failure("192.0.2.1", "alice")
+	assert.Equal(t, 2*time.Second, throttle.Check("192.0.2.1", "alice"))
+
+	// The account is delayed from every IP address, other accounts are not
+	assert.Equal(t, 2*time.Second, throttle.Check("198.51.100.7", "alice"))
+	assert.Zero(t, throttle.Check("192.0.2.1", "reader"))
+	now = now.Add(2 * time.Second)
+	assert.Zero(t, throttle.Check("192.0.2.1", "alice"))
+
+	// Reaching the maximum locks the account out
+	accountLocked, ipLocked := throttle.Failure("192.0.2.1", "alice")
+	assert.True(t, accountLocked)
+	assert.False(t, ipLocked)
+	assert.Equal(t, time.Minute, throttle.Check("192.0.2.1", "alice"))
+
+	// The lockout ends, and a successful login forgets the failures
+	now = now.Add(time.Minute)
+	assert.Zero(t, throttle.Check("192.0.2.1", "alice"))
+	for i := 0; i < 4; i++ {
+		throttle.Failure("192.0.2.1", "alice")
+	}
+	throttle.Success("192.0.2.1", "alice")
+	assert.Zero(t, throttle.Check("192.0.2.1", "alice"))
+
+	// Guessing many accounts from one IP address delays that address
+	for i := 0; i < 11; i++ {
+		throttle.Failure("203.0.113.9", "")
+	}
+	assert.Equal(t, time.Second, throttle.Check("203.0.113.9", "reader"))
+	assert.Zero(t, throttle.Check("192.0.2.1", "reader"))
+}
+
+// gatedUserStore is a user store whose lookups wait until its gate is closed
+type gatedUserStore struct {
+	fakeUserStore
+	gate chan struct{}
+}
+
+// GetUserByUsername returns the user with the given username once the gate is closed
+func (s gatedUserStore) GetUserByUsername(username string) (*database.User, error) {
+	<-s.gate
+	return s.fakeUserStore.GetUserByUsername(username)
+}
+
+// TestLoginThrottleConcurrent tests that logins whose password is still being checked count towards the limit
+func TestLoginThrottleConcurrent(t *testing.T) {
+	store := gatedUserStore{fakeUserStore: useFakeUsers(t), gate: make(chan struct{})}
+	middleware.SetUserStore(store)
+	useFakeAudit(t)
+	middleware.ConfigureLoginThrottle(middleware.ThrottleConfig{
+		Account: middleware.ThrottlePolicy{FreeAttempts: 2, MaxFailures: 3, BaseDelay: time.Second, Lockout: time.Minute},
+		IP:      middleware.DefaultThrottleConfig.IP,
+	})
+
+	// All guesses start before any password is checked
+	const guesses = 10
+	results := make(chan error, guesses)
+	for i := 0; i < guesses; i++ {
+		go func() {
+			_, err := middleware.AuthenticateLogin(httptest.NewRequest("POST", "/login", nil),
+				middleware.Credentials{Username: "alice", Password: "wrong"})
+			results <- err
+		}()
+	}
+
+	// Only as many guesses as the account allows get to check their password, the others are rejected right away
+	var throttled *middleware.ThrottledError
+	for i := 0; i < guesses-3; i++ {
+		select {
+		case err := <-results:
+			assert.True(t, errors.As(err, &throttled), "unexpected error %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("guesses were not throttled while passwords were being checked")
+		}
+	}
+	close(store.gate)
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, <-results, middleware.ErrInvalidCredentials)
+	}
+
+	// The failures lock the account out
+	_, err := middleware.AuthenticateLogin(httptest.NewRequest("POST", "/login", nil),
+		middleware.Credentials{Username: "alice", Password: "secret"})
+	require.True(t, errors.As(err, &throttled))
+	assert.Equal(t, time.Minute, throttled.RetryAfter.Round(time.Minute))
+}
+
+// TestLoginLockout tests that the login page and AuthMiddleware reject logins after too many failures
+func TestLoginLockout(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	useFakeUsers(t)
+	recorder := useFakeAudit(t)
+	middleware.ConfigureLoginThrottle(middleware.ThrottleConfig{
+		Account: middleware.ThrottlePolicy{FreeAttempts: 1, MaxFailures: 2, BaseDelay: time.Second, Lockout: time.Minute},
+		IP:      middleware.DefaultThrottleConfig.IP,
+	})
+	login := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader("username=alice&password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "test-agent")
+		rr := httptest.NewRecorder()
+		handlers.LoginHandler(rr, req)
+		return rr
+	}
+
+	// Failed attempts are recorded as audit events, the last one locks the account
+	assert.Equal(t, http.StatusOK, login("wrong").Code)
+	assert.Equal(t, http.StatusOK, login("wrong").Code)
+	assert.Equal(t, []string{audit.EventLoginFailed, audit.EventLoginFailed, audit.EventLockout}, recorder.types())
+	assert.Equal(t, "alice", recorder.events[0].Username)
+	assert.Equal(t, "192.0.2.1", recorder.events[0].IPAddress)
+	assert.Equal(t, "test-agent", recorder.events[0].UserAgent)
+
+	// Even the right password is rejected during the lockout
+	rr := login("secret")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "Too many failed login attempts")
+	assert.Empty(t, rr.Result().Cookies())
+	assert.Equal(t, audit.EventLoginThrottled, recorder.types()[3])
+
+	// The password fallback of AuthMiddleware is locked out as well
+	req := httptest.NewRequest("POST", "/private/tools/text-storage", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), `"code":"too_many_requests"`)
+}
+
+// TestClientIP tests that forwarding headers are only honoured from trusted proxies
+func TestClientIP(t *testing.T) {
+	proxies, err := middleware.ParseTrustedProxies("10.0.0.1, 172.16.0.0/12")
+	require.NoError(t, err)
+	require.Len(t, proxies, 2)
+	_, err = middleware.ParseTrustedProxies("10.0.0.300")
+	assert.Error(t, err)
+	t.Cleanup(func() { middleware.ConfigureTrustedProxies(nil) })
+
+	clientIP := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return middleware.ClientIP(req)
+	}
+
+	// Without trusted proxies the headers are ignored
+	middleware.ConfigureTrustedProxies(nil)
+	assert.Equal(t, "192.0.2.1:1234", clientIP("192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}))
+	assert.Equal(t, "192.0.2.1:1234", clientIP("192.0.2.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}))
+
+	// Trusted proxies forward the client, addresses the client sent before it are ignored
+	middleware.ConfigureTrustedProxies(proxies)
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}))
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 172.16.0.5"}))
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.7"}))
+	assert.Equal(t, "10.0.0.1:1234", clientIP("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "not-an-ip"}))
+	assert.Equal(t, "192.0.2.1:1234", clientIP("192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}))
+}
+
+// TestLoginThrottleForgedHeaders tests that forged forwarding headers do not get around the IP address limit
+func TestLoginThrottleForgedHeaders(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	useFakeUsers(t)
+	recorder := useFakeAudit(t)
+	middleware.ConfigureLoginThrottle(middleware.ThrottleConfig{
+		Account: middleware.DefaultThrottleConfig.Account,
+		IP:      middleware.ThrottlePolicy{FreeAttempts: 1, MaxFailures: 2, BaseDelay: time.Second, Lockout: time.Minute},
+	})
+	t.Cleanup(func() { middleware.ConfigureLoginThrottle(middleware.DefaultThrottleConfig) })
+
+	// Each attempt guesses another account and claims another address
+	for i, username := range []string{"alice", "reader", "bob"} {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader("username="+username+"&password=wrong"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		rr := httptest.NewRecorder()
+		handlers.LoginHandler(rr, req)
+		if i < 2 {
+			assert.Equal(t, http.StatusOK, rr.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		}
+	}
+	for _, event := range recorder.events {
+		assert.Equal(t, "192.0.2.1", event.IPAddress)
+	}
+}
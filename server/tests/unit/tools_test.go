@@ -2,11 +2,22 @@
 package unit
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/CJFEdu/allmitools/server/internal/models"
 	"github.com/CJFEdu/allmitools/server/internal/tools"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestRandomNumberValidation tests the validation of random number parameters
@@ -41,6 +52,22 @@ func TestRandomNumberValidation(t *testing.T) {
 			},
 			expectedError: "",
 		},
+		{
+			name: "Min out of range",
+			params: tools.RandomNumberParams{
+				Min: math.MinInt64,
+				Max: 1,
+			},
+			expectedError: "minimum value is out of range",
+		},
+		{
+			name: "Max out of range",
+			params: tools.RandomNumberParams{
+				Min: 1,
+				Max: math.MaxInt64,
+			},
+			expectedError: "maximum value is out of range",
+		},
 	}
 
 	// Run the test cases
@@ -92,6 +119,15 @@ func TestGenerateRandomNumber(t *testing.T) {
 			expectError:   true,
 			validateRange: false,
 		},
+		{
+			name: "Error with a range that overflows",
+			params: tools.RandomNumberParams{
+				Min: math.MinInt64,
+				Max: math.MaxInt64,
+			},
+			expectError:   true,
+			validateRange: false,
+		},
 	}
 
 	// Run the test cases
@@ -369,3 +405,227 @@ func TestGenerateTextFile(t *testing.T) {
 		})
 	}
 }
+
+// bindTestParams is the parameter list used by TestBindParams
+var bindTestParams = []models.ToolParameter{
+	{Name: "text", Type: "string", Required: true},
+	{Name: "count", Type: "int", Default: 3},
+	{Name: "ratio", Type: "float", Default: 0.5},
+	{Name: "enabled", Type: "bool", Default: false},
+}
+
+// bindTestTarget is the struct bound by TestBindParams
+type bindTestTarget struct {
+	Text    string  `json:"text"`
+	Count   int     `json:"count"`
+	Ratio   float64 `json:"ratio"`
+	Enabled bool    `json:"enabled"`
+}
+
+// newMultipartRequest creates a multipart POST request with the given fields
+func newMultipartRequest(t *testing.T, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		assert.NoError(t, writer.WriteField(name, value))
+	}
+	assert.NoError(t, writer.Close())
+
+	req, err := http.NewRequest("POST", "/tools/test", body)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// TestBindParams tests binding tool parameters from the different request formats
+func TestBindParams(t *testing.T) {
+	form := url.Values{"text": {"hello"}, "count": {"7"}, "enabled": {"on"}}
+	formReq, _ := http.NewRequest("POST", "/tools/test", strings.NewReader(form.Encode()))
+	formReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	jsonReq, _ := http.NewRequest("POST", "/tools/test", strings.NewReader(`{"text":"hello","count":7,"ratio":1.5,"enabled":true}`))
+	jsonReq.Header.Set("Content-Type", "application/json")
+
+	queryReq, _ := http.NewRequest("GET", "/tools/test?text=hello&count=7&enabled=1", nil)
+	emptyPostReq, _ := http.NewRequest("POST", "/tools/test?text=hello", nil)
+	missingReq, _ := http.NewRequest("GET", "/tools/test?count=7", nil)
+	badIntReq, _ := http.NewRequest("GET", "/tools/test?text=hello&count=seven", nil)
+	badBoolReq, _ := http.NewRequest("GET", "/tools/test?text=hello&enabled=maybe", nil)
+
+	testCases := []struct {
+		name          string
+		req           *http.Request
+		expected      bindTestTarget
+		expectedError string
+	}{
+		{"Query string", queryReq, bindTestTarget{Text: "hello", Count: 7, Ratio: 0.5, Enabled: true}, ""},
+		{"Urlencoded form", formReq, bindTestTarget{Text: "hello", Count: 7, Ratio: 0.5, Enabled: true}, ""},
+		{"Multipart form", newMultipartRequest(t, map[string]string{"text": "hello", "ratio": "2.5"}), bindTestTarget{Text: "hello", Count: 3, Ratio: 2.5}, ""},
+		{"JSON body", jsonReq, bindTestTarget{Text: "hello", Count: 7, Ratio: 1.5, Enabled: true}, ""},
+		{"POST without body falls back to query", emptyPostReq, bindTestTarget{Text: "hello", Count: 3, Ratio: 0.5}, ""},
+		{"Missing required parameter", missingReq, bindTestTarget{}, "invalid parameters: text is required"},
+		{"Invalid integer", badIntReq, bindTestTarget{}, "invalid parameters: count must be an integer, got seven"},
+		{"Invalid boolean", badBoolReq, bindTestTarget{}, "invalid parameters: enabled must be a boolean (true, false, on, off, 1 or 0), got maybe"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var target bindTestTarget
+			err := tools.BindParams(tc.req, bindTestParams, &target)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, target)
+		})
+	}
+}
+
+// TestErrorCodes tests the error codes of the typed tool errors
+func TestErrorCodes(t *testing.T) {
+	cause := errors.New("connection refused")
+
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode tools.ErrorCode
+		expectedMsg  string
+	}{
+		{"Validation error", tools.ErrMissingRequiredParameter("text"), tools.CodeValidation, "missing required parameter: text"},
+		{"Wrapped validation error", fmt.Errorf("step failed: %w", tools.ValidateRandomNumberParams(tools.RandomNumberParams{Min: 2, Max: 1})), tools.CodeValidation, "step failed: minimum value cannot be greater than maximum value"},
+		{"Not found", tools.NotFoundError("text entry with ID x not found"), tools.CodeNotFound, "text entry with ID x not found"},
+		{"Gone", tools.GoneError("text entry with ID x has expired"), tools.CodeGone, "text entry with ID x has expired"},
+		{"Precondition failed", tools.PreconditionFailedError("text entry with ID x changed"), tools.CodePreconditionFailed, "text entry with ID x changed"},
+		{"Unauthorized", tools.UnauthorizedError("authentication required"), tools.CodeUnauthorized, "authentication required"},
+		{"Unavailable", tools.UnavailableError("database error", cause), tools.CodeUnavailable, "database error: connection refused"},
+		{"Internal", tools.InternalError("unexpected", cause), tools.CodeInternal, "unexpected: connection refused"},
+		{"Plain error", cause, tools.CodeInternal, "connection refused"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedCode, tools.CodeOf(tc.err))
+			assert.EqualError(t, tc.err, tc.expectedMsg)
+		})
+	}
+
+	// The underlying error stays available
+	assert.ErrorIs(t, tools.UnavailableError("database error", cause), cause)
+
+	// Validation errors from tools name the rejected field
+	var validationErr *tools.ValidationError
+	require.ErrorAs(t, tools.ErrMissingRequiredParameter("text"), &validationErr)
+	assert.Equal(t, []tools.FieldError{{Field: "text", Message: "is required"}}, validationErr.Fields)
+}
+
+// TestParseTTL tests parsing the time to live of stored text
+func TestParseTTL(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"90m":   90 * time.Minute,
+		"1h":    time.Hour,
+		"30d":   30 * 24 * time.Hour,
+		"never": 0,
+	} {
+		ttl, err := tools.ParseTTL(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, ttl, value)
+	}
+
+	for _, value := range []string{"", "d", "1.5d", "-1h", "0d", "soon"} {
+		_, err := tools.ParseTTL(value)
+		assert.Error(t, err, value)
+	}
+
+	// Day counts that overflow a duration are rejected rather than wrapped around
+	ttl, err := tools.ParseTTL("106751d")
+	assert.NoError(t, err)
+	assert.Equal(t, 106751*24*time.Hour, ttl)
+	for _, value := range []string{"106752d", "213504d", "9999999999d"} {
+		_, err := tools.ParseTTL(value)
+		assert.ErrorContains(t, err, "too long", value)
+	}
+}
+
+// TestTextExpiry tests the expiry of stored text with the default and maximum ttl
+func TestTextExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	t.Cleanup(func() { tools.ConfigureTextStorage(tools.DefaultTextStorageConfig) })
+
+	tools.ConfigureTextStorage(tools.TextStorageConfig{DefaultTTL: 24 * time.Hour})
+	expiresAt, err := tools.TextExpiry("", false, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(24*time.Hour), *expiresAt)
+
+	expiresAt, err = tools.TextExpiry("2h", false, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Hour), *expiresAt)
+
+	// Saved text and a ttl of never do not expire
+	for _, tc := range []struct {
+		ttl  string
+		save bool
+	}{{"", true}, {"never", true}, {"never", false}} {
+		expiresAt, err = tools.TextExpiry(tc.ttl, tc.save, now)
+		assert.NoError(t, err)
+		assert.Nil(t, expiresAt)
+	}
+
+	_, err = tools.TextExpiry("2h", true, now)
+	assert.Equal(t, tools.CodeValidation, tools.CodeOf(err))
+	_, err = tools.TextExpiry("later", false, now)
+	assert.EqualError(t, err, "invalid parameters: ttl must be a positive duration such as 1h or 30d, or never")
+
+	// With a maximum ttl, the default is reduced to it and text cannot be kept forever
+	tools.ConfigureTextStorage(tools.TextStorageConfig{DefaultTTL: 7 * 24 * time.Hour, MaxTTL: 48 * time.Hour})
+	expiresAt, err = tools.TextExpiry("", false, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(48*time.Hour), *expiresAt)
+
+	_, err = tools.TextExpiry("3d", false, now)
+	assert.EqualError(t, err, "invalid parameters: ttl must be at most 48h0m0s")
+	for _, tc := range []struct {
+		ttl  string
+		save bool
+	}{{"", true}, {"never", false}} {
+		_, err = tools.TextExpiry(tc.ttl, tc.save, now)
+		assert.Equal(t, tools.CodeValidation, tools.CodeOf(err))
+	}
+}
+
+// TestTextETag tests the entity tags of text entry versions
+func TestTextETag(t *testing.T) {
+	assert.Equal(t, `"3"`, tools.TextETag(3))
+	assert.Equal(t, `"3"`, tools.TextRetrievalResult{Version: 3}.ETag())
+
+	for etag, expected := range map[string]int64{`"3"`: 3, "3": 3, ` "12" `: 12, "*": 0, "": 0} {
+		version, err := tools.ParseTextETag(etag)
+		assert.NoError(t, err, etag)
+		assert.Equal(t, expected, version, etag)
+	}
+
+	for _, etag := range []string{`W/"3"`, `"0"`, `"abc"`, `"1", "2"`} {
+		_, err := tools.ParseTextETag(etag)
+		assert.Error(t, err, etag)
+	}
+}
+
+// TestTextSaveFormParams tests that the text save form can unsave text, where an unchecked checkbox sends nothing
+func TestTextSaveFormParams(t *testing.T) {
+	info, err := models.GetPrivateToolInfo("text-save")
+	require.NoError(t, err)
+
+	form := url.Values{"id": {"123e4567-e89b-12d3-a456-426614174000"}, "ttl": {"1h"}}
+	req := httptest.NewRequest("POST", "/private/tools/text-save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var params tools.TextSaveParams
+	require.NoError(t, tools.BindParams(req, info.Parameters, &params))
+	assert.False(t, params.Save)
+	assert.Equal(t, "1h", params.TTL)
+
+	form.Set("save", "on")
+	req = httptest.NewRequest("POST", "/private/tools/text-save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	require.NoError(t, tools.BindParams(req, info.Parameters, &params))
+	assert.True(t, params.Save)
+}
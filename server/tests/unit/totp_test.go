@@ -0,0 +1,327 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CJFEdu/allmitools/server/internal/audit"
+	"github.com/CJFEdu/allmitools/server/internal/database"
+	"github.com/CJFEdu/allmitools/server/internal/handlers"
+	"github.com/CJFEdu/allmitools/server/internal/middleware"
+	"github.com/CJFEdu/allmitools/server/internal/templates"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTOTPStore is an in-memory TOTPStore for the users of a fakeUserStore
+type fakeTOTPStore struct {
+	mutex     sync.Mutex
+	users     fakeUserStore
+	lastSteps map[string]int64
+	// recoveryCodes are the unused recovery code hashes, keyed by user ID
+	recoveryCodes map[string]map[string]bool
+}
+
+// user returns the user with the given ID
+func (s *fakeTOTPStore) user(id string) (*database.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// GetUserByID returns the user with the given ID
+func (s *fakeTOTPStore) GetUserByID(id string) (*database.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.user(id)
+}
+
+// SetTOTPSecret stores a TOTP secret without enabling it
+func (s *fakeTOTPStore) SetTOTPSecret(id string, secret string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, err := s.user(id)
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret, user.TOTPEnabled = secret, false
+	s.lastSteps[id] = 0
+	return nil
+}
+
+// EnableTOTP enables two-factor authentication and stores the recovery codes
+func (s *fakeTOTPStore) EnableTOTP(id string, recoveryCodeHashes []string) error {
+	s.mutex.Lock()
+	user, err := s.user(id)
+	if err == nil {
+		user.TOTPEnabled = true
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.ReplaceRecoveryCodes(id, recoveryCodeHashes)
+}
+
+// DisableTOTP disables two-factor authentication and deletes the recovery codes
+func (s *fakeTOTPStore) DisableTOTP(id string) error {
+	s.mutex.Lock()
+	user, err := s.user(id)
+	if err == nil {
+		user.TOTPSecret, user.TOTPEnabled = "", false
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.ReplaceRecoveryCodes(id, nil)
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user
+func (s *fakeTOTPStore) ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recoveryCodes[userID] = make(map[string]bool)
+	for _, codeHash := range codeHashes {
+		s.recoveryCodes[userID][codeHash] = true
+	}
+	return nil
+}
+
+// UseTOTPStep records the step of an accepted code unless a later one was used
+func (s *fakeTOTPStore) UseTOTPStep(id string, step int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if step <= s.lastSteps[id] {
+		return false, nil
+	}
+	s.lastSteps[id] = step
+	return true, nil
+}
+
+// UseRecoveryCode marks a recovery code as used
+func (s *fakeTOTPStore) UseRecoveryCode(userID string, codeHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.recoveryCodes[userID][codeHash] {
+		return database.ErrNotFound
+	}
+	delete(s.recoveryCodes[userID], codeHash)
+	return nil
+}
+
+// useFakeTOTP installs a TOTP store for the users of useFakeUsers for the duration of a test
+func useFakeTOTP(t *testing.T, users fakeUserStore) *fakeTOTPStore {
+	store := &fakeTOTPStore{users: users, lastSteps: make(map[string]int64), recoveryCodes: make(map[string]map[string]bool)}
+	middleware.SetTOTPStore(store)
+	t.Cleanup(func() { middleware.SetTOTPStore(nil) })
+	return store
+}
+
+// currentTOTP returns the TOTP code of a secret at a time offset from now
+func currentTOTP(t *testing.T, secret string, offset time.Duration) string {
+	code, err := middleware.TOTPCode(secret, time.Now().Add(offset))
+	require.NoError(t, err)
+	return code
+}
+
+// TestTOTPCode tests the TOTP codes against the SHA-1 test vectors of RFC 6238
+func TestTOTPCode(t *testing.T) {
+	// The base32 encoding of the ASCII secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := middleware.TOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "code at %d", unix)
+	}
+
+	// Secrets are decoded ignoring case and spaces
+	code, err := middleware.TOTPCode("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+	_, err = middleware.TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+
+	generated, err := middleware.GenerateTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, generated, 32)
+
+	// The enrollment URI names the issuer and the user
+	uri, err := url.Parse(middleware.TOTPURI("alice", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/AllMiTools:alice", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "AllMiTools", uri.Query().Get("issuer"))
+
+	// Recovery codes are unique and hashed ignoring case and dashes
+	codes, hashes, err := middleware.GenerateRecoveryCodes()
+	require.NoError(t, err)
+	assert.Len(t, codes, middleware.RecoveryCodeCount)
+	assert.NotEqual(t, codes[0], codes[1])
+	assert.Equal(t, hashes[0], middleware.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
+
+// TestTOTPLogin tests the second login step of users with two-factor authentication
+func TestTOTPLogin(t *testing.T) {
+	require.NoError(t, templates.Initialize("../../"))
+	users := useFakeUsers(t)
+	store := useFakeTOTP(t, users)
+	recorder := useFakeAudit(t)
+
+	secret, _, err := middleware.EnrollTOTP(store, "alice-id")
+	require.NoError(t, err)
+	recoveryCodes, err := middleware.ConfirmTOTP(store, "alice-id", currentTOTP(t, secret, 0))
+	require.NoError(t, err)
+	require.True(t, users["alice"].TOTPEnabled)
+
+	// The password alone no longer logs in, it leads to the second step
+	form := url.Values{"username": {"alice"}, "password": {"secret"}, "next": {"/private/tools"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handlers.LoginHandler(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login/totp?next=%2Fprivate%2Ftools", rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	pending := cookies[0]
+	assert.Equal(t, middleware.PendingLoginCookieName, pending.Name)
+
+	submit := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login/totp", strings.NewReader(url.Values{"code": {code}, "next": {"/private/tools"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(pending)
+		rr := httptest.NewRecorder()
+		handlers.LoginTOTPHandler(rr, req)
+		return rr
+	}
+	authCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == middleware.CookieName && cookie.Value != "" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// Without the pending login the second step starts over
+	rr = httptest.NewRecorder()
+	handlers.LoginTOTPHandler(rr, httptest.NewRequest("GET", "/login/totp", nil))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login", rr.Header().Get("Location"))
+
+	// Wrong codes, and the code already used to confirm the enrollment, are rejected
+	rr = submit("000000")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid authentication code")
+	assert.Nil(t, authCookie(rr))
+	assert.Nil(t, authCookie(submit(currentTOTP(t, secret, 0))))
+	assert.Contains(t, recorder.types(), audit.EventTOTPFailed)
+
+	// The next code logs in and returns to the requested page
+	rr = submit(currentTOTP(t, secret, middleware.TOTPPeriod))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/private/tools", rr.Header().Get("Location"))
+	require.NotNil(t, authCookie(rr))
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(authCookie(rr))
+	identity, ok := middleware.CurrentUser(req)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", identity.Username)
+
+	// A recovery code works once
+	require.NotNil(t, authCookie(submit(recoveryCodes[0])))
+	assert.Nil(t, authCookie(submit(recoveryCodes[0])))
+	assert.Contains(t, recorder.types(), audit.EventRecoveryCodeUsed)
+
+	// The password fallback of the private routes does not bypass the second factor
+	req = httptest.NewRequest("POST", "/private/tools/text-storage", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+// TestTOTPHandlers tests enrolling and disabling two-factor authentication through the private endpoints
+func TestTOTPHandlers(t *testing.T) {
+	users := useFakeUsers(t)
+	store := useFakeTOTP(t, users)
+	useFakeAudit(t)
+	cookie := loginCookie(t)
+
+	router := mux.NewRouter()
+	private := router.PathPrefix("/private").Subrouter()
+	private.Use(middleware.AuthMiddleware)
+	private.HandleFunc("/totp", handlers.TOTPEnrollHandler).Methods("POST")
+	private.HandleFunc("/totp", handlers.TOTPDisableHandler).Methods("DELETE")
+	private.HandleFunc("/totp/confirm", handlers.TOTPConfirmHandler).Methods("POST")
+	private.HandleFunc("/totp/recovery-codes", handlers.TOTPRecoveryCodesHandler).Methods("POST")
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Enrolling returns the secret and its URI without enabling it yet
+	rr := serve("POST", "/private/totp", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var enrollment struct {
+		Data handlers.TOTPEnrollment `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &enrollment))
+	assert.Contains(t, enrollment.Data.URI, "secret="+enrollment.Data.Secret)
+	assert.False(t, users["alice"].TOTPEnabled)
+
+	// A wrong code does not enable it, the right code returns the recovery codes
+	assert.Equal(t, http.StatusBadRequest, serve("POST", "/private/totp/confirm", `{"code":"000000"}`).Code)
+	rr = serve("POST", "/private/totp/confirm", `{"code":"`+currentTOTP(t, enrollment.Data.Secret, 0)+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var codes struct {
+		Data handlers.RecoveryCodes `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &codes))
+	assert.Len(t, codes.Data.Codes, middleware.RecoveryCodeCount)
+	assert.True(t, users["alice"].TOTPEnabled)
+
+	// Enrolling again needs disabling first
+	assert.Equal(t, http.StatusConflict, serve("POST", "/private/totp", "").Code)
+
+	// Replacing the recovery codes and disabling need a current code
+	assert.Equal(t, http.StatusBadRequest, serve("POST", "/private/totp/recovery-codes", "").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/private/totp/recovery-codes", `{"code":"`+codes.Data.Codes[0]+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("DELETE", "/private/totp", `{"code":"`+codes.Data.Codes[1]+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("DELETE", "/private/totp", `{"code":"000000"}`).Code)
+	rr = serve("DELETE", "/private/totp", `{"code":"`+currentTOTP(t, enrollment.Data.Secret, middleware.TOTPPeriod)+`"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, users["alice"].TOTPEnabled)
+	assert.Empty(t, store.recoveryCodes["alice-id"])
+
+	// API keys cannot manage two-factor authentication
+	apiKeys := useFakeAPIKeys(t)
+	_, key, err := middleware.CreateAPIKey(apiKeys, "alice-id", "deploy", aliceScopes, nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, bearerRequest("POST", "/private/totp", key))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}